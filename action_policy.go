@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Actions the agent can take that mutate GitHub.
+const (
+	actionCreateIssue  = "create_issue"
+	actionComment      = "comment"
+	actionAddLabels    = "add_labels"
+	actionRemoveLabels = "remove_labels"
+	actionReopen       = "reopen"
+	actionAssign       = "assign"
+	actionSetMilestone = "set_milestone"
+	actionAddToProject = "add_to_project"
+)
+
+var knownActions = []string{actionCreateIssue, actionComment, actionAddLabels, actionRemoveLabels, actionReopen, actionAssign, actionSetMilestone, actionAddToProject}
+
+// ActionPolicy limits what the agent may do in a repository.
+type ActionPolicy struct {
+	// Allow lists the permitted actions. Nil means the default set.
+	Allow []string `yaml:"allow"`
+	// ProtectedLabels may never be added or removed by the agent.
+	ProtectedLabels []string `yaml:"protected_labels"`
+}
+
+// ActionPolicyConfig holds the default policy and per-repository overrides,
+// keyed by "owner/repo".
+type ActionPolicyConfig struct {
+	Default ActionPolicy            `yaml:"default"`
+	Repos   map[string]ActionPolicy `yaml:"repos"`
+}
+
+var actionPolicies ActionPolicyConfig
+
+// policyFor returns the policy for a repository: its own entry if there is
+// one, otherwise the default.
+func policyFor(target repoTarget) ActionPolicy {
+	for repo, policy := range actionPolicies.Repos {
+		if strings.EqualFold(repo, target.String()) {
+			return policy
+		}
+	}
+	return actionPolicies.Default
+}
+
+func (p ActionPolicy) allows(action string) bool {
+	if p.Allow == nil {
+		return true
+	}
+	return slices.Contains(p.Allow, action)
+}
+
+// checkAction returns an error explaining why the action is refused in the
+// repository, or nil. The error text is shown to the agent.
+func checkAction(target repoTarget, action string, labels []string) error {
+	policy := policyFor(target)
+	if !policy.allows(action) {
+		return fmt.Errorf("action %q is not permitted in %s by the action policy", action, target)
+	}
+
+	for _, label := range labels {
+		for _, protected := range policy.ProtectedLabels {
+			if strings.EqualFold(label, protected) {
+				return fmt.Errorf("label %q is protected in %s and cannot be changed by the agent", label, target)
+			}
+		}
+	}
+	return nil
+}
+
+func validateActionPolicy(name string, policy ActionPolicy) []string {
+	var problems []string
+	for i, action := range policy.Allow {
+		if !slices.Contains(knownActions, action) {
+			problems = append(problems, fmt.Sprintf("%s.allow[%d]: unknown action %q, expected one of %s", name, i, action, strings.Join(knownActions, ", ")))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// adminToken protects the /admin API. The admin API is disabled when it is
+// empty.
+var adminToken string
+
+func registerAdminRoutes(mux *http.ServeMux) {
+	mux.Handle("GET /admin/runs", requireAdmin(handleAdminListRuns))
+	mux.Handle("GET /admin/runs/{id}", requireAdmin(handleAdminGetRun))
+	mux.Handle("GET /admin/deadletters", requireAdmin(handleAdminListDeadLetters))
+	mux.Handle("POST /admin/deadletters/{id}/retry", requireAdmin(handleAdminRetryDeadLetter))
+	mux.Handle("GET /admin/config/validate", requireAdmin(handleAdminValidateConfig))
+	mux.Handle("POST /admin/prompt/test", requireAdmin(handleAdminPromptTest))
+	mux.Handle("POST /admin/fallback/resubmit", requireAdmin(handleAdminResubmitFallback))
+	mux.Handle("POST /admin/issues/relabel", requireAdmin(handleAdminRelabelIssues))
+	mux.Handle("GET /admin/fingerprints", requireAdmin(handleAdminListFingerprints))
+	mux.Handle("GET /admin/fingerprints/{fingerprint}", requireAdmin(handleAdminGetFingerprint))
+	mux.Handle("POST /admin/fingerprints/{fingerprint}/transition", requireAdmin(handleAdminTransitionFingerprint))
+	mux.Handle("POST /admin/fingerprints/{fingerprint}/remap", requireAdmin(handleAdminRemapFingerprint))
+	mux.Handle("POST /debug/fingerprint", requireAdmin(handleDebugFingerprint))
+	mux.Handle("GET /admin/report", requireAdmin(handleAdminUsageReport))
+	mux.Handle("GET /admin/services", requireAdmin(handleAdminListServices))
+	mux.Handle("GET /admin/services/{name}", requireAdmin(handleAdminGetService))
+	mux.Handle("PUT /admin/services/{name}", requireAdmin(handleAdminPutService))
+	mux.Handle("DELETE /admin/services/{name}", requireAdmin(handleAdminDeleteService))
+	mux.Handle("GET /admin/flags", requireAdmin(handleAdminListFlags))
+	mux.Handle("GET /admin/telemetry", requireAdmin(handleAdminTelemetry))
+	mux.Handle("PUT /admin/flags/{name}", requireAdmin(handleAdminPutFlag))
+	mux.Handle("DELETE /admin/flags/{name}", requireAdmin(handleAdminDeleteFlag))
+}
+
+func requireAdmin(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			http.Error(w, "Admin API is disabled: ADMIN_TOKEN is not set", http.StatusForbidden)
+			return
+		}
+
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			http.Error(w, "Invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func handleAdminListRuns(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, runs.list(r.URL.Query().Get("status")))
+}
+
+func handleAdminGetRun(w http.ResponseWriter, r *http.Request) {
+	run, ok := runs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+func handleAdminListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, runs.deadLetters())
+}
+
+// handleAdminRetryDeadLetter re-runs a failed run against the repository it
+// originally targeted.
+func handleAdminRetryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	existing, ok := runs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	}
+	if existing.Status != runStatusFailed {
+		http.Error(w, fmt.Sprintf("Run is %s, only failed runs can be retried", existing.Status), http.StatusConflict)
+		return
+	}
+
+	resp, err := retryRun(r.Context(), existing.ID)
+	if errors.Is(err, errRunNotRetryable) {
+		http.Error(w, "Run is already being retried", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Agent failed to process error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type configValidation struct {
+	Valid    bool     `json:"valid"`
+	Problems []string `json:"problems"`
+}
+
+func handleAdminValidateConfig(w http.ResponseWriter, r *http.Request) {
+	problems := validateRuntimeConfig(r.Context())
+	writeJSON(w, http.StatusOK, configValidation{Valid: len(problems) == 0, Problems: problems})
+}
+
+// validateRuntimeConfig checks the running configuration against GitHub:
+// every repository the service may write to must be reachable with the
+// configured token. Repositories are not checked with other trackers.
+func validateRuntimeConfig(ctx context.Context) []string {
+	problems := []string{}
+
+	targets := []repoTarget{{Owner: ghOwner, Repo: ghRepo}}
+	if externalDepsTarget != nil {
+		targets = append(targets, *externalDepsTarget)
+	}
+	for _, entry := range allowedRepos {
+		if strings.HasSuffix(entry, "/*") {
+			continue
+		}
+		if target, err := parseRepoTarget(entry); err == nil {
+			targets = append(targets, target)
+		}
+	}
+
+	for _, rule := range routeRules {
+		targets = append(targets, rule.target)
+	}
+	for _, s := range services.list() {
+		if target, err := parseRepoTarget(s.Repository); err == nil {
+			targets = append(targets, target)
+		}
+	}
+
+	if tracker.Name() != trackerGitHub {
+		targets = nil
+	}
+	for _, target := range targets {
+		if _, _, err := ghClient.Repositories.Get(ctx, target.Owner, target.Repo); err != nil {
+			problems = append(problems, fmt.Sprintf("repository %s is not reachable: %v", target, err))
+		}
+	}
+
+	if len(allowedRepos) > 0 && repoOverrideToken == "" {
+		problems = append(problems, "ALLOWED_REPOS is set but REPO_OVERRIDE_TOKEN is not: any caller can override the repository")
+	}
+
+	return problems
+}
+
+type promptTestResponse struct {
+	Repository   string       `json:"repository"`
+	Analysis     *LogAnalysis `json:"analysis"`
+	SystemPrompt string       `json:"system_prompt"`
+	AgentInput   string       `json:"agent_input"`
+}
+
+// handleAdminPromptTest renders what the agent would receive for a log
+// without calling the LLM or GitHub.
+func handleAdminPromptTest(w http.ResponseWriter, r *http.Request) {
+	var req ErrorLogRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.ErrorLog == "" {
+		http.Error(w, "Error log cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	analysis := analyzeErrorLog(req.ErrorLog)
+	svc, hasService := services.get(req.Service)
+	if hasService {
+		svc.apply(analysis)
+		applyOnCall(r.Context(), svc, analysis)
+	} else if req.Service != "" {
+		analysis.setMetadata("service", req.Service)
+	}
+	if req.Environment != "" {
+		analysis.setMetadata("environment", req.Environment)
+	}
+	classifySeverity(req.ErrorLog, analysis)
+	linkRunbooks(analysis)
+	if known, ok := matchKnownIssue(analysis); ok {
+		analysis.KnownIssue = &known
+	}
+
+	target := routeTarget(req.ErrorLog, analysis)
+	if hasService && svc.Repository != "" {
+		target, _ = parseRepoTarget(svc.Repository)
+	}
+	if req.Repository != "" {
+		var err error
+		if target, err = parseRepoTarget(req.Repository); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if state, ok := lifecycles.get(target.String(), analysis.Fingerprint); ok {
+		analysis.Lifecycle = &state
+	}
+	writeJSON(w, http.StatusOK, promptTestResponse{
+		Repository:   target.String(),
+		Analysis:     analysis,
+		SystemPrompt: fmt.Sprintf(agentSystemPrompt, target.Owner, target.Repo),
+		AgentInput:   buildAgentInput(req.ErrorLog, analysis),
+	})
+}
+
+// handleAdminResubmitFallback creates the issues held in the fallback store.
+func handleAdminResubmitFallback(w http.ResponseWriter, r *http.Request) {
+	result, err := resubmitStoredIssues(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Resubmitting stored issues failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func handleAdminListFingerprints(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, lifecycles.list(r.URL.Query().Get("state")))
+}
+
+func handleAdminGetFingerprint(w http.ResponseWriter, r *http.Request) {
+	state, ok := adminFingerprint(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+// adminFingerprint finds the fingerprint of the path in the repository of
+// the "repository" query parameter, which may be left out for a
+// fingerprint tracked in a single repository. It reports an error itself.
+func adminFingerprint(w http.ResponseWriter, r *http.Request) (FingerprintState, bool) {
+	state, err := lifecycles.find(r.URL.Query().Get("repository"), r.PathValue("fingerprint"))
+	switch {
+	case errors.Is(err, errFingerprintNotFound):
+		http.Error(w, "Fingerprint not found", http.StatusNotFound)
+		return FingerprintState{}, false
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return FingerprintState{}, false
+	}
+	return state, true
+}
+
+type transitionRequest struct {
+	State  string `json:"state"`
+	Reason string `json:"reason,omitempty"`
+	// FixVersion records the release a fix shipped in, for a move to
+	// fixed. It starts release verification.
+	FixVersion string `json:"fix_version,omitempty"`
+}
+
+func handleAdminTransitionFingerprint(w http.ResponseWriter, r *http.Request) {
+	var req transitionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	current, ok := adminFingerprint(w, r)
+	if !ok {
+		return
+	}
+
+	state, err := lifecycles.transitionWith(current.Repository, current.Fingerprint, req.State, req.Reason, func(st *FingerprintState) {
+		if req.FixVersion != "" {
+			st.FixVersion = req.FixVersion
+		}
+	})
+	switch {
+	case errors.Is(err, errFingerprintNotFound):
+		http.Error(w, "Fingerprint not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	slog.InfoContext(r.Context(), "Fingerprint moved", "fingerprint", state.Fingerprint, "state", state.State)
+	writeJSON(w, http.StatusOK, state)
+}
+
+type remapRequest struct {
+	// IssueURL is the issue the fingerprint belongs to; empty splits it
+	// from its current issue.
+	IssueURL string `json:"issue_url"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func handleAdminRemapFingerprint(w http.ResponseWriter, r *http.Request) {
+	var req remapRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if u, err := url.Parse(req.IssueURL); req.IssueURL != "" && (err != nil || u.Scheme == "" || u.Host == "") {
+		http.Error(w, fmt.Sprintf("Invalid issue_url %q: expected an issue URL", req.IssueURL), http.StatusBadRequest)
+		return
+	}
+
+	current, ok := adminFingerprint(w, r)
+	if !ok {
+		return
+	}
+
+	state, err := lifecycles.remap(current.Repository, current.Fingerprint, req.IssueURL, req.Reason)
+	switch {
+	case errors.Is(err, errFingerprintNotFound):
+		http.Error(w, "Fingerprint not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	kind := "remap"
+	if req.IssueURL == "" {
+		kind = "split"
+	}
+	if target, err := parseRepoTarget(state.Repository); err == nil {
+		// The cached response names the previous issue.
+		forgetResponse(r.Context(), target, state.Fingerprint)
+		if kind == "split" {
+			// The next occurrence files a new issue.
+			forgetOutbox(r.Context(), target, state.Fingerprint, intentCreate)
+		}
+	}
+	metrics.add("triage_duplicate_corrections_total", labelSet("kind", kind), 1)
+	slog.InfoContext(r.Context(), "Fingerprint remapped", "fingerprint", state.Fingerprint, "issue_url", state.IssueURL)
+	writeJSON(w, http.StatusOK, state)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/luisya22/swarmlet"
+)
+
+// TriageResult is the structured outcome of a run. The agent reports it by
+// calling report_result, whose parameters act as the response schema:
+// the action is an enum, and the issue must be one the run actually
+// created or found, so the API response never depends on the wording of
+// the agent's final message.
+type TriageResult struct {
+	// Action is created, duplicate or none (the run outcomes).
+	Action      string `json:"action"`
+	IssueNumber int    `json:"issue_number,omitempty"`
+	IssueURL    string `json:"issue_url,omitempty"`
+	// Duplicate is set when the error was already reported in IssueURL.
+	Duplicate bool `json:"duplicate"`
+	// Reopened is set when the run reopened the closed issue in IssueURL.
+	Reopened bool   `json:"reopened,omitempty"`
+	Summary  string `json:"summary,omitempty"`
+}
+
+var resultActions = []string{runOutcomeCreated, runOutcomeDuplicate, runOutcomeNone}
+
+func (t *toolSession) reportResultTool() swarmlet.LLMTool {
+	return swarmlet.LLMTool{
+		Name:        "report_result",
+		Description: "Reports the outcome of the triage. Call it exactly once, after all other tools and before your final answer.",
+		Params: map[string]swarmlet.LLMToolFieldProperty{
+			"action": {
+				Type:        "string",
+				Description: "'created' if you created a new issue, 'duplicate' if an existing issue already covers the error, 'none' otherwise (e.g. a tool failed or the action was refused).",
+				Enum:        resultActions,
+			},
+			"issue_url": {
+				Type:        "string",
+				Description: "The URL of the issue you created ('created') or of the existing issue ('duplicate'), exactly as returned by the tool. Leave empty for 'none'.",
+			},
+			"summary": {
+				Type:        "string",
+				Description: "One sentence describing what you did.",
+			},
+		},
+		Executor: t.reportResult,
+	}
+}
+
+func (t *toolSession) reportResult(args map[string]any) (string, error) {
+	action, _ := args["action"].(string)
+	if !slices.Contains(resultActions, action) {
+		return "", fmt.Errorf("invalid 'action' argument for report_result: expected one of %s", strings.Join(resultActions, ", "))
+	}
+	url, _ := args["issue_url"].(string)
+	url = strings.TrimSpace(url)
+	summary, _ := args["summary"].(string)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.result != nil {
+		return "", fmt.Errorf("report_result was already called for this run")
+	}
+
+	result := TriageResult{Action: action, IssueURL: url, Summary: summary}
+	switch action {
+	case runOutcomeCreated:
+		switch {
+		case url != "" && !slices.Contains(t.createdURLs, url):
+			return "", fmt.Errorf("issue_url %q is not an issue created in this run", url)
+		case url == "" && len(t.plannedIssues) == 0:
+			return "", fmt.Errorf("action 'created' requires a successful create_github_issue call")
+		}
+	case runOutcomeDuplicate:
+		if !t.foundURLs[url] {
+			return "", fmt.Errorf("issue_url %q is not an issue returned by search_github_issues in this run", url)
+		}
+		result.Duplicate = true
+	case runOutcomeNone:
+		result.IssueURL = ""
+	}
+	if result.IssueURL != "" {
+		result.IssueNumber, _ = issueNumberFromURL(result.IssueURL)
+	}
+	result.Reopened = result.Duplicate && result.IssueNumber > 0 && slices.Contains(t.reopened, result.IssueNumber)
+
+	t.result = &result
+	t.log.Info("Tool call", "tool", "report_result", "action", action, "issue_url", result.IssueURL)
+	return "Result recorded. Now give your final answer.", nil
+}
+
+// triageResult returns the result the agent reported, or, if it never
+// called report_result, e.g. as it ran out of iterations, one derived from
+// what its tools did: the issue it created, or else the existing issue it
+// reopened or commented on, as a duplicate.
+func (t *toolSession) triageResult() TriageResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.result != nil {
+		return *t.result
+	}
+	t.log.Warn("The agent did not report a result, deriving it from the tool calls", "repository", t.target.String())
+	if n := len(t.createdURLs); n > 0 {
+		url := t.createdURLs[n-1]
+		number, _ := issueNumberFromURL(url)
+		return TriageResult{Action: runOutcomeCreated, IssueNumber: number, IssueURL: url}
+	}
+	if t.dryRun && len(t.plannedIssues) > 0 {
+		return TriageResult{Action: runOutcomeCreated}
+	}
+
+	existing := slices.Concat(t.reopened, t.commented)
+	for _, c := range t.plannedComments {
+		existing = append(existing, c.IssueNumber)
+	}
+	for _, number := range existing {
+		if url, ok := t.foundNumbers[number]; ok {
+			return TriageResult{Action: runOutcomeDuplicate, IssueNumber: number, IssueURL: url, Duplicate: true, Reopened: slices.Contains(t.reopened, number)}
+		}
+	}
+	return TriageResult{Action: runOutcomeNone}
+}
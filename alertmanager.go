@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxAlerts caps how many new alerts one notification triages, since each
+// one is an agent run.
+const maxAlerts = 25
+
+// alertIdentityLabels, with the alert name, identify an alert for the
+// fingerprint. Per-instance labels such as instance or pod are left out so
+// that an alert firing on many replicas maps to one issue.
+var alertIdentityLabels = []string{"service", "job", "namespace"}
+
+// alertmanagerFilter selects the alerts to triage. Empty lists match every
+// alert.
+var alertmanagerFilter AlertmanagerConfig
+
+// AlertmanagerConfig filters the alerts received on /ingest/alertmanager.
+type AlertmanagerConfig struct {
+	// Alertnames, when set, are the only alert names triaged.
+	Alertnames []string `yaml:"alertnames"`
+	// Severities, when set, are the only values of the severity label
+	// triaged.
+	Severities []string `yaml:"severities"`
+}
+
+func (c AlertmanagerConfig) matches(a alertmanagerAlert) bool {
+	if len(c.Alertnames) > 0 && !slices.Contains(c.Alertnames, a.Labels["alertname"]) {
+		return false
+	}
+	if len(c.Severities) > 0 && !slices.Contains(c.Severities, a.Labels["severity"]) {
+		return false
+	}
+	return true
+}
+
+// alertmanagerPayload is the webhook notification Alertmanager sends for a
+// group of alerts (version 4).
+type alertmanagerPayload struct {
+	Version           string              `json:"version"`
+	GroupKey          string              `json:"groupKey"`
+	Status            string              `json:"status"`
+	Receiver          string              `json:"receiver"`
+	CommonLabels      map[string]string   `json:"commonLabels"`
+	CommonAnnotations map[string]string   `json:"commonAnnotations"`
+	ExternalURL       string              `json:"externalURL"`
+	Alerts            []alertmanagerAlert `json:"alerts"`
+}
+
+type alertmanagerAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+type AlertmanagerResponse struct {
+	Repository string `json:"repository"`
+	Alerts     int    `json:"alerts"`
+	// Firing is how many alerts were firing and passed the filter.
+	Firing   int             `json:"firing"`
+	Findings []FindingResult `json:"findings"`
+}
+
+// alertFinding turns a firing alert into a finding: the labels and
+// annotations become the log the agent sees and the pre-analysis metadata.
+func alertFinding(a alertmanagerAlert) finding {
+	name := a.Labels["alertname"]
+	analysis := &LogAnalysis{
+		Format:    "alertmanager",
+		Category:  categoryAlert,
+		ErrorType: name,
+		Message:   firstNonEmpty(a.Annotations["summary"], a.Annotations["description"], name),
+	}
+	for _, k := range sortedKeys(a.Labels) {
+		if k != "alertname" && k != "severity" {
+			analysis.setMetadata("label_"+k, a.Labels[k])
+		}
+	}
+	if severity := a.Labels["severity"]; severity != "" {
+		analysis.setMetadata("severity", severity)
+	}
+	if runbook := a.Annotations["runbook_url"]; runbook != "" {
+		analysis.setMetadata("runbooks", runbook)
+	}
+	analysis.addLabel("alert")
+
+	key := []string{"alert", name}
+	for _, l := range alertIdentityLabels {
+		key = append(key, a.Labels[l])
+	}
+	analysis.FingerprintKey = strings.Join(key, ":")
+	analysis.Fingerprint = computeFingerprint(analysis)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[FIRING] %s", name)
+	if severity := a.Labels["severity"]; severity != "" {
+		fmt.Fprintf(&sb, " (%s)", severity)
+	}
+	sb.WriteString("\n")
+	for _, k := range sortedKeys(a.Annotations) {
+		fmt.Fprintf(&sb, "%s: %s\n", k, a.Annotations[k])
+	}
+	sb.WriteString("Labels:\n")
+	for _, k := range sortedKeys(a.Labels) {
+		fmt.Fprintf(&sb, "  %s=%s\n", k, a.Labels[k])
+	}
+	if !a.StartsAt.IsZero() {
+		fmt.Fprintf(&sb, "Started: %s\n", a.StartsAt.UTC().Format(time.RFC3339))
+	}
+	if a.GeneratorURL != "" {
+		fmt.Fprintf(&sb, "Source: %s\n", a.GeneratorURL)
+	}
+	text := normalizeLogText(sb.String())
+	analysis.SimHash = fmt.Sprintf("%016x", simhashLog(text))
+
+	return finding{Name: name, Text: text, Analysis: analysis}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// handleIngestAlertmanager triages the firing alerts of an Alertmanager
+// webhook notification. Resolved alerts and alerts rejected by the filter
+// are ignored. The repository can be overridden with the "repository"
+// query parameter.
+func handleIngestAlertmanager(w http.ResponseWriter, r *http.Request) {
+	var payload alertmanagerPayload
+	data, err := io.ReadAll(r.Body)
+	if err == nil {
+		data, err = decodeText(data, r.Header.Get("Content-Type"))
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &payload)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid Alertmanager payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	target, status, err := findingsTarget(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	var findings []finding
+	for _, a := range payload.Alerts {
+		if a.Status != "firing" || !alertmanagerFilter.matches(a) {
+			continue
+		}
+		findings = append(findings, alertFinding(a))
+	}
+	// Alertmanager's own order within a group is arbitrary; triage the
+	// most severe alerts first in case the limit is reached.
+	sort.SliceStable(findings, func(i, j int) bool {
+		return severityRank(findings[i].Analysis.Metadata["severity"]) < severityRank(findings[j].Analysis.Metadata["severity"])
+	})
+
+	var stream *findingsStream
+	var emit func(FindingResult)
+	if wantsStream(r) {
+		stream = newFindingsStream(w)
+		emit = stream.result
+	}
+
+	results := triageFindings(r.Context(), target, findings, maxAlerts, emit)
+	slog.InfoContext(r.Context(), "Processed Alertmanager notification", "group_key", payload.GroupKey, "repository", target.String(), "alerts", len(payload.Alerts), "firing", len(findings))
+	resp := AlertmanagerResponse{
+		Repository: target.String(),
+		Alerts:     len(payload.Alerts),
+		Firing:     len(findings),
+		Findings:   results,
+	}
+	if stream != nil {
+		stream.summary(resp)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// alertSeverityOrder covers the severities of common alerting rules and
+// the service registry, most severe first.
+var alertSeverityOrder = []string{"critical", "high", "error", "warning", "medium", "low", "info"}
+
+// severityRank orders severities from most to least severe; unknown ones
+// come last.
+func severityRank(severity string) int {
+	if i := slices.Index(alertSeverityOrder, strings.ToLower(severity)); i >= 0 {
+		return i
+	}
+	return len(alertSeverityOrder)
+}
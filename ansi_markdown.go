@@ -0,0 +1,115 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ANSI rendering modes.
+const (
+	ansiStrip    = "strip"
+	ansiMarkdown = "markdown"
+)
+
+// ansiRendering is ANSI_RENDERING or ansi_rendering. With "markdown", logs
+// with ANSI colors also get a highlighted rendering for the issue body;
+// the analysis and the fingerprint always use the stripped text.
+var ansiRendering = ansiStrip
+
+// sgrPattern matches SGR ("Select Graphic Rendition") sequences, the
+// subset of ANSI escapes that set colors and text attributes.
+var sgrPattern = regexp.MustCompile(`\x1b\[([0-9;]*)m`)
+
+// lineColor is the emphasis a log line had in the terminal, from weakest
+// to strongest.
+type lineColor int
+
+const (
+	colorPlain lineColor = iota
+	colorGreen
+	colorRed
+)
+
+// renderANSIMarkdown renders a log with ANSI colors as a Markdown diff
+// block, which GitHub highlights: lines the logger printed in red become
+// red "-" lines and green ones green "+" lines, so errors stand out the way
+// they did in the terminal. It returns "" for a log without colors.
+func renderANSIMarkdown(raw string) string {
+	if !sgrPattern.MatchString(raw) {
+		return ""
+	}
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+	raw = strings.ReplaceAll(raw, "\r", "\n")
+
+	var sb strings.Builder
+	sb.WriteString("```diff\n")
+	// The color in effect carries over from one line to the next, as it
+	// does in a terminal.
+	current := colorPlain
+	for _, line := range strings.Split(strings.TrimRight(raw, "\n"), "\n") {
+		strongest := colorPlain
+		rest := line
+		var text strings.Builder
+		for {
+			loc := sgrPattern.FindStringSubmatchIndex(rest)
+			segment := rest
+			if loc != nil {
+				segment = rest[:loc[0]]
+			}
+			if strings.TrimSpace(segment) != "" && current > strongest {
+				strongest = current
+			}
+			text.WriteString(segment)
+			if loc == nil {
+				break
+			}
+			current = applySGR(current, rest[loc[2]:loc[3]])
+			rest = rest[loc[1]:]
+		}
+
+		switch strongest {
+		case colorRed:
+			sb.WriteString("- ")
+		case colorGreen:
+			sb.WriteString("+ ")
+		default:
+			sb.WriteString("  ")
+		}
+		// Any other escape sequences are dropped as when stripping.
+		sb.WriteString(normalizeLogText(text.String()))
+		sb.WriteString("\n")
+	}
+	sb.WriteString("```")
+	return sb.String()
+}
+
+// applySGR returns the color in effect after an SGR sequence's parameters.
+// Only red and green matter for the rendering; other colors reset to plain.
+func applySGR(current lineColor, params string) lineColor {
+	if params == "" {
+		return colorPlain
+	}
+	codes := strings.Split(params, ";")
+	for i := 0; i < len(codes); i++ {
+		n, _ := strconv.Atoi(codes[i])
+		switch {
+		case n == 0 || n == 39:
+			current = colorPlain
+		case n == 31 || n == 91 || n == 41 || n == 101:
+			current = colorRed
+		case n == 32 || n == 92 || n == 42 || n == 102:
+			current = colorGreen
+		case (n >= 30 && n <= 37) || (n >= 90 && n <= 97):
+			current = colorPlain
+		case n == 38 || n == 48:
+			// 256-color and truecolor: 38;5;N or 38;2;R;G;B.
+			if i+1 < len(codes) && codes[i+1] == "5" {
+				i += 2
+			} else if i+1 < len(codes) && codes[i+1] == "2" {
+				i += 4
+			}
+		}
+	}
+	return current
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// apiKeyHeader carries the API key. The Authorization header is left to
+// the repository override token.
+const apiKeyHeader = "X-API-Key"
+
+// sourceRead scopes a key to the read endpoints: /jobs, /tests, /stability,
+// /rejections and /reports/canary.
+const sourceRead = "read"
+
+// APIKey lets a client call the ingestion and read endpoints. Once any key
+// is configured, requests to them without a valid key get 401, and
+// requests outside the key's scopes 403.
+type APIKey struct {
+	Name string `yaml:"name"`
+	// KeyEnv names the variable holding the key.
+	KeyEnv string `yaml:"key_env"`
+	// KeySHA256 is the hex SHA-256 of the key, for keys kept out of the
+	// environment.
+	KeySHA256 string `yaml:"key_sha256"`
+	// Sources limits the key to these endpoints: process_error, sarif,
+	// test_results, alertmanager, sessions and read. Empty allows all.
+	Sources []string `yaml:"sources"`
+	// Repositories limits the repositories the key's requests may target
+	// ("owner/repo" or "owner/*"). Empty allows all.
+	Repositories []string `yaml:"repositories"`
+}
+
+type apiKey struct {
+	name    string
+	hash    []byte
+	sources []string
+	repos   []string
+}
+
+func (k apiKey) allowsSource(source string) bool {
+	return len(k.sources) == 0 || slices.Contains(k.sources, source)
+}
+
+func (k apiKey) allowsRepo(target repoTarget) bool {
+	return len(k.repos) == 0 || repoMatches(k.repos, target)
+}
+
+// apiKeys are the configured keys. Authentication is off when there are
+// none.
+var apiKeys []apiKey
+
+// compileAPIKeys validates the api_keys config setting and the unscoped
+// keys of API_KEYS ("name:key,..."). Invalid entries, and entries whose
+// variable is not set, are left out.
+func compileAPIKeys(entries []APIKey, env string) ([]apiKey, []string) {
+	var out []apiKey
+	var problems []string
+	names := make(map[string]bool)
+	for i, e := range entries {
+		prefix := fmt.Sprintf("api_keys[%d]", i)
+		if e.Name == "" {
+			problems = append(problems, prefix+".name must be set")
+			continue
+		}
+		if names[e.Name] {
+			problems = append(problems, fmt.Sprintf("%s: duplicate name %q", prefix, e.Name))
+			continue
+		}
+		names[e.Name] = true
+
+		var hash []byte
+		switch {
+		case (e.KeyEnv == "") == (e.KeySHA256 == ""):
+			problems = append(problems, prefix+": exactly one of key_env and key_sha256 must be set")
+			continue
+		case e.KeySHA256 != "":
+			var err error
+			if hash, err = hex.DecodeString(e.KeySHA256); err != nil || len(hash) != sha256.Size {
+				problems = append(problems, fmt.Sprintf("%s.key_sha256: expected a hex SHA-256, got %q", prefix, e.KeySHA256))
+				continue
+			}
+		}
+		var bad []string
+		for _, source := range e.Sources {
+			if source != sourceRead && !slices.Contains(signedSources, source) {
+				bad = append(bad, source)
+			}
+		}
+		if len(bad) > 0 {
+			problems = append(problems, fmt.Sprintf("%s.sources: unknown sources %s, expected %s or %s", prefix, strings.Join(bad, ", "), strings.Join(signedSources, ", "), sourceRead))
+			continue
+		}
+		repos, err := parseAllowedRepos(strings.Join(e.Repositories, ","))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s.repositories: %v", prefix, err))
+			continue
+		}
+
+		if e.KeyEnv != "" {
+			key := os.Getenv(e.KeyEnv)
+			if key == "" {
+				continue
+			}
+			sum := sha256.Sum256([]byte(key))
+			hash = sum[:]
+		}
+		out = append(out, apiKey{name: e.Name, hash: hash, sources: e.Sources, repos: repos})
+	}
+
+	for _, entry := range strings.Split(env, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(entry, ":")
+		if !ok || name == "" || key == "" {
+			problems = append(problems, "API_KEYS: expected name:key entries")
+			continue
+		}
+		sum := sha256.Sum256([]byte(key))
+		out = append(out, apiKey{name: name, hash: sum[:]})
+	}
+	return out, problems
+}
+
+// lookupAPIKey finds the key a client presented, comparing against every
+// key in constant time.
+func lookupAPIKey(key string) (apiKey, bool) {
+	sum := sha256.Sum256([]byte(key))
+	var found apiKey
+	ok := false
+	for _, k := range apiKeys {
+		if subtle.ConstantTimeCompare(sum[:], k.hash) == 1 {
+			found, ok = k, true
+		}
+	}
+	return found, ok && key != ""
+}
+
+type apiKeyContextKey struct{}
+
+// requireAPIKey rejects requests without a valid key (401) or with a key
+// not scoped to the source (403), when keys are configured. The key is
+// passed on in the context for authorizeRepo.
+func requireAPIKey(source string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(apiKeys) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, ok := lookupAPIKey(r.Header.Get(apiKeyHeader))
+		if !ok {
+			slog.WarnContext(r.Context(), "Refused request without a valid API key", "source", source, "remote_addr", r.RemoteAddr)
+			http.Error(w, "Missing or invalid "+apiKeyHeader+" header", http.StatusUnauthorized)
+			return
+		}
+		if !key.allowsSource(source) {
+			slog.WarnContext(r.Context(), "Refused request outside the API key's sources", "api_key", key.name, "source", source)
+			http.Error(w, fmt.Sprintf("API key %s may not call this endpoint", key.name), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+// authorizeRepo checks that the request's API key, if any, may target the
+// repository.
+func authorizeRepo(r *http.Request, target repoTarget) error {
+	key, ok := r.Context().Value(apiKeyContextKey{}).(apiKey)
+	if !ok || key.allowsRepo(target) {
+		return nil
+	}
+	slog.WarnContext(r.Context(), "Refused request outside the API key's repositories", "api_key", key.name, "repository", target.String())
+	return fmt.Errorf("API key %s may not target repository %s", key.name, target)
+}
+
+// ingest protects an ingestion endpoint with the API keys, the rate limit
+// and the source's signing secret.
+func ingest(source string, h http.HandlerFunc) http.Handler {
+	return requireAPIKey(source, rateLimit(source, requireSignature(source, withIngestSource(source, h))))
+}
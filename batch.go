@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchWorkers  = 4
+	defaultBatchMaxItems = 500
+)
+
+// BatchConfig bounds /process_errors.
+type BatchConfig struct {
+	// Workers is how many error logs of a batch are triaged at once
+	// (default 4).
+	Workers int `yaml:"workers"`
+	// MaxItems is the largest batch accepted (default 500).
+	MaxItems int `yaml:"max_items"`
+}
+
+// batchConfig is the configured batch limits.
+var batchConfig = BatchConfig{Workers: defaultBatchWorkers, MaxItems: defaultBatchMaxItems}
+
+func configBatch(cfg *Config) BatchConfig {
+	out := cfg.Batch
+	if out.Workers == 0 {
+		out.Workers = defaultBatchWorkers
+	}
+	if out.MaxItems == 0 {
+		out.MaxItems = defaultBatchMaxItems
+	}
+	return out
+}
+
+func validateBatch(bc BatchConfig) []string {
+	var problems []string
+	if bc.Workers < 0 {
+		problems = append(problems, fmt.Sprintf("batch.workers: %d must be positive", bc.Workers))
+	}
+	if bc.MaxItems < 0 {
+		problems = append(problems, fmt.Sprintf("batch.max_items: %d must be positive", bc.MaxItems))
+	}
+	return problems
+}
+
+// BatchItemResult is the outcome of one error log of a batch: the status
+// and response /process_error would have given it.
+type BatchItemResult struct {
+	Index  int `json:"index"`
+	Status int `json:"status"`
+	// Response is the JSON response of the item, e.g. an APIResponse or,
+	// for an asynchronous request, a JobAccepted.
+	Response json.RawMessage `json:"response,omitempty"`
+	// Error is the error message of an item that failed.
+	Error string `json:"error,omitempty"`
+}
+
+// BatchResponse is the response to /process_errors, with the results in
+// the order of the error logs.
+type BatchResponse struct {
+	Total   int               `json:"total"`
+	Failed  int               `json:"failed"`
+	Results []BatchItemResult `json:"results"`
+}
+
+// handleProcessErrors triages a batch of error logs, sent as a JSON array
+// or as NDJSON (one ErrorLogRequest per line), on a bounded pool of
+// workers. Each item is handled like a /process_error request, with the
+// request's headers and query parameters (e.g. async=true), and the
+// response lists the per-item results.
+func handleProcessErrors(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err == nil {
+		data, err = decodeText(data, r.Header.Get("Content-Type"))
+	}
+	var reqs []ErrorLogRequest
+	if err == nil {
+		reqs, err = decodeBatch(data, batchConfig.MaxItems)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	resp := BatchResponse{Total: len(reqs), Results: make([]BatchItemResult, len(reqs))}
+	allowed := allowBatchItems(w, r, len(reqs))
+	for i := allowed; i < len(reqs); i++ {
+		resp.Results[i] = BatchItemResult{Index: i, Status: http.StatusTooManyRequests, Error: "Rate limit exceeded"}
+	}
+
+	items := make(chan int)
+	var wg sync.WaitGroup
+	for range min(batchConfig.Workers, allowed) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				resp.Results[i] = processBatchItem(r, i, reqs[i])
+			}
+		}()
+	}
+	for i := range allowed {
+		items <- i
+	}
+	close(items)
+	wg.Wait()
+
+	for _, res := range resp.Results {
+		if res.Status >= http.StatusBadRequest {
+			resp.Failed++
+		}
+	}
+	slog.InfoContext(r.Context(), "Processed batch of error logs", "total", resp.Total, "failed", resp.Failed)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// allowBatchItems charges the client's rate limit for each error log of a
+// batch, and returns how many of the first logs it may have triaged: the
+// request paid for the first one, and each other one takes a token. When
+// some are over the limit, it sets Retry-After for them.
+func allowBatchItems(w http.ResponseWriter, r *http.Request, n int) int {
+	if limiter == nil || n <= 1 {
+		return n
+	}
+	client := rateLimitClient(r)
+	taken, wait := limiter.take(client, n-1, time.Now())
+	if allowed := taken + 1; allowed < n {
+		source := ingestSource(r.Context())
+		metrics.add("triage_rate_limited_total", labelSet("source", source), float64(n-allowed))
+		slog.WarnContext(r.Context(), "Refused error logs of a batch over the rate limit", "source", source, "client", client, "refused", n-allowed)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return allowed
+	}
+	return n
+}
+
+// decodeBatch reads a JSON array of error log requests, or a stream of them
+// such as NDJSON.
+func decodeBatch(data []byte, maxItems int) ([]ErrorLogRequest, error) {
+	var reqs []ErrorLogRequest
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := json.Unmarshal(data, &reqs); err != nil {
+			return nil, err
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var req ErrorLogRequest
+			if err := dec.Decode(&req); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("error log %d: %w", len(reqs), err)
+			}
+			reqs = append(reqs, req)
+		}
+	}
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("no error logs")
+	}
+	if len(reqs) > maxItems {
+		return nil, fmt.Errorf("%d error logs, at most %d are accepted per batch", len(reqs), maxItems)
+	}
+	return reqs, nil
+}
+
+// processBatchItem triages one error log of a batch, recording the
+// response processErrorLog writes.
+func processBatchItem(r *http.Request, index int, req ErrorLogRequest) (result BatchItemResult) {
+	result.Index = index
+	rec := &itemRecorder{header: make(http.Header)}
+	defer func() {
+		if v := recover(); v != nil {
+			result.Status = http.StatusInternalServerError
+			result.Error = recoveredPanic(r.Context(), "http", v).Error()
+		}
+	}()
+	processErrorLog(rec, r, req)
+
+	result.Status = rec.status
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+	body := bytes.TrimSpace(rec.body.Bytes())
+	if strings.HasPrefix(rec.header.Get("Content-Type"), "application/json") && json.Valid(body) {
+		result.Response = body
+	} else {
+		result.Error = string(body)
+	}
+	return result
+}
+
+// itemRecorder is the response writer of a batch item.
+type itemRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *itemRecorder) Header() http.Header { return rec.header }
+
+func (rec *itemRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *itemRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(p)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// defaultBootstrapIssues is how many of a repository's most recent issues
+// are crawled when bootstrap.max_issues is not set.
+const defaultBootstrapIssues = 500
+
+// BootstrapConfig seeds the fingerprint and near-duplicate indexes from a
+// repository's existing issues the first time the service works on it, so
+// that errors already reported by people are recognized, not only the
+// issues the agent filed itself.
+type BootstrapConfig struct {
+	// Skip turns the bootstrap off.
+	Skip bool `yaml:"skip"`
+	// MaxIssues is how many of the most recently updated issues, open and
+	// closed, are crawled (default 500).
+	MaxIssues int `yaml:"max_issues"`
+}
+
+var (
+	// fencedBlock finds the code blocks of an issue body.
+	fencedBlock = regexp.MustCompile("(?s)```[^\\n]*\\n(.*?)```")
+	// fingerprintLine finds the "Fingerprint:" line the agent ends issue
+	// bodies with.
+	fingerprintLine = regexp.MustCompile(`(?m)^\s*Fingerprint:\s*([0-9a-f]{16})\s*$`)
+)
+
+// bootstrapper crawls each repository at most once; the store remembers
+// which repositories were crawled across restarts.
+type bootstrapper struct {
+	maxIssues int
+
+	mu      sync.Mutex
+	running map[string]bool
+	done    map[string]bool
+}
+
+// bootstrap is nil when the bootstrap is off.
+var bootstrap *bootstrapper
+
+func configBootstrap(cfg *Config) BootstrapConfig {
+	out := cfg.Bootstrap
+	if out.MaxIssues == 0 {
+		out.MaxIssues = defaultBootstrapIssues
+	}
+	return out
+}
+
+func newBootstrapper(bc BootstrapConfig) *bootstrapper {
+	if bc.Skip {
+		return nil
+	}
+	return &bootstrapper{maxIssues: bc.MaxIssues, running: make(map[string]bool), done: make(map[string]bool)}
+}
+
+func bootstrapKey(target repoTarget) string {
+	return "bootstrap:" + strings.ToLower(target.String())
+}
+
+// ensure crawls a repository in the background unless it was crawled
+// before or is being crawled. Only GitHub issues are crawled.
+func (b *bootstrapper) ensure(target repoTarget) {
+	if b == nil || tracker.Name() != trackerGitHub {
+		return
+	}
+	key := bootstrapKey(target)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.running[key] || b.done[key] {
+		return
+	}
+	if _, done, err := store.GetValue(context.Background(), key); err != nil || done {
+		logStoreError("get bootstrap", err)
+		b.done[key] = done
+		return
+	}
+	b.running[key] = true
+
+	go func() {
+		seeded, crawled, err := b.crawl(context.Background(), target)
+		if err != nil {
+			slog.Error("Bootstrapping from issues failed", "repository", target.String(), "error", err)
+		} else {
+			slog.Info("Bootstrapped from issues", "repository", target.String(), "fingerprints", seeded, "issues", crawled)
+			logStoreError("save bootstrap", store.SetValue(context.Background(), key, []byte(time.Now().UTC().Format(time.RFC3339))))
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.running, key)
+		b.done[key] = err == nil
+	}()
+}
+
+// crawl seeds the fingerprints of a repository's issues. It returns how
+// many fingerprints were new and how many issues were read.
+func (b *bootstrapper) crawl(ctx context.Context, target repoTarget) (seeded, crawled int, err error) {
+	opts := &github.IssueListByRepoOptions{
+		State:       "all",
+		Sort:        "updated",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for crawled < b.maxIssues {
+		var issues []*github.Issue
+		var resp *github.Response
+		err := retryGitHub(ctx, func() (*github.Response, error) {
+			var err error
+			issues, resp, err = ghClient.Issues.ListByRepo(ctx, target.Owner, target.Repo, opts)
+			return resp, err
+		})
+		if err != nil {
+			return seeded, crawled, err
+		}
+		for _, issue := range issues {
+			if issue.IsPullRequest() || crawled >= b.maxIssues {
+				continue
+			}
+			crawled++
+			seeded += seedIssue(target, issue)
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return seeded, crawled, nil
+}
+
+// seedIssue links the fingerprints found in an issue body to the issue:
+// the "Fingerprint:" line of issues the agent filed, and the fingerprints
+// of stack traces in code blocks of issues filed by people. Closed issues
+// seed resolved fingerprints, so a recurrence is handled as a regression.
+// It returns how many fingerprints were new.
+func seedIssue(target repoTarget, issue *github.Issue) int {
+	state := stateFiled
+	if issue.GetState() == "closed" {
+		state = stateResolved
+	}
+	seen := make(map[string]bool)
+	seeded := 0
+	seed := func(fingerprint string) {
+		if seen[fingerprint] {
+			return
+		}
+		seen[fingerprint] = true
+		if lifecycles.seed(fingerprint, target, state, issue.GetHTMLURL(), issue.GetCreatedAt()) {
+			seeded++
+		}
+	}
+
+	body := issue.GetBody()
+	for _, m := range fingerprintLine.FindAllStringSubmatch(body, -1) {
+		seed(m[1])
+	}
+	for _, m := range fencedBlock.FindAllStringSubmatch(body, -1) {
+		errorLog := sanitizeText(normalizeLogText(m[1]))
+		if errorLog == "" {
+			continue
+		}
+		analysis := analyzeErrorLog(errorLog)
+		// Without a parsed stack trace the fingerprint is only the first
+		// line, which is too weak to link future errors to the issue.
+		if analysis.Format == "generic" {
+			continue
+		}
+		clusters.assign(analysis)
+		seed(analysis.Fingerprint)
+	}
+	return seeded
+}
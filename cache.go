@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache holds short-lived shared state: the response cache that suppresses
+// repeated triage of the same error. The in-memory cache is per replica;
+// the Redis cache is shared by every replica of a deployment.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Cache drivers.
+const (
+	cacheMemory = "memory"
+	cacheRedis  = "redis"
+)
+
+const (
+	// defaultResponseTTL is how long a triage response is reused for the
+	// same fingerprint and repository.
+	defaultResponseTTL = 10 * time.Minute
+	// maxMemoryCacheEntries bounds the in-memory cache.
+	maxMemoryCacheEntries = 10000
+	// cacheKeyPrefix namespaces keys in a shared Redis.
+	cacheKeyPrefix = "triage:"
+)
+
+// CacheConfig selects the cache backend.
+type CacheConfig struct {
+	// Driver is memory (default) or redis.
+	Driver string `yaml:"driver"`
+	// URL is the Redis URL, e.g. redis://localhost:6379/0.
+	URL string `yaml:"url"`
+	// ResponseTTL is how long responses are reused; "0s" turns the
+	// response cache off.
+	ResponseTTL string `yaml:"response_ttl"`
+}
+
+var (
+	cache       Cache = newMemoryCache()
+	responseTTL       = defaultResponseTTL
+)
+
+func openCache(cfg CacheConfig) (Cache, error) {
+	switch cfg.Driver {
+	case "", cacheMemory:
+		return newMemoryCache(), nil
+	case cacheRedis:
+		opts, err := redis.ParseURL(cfg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis URL: %w", err)
+		}
+		client := redis.NewClient(opts)
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			return nil, fmt.Errorf("connecting to redis: %w", err)
+		}
+		return redisCache{client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown cache driver %q, expected memory or redis", cfg.Driver)
+}
+
+type memoryCacheEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{entries: make(map[string]memoryCacheEntry)}
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expiresAt) {
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	return slices.Clone(e.value), true, nil
+}
+
+func (c *memoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxMemoryCacheEntries {
+		now := time.Now()
+		for k, e := range c.entries {
+			if now.After(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		// Still full of live entries: drop an arbitrary one.
+		for k := range c.entries {
+			if len(c.entries) < maxMemoryCacheEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = memoryCacheEntry{value: slices.Clone(value), expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (c *memoryCache) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+	return nil
+}
+
+type redisCache struct {
+	client *redis.Client
+}
+
+func (c redisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := c.client.Get(ctx, cacheKeyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, cacheKeyPrefix+key, value, ttl).Err()
+}
+
+func (c redisCache) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, cacheKeyPrefix+key).Err()
+}
+
+func responseCacheKey(target repoTarget, fingerprint string) string {
+	return "response:" + target.String() + ":" + fingerprint
+}
+
+// cachedResponse returns the response of a recent run for the same
+// fingerprint and repository, if there is one.
+func cachedResponse(ctx context.Context, target repoTarget, fingerprint string) (APIResponse, bool) {
+	if responseTTL <= 0 {
+		return APIResponse{}, false
+	}
+	data, ok, err := cache.Get(ctx, responseCacheKey(target, fingerprint))
+	if err != nil {
+		slog.ErrorContext(ctx, "Cache error", "op", "get response", "error", err)
+		return APIResponse{}, false
+	}
+	var resp APIResponse
+	if !ok || json.Unmarshal(data, &resp) != nil {
+		return APIResponse{}, false
+	}
+	return resp, true
+}
+
+// cacheResponse keeps a response that found or filed an issue, so the same
+// error arriving again within the TTL is answered without another run.
+func cacheResponse(ctx context.Context, target repoTarget, resp APIResponse) {
+	if responseTTL <= 0 || resp.IssueURL == "" {
+		return
+	}
+	data, _ := json.Marshal(resp)
+	if err := cache.Set(ctx, responseCacheKey(target, resp.Fingerprint), data, responseTTL); err != nil {
+		slog.ErrorContext(ctx, "Cache error", "op", "set response", "error", err)
+	}
+}
+
+// forgetResponse drops the cached response, e.g. when the fingerprint
+// regressed and must be triaged again.
+func forgetResponse(ctx context.Context, target repoTarget, fingerprint string) {
+	if err := cache.Delete(ctx, responseCacheKey(target, fingerprint)); err != nil {
+		slog.ErrorContext(ctx, "Cache error", "op", "delete response", "error", err)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// canaryRunPrefix prefixes the run IDs of canary runs, which the LLM
+// transport routes to the canary's provider.
+const canaryRunPrefix = "canary-"
+
+func init() {
+	metrics.describe("triage_canary_runs_total", "counter", "Paired dry runs through the canary model, by whether they agreed with the run (true, false or failed).")
+}
+
+// CanaryConfig runs a second model alongside the configured one: a share
+// of the runs is repeated as a dry run through it, and compared.
+type CanaryConfig struct {
+	// LLM is the canary model, configured like llm but without its
+	// environment overrides. The canary is off when neither provider nor
+	// model is set.
+	LLM LLMConfig `yaml:"llm"`
+	// SampleRate is the share of runs repeated through the canary
+	// (default 1).
+	SampleRate *float64 `yaml:"sample_rate"`
+}
+
+// canaryRunner pairs runs with dry runs through the canary model.
+type canaryRunner struct {
+	llm        LLMConfig
+	key        string
+	sampleRate float64
+}
+
+// canary is nil when no canary is configured.
+var canary *canaryRunner
+
+// configCanary returns the canary settings, and whether a canary is
+// configured.
+func configCanary(cfg *Config) (CanaryConfig, bool) {
+	out := cfg.Canary
+	if out.LLM.Provider == "" && out.LLM.Model == "" {
+		return out, false
+	}
+	out.LLM = withLLMDefaults(out.LLM)
+	if out.SampleRate == nil {
+		rate := 1.0
+		out.SampleRate = &rate
+	}
+	return out, true
+}
+
+func validateCanary(cc CanaryConfig, ok bool) []string {
+	if !ok {
+		return nil
+	}
+	var problems []string
+	for _, p := range validateLLM(cc.LLM) {
+		problems = append(problems, "canary."+p)
+	}
+	if key, env := llmAPIKey(cc.LLM); key == "" && cc.LLM.BaseURL == "" && env != "" {
+		problems = append(problems, fmt.Sprintf("canary.llm: %s must be set for the canary's provider", env))
+	}
+	if rate := *cc.SampleRate; rate < 0 || rate > 1 {
+		problems = append(problems, fmt.Sprintf("canary.sample_rate: %v must be between 0 and 1", rate))
+	}
+	return problems
+}
+
+func newCanaryRunner(cc CanaryConfig, ok bool) *canaryRunner {
+	if !ok {
+		return nil
+	}
+	key, _ := llmAPIKey(cc.LLM)
+	return &canaryRunner{llm: cc.LLM, key: key, sampleRate: *cc.SampleRate}
+}
+
+// isCanaryRun reports whether a run ID is that of a canary run.
+func isCanaryRun(id string) bool {
+	return strings.HasPrefix(id, canaryRunPrefix)
+}
+
+// CanaryRun is the dry run of a run through the canary model.
+type CanaryRun struct {
+	Provider string        `json:"provider"`
+	Model    string        `json:"model"`
+	Result   *TriageResult `json:"result,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	// Agrees is set when the canary reached the run's verdict: the same
+	// action and, for a duplicate, the same issue.
+	Agrees bool        `json:"agrees"`
+	Usage  *TokenUsage `json:"usage,omitempty"`
+	// LatencyMS is the time spent in model calls.
+	LatencyMS int64 `json:"latency_ms"`
+}
+
+// agrees reports whether the canary's result matches the run's.
+func agrees(run, canary TriageResult) bool {
+	if run.Action != canary.Action {
+		return false
+	}
+	return run.Action != runOutcomeDuplicate || run.IssueNumber == canary.IssueNumber
+}
+
+// pair repeats a finished run as a dry run through the canary model, for a
+// sample of the runs, and records it on the run. The dry run searches the
+// tracker like the run, but writes nothing.
+func (c *canaryRunner) pair(ctx context.Context, run TriageRun, target repoTarget, analysis *LogAnalysis, result TriageResult) {
+	if rand.Float64() >= c.sampleRate {
+		return
+	}
+	id := canaryRunPrefix + run.ID
+	a := *analysis
+	session := newToolSession(target, true)
+	session.ctx = contextWithRunID(ctx, id)
+	session.log = requestLogger(ctx).With("run_id", run.ID, "canary", c.llm.Model)
+	session.allowLabels(a.Labels...)
+	session.analysis, session.errorLog = &a, run.ErrorLog
+
+	var out bytes.Buffer
+	_, err := newTriagePipeline(session.ctx, session, newLLM(c.llm, c.key, id), llmOptionsFor(c.llm)).Run(session.ctx, buildAgentInput(run.ErrorLog, &a), id, &out)
+	iterations := llmUsage.take(id)
+	toolCalls.take(id)
+
+	cr := CanaryRun{Provider: c.llm.Provider, Model: c.llm.Model}
+	for _, it := range iterations {
+		if cr.Usage == nil {
+			cr.Usage = &TokenUsage{}
+		}
+		cr.Usage.add(it.TokenUsage)
+		cr.LatencyMS += it.DurationMS
+	}
+	if err != nil {
+		cr.Error = err.Error()
+		session.log.Warn("Canary run failed", "error", err)
+		metrics.add("triage_canary_runs_total", `agrees="failed"`, 1)
+	} else {
+		canaryResult := session.triageResult()
+		cr.Result, cr.Agrees = &canaryResult, agrees(result, canaryResult)
+		metrics.add("triage_canary_runs_total", labelSet("agrees", fmt.Sprint(cr.Agrees)), 1)
+	}
+	runs.recordCanary(run.ID, cr)
+}
+
+// CanaryModelStats sums up one side of the paired runs.
+type CanaryModelStats struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	// Tokens and MeanTokens are the token usage of the runs, in total and
+	// per run.
+	Tokens     int     `json:"tokens"`
+	MeanTokens float64 `json:"mean_tokens"`
+	// MeanLatencyMS and P95LatencyMS are the time spent in model calls per
+	// run.
+	MeanLatencyMS float64 `json:"mean_latency_ms"`
+	P95LatencyMS  int64   `json:"p95_latency_ms"`
+
+	latencies []int64
+}
+
+func (s *CanaryModelStats) add(usage *TokenUsage, latencyMS int64) {
+	if usage != nil {
+		s.Tokens += usage.TotalTokens
+	}
+	s.latencies = append(s.latencies, latencyMS)
+}
+
+func (s *CanaryModelStats) finalize() {
+	n := len(s.latencies)
+	if n == 0 {
+		return
+	}
+	s.MeanTokens = float64(s.Tokens) / float64(n)
+	var total int64
+	for _, l := range s.latencies {
+		total += l
+	}
+	s.MeanLatencyMS = float64(total) / float64(n)
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	s.P95LatencyMS = s.latencies[(n*95-1)/100]
+}
+
+// CanaryReport compares the runs with their canary runs.
+type CanaryReport struct {
+	Since time.Time `json:"since"`
+	// Paired counts the runs with a canary run, Failed the canary runs
+	// that failed, and Agreed those that reached the run's verdict.
+	// AgreementRate is Agreed over the canary runs that didn't fail.
+	Paired        int              `json:"paired"`
+	Failed        int              `json:"failed"`
+	Agreed        int              `json:"agreed"`
+	AgreementRate *float64         `json:"agreement_rate,omitempty"`
+	Primary       CanaryModelStats `json:"primary"`
+	Canary        CanaryModelStats `json:"canary"`
+	// Disagreements lists the runs whose canary run reached another
+	// verdict, most recent first.
+	Disagreements []CanaryDisagreement `json:"disagreements,omitempty"`
+}
+
+// CanaryDisagreement is a run whose canary run reached another verdict.
+type CanaryDisagreement struct {
+	RunID  string        `json:"run_id"`
+	Run    *TriageResult `json:"run"`
+	Canary *TriageResult `json:"canary"`
+}
+
+// maxCanaryDisagreements caps the disagreements listed in the report.
+const maxCanaryDisagreements = 20
+
+// buildCanaryReport compares the runs since the given time with their
+// canary runs.
+func buildCanaryReport(since time.Time) *CanaryReport {
+	report := &CanaryReport{Since: since}
+	for _, run := range runs.list("") {
+		if run.Canary == nil || run.StartedAt.Before(since) {
+			continue
+		}
+		c := run.Canary
+		report.Paired++
+		report.Canary.Provider, report.Canary.Model = c.Provider, c.Model
+		if c.Error != "" {
+			report.Failed++
+			continue
+		}
+		var latency int64
+		for _, it := range run.Iterations {
+			latency += it.DurationMS
+		}
+		report.Primary.add(run.Usage, latency)
+		report.Canary.add(c.Usage, c.LatencyMS)
+		if c.Agrees {
+			report.Agreed++
+		} else if len(report.Disagreements) < maxCanaryDisagreements {
+			report.Disagreements = append(report.Disagreements, CanaryDisagreement{RunID: run.ID, Run: run.Result, Canary: c.Result})
+		}
+	}
+	if n := report.Paired - report.Failed; n > 0 {
+		rate := float64(report.Agreed) / float64(n)
+		report.AgreementRate = &rate
+	}
+	report.Primary.Provider, report.Primary.Model = llmConfig.Provider, llmConfig.Model
+	report.Primary.finalize()
+	report.Canary.finalize()
+	return report
+}
+
+// handleCanaryReport serves the comparison of the runs with their canary
+// runs. The period defaults to the last 7 days, or is set with ?since=
+// as a duration.
+func handleCanaryReport(w http.ResponseWriter, r *http.Request) {
+	window := defaultReportWindow
+	if s := r.URL.Query().Get("since"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid since %q, expected a duration such as 168h", s), http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+	writeJSON(w, http.StatusOK, buildCanaryReport(time.Now().Add(-window)))
+}
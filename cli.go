@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const adminUsage = `Usage: triage admin [-url URL] [-token TOKEN] <command>
+
+Commands:
+  runs list [-status running|succeeded|failed]
+  runs show <run-id>
+  deadletter list
+  deadletter retry <run-id>
+  config validate
+  fallback resubmit
+  issues relabel [-repo owner/repo] [-dry-run]
+  prompt test [-repo owner/repo] [file]   (reads the log from stdin without a file)
+  report [-since 720h]
+  fingerprints list [-state STATE]
+  fingerprints show [-repo owner/repo] <fingerprint>
+  fingerprints transition [-repo owner/repo] [-fix-version V] <fingerprint> <state> [reason]
+  fingerprints remap [-repo owner/repo] <fingerprint> <issue-url> [reason]
+  fingerprints split [-repo owner/repo] <fingerprint> [reason]
+  services list
+  services show <name>
+  services set [-repo owner/repo] [-owners a,b] [-labels a,b] [-runbooks URL,URL] [-severity S] <name>
+  services delete <name>
+  flags list
+  flags set [-repos owner/repo=on,owner/repo=off] <flag> [on|off]
+  flags clear <flag>
+
+The server URL and token default to TRIAGE_URL and ADMIN_TOKEN.
+`
+
+// adminClient talks to the /admin API of a running server.
+type adminClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// runAdminCLI implements "triage admin ..." and returns the exit code.
+func runAdminCLI(args []string) int {
+	fs := flag.NewFlagSet("admin", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, adminUsage) }
+
+	baseURL := os.Getenv("TRIAGE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8000"
+	}
+	fs.StringVar(&baseURL, "url", baseURL, "server URL")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "admin token")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	client := &adminClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   *token,
+		http:    &http.Client{Timeout: 5 * time.Minute},
+	}
+
+	rest := fs.Args()
+	if len(rest) > 0 && rest[0] == "report" {
+		if err := client.report(rest[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	if len(rest) < 2 {
+		fs.Usage()
+		return 2
+	}
+
+	var err error
+	switch rest[0] + " " + rest[1] {
+	case "runs list":
+		err = client.runsList(rest[2:])
+	case "runs show":
+		err = client.runShow(rest[2:])
+	case "deadletter list":
+		err = client.printRuns("/admin/deadletters")
+	case "deadletter retry":
+		err = client.deadLetterRetry(rest[2:])
+	case "config validate":
+		err = client.configValidate()
+	case "fallback resubmit":
+		err = client.fallbackResubmit()
+	case "issues relabel":
+		err = client.issuesRelabel(rest[2:])
+	case "prompt test":
+		err = client.promptTest(rest[2:])
+	case "fingerprints list":
+		err = client.fingerprintsList(rest[2:])
+	case "fingerprints show":
+		err = client.fingerprintShow(rest[2:])
+	case "fingerprints transition":
+		err = client.fingerprintTransition(rest[2:])
+	case "fingerprints remap":
+		err = client.fingerprintRemap(rest[2:])
+	case "fingerprints split":
+		err = client.fingerprintSplit(rest[2:])
+	case "services list":
+		err = client.servicesList()
+	case "services show":
+		err = client.serviceShow(rest[2:])
+	case "services set":
+		err = client.serviceSet(rest[2:])
+	case "services delete":
+		err = client.serviceDelete(rest[2:])
+	case "flags list":
+		err = client.flagsList()
+	case "flags set":
+		err = client.flagSet(rest[2:])
+	case "flags clear":
+		err = client.flagClear(rest[2:])
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func (c *adminClient) do(method, path string, body any, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func (c *adminClient) runsList(args []string) error {
+	fs := flag.NewFlagSet("runs list", flag.ContinueOnError)
+	status := fs.String("status", "", "only list runs with this status")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path := "/admin/runs"
+	if *status != "" {
+		path += "?status=" + *status
+	}
+	return c.printRuns(path)
+}
+
+func (c *adminClient) printRuns(path string) error {
+	var list []TriageRun
+	if err := c.do(http.MethodGet, path, nil, &list); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tSTARTED\tREPOSITORY\tFINGERPRINT\tISSUE")
+	for _, run := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", run.ID, run.Status, run.StartedAt.Format(time.RFC3339), run.Repository, run.Fingerprint, run.IssueURL)
+	}
+	return tw.Flush()
+}
+
+func (c *adminClient) runShow(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: triage admin runs show <run-id>")
+	}
+
+	var run TriageRun
+	if err := c.do(http.MethodGet, "/admin/runs/"+args[0], nil, &run); err != nil {
+		return err
+	}
+	printJSON(run)
+	return nil
+}
+
+func (c *adminClient) deadLetterRetry(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: triage admin deadletter retry <run-id>")
+	}
+
+	var resp APIResponse
+	if err := c.do(http.MethodPost, "/admin/deadletters/"+args[0]+"/retry", nil, &resp); err != nil {
+		return err
+	}
+	printJSON(resp)
+	return nil
+}
+
+func (c *adminClient) configValidate() error {
+	var result configValidation
+	if err := c.do(http.MethodGet, "/admin/config/validate", nil, &result); err != nil {
+		return err
+	}
+
+	if result.Valid {
+		fmt.Println("Configuration is valid.")
+		return nil
+	}
+	for _, p := range result.Problems {
+		fmt.Println("- " + p)
+	}
+	return fmt.Errorf("%d configuration problem(s)", len(result.Problems))
+}
+
+func (c *adminClient) fallbackResubmit() error {
+	var result resubmitResult
+	if err := c.do(http.MethodPost, "/admin/fallback/resubmit", nil, &result); err != nil {
+		return err
+	}
+
+	for _, url := range result.Submitted {
+		fmt.Println("Created " + url)
+	}
+	for _, e := range result.Errors {
+		fmt.Println("Failed: " + e)
+	}
+	fmt.Printf("%d submitted, %d still stored.\n", len(result.Submitted), result.Remaining)
+	return nil
+}
+
+func (c *adminClient) issuesRelabel(args []string) error {
+	fs := flag.NewFlagSet("issues relabel", flag.ContinueOnError)
+	repo := fs.String("repo", "", "repository to relabel (default: the default repository)")
+	dryRun := fs.Bool("dry-run", false, "list the changes without making them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var result relabelResult
+	if err := c.do(http.MethodPost, "/admin/issues/relabel", relabelRequest{Repository: *repo, DryRun: *dryRun}, &result); err != nil {
+		return err
+	}
+	verb := "Updated"
+	if result.DryRun {
+		verb = "Would update"
+	}
+	for _, issue := range result.Updated {
+		var changes []string
+		if len(issue.Labels) > 0 {
+			changes = append(changes, "labels "+strings.Join(issue.Labels, ", "))
+		}
+		if len(issue.Assignees) > 0 {
+			changes = append(changes, "assignees @"+strings.Join(issue.Assignees, ", @"))
+		}
+		fmt.Printf("%s %s: %s\n", verb, issue.URL, strings.Join(changes, "; "))
+	}
+	for _, s := range result.Skipped {
+		fmt.Println("Skipped " + s)
+	}
+	for _, e := range result.Errors {
+		fmt.Println("Failed: " + e)
+	}
+	fmt.Printf("%d agent issues in %s, %d to update.\n", result.Scanned, result.Repository, len(result.Updated))
+	return nil
+}
+
+func (c *adminClient) promptTest(args []string) error {
+	fs := flag.NewFlagSet("prompt test", flag.ContinueOnError)
+	repo := fs.String("repo", "", "render the prompt for this repository")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var errorLog []byte
+	var err error
+	if fs.NArg() > 0 {
+		errorLog, err = os.ReadFile(fs.Arg(0))
+	} else {
+		errorLog, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+
+	var result promptTestResponse
+	req := ErrorLogRequest{ErrorLog: string(errorLog), Repository: *repo}
+	if err := c.do(http.MethodPost, "/admin/prompt/test", req, &result); err != nil {
+		return err
+	}
+
+	fmt.Printf("Repository: %s\nFingerprint: %s\n\n--- System prompt ---\n%s\n\n--- Agent input ---\n%s\n",
+		result.Repository, result.Analysis.Fingerprint, strings.TrimSpace(result.SystemPrompt), result.AgentInput)
+	return nil
+}
+
+func (c *adminClient) fingerprintsList(args []string) error {
+	fs := flag.NewFlagSet("fingerprints list", flag.ContinueOnError)
+	state := fs.String("state", "", "only list fingerprints in this state")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path := "/admin/fingerprints"
+	if *state != "" {
+		path += "?state=" + *state
+	}
+	var list []FingerprintState
+	if err := c.do(http.MethodGet, path, nil, &list); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FINGERPRINT\tSTATE\tSEEN\tLAST SEEN\tREPOSITORY\tISSUE")
+	for _, st := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", st.Fingerprint, st.State, st.Occurrences, st.LastSeen.Format(time.RFC3339), st.Repository, st.IssueURL)
+	}
+	return tw.Flush()
+}
+
+// fingerprintPath is the admin path of a fingerprint, in a repository if
+// one is given.
+func fingerprintPath(fingerprint, suffix, repo string) string {
+	path := "/admin/fingerprints/" + fingerprint + suffix
+	if repo != "" {
+		path += "?repository=" + url.QueryEscape(repo)
+	}
+	return path
+}
+
+// fingerprintFlags returns the flags of a fingerprint command, with its
+// -repo flag.
+func fingerprintFlags(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	repo := fs.String("repo", "", "repository of the fingerprint, if it is tracked in several")
+	return fs, repo
+}
+
+func (c *adminClient) fingerprintShow(args []string) error {
+	fs, repo := fingerprintFlags("fingerprints show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) != 1 {
+		return fmt.Errorf("usage: triage admin fingerprints show [-repo owner/repo] <fingerprint>")
+	}
+
+	var st FingerprintState
+	if err := c.do(http.MethodGet, fingerprintPath(args[0], "", *repo), nil, &st); err != nil {
+		return err
+	}
+	printJSON(st)
+	return nil
+}
+
+func (c *adminClient) fingerprintTransition(args []string) error {
+	fs, repo := fingerprintFlags("fingerprints transition")
+	fixVersion := fs.String("fix-version", "", "release the fix shipped in")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) < 2 {
+		return fmt.Errorf("usage: triage admin fingerprints transition [-repo owner/repo] [-fix-version V] <fingerprint> <state> [reason]")
+	}
+
+	req := transitionRequest{State: args[1], Reason: strings.Join(args[2:], " "), FixVersion: *fixVersion}
+	var st FingerprintState
+	if err := c.do(http.MethodPost, fingerprintPath(args[0], "/transition", *repo), req, &st); err != nil {
+		return err
+	}
+	fmt.Printf("%s is now %s\n", st.Fingerprint, st.State)
+	return nil
+}
+
+func (c *adminClient) fingerprintRemap(args []string) error {
+	fs, repo := fingerprintFlags("fingerprints remap")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) < 2 {
+		return fmt.Errorf("usage: triage admin fingerprints remap [-repo owner/repo] <fingerprint> <issue-url> [reason]")
+	}
+	return c.remap(args[0], *repo, remapRequest{IssueURL: args[1], Reason: strings.Join(args[2:], " ")})
+}
+
+func (c *adminClient) fingerprintSplit(args []string) error {
+	fs, repo := fingerprintFlags("fingerprints split")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) < 1 {
+		return fmt.Errorf("usage: triage admin fingerprints split [-repo owner/repo] <fingerprint> [reason]")
+	}
+	return c.remap(args[0], *repo, remapRequest{Reason: strings.Join(args[1:], " ")})
+}
+
+func (c *adminClient) remap(fingerprint, repo string, req remapRequest) error {
+	var st FingerprintState
+	if err := c.do(http.MethodPost, fingerprintPath(fingerprint, "/remap", repo), req, &st); err != nil {
+		return err
+	}
+	if st.IssueURL == "" {
+		fmt.Printf("%s is split from its issue and will be triaged as a new error\n", st.Fingerprint)
+		return nil
+	}
+	fmt.Printf("%s is now linked to %s\n", st.Fingerprint, st.IssueURL)
+	return nil
+}
+
+func (c *adminClient) servicesList() error {
+	var list []Service
+	if err := c.do(http.MethodGet, "/admin/services", nil, &list); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSOURCE\tREPOSITORY\tSEVERITY\tOWNERS\tLABELS")
+	for _, s := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, s.Source, s.Repository, s.Severity, strings.Join(s.Owners, ","), strings.Join(s.Labels, ","))
+	}
+	return tw.Flush()
+}
+
+func (c *adminClient) serviceShow(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: triage admin services show <name>")
+	}
+
+	var s Service
+	if err := c.do(http.MethodGet, "/admin/services/"+args[0], nil, &s); err != nil {
+		return err
+	}
+	printJSON(s)
+	return nil
+}
+
+func (c *adminClient) serviceSet(args []string) error {
+	fs := flag.NewFlagSet("services set", flag.ContinueOnError)
+	repo := fs.String("repo", "", "repository for the service's issues (owner/repo)")
+	owners := fs.String("owners", "", "comma-separated GitHub handles")
+	labels := fs.String("labels", "", "comma-separated labels")
+	runbooks := fs.String("runbooks", "", "comma-separated runbook URLs")
+	severity := fs.String("severity", "", "severity override: "+strings.Join(severities, ", "))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: triage admin services set [flags] <name>")
+	}
+
+	s := Service{
+		Repository: *repo,
+		Owners:     splitList(*owners),
+		Labels:     splitList(*labels),
+		Runbooks:   splitList(*runbooks),
+		Severity:   *severity,
+	}
+	if err := c.do(http.MethodPut, "/admin/services/"+fs.Arg(0), s, &s); err != nil {
+		return err
+	}
+	printJSON(s)
+	return nil
+}
+
+func (c *adminClient) serviceDelete(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: triage admin services delete <name>")
+	}
+	if err := c.do(http.MethodDelete, "/admin/services/"+args[0], nil, nil); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted service %s\n", args[0])
+	return nil
+}
+
+func (c *adminClient) flagsList() error {
+	var list []FlagStatus
+	if err := c.do(http.MethodGet, "/admin/flags", nil, &list); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FLAG\tDEFAULT\tCONFIG\tOVERRIDE")
+	for _, f := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.Name, onOff(f.Default), describeFlag(f.Config), describeFlag(f.Override))
+	}
+	return tw.Flush()
+}
+
+// describeFlag summarizes a flag's settings, e.g. "off, myorg/api=on".
+func describeFlag(f *FeatureFlag) string {
+	if f == nil {
+		return "-"
+	}
+	var parts []string
+	if f.Enabled != nil {
+		parts = append(parts, onOff(*f.Enabled))
+	}
+	for _, repo := range sortedKeys(f.Repos) {
+		parts = append(parts, repo+"="+onOff(f.Repos[repo]))
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// flagSet replaces the override of a flag. Without on or off, only the
+// listed repositories are overridden.
+func (c *adminClient) flagSet(args []string) error {
+	fs := flag.NewFlagSet("flags set", flag.ContinueOnError)
+	repos := fs.String("repos", "", "comma-separated owner/repo=on|off")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return fmt.Errorf("usage: triage admin flags set [-repos owner/repo=on,...] <flag> [on|off]")
+	}
+
+	var f FeatureFlag
+	if fs.NArg() == 2 {
+		on, err := parseOnOff(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		f.Enabled = &on
+	}
+	for _, entry := range splitList(*repos) {
+		repo, state, _ := strings.Cut(entry, "=")
+		on, err := parseOnOff(state)
+		if err != nil {
+			return fmt.Errorf("-repos %s: %w", entry, err)
+		}
+		if f.Repos == nil {
+			f.Repos = make(map[string]bool)
+		}
+		f.Repos[repo] = on
+	}
+
+	var status FlagStatus
+	if err := c.do(http.MethodPut, "/admin/flags/"+fs.Arg(0), f, &status); err != nil {
+		return err
+	}
+	printJSON(status)
+	return nil
+}
+
+func parseOnOff(s string) (bool, error) {
+	switch s {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected on or off, got %q", s)
+}
+
+func (c *adminClient) flagClear(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: triage admin flags clear <flag>")
+	}
+	if err := c.do(http.MethodDelete, "/admin/flags/"+args[0], nil, nil); err != nil {
+		return err
+	}
+	fmt.Printf("Removed the override of %s\n", args[0])
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func (c *adminClient) report(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	since := fs.String("since", "", "report period, e.g. 168h (default 720h)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path := "/admin/report"
+	if *since != "" {
+		path += "?since=" + *since
+	}
+	var report UsageReport
+	if err := c.do(http.MethodGet, path, nil, &report); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Since %s\n\n", report.Since.Format(time.RFC3339))
+	fmt.Fprintln(tw, "SCOPE\tRUNS\tCREATED\tDUPLICATE RATE\tMEAN TIME TO ISSUE\tLLM ISSUES\tACCEPTANCE")
+	row := func(scope string, s *UsageStats) {
+		acceptance := "-"
+		if s.AcceptanceRate != nil {
+			acceptance = fmt.Sprintf("%.0f%%", *s.AcceptanceRate*100)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.0f%%\t%s\t%d\t%s\n", scope, s.Runs, s.IssuesCreated, s.DuplicateRate*100,
+			time.Duration(s.MeanTimeToIssue*float64(time.Second)).Round(time.Second), s.LLMIssues, acceptance)
+	}
+	for _, name := range sortedKeys(report.Teams) {
+		row("team "+name, report.Teams[name])
+	}
+	for _, name := range sortedKeys(report.Repositories) {
+		row(name, report.Repositories[name])
+	}
+	row("total", report.Total)
+	return tw.Flush()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
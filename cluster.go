@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	// simhashMaxDistance is the largest Hamming distance between two log
+	// SimHashes that still counts as a near-duplicate.
+	simhashMaxDistance = 6
+	// simhashBands splits the 64-bit hash for lookup. With more bands than
+	// simhashMaxDistance, any near-duplicate shares at least one band.
+	simhashBands = 8
+	// maxClusterEntries caps the near-duplicate index.
+	maxClusterEntries = 5000
+)
+
+var (
+	tokenPattern = regexp.MustCompile(`<[a-z]+>|[a-z_][a-z0-9_]*`)
+	// hostnamePattern matches pod and host names with generated suffixes,
+	// e.g. "api-7f9c8d6b5-x2kqz" or "ip-10-0-1-12".
+	hostnamePattern = regexp.MustCompile(`\b[a-z][a-z0-9]*(-[a-z0-9]+){2,}\b`)
+)
+
+// simhashLog computes a 64-bit SimHash over the normalized log. Logs that
+// differ only in IDs, hosts, addresses or line numbers get hashes a few bits
+// apart, which the fingerprint (exact by design) can't express.
+func simhashLog(errorLog string) uint64 {
+	tokens := tokenPattern.FindAllString(simhashText(errorLog), -1)
+
+	var weights [64]int
+	add := func(shingle string) {
+		h := fnv.New64a()
+		h.Write([]byte(shingle))
+		sum := h.Sum64()
+		for i := range weights {
+			if sum&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	// Three-token shingles keep some word order; short logs fall back to
+	// single tokens.
+	if len(tokens) < 3 {
+		for _, t := range tokens {
+			add(t)
+		}
+	}
+	for i := 0; i+3 <= len(tokens); i++ {
+		add(strings.Join(tokens[i:i+3], " "))
+	}
+
+	var hash uint64
+	for i, w := range weights {
+		if w > 0 {
+			hash |= 1 << i
+		}
+	}
+	return hash
+}
+
+// simhashText is the normalized log the SimHash is computed over.
+func simhashText(errorLog string) string {
+	text := hostnamePattern.ReplaceAllString(strings.ToLower(errorLog), "<host>")
+	return normalizeMessage(text)
+}
+
+type clusterEntry struct {
+	hash        uint64
+	fingerprint string
+	cluster     string
+}
+
+// clusterIndex groups near-duplicate logs. Each cluster is named after the
+// fingerprint of its first log, and remembers every fingerprint seen in it.
+type clusterIndex struct {
+	mu      sync.Mutex
+	entries []*clusterEntry
+	bands   [simhashBands]map[uint8][]*clusterEntry
+	members map[string][]string
+}
+
+var clusters = newClusterIndex()
+
+func newClusterIndex() *clusterIndex {
+	c := &clusterIndex{members: make(map[string][]string)}
+	for i := range c.bands {
+		c.bands[i] = make(map[uint8][]*clusterEntry)
+	}
+	return c
+}
+
+func bandKey(hash uint64, band int) uint8 {
+	return uint8(hash >> (8 * band))
+}
+
+// assign places the analysed log in a cluster and fills in Cluster and
+// RelatedFingerprints: the other fingerprints of near-duplicate logs seen
+// before.
+func (c *clusterIndex) assign(analysis *LogAnalysis) {
+	var hash uint64
+	fmt.Sscanf(analysis.SimHash, "%x", &hash)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var nearest *clusterEntry
+	best := simhashMaxDistance + 1
+	for band := range c.bands {
+		for _, e := range c.bands[band][bandKey(hash, band)] {
+			if d := bits.OnesCount64(e.hash ^ hash); d < best {
+				nearest, best = e, d
+			}
+		}
+	}
+
+	cluster := analysis.Fingerprint
+	if nearest != nil {
+		cluster = nearest.cluster
+	}
+	analysis.Cluster = cluster
+	for _, fp := range c.members[cluster] {
+		if fp != analysis.Fingerprint {
+			analysis.RelatedFingerprints = append(analysis.RelatedFingerprints, fp)
+		}
+	}
+
+	if nearest != nil && nearest.hash == hash && nearest.fingerprint == analysis.Fingerprint {
+		return
+	}
+	if !slices.Contains(c.members[cluster], analysis.Fingerprint) {
+		c.members[cluster] = append(c.members[cluster], analysis.Fingerprint)
+	}
+	c.add(&clusterEntry{hash: hash, fingerprint: analysis.Fingerprint, cluster: cluster})
+}
+
+func (c *clusterIndex) add(e *clusterEntry) {
+	if len(c.entries) >= maxClusterEntries {
+		c.remove(c.entries[0])
+		c.entries = c.entries[1:]
+	}
+	c.entries = append(c.entries, e)
+	for band := range c.bands {
+		key := bandKey(e.hash, band)
+		c.bands[band][key] = append(c.bands[band][key], e)
+	}
+}
+
+func (c *clusterIndex) remove(e *clusterEntry) {
+	for band := range c.bands {
+		key := bandKey(e.hash, band)
+		c.bands[band][key] = slices.DeleteFunc(c.bands[band][key], func(x *clusterEntry) bool { return x == e })
+		if len(c.bands[band][key]) == 0 {
+			delete(c.bands[band], key)
+		}
+	}
+}
+
+// clusterMatch is a cluster near a log, by the distance of its closest
+// entry.
+type clusterMatch struct {
+	Cluster     string `json:"cluster"`
+	Fingerprint string `json:"fingerprint"`
+	Distance    int    `json:"distance"`
+	// NearDuplicate is set when the log would be assigned to the cluster.
+	NearDuplicate bool     `json:"near_duplicate"`
+	Members       []string `json:"members"`
+}
+
+// nearest returns up to n clusters closest to a SimHash, at any distance,
+// without assigning the log.
+func (c *clusterIndex) nearest(simhash string, n int) []clusterMatch {
+	var hash uint64
+	fmt.Sscanf(simhash, "%x", &hash)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	best := make(map[string]clusterMatch)
+	for _, e := range c.entries {
+		d := bits.OnesCount64(e.hash ^ hash)
+		if m, ok := best[e.cluster]; ok && m.Distance <= d {
+			continue
+		}
+		best[e.cluster] = clusterMatch{Cluster: e.cluster, Fingerprint: e.fingerprint, Distance: d, NearDuplicate: d <= simhashMaxDistance}
+	}
+	out := make([]clusterMatch, 0, len(best))
+	for _, m := range best {
+		m.Members = slices.Clone(c.members[m.Cluster])
+		out = append(out, m)
+	}
+	slices.SortFunc(out, func(a, b clusterMatch) int {
+		if a.Distance != b.Distance {
+			return a.Distance - b.Distance
+		}
+		return strings.Compare(a.Cluster, b.Cluster)
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// codeOwnersMaxAge is how long a repository's CODEOWNERS file is reused
+// before it is fetched again.
+const codeOwnersMaxAge = 10 * time.Minute
+
+// codeOwnersPaths are where GitHub looks for the CODEOWNERS file, in the
+// order it does.
+var codeOwnersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// codeOwnersRule is a line of a CODEOWNERS file: a path pattern and the
+// users and teams that own the matching files.
+type codeOwnersRule struct {
+	Pattern string
+	Owners  []string
+	re      *regexp.Regexp
+}
+
+// parseCodeOwners reads the rules of a CODEOWNERS file. Invalid patterns
+// are skipped, as GitHub does.
+func parseCodeOwners(text string) []codeOwnersRule {
+	var rules []codeOwnersRule
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		re, err := codeOwnersPattern(fields[0])
+		if err != nil {
+			continue
+		}
+		rules = append(rules, codeOwnersRule{Pattern: fields[0], Owners: fields[1:], re: re})
+	}
+	return rules
+}
+
+// codeOwnersPattern compiles a CODEOWNERS pattern, which follows gitignore
+// rules: patterns with a leading or inner slash are relative to the
+// repository root, others match at any depth, and a pattern matching a
+// directory matches everything in it.
+func codeOwnersPattern(pattern string) (*regexp.Regexp, error) {
+	p := strings.TrimSuffix(pattern, "/")
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return nil, fmt.Errorf("empty pattern %q", pattern)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	if !anchored {
+		sb.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(p); i++ {
+		switch {
+		case strings.HasPrefix(p[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(p[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case p[i] == '*':
+			sb.WriteString("[^/]*")
+		case p[i] == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		}
+	}
+	sb.WriteString("(?:/.*)?$")
+	return regexp.Compile(sb.String())
+}
+
+// codeOwnersOf returns the owners of a file: those of the last matching
+// rule, as in GitHub. A matching rule without owners leaves the file
+// unowned.
+func codeOwnersOf(rules []codeOwnersRule, file string) (owners []string, pattern string) {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].re.MatchString(file) {
+			return rules[i].Owners, rules[i].Pattern
+		}
+	}
+	return nil, ""
+}
+
+// codeOwnersCache keeps the CODEOWNERS rules of each repository, as every
+// issue filed in a repository reads the same file.
+type codeOwnersCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedCodeOwners
+}
+
+type cachedCodeOwners struct {
+	rules []codeOwnersRule
+	at    time.Time
+}
+
+var codeOwners = &codeOwnersCache{entries: make(map[string]cachedCodeOwners)}
+
+// rules returns the CODEOWNERS rules of a repository's default branch, or
+// nil if it has no CODEOWNERS file.
+func (c *codeOwnersCache) rules(ctx context.Context, target repoTarget) ([]codeOwnersRule, error) {
+	key := strings.ToLower(target.String())
+	c.mu.Lock()
+	cached, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Since(cached.at) < codeOwnersMaxAge {
+		return cached.rules, nil
+	}
+
+	var rules []codeOwnersRule
+	for _, file := range codeOwnersPaths {
+		content, _, resp, err := ghClient.Repositories.GetContents(ctx, target.Owner, target.Repo, file, nil)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if content == nil || content.GetType() != "file" {
+			continue
+		}
+		text, err := content.GetContent()
+		if err != nil {
+			return nil, err
+		}
+		rules = parseCodeOwners(text)
+		break
+	}
+
+	c.mu.Lock()
+	c.entries[key] = cachedCodeOwners{rules: rules, at: time.Now()}
+	c.mu.Unlock()
+	return rules, nil
+}
+
+// fileOwners are the CODEOWNERS owners of a file of the stack trace.
+type fileOwners struct {
+	// Path is the path in the repository the stack trace file matched.
+	Path    string
+	Pattern string
+	Owners  []string
+}
+
+// resolveCodeOwners maps stack trace files to their owners in the target
+// repository's CODEOWNERS file. Stack trace paths are tried as in
+// suggest_owners, and the first that exists in the repository is used, as
+// a catch-all pattern would match any of them. Files without owners are
+// left out.
+func resolveCodeOwners(ctx context.Context, target repoTarget, files []string) ([]fileOwners, error) {
+	rules, err := codeOwners.rules(ctx, target)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+
+	var out []fileOwners
+	for _, file := range files {
+		candidate, found, err := repoFilePath(ctx, target, file)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		if owners, pattern := codeOwnersOf(rules, candidate); len(owners) > 0 {
+			out = append(out, fileOwners{Path: candidate, Pattern: pattern, Owners: owners})
+		}
+	}
+	return out, nil
+}
+
+// repoFilePath returns the first path candidate of a stack trace file that
+// exists in the repository.
+func repoFilePath(ctx context.Context, target repoTarget, file string) (string, bool, error) {
+	for _, candidate := range repoPathCandidates(file) {
+		content, _, resp, err := ghClient.Repositories.GetContents(ctx, target.Owner, target.Repo, candidate, nil)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return "", false, err
+		}
+		if content != nil && content.GetType() == "file" {
+			return candidate, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// splitOwners separates the owners of files into users and teams, keeping
+// their order and dropping duplicates. Owners given as email addresses
+// have no GitHub handle to assign or mention and are dropped.
+func splitOwners(owned []fileOwners) (users, teams []string) {
+	for _, f := range owned {
+		for _, owner := range f.Owners {
+			switch {
+			case !strings.HasPrefix(owner, "@"):
+			case strings.Contains(owner, "/"):
+				teams = appendNew(teams, owner)
+			default:
+				users = appendNew(users, strings.TrimPrefix(owner, "@"))
+			}
+		}
+	}
+	return users, teams
+}
+
+// formatCodeOwners lists the owners of each file for the agent.
+func formatCodeOwners(owned []fileOwners) string {
+	var lines []string
+	for _, f := range owned {
+		lines = append(lines, fmt.Sprintf("- %s: %s (CODEOWNERS pattern %s)", f.Path, strings.Join(f.Owners, " "), f.Pattern))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// appFrameFiles returns the files of an analysis's application frames,
+// innermost first, up to maxOwnerFiles.
+func appFrameFiles(analysis *LogAnalysis) []string {
+	var files []string
+	for _, f := range analysis.Frames {
+		if f.InApp && f.File != "" {
+			files = appendNew(files, f.File)
+		}
+	}
+	if len(files) > maxOwnerFiles {
+		files = files[:maxOwnerFiles]
+	}
+	return files
+}
+
+// assignIssue assigns an issue the run created to the CODEOWNERS users of
+// the stack trace files. Teams can't be assigned: the agent mentions them
+// in the issue body instead, from suggest_owners. Users aren't assigned
+// either when the auto_assign flag is off or the tracker has no GitHub
+// users; the agent mentions them in the body too.
+func (t *toolSession) assignIssue(number int, files []string) error {
+	if err := checkAction(t.target, actionAssign, nil); err != nil {
+		t.log.Info("Not assigning the new issue", "issue_number", number, "reason", err)
+		return nil
+	}
+	if tracker.Name() != trackerGitHub || !flags.allows(t.ctx, flagAutoAssign, t.target) {
+		return nil
+	}
+
+	owned, err := resolveCodeOwners(t.ctx, t.target, files)
+	if err != nil {
+		return fmt.Errorf("reading CODEOWNERS: %w", err)
+	}
+	users, _ := splitOwners(owned)
+	if len(users) == 0 {
+		return nil
+	}
+
+	res, done := writer.submitAndWait(&githubIntent{
+		Kind:        intentAssign,
+		Target:      t.target,
+		IssueNumber: number,
+		Labels:      users,
+		RunID:       runID(t.ctx),
+	})
+	if done && res.Err != nil {
+		return fmt.Errorf("assigning issue #%d: %w", number, res.Err)
+	}
+	t.log.Info("Assigned the new issue", "issue_number", number, "assignees", users, "queued", !done)
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// duplicateLabel marks issues a maintainer merged into another issue.
+const duplicateLabel = "duplicate"
+
+// prioritySeverities maps the priorities maintainers may use in
+// "/triage severity" to severities.
+var prioritySeverities = map[string]string{
+	"p0": "critical",
+	"p1": "high",
+	"p2": "medium",
+	"p3": "low",
+	"p4": "low",
+}
+
+// issueURLWithNumber returns the URL of another issue of the same
+// repository.
+func issueURLWithNumber(issueURL string, number int) string {
+	return issueNumberInURL.ReplaceAllLiteralString(issueURL, "/issues/"+strconv.Itoa(number))
+}
+
+// runDedupeIntoCommand handles "/triage dedupe-into #123": the errors linked
+// to this issue are relinked to #123, so future occurrences are reported
+// there, and this issue is labeled as a duplicate.
+func runDedupeIntoCommand(ctx context.Context, cmd botCommand) (string, error) {
+	if len(cmd.Args) != 1 {
+		return "", fmt.Errorf("usage: %s dedupe-into #<issue>", botCommandPrefix)
+	}
+	into, err := strconv.Atoi(strings.TrimPrefix(cmd.Args[0], "#"))
+	if err != nil || into <= 0 {
+		return "", fmt.Errorf("%q is not an issue number", cmd.Args[0])
+	}
+	if into == cmd.IssueNumber {
+		return "", fmt.Errorf("an issue can't be a duplicate of itself")
+	}
+	intoURL := issueURLWithNumber(cmd.IssueURL, into)
+
+	moved, err := lifecycles.updateWhere(
+		func(st FingerprintState) bool { return st.IssueURL == cmd.IssueURL },
+		func(st *FingerprintState) {
+			st.MergedFrom = firstNonEmpty(st.MergedFrom, st.IssueURL)
+			st.IssueURL = intoURL
+		})
+	// Cached responses name the old issue.
+	for _, fp := range moved {
+		forgetResponse(ctx, cmd.Target, fp)
+	}
+	if err != nil {
+		return "", err
+	}
+	writer.submit(&githubIntent{Kind: intentAddLabels, Target: cmd.Target, IssueNumber: cmd.IssueNumber, Labels: []string{duplicateLabel}})
+	return fmt.Sprintf("Marked as a duplicate of #%d. %s", into, fingerprintsMoved(moved, fmt.Sprintf("#%d", into))), nil
+}
+
+// runNotADuplicateCommand handles "/triage not-a-duplicate", undoing
+// "/triage dedupe-into" on this issue: the errors merged away from it are
+// linked back, and the duplicate label is removed.
+func runNotADuplicateCommand(ctx context.Context, cmd botCommand) (string, error) {
+	moved, err := lifecycles.updateWhere(
+		func(st FingerprintState) bool { return st.MergedFrom == cmd.IssueURL },
+		func(st *FingerprintState) {
+			st.IssueURL = st.MergedFrom
+			st.MergedFrom = ""
+		})
+	for _, fp := range moved {
+		forgetResponse(ctx, cmd.Target, fp)
+	}
+	if err != nil {
+		return "", err
+	}
+	labeled := slices.Contains(cmd.Labels, duplicateLabel)
+	if labeled {
+		writer.submit(&githubIntent{Kind: intentRemoveLabels, Target: cmd.Target, IssueNumber: cmd.IssueNumber, Labels: []string{duplicateLabel}})
+	}
+	if len(moved) == 0 && !labeled {
+		return "This issue isn't marked as a duplicate.", nil
+	}
+	return "No longer a duplicate. " + fingerprintsMoved(moved, "this issue"), nil
+}
+
+// runSeverityCommand handles "/triage severity P1" (or "high"): the issue's
+// severity label is replaced, and future occurrences of its errors keep
+// that severity instead of being classified.
+func runSeverityCommand(ctx context.Context, cmd botCommand) (string, error) {
+	if len(cmd.Args) != 1 {
+		return "", fmt.Errorf("usage: %s severity <critical|high|medium|low|P0-P4>", botCommandPrefix)
+	}
+	severity := strings.ToLower(cmd.Args[0])
+	if s, ok := prioritySeverities[severity]; ok {
+		severity = s
+	}
+	label := severityLabel(severity)
+	if label == "" || severity != strings.TrimPrefix(label, "severity:") {
+		return "", fmt.Errorf("unknown severity %q, expected critical, high, medium, low or P0-P4", cmd.Args[0])
+	}
+
+	updated, err := lifecycles.updateWhere(
+		func(st FingerprintState) bool { return st.IssueURL == cmd.IssueURL },
+		func(st *FingerprintState) { st.Severity = severity })
+	if err != nil {
+		return "", err
+	}
+
+	var stale []string
+	for _, l := range cmd.Labels {
+		if strings.HasPrefix(l, "severity:") && l != label {
+			stale = append(stale, l)
+		}
+	}
+	if len(stale) > 0 {
+		writer.submit(&githubIntent{Kind: intentRemoveLabels, Target: cmd.Target, IssueNumber: cmd.IssueNumber, Labels: stale})
+	}
+	writer.submit(&githubIntent{Kind: intentAddLabels, Target: cmd.Target, IssueNumber: cmd.IssueNumber, Labels: []string{label}})
+
+	reply := fmt.Sprintf("Severity set to %s.", severity)
+	if len(updated) > 0 {
+		reply += fmt.Sprintf(" Future occurrences of %s keep it.", errorCount(len(updated)))
+	}
+	return reply, nil
+}
+
+// fingerprintsMoved describes the fingerprints a command relinked.
+func fingerprintsMoved(moved []string, to string) string {
+	if len(moved) == 0 {
+		return "No tracked errors were linked to this issue."
+	}
+	return fmt.Sprintf("Future occurrences of %s (%s) will be reported in %s.", errorCount(len(moved)), strings.Join(moved, ", "), to)
+}
+
+func errorCount(n int) string {
+	if n == 1 {
+		return "its error"
+	}
+	return fmt.Sprintf("its %d errors", n)
+}
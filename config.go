@@ -0,0 +1,589 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigPath is used when TRIAGE_CONFIG is not set. The file is
+// optional: without it the service is configured from the environment only.
+const defaultConfigPath = "triage.yaml"
+
+// Config is the optional config file. Environment variables take precedence
+// over the values in the file; secrets are only read from the environment.
+// The struct doubles as the schema: unknown keys are rejected.
+type Config struct {
+	GitHub GitHubConfig `yaml:"github"`
+
+	// Tracker is where issues are searched and filed: github (default),
+	// jira, bitbucket, gitea (also forgejo), gitlab or azure.
+	Tracker string `yaml:"tracker"`
+
+	// ExternalDepsRepo receives dependency failures ("owner/repo").
+	ExternalDepsRepo string `yaml:"external_deps_repo"`
+
+	// AllowedRepos may be targeted per request ("owner/repo" or "owner/*").
+	AllowedRepos []string `yaml:"allowed_repos"`
+
+	// DependencyPatterns are extra regular expressions that mark an error
+	// as a dependency failure, e.g. the names of internal downstream
+	// services. They are matched against its type and message.
+	DependencyPatterns []string `yaml:"dependency_patterns"`
+
+	SelfTest SelfTestConfig `yaml:"selftest"`
+
+	Fallback FallbackConfig `yaml:"fallback"`
+
+	ActionPolicy ActionPolicyConfig `yaml:"action_policy"`
+
+	Verification VerificationConfig `yaml:"verification"`
+
+	// Teams maps team names to the repositories they own ("owner/repo" or
+	// "owner/*"), for the usage report.
+	Teams map[string][]string `yaml:"teams"`
+
+	Store StoreConfig `yaml:"store"`
+
+	Cache CacheConfig `yaml:"cache"`
+
+	Jobs JobsConfig `yaml:"jobs"`
+
+	// ANSIRendering is "strip" (default) to drop ANSI colors from logs, or
+	// "markdown" to also render them as a highlighted block for the issue
+	// body.
+	ANSIRendering string `yaml:"ansi_rendering"`
+
+	// Services maps service names to their defaults. More can be added
+	// through the admin API.
+	Services map[string]Service `yaml:"services"`
+
+	Alertmanager AlertmanagerConfig `yaml:"alertmanager"`
+
+	// Runbooks link runbooks to errors by service, category or pattern.
+	Runbooks []RunbookRule `yaml:"runbooks"`
+
+	// KnowledgeBase lists known errors with canned resolutions.
+	KnowledgeBase []KnownIssue `yaml:"knowledge_base"`
+
+	// Routes send errors to repositories by service, category or log
+	// pattern. The first matching route wins.
+	Routes []RouteRule `yaml:"routes"`
+
+	// Rules take actions on errors matching a CEL expression after
+	// classification.
+	Rules []Rule `yaml:"rules"`
+
+	Notifications NotificationConfig `yaml:"notifications"`
+
+	Jira JiraConfig `yaml:"jira"`
+
+	Bitbucket BitbucketConfig `yaml:"bitbucket"`
+
+	Gitea GiteaConfig `yaml:"gitea"`
+
+	GitLab GitLabConfig `yaml:"gitlab"`
+
+	AzureDevOps AzureDevOpsConfig `yaml:"azure_devops"`
+
+	Redaction RedactionConfig `yaml:"redaction"`
+
+	PII PIIConfig `yaml:"pii"`
+
+	ServiceNow ServiceNowConfig `yaml:"servicenow"`
+
+	OnCall OnCallConfig `yaml:"oncall"`
+
+	LLM LLMConfig `yaml:"llm"`
+
+	Canary CanaryConfig `yaml:"canary"`
+
+	Bootstrap BootstrapConfig `yaml:"bootstrap"`
+
+	// Normalization are the ordered rules that strip high-cardinality
+	// values from messages before fingerprinting (default uuid, hex,
+	// number).
+	Normalization []NormalizationRule `yaml:"normalization"`
+
+	Tracing TracingConfig `yaml:"tracing"`
+
+	Logging LoggingConfig `yaml:"logging"`
+
+	// APIKeys are the keys clients authenticate with. API_KEYS adds
+	// unscoped keys.
+	APIKeys []APIKey `yaml:"api_keys"`
+
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+
+	ToolLimits ToolLimitsConfig `yaml:"tool_limits"`
+
+	UserAgent UserAgentConfig `yaml:"user_agent"`
+
+	Batch BatchConfig `yaml:"batch"`
+
+	// FeatureFlags turn risky capabilities on or off, by flag name. The
+	// admin API can override them at runtime.
+	FeatureFlags map[string]FeatureFlag `yaml:"feature_flags"`
+
+	Sandbox SandboxConfig `yaml:"sandbox"`
+
+	Retry RetryConfig `yaml:"retry"`
+
+	// Telemetry opts in to reporting aggregate quality metrics.
+	Telemetry TelemetryConfig `yaml:"telemetry"`
+
+	// Translation configures the translation of localized error messages.
+	Translation TranslationConfig `yaml:"translation"`
+
+	// Timestamps configures how timestamps in logs are read.
+	Timestamps TimestampConfig `yaml:"timestamps"`
+
+	// Milestones configures the milestones of new issues.
+	Milestones MilestoneConfig `yaml:"milestones"`
+
+	// Project configures the project board new issues are added to.
+	Project ProjectConfig `yaml:"project"`
+
+	// Labels configures the label taxonomy.
+	Labels LabelConfig `yaml:"labels"`
+
+	// IssueTemplate lays out the body of new issues.
+	IssueTemplate IssueTemplateConfig `yaml:"issue_template"`
+}
+
+// JobsConfig sizes the worker pool for asynchronous requests.
+type JobsConfig struct {
+	// Workers is how many jobs run at once (default 4).
+	Workers int `yaml:"workers"`
+}
+
+// VerificationConfig enables release verification of fixed fingerprints.
+type VerificationConfig struct {
+	// Interval is how often closed issues and verification windows are
+	// checked. Verification is off when it is empty.
+	Interval string `yaml:"interval"`
+	// Window is how long a fix is watched for regressions (default 168h).
+	Window string `yaml:"window"`
+}
+
+// SelfTestConfig enables the scheduled synthetic probe.
+type SelfTestConfig struct {
+	// Interval between probe runs, e.g. "15m". Empty disables the probe.
+	Interval string `yaml:"interval"`
+}
+
+type GitHubConfig struct {
+	Owner string `yaml:"owner"`
+	Repo  string `yaml:"repo"`
+}
+
+func configPath() string {
+	if path := os.Getenv("TRIAGE_CONFIG"); path != "" {
+		return path
+	}
+	return defaultConfigPath
+}
+
+// loadConfig reads and decodes the config file. A missing file is not an
+// error unless it was asked for explicitly through TRIAGE_CONFIG.
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && os.Getenv("TRIAGE_CONFIG") == "" {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeConfig(data)
+}
+
+// unknownKeyPattern rewrites yaml's "field x not found in type main.Config"
+// into something that doesn't require knowing the Go types.
+var unknownKeyPattern = regexp.MustCompile(`field (\S+) not found in type \S+`)
+
+// decodeConfig decodes a config file, rejecting unknown keys. On a
+// *yaml.TypeError the returned config holds everything that did decode.
+func decodeConfig(data []byte) (*Config, error) {
+	cfg := &Config{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// validateConfig returns every problem found in the config, each phrased so
+// that it can be fixed without reading the code. Environment overrides are
+// taken into account, since that is what the service will run with.
+func validateConfig(cfg *Config) []string {
+	var problems []string
+
+	owner := envOr("GITHUB_OWNER", cfg.GitHub.Owner)
+	repo := envOr("GITHUB_REPO", cfg.GitHub.Repo)
+	if owner == "" || repo == "" {
+		problems = append(problems, "github.owner and github.repo (or GITHUB_OWNER and GITHUB_REPO) must be set")
+	}
+
+	if depsRepo := envOr("EXTERNAL_DEPS_REPO", cfg.ExternalDepsRepo); depsRepo != "" {
+		if _, err := parseRepoTarget(depsRepo); err != nil {
+			problems = append(problems, fmt.Sprintf("external_deps_repo: %v", err))
+		}
+	}
+
+	for i, entry := range cfg.AllowedRepos {
+		if _, err := parseAllowedRepos(entry); err != nil {
+			problems = append(problems, fmt.Sprintf("allowed_repos[%d]: %v", i, err))
+		}
+	}
+
+	for i, pattern := range cfg.DependencyPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("dependency_patterns[%d]: invalid regular expression: %v", i, err))
+		}
+	}
+
+	if interval := envOr("SELFTEST_INTERVAL", cfg.SelfTest.Interval); interval != "" {
+		if d, err := time.ParseDuration(interval); err != nil || d < time.Minute {
+			problems = append(problems, fmt.Sprintf("selftest.interval: %q must be a duration of at least 1m, e.g. \"15m\"", interval))
+		}
+	}
+
+	if interval := envOr("VERIFY_INTERVAL", cfg.Verification.Interval); interval != "" {
+		if d, err := time.ParseDuration(interval); err != nil || d < time.Minute {
+			problems = append(problems, fmt.Sprintf("verification.interval: %q must be a duration of at least 1m, e.g. \"1h\"", interval))
+		}
+	}
+	if window := envOr("VERIFY_WINDOW", cfg.Verification.Window); window != "" {
+		if d, err := time.ParseDuration(window); err != nil || d <= 0 {
+			problems = append(problems, fmt.Sprintf("verification.window: %q must be a positive duration, e.g. \"168h\"", window))
+		}
+	}
+
+	switch sc := configStore(cfg); sc.Driver {
+	case "", storeMemory, storeSQLite:
+	case storePostgres:
+		if sc.DSN == "" {
+			problems = append(problems, "store.dsn (or STORE_DSN) must be set for the postgres store")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("store.driver: unknown driver %q, expected memory, sqlite or postgres", sc.Driver))
+	}
+
+	switch cc := configCache(cfg); cc.Driver {
+	case "", cacheMemory:
+	case cacheRedis:
+		if cc.URL == "" {
+			problems = append(problems, "cache.url (or REDIS_URL) must be set for the redis cache")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("cache.driver: unknown driver %q, expected memory or redis", cc.Driver))
+	}
+	if ttl := configCache(cfg).ResponseTTL; ttl != "" {
+		if d, err := time.ParseDuration(ttl); err != nil || d < 0 {
+			problems = append(problems, fmt.Sprintf("cache.response_ttl: %q must be a duration, e.g. \"10m\" (\"0s\" disables it)", ttl))
+		}
+	}
+
+	if v := os.Getenv("JOB_WORKERS"); v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n < 1 {
+			problems = append(problems, fmt.Sprintf("JOB_WORKERS: %q must be a positive number", v))
+		}
+	} else if cfg.Jobs.Workers < 0 {
+		problems = append(problems, fmt.Sprintf("jobs.workers: %d must be positive", cfg.Jobs.Workers))
+	}
+
+	switch mode := envOr("ANSI_RENDERING", cfg.ANSIRendering); mode {
+	case "", ansiStrip, ansiMarkdown:
+	default:
+		problems = append(problems, fmt.Sprintf("ansi_rendering: unknown mode %q, expected strip or markdown", mode))
+	}
+
+	onCallCfg := configOnCall(cfg)
+	problems = append(problems, validateOnCall(onCallCfg)...)
+	for _, name := range sortedKeys(cfg.Services) {
+		problems = append(problems, validateService("services."+name, cfg.Services[name])...)
+		if cfg.Services[name].OnCall != "" && onCallCfg.Provider == "" {
+			problems = append(problems, fmt.Sprintf("services.%s.oncall: oncall.provider (or ONCALL_PROVIDER) must be set to look up on-call schedules", name))
+		}
+	}
+
+	_, runbookProblems := compileRunbookRules(cfg.Runbooks)
+	problems = append(problems, runbookProblems...)
+	_, kbProblems := compileKnowledgeBase(cfg.KnowledgeBase)
+	problems = append(problems, kbProblems...)
+	_, routeProblems := compileRouteRules(cfg.Routes)
+	problems = append(problems, routeProblems...)
+	_, ruleProblems := compileRules(cfg.Rules)
+	problems = append(problems, ruleProblems...)
+	_, notificationProblems := compileNotifications(cfg.Notifications)
+	problems = append(problems, notificationProblems...)
+	if configTracker(cfg) != trackerGitHub {
+		for i, rule := range cfg.Rules {
+			if slices.ContainsFunc(rule.Assign, func(who string) bool { return !strings.Contains(who, "/") }) {
+				problems = append(problems, fmt.Sprintf("rules[%d].assign: users can only be assigned with the github tracker", i))
+			}
+		}
+	}
+	_, normalizationProblems := compileNormalization(cfg.Normalization)
+	problems = append(problems, normalizationProblems...)
+	_, redactionProblems := compileRedaction(cfg.Redaction)
+	problems = append(problems, redactionProblems...)
+	_, piiProblems := compilePII(cfg.PII)
+	problems = append(problems, piiProblems...)
+	problems = append(problems, validateServiceNow(configServiceNow(cfg))...)
+	problems = append(problems, validateLLM(configLLM(cfg))...)
+	problems = append(problems, validateCanary(configCanary(cfg))...)
+	problems = append(problems, validateTracing(configTracing(cfg))...)
+	problems = append(problems, validateLogging(configLogging(cfg))...)
+	_, apiKeyProblems := compileAPIKeys(cfg.APIKeys, os.Getenv("API_KEYS"))
+	problems = append(problems, apiKeyProblems...)
+	problems = append(problems, validateRateLimit(configRateLimit(cfg))...)
+	problems = append(problems, validateToolLimits(configToolLimits(cfg))...)
+	problems = append(problems, validateUserAgent(configUserAgent(cfg))...)
+	problems = append(problems, validateBatch(configBatch(cfg))...)
+	problems = append(problems, validateFeatureFlags(cfg.FeatureFlags)...)
+	problems = append(problems, validateSandbox(configSandbox(cfg))...)
+	problems = append(problems, validateRetry(configRetry(cfg))...)
+	problems = append(problems, validateTelemetry(configTelemetry(cfg))...)
+	problems = append(problems, validateTimestamps(configTimestamps(cfg))...)
+	problems = append(problems, validateProject(configProject(cfg))...)
+	problems = append(problems, validateLabels(configLabels(cfg))...)
+	_, issueTemplateProblems := compileIssueTemplate(configIssueTemplate(cfg))
+	problems = append(problems, issueTemplateProblems...)
+	if cfg.Bootstrap.MaxIssues < 0 {
+		problems = append(problems, fmt.Sprintf("bootstrap.max_issues: must not be negative, got %d", cfg.Bootstrap.MaxIssues))
+	}
+
+	trackerName := configTracker(cfg)
+	switch trackerName {
+	case trackerGitHub, trackerJira:
+	case trackerBitbucket:
+		problems = append(problems, validateBitbucket(configBitbucket(cfg))...)
+	case trackerGitea, trackerForgejo:
+		problems = append(problems, validateGitea(configGitea(cfg))...)
+	case trackerGitLab:
+		problems = append(problems, validateGitLab(configGitLab(cfg))...)
+	case trackerAzure:
+		problems = append(problems, validateAzureDevOps(configAzureDevOps(cfg))...)
+	default:
+		problems = append(problems, fmt.Sprintf("tracker: unknown tracker %q, expected github, jira, bitbucket, gitea, gitlab or azure", trackerName))
+	}
+	if trackerName != trackerGitHub {
+		if configJira(cfg).DualWrite {
+			problems = append(problems, "jira.dual_write mirrors GitHub issues and requires the github tracker")
+		}
+		if envOr("VERIFY_INTERVAL", cfg.Verification.Interval) != "" {
+			problems = append(problems, "verification requires the github tracker")
+		}
+		if configProject(cfg).enabled() {
+			problems = append(problems, "project boards require the github tracker")
+		}
+	}
+	problems = append(problems, validateJira(configJira(cfg), trackerName)...)
+
+	if _, err := parseTeams(cfg.Teams); err != nil {
+		problems = append(problems, fmt.Sprintf("teams: %v", err))
+	}
+
+	for i, action := range configFallbackActions(cfg) {
+		if action != fallbackRepo && action != fallbackSlack && action != fallbackStore {
+			problems = append(problems, fmt.Sprintf("fallback.actions[%d]: unknown action %q, expected one of repo, slack, store", i, action))
+		}
+		if action == fallbackRepo && envOr("FALLBACK_REPO", cfg.Fallback.Repo) == "" {
+			problems = append(problems, "fallback.actions contains \"repo\" but fallback.repo is not set")
+		}
+	}
+	if repo := envOr("FALLBACK_REPO", cfg.Fallback.Repo); repo != "" {
+		if _, err := parseRepoTarget(repo); err != nil {
+			problems = append(problems, fmt.Sprintf("fallback.repo: %v", err))
+		}
+	}
+
+	problems = append(problems, validateActionPolicy("action_policy.default", cfg.ActionPolicy.Default)...)
+	for repo, policy := range cfg.ActionPolicy.Repos {
+		if _, err := parseRepoTarget(repo); err != nil {
+			problems = append(problems, fmt.Sprintf("action_policy.repos: %v", err))
+		}
+		problems = append(problems, validateActionPolicy("action_policy.repos."+repo, policy)...)
+	}
+
+	return problems
+}
+
+// configStore returns the store settings, with STORE_DRIVER and STORE_DSN
+// taking precedence over the config file.
+func configStore(cfg *Config) StoreConfig {
+	return StoreConfig{
+		Driver:  envOr("STORE_DRIVER", cfg.Store.Driver),
+		DSN:     envOr("STORE_DSN", cfg.Store.DSN),
+		Migrate: cfg.Store.Migrate,
+	}
+}
+
+// configCache returns the cache settings, with CACHE_DRIVER, REDIS_URL and
+// RESPONSE_CACHE_TTL taking precedence over the config file.
+func configCache(cfg *Config) CacheConfig {
+	return CacheConfig{
+		Driver:      envOr("CACHE_DRIVER", cfg.Cache.Driver),
+		URL:         envOr("REDIS_URL", cfg.Cache.URL),
+		ResponseTTL: envOr("RESPONSE_CACHE_TTL", cfg.Cache.ResponseTTL),
+	}
+}
+
+// configAlertmanager returns the alert filter, with ALERTMANAGER_ALERTNAMES
+// and ALERTMANAGER_SEVERITIES (comma-separated) taking precedence over the
+// config file.
+func configAlertmanager(cfg *Config) AlertmanagerConfig {
+	out := cfg.Alertmanager
+	if v := os.Getenv("ALERTMANAGER_ALERTNAMES"); v != "" {
+		out.Alertnames = splitList(v)
+	}
+	if v := os.Getenv("ALERTMANAGER_SEVERITIES"); v != "" {
+		out.Severities = splitList(v)
+	}
+	return out
+}
+
+// configTracker returns ISSUE_TRACKER if set, or tracker, defaulting to
+// github.
+func configTracker(cfg *Config) string {
+	if name := envOr("ISSUE_TRACKER", cfg.Tracker); name != "" {
+		return name
+	}
+	return trackerGitHub
+}
+
+// configJobWorkers returns JOB_WORKERS if set, or jobs.workers.
+func configJobWorkers(cfg *Config) int {
+	if v := os.Getenv("JOB_WORKERS"); v != "" {
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	return cfg.Jobs.Workers
+}
+
+// configFallbackActions returns FALLBACK_ACTIONS (comma-separated) if set,
+// or fallback.actions.
+func configFallbackActions(cfg *Config) []string {
+	if actions := os.Getenv("FALLBACK_ACTIONS"); actions != "" {
+		var out []string
+		for _, a := range strings.Split(actions, ",") {
+			out = append(out, strings.TrimSpace(a))
+		}
+		return out
+	}
+	return cfg.Fallback.Actions
+}
+
+// configRepoTargets lists the concrete repositories the config writes to.
+func configRepoTargets(cfg *Config) []repoTarget {
+	var targets []repoTarget
+	repos := append([]string{
+		envOr("GITHUB_OWNER", cfg.GitHub.Owner) + "/" + envOr("GITHUB_REPO", cfg.GitHub.Repo),
+		envOr("EXTERNAL_DEPS_REPO", cfg.ExternalDepsRepo),
+		envOr("FALLBACK_REPO", cfg.Fallback.Repo),
+		configSandbox(cfg).Repo,
+	}, cfg.AllowedRepos...)
+	for _, rule := range cfg.Routes {
+		repos = append(repos, rule.Repository)
+	}
+	for _, name := range sortedKeys(cfg.Services) {
+		repos = append(repos, cfg.Services[name].Repository)
+	}
+	for _, s := range repos {
+		if strings.HasSuffix(s, "/*") {
+			continue
+		}
+		if target, err := parseRepoTarget(s); err == nil {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// runConfigCLI implements "triage config validate" and returns the exit
+// code, so that it can gate config changes in CI.
+func runConfigCLI(args []string) int {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintln(os.Stderr, "Usage: triage config validate [-config path] [-offline]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	path := fs.String("config", configPath(), "config file to validate")
+	offline := fs.Bool("offline", false, "skip checking that repositories are reachable on GitHub")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	data, err := os.ReadFile(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	var problems []string
+	cfg, err := decodeConfig(data)
+	var typeErr *yaml.TypeError
+	switch {
+	case errors.As(err, &typeErr):
+		// Unknown keys and type mismatches are reported alongside the other
+		// problems; the rest of the file still decoded.
+		for _, e := range typeErr.Errors {
+			problems = append(problems, unknownKeyPattern.ReplaceAllString(e, `unknown key "$1"`))
+		}
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *path, err)
+		return 1
+	}
+
+	problems = append(problems, validateConfig(cfg)...)
+	// Reachability is only checked on GitHub.
+	if !*offline && configTracker(cfg) == trackerGitHub {
+		problems = append(problems, checkReposReachable(cfg)...)
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("%s is valid.\n", *path)
+		return 0
+	}
+	for _, p := range problems {
+		fmt.Printf("%s: %s\n", *path, p)
+	}
+	return 1
+}
+
+func checkReposReachable(cfg *Config) []string {
+	ts, err := githubTokenSource()
+	if err != nil {
+		return []string{fmt.Sprintf("%v, cannot check that repositories are reachable (use -offline to skip)", err)}
+	}
+
+	client := newGitHubClient(ts)
+	var problems []string
+	for _, target := range configRepoTargets(cfg) {
+		if _, _, err := client.Repositories.Get(context.Background(), target.Owner, target.Repo); err != nil {
+			problems = append(problems, fmt.Sprintf("repository %s is not reachable with %s: %v", target, githubAuthDescription, err))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// debugNearestClusters is how many clusters /debug/fingerprint lists.
+const debugNearestClusters = 5
+
+type fingerprintDebugRequest struct {
+	ErrorLog string `json:"error_log"`
+	// Repository selects the lifecycle shown for a fingerprint tracked in
+	// several repositories.
+	Repository string `json:"repository,omitempty"`
+}
+
+// fingerprintDebug explains how a log is fingerprinted and clustered.
+type fingerprintDebug struct {
+	Format    string `json:"format"`
+	Category  string `json:"category"`
+	ErrorType string `json:"error_type,omitempty"`
+	Message   string `json:"message,omitempty"`
+	// OriginalMessage is the message as logged when Message is its English
+	// translation.
+	OriginalMessage string       `json:"original_message,omitempty"`
+	Frames          []StackFrame `json:"frames,omitempty"`
+	// Basis are the parts the fingerprint is a hash of: the format, error
+	// type, route and top in-app frames, or the normalized message when
+	// there are no in-app frames, or the key set by an enricher.
+	Basis       []string `json:"basis"`
+	Fingerprint string   `json:"fingerprint"`
+	// NormalizedLog is the text the SimHash is computed over.
+	NormalizedLog string `json:"normalized_log"`
+	SimHash       string `json:"simhash"`
+	// Cluster is the cluster the log would be assigned to: the nearest
+	// near-duplicate one, or a new one named after the fingerprint.
+	Cluster         string            `json:"cluster"`
+	NearestClusters []clusterMatch    `json:"nearest_clusters"`
+	Lifecycle       *FingerprintState `json:"lifecycle,omitempty"`
+}
+
+// handleDebugFingerprint analyses a log like /process_error does, without
+// running triage or recording anything, so that operators can see why two
+// logs did or didn't get the same fingerprint or cluster.
+func handleDebugFingerprint(w http.ResponseWriter, r *http.Request) {
+	var req fingerprintDebugRequest
+	data, err := io.ReadAll(r.Body)
+	if err == nil {
+		data, err = decodeText(data, r.Header.Get("Content-Type"))
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &req)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	errorLog := normalizeLogText(req.ErrorLog)
+	if errorLog == "" {
+		http.Error(w, "Error log cannot be empty", http.StatusBadRequest)
+		return
+	}
+	if reason := checkErrorLogText(errorLog); reason != "" {
+		http.Error(w, "Error log would be rejected: "+reason, http.StatusUnprocessableEntity)
+		return
+	}
+	errorLog = sanitizeText(errorLog)
+
+	analysis := analyzeErrorLog(errorLog)
+	translateAnalysis(r.Context(), analysis)
+	out := fingerprintDebug{
+		Format:          analysis.Format,
+		Category:        analysis.Category,
+		ErrorType:       analysis.ErrorType,
+		Message:         analysis.Message,
+		OriginalMessage: analysis.OriginalMessage,
+		Frames:          analysis.Frames,
+		Basis:           fingerprintBasis(analysis),
+		Fingerprint:     analysis.Fingerprint,
+		NormalizedLog:   simhashText(errorLog),
+		SimHash:         analysis.SimHash,
+		Cluster:         analysis.Fingerprint,
+		NearestClusters: clusters.nearest(analysis.SimHash, debugNearestClusters),
+	}
+	if len(out.NearestClusters) > 0 && out.NearestClusters[0].NearDuplicate {
+		out.Cluster = out.NearestClusters[0].Cluster
+	}
+	if state, err := lifecycles.find(req.Repository, analysis.Fingerprint); err == nil {
+		out.Lifecycle = &state
+	}
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// DryRunPreview is what a dry run would have written to the tracker.
+type DryRunPreview struct {
+	Issues   []plannedIssue   `json:"issues"`
+	Comments []plannedComment `json:"comments"`
+}
+
+// wantsDryRun reports whether a request asks for a dry run, with the
+// dry_run field or the dry_run=true query parameter.
+func wantsDryRun(r *http.Request, req ErrorLogRequest) bool {
+	return req.DryRun || r.URL.Query().Get("dry_run") == "true"
+}
+
+// preview returns the writes a dry run recorded.
+func (t *toolSession) preview() *DryRunPreview {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &DryRunPreview{
+		Issues:   append([]plannedIssue{}, t.plannedIssues...),
+		Comments: append([]plannedComment{}, t.plannedComments...),
+	}
+}
+
+// dryRunTriage runs the full pipeline with the tracker writes replaced by
+// recorders. Like self-test probes, dry runs are not recorded in the run
+// registry, so they don't count towards reports or show up as dead letters.
+func dryRunTriage(ctx context.Context, errorLog string, target repoTarget, analysis *LogAnalysis) (APIResponse, error) {
+	run := TriageRun{
+		ID:          "dryrun-" + newID(),
+		Status:      runStatusRunning,
+		Repository:  target.String(),
+		Fingerprint: analysis.Fingerprint,
+		ErrorLog:    errorLog,
+		StartedAt:   time.Now().UTC(),
+	}
+	session := newToolSession(target, true)
+	resp, err := executeTriage(ctx, run, session, analysis)
+	if err != nil {
+		return APIResponse{}, err
+	}
+	resp.DryRun = true
+	resp.Preview = session.preview()
+	return resp, nil
+}
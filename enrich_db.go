@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	sqlstatePattern    = regexp.MustCompile(`SQLSTATE[\s\[:=]*([0-9A-Z]{5})\b`)
+	mysqlErrorPattern  = regexp.MustCompile(`(?i)\b(?:error|errno)[:\s]*(\d{4})\b(?:\s*\(([0-9A-Z]{5})\))?`)
+	oracleErrorPattern = regexp.MustCompile(`\bORA-(\d{5})\b`)
+)
+
+type dbErrorCode struct {
+	explanation string
+	// connection marks errors caused by the database being unreachable.
+	// These are fingerprinted on the code alone so that a connection storm
+	// hitting many code paths collapses into a single issue.
+	connection bool
+}
+
+var sqlstateCodes = map[string]dbErrorCode{
+	"08000": {"connection exception", true},
+	"08001": {"client unable to establish connection", true},
+	"08003": {"connection does not exist", true},
+	"08004": {"server rejected the connection", true},
+	"08006": {"connection failure", true},
+	"23502": {"not-null constraint violation", false},
+	"23503": {"foreign key constraint violation", false},
+	"23505": {"unique constraint violation", false},
+	"23514": {"check constraint violation", false},
+	"28P01": {"invalid password", false},
+	"40001": {"serialization failure, the transaction should be retried", false},
+	"40P01": {"deadlock detected", false},
+	"42P01": {"undefined table", false},
+	"42703": {"undefined column", false},
+	"42601": {"syntax error", false},
+	"53300": {"too many connections", true},
+	"57014": {"query canceled, usually by a statement timeout", false},
+	"57P01": {"server is shutting down (admin shutdown)", true},
+}
+
+// sqlstateClasses explains codes missing from sqlstateCodes by their class,
+// the first two characters of the SQLSTATE.
+var sqlstateClasses = map[string]string{
+	"08": "connection exception",
+	"22": "data exception",
+	"23": "integrity constraint violation",
+	"25": "invalid transaction state",
+	"28": "invalid authorization",
+	"3D": "invalid catalog name",
+	"40": "transaction rollback",
+	"42": "syntax error or access rule violation",
+	"53": "insufficient resources",
+	"57": "operator intervention",
+	"XX": "internal database error",
+}
+
+var mysqlCodes = map[string]dbErrorCode{
+	"1040": {"too many connections", true},
+	"1045": {"access denied for user", false},
+	"1062": {"duplicate entry for unique key", false},
+	"1146": {"table does not exist", false},
+	"1205": {"lock wait timeout exceeded", false},
+	"1213": {"deadlock found when trying to get lock", false},
+	"1452": {"foreign key constraint fails", false},
+	"2002": {"cannot connect through socket", true},
+	"2003": {"cannot connect to server", true},
+	"2006": {"server has gone away", true},
+	"2013": {"lost connection during query", true},
+}
+
+var oracleCodes = map[string]dbErrorCode{
+	"00001": {"unique constraint violated", false},
+	"00060": {"deadlock detected while waiting for resource", false},
+	"00942": {"table or view does not exist", false},
+	"01017": {"invalid username/password", false},
+	"01555": {"snapshot too old", false},
+	"03113": {"end-of-file on communication channel", true},
+	"03114": {"not connected to Oracle", true},
+	"12154": {"could not resolve the connect identifier", true},
+	"12170": {"connect timeout occurred", true},
+	"12514": {"listener does not know of the requested service", true},
+	"12541": {"no listener", true},
+}
+
+// enrichDatabaseError detects SQLSTATE, MySQL and Oracle error codes, adds a
+// readable explanation and the db-error label, and switches the fingerprint
+// to the code for connection errors.
+func enrichDatabaseError(errorLog string, analysis *LogAnalysis) {
+	system, code, info, ok := detectDatabaseError(errorLog)
+	if !ok {
+		return
+	}
+
+	analysis.setMetadata("db_error_code", fmt.Sprintf("%s %s", system, code))
+	if info.explanation != "" {
+		analysis.setMetadata("db_error", info.explanation)
+	}
+	analysis.addLabel("db-error")
+
+	if info.connection {
+		analysis.FingerprintKey = "db:" + system + ":" + code
+	}
+}
+
+func detectDatabaseError(errorLog string) (string, string, dbErrorCode, bool) {
+	if m := oracleErrorPattern.FindStringSubmatch(errorLog); m != nil {
+		return "oracle", "ORA-" + m[1], oracleCodes[m[1]], true
+	}
+
+	if m := sqlstatePattern.FindStringSubmatch(errorLog); m != nil {
+		return "sqlstate", m[1], lookupSQLState(m[1]), true
+	}
+
+	if m := mysqlErrorPattern.FindStringSubmatch(errorLog); m != nil {
+		if info, known := mysqlCodes[m[1]]; known {
+			return "mysql", m[1], info, true
+		}
+		if m[2] != "" {
+			return "mysql", m[1], lookupSQLState(m[2]), true
+		}
+	}
+
+	return "", "", dbErrorCode{}, false
+}
+
+func lookupSQLState(code string) dbErrorCode {
+	if info, ok := sqlstateCodes[code]; ok {
+		return info
+	}
+	return dbErrorCode{
+		explanation: sqlstateClasses[code[:2]],
+		connection:  code[:2] == "08",
+	}
+}
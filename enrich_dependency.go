@@ -0,0 +1,83 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	dependencyFailurePattern = regexp.MustCompile(`(?i)(context deadline exceeded|i/o timeout|\btimed? ?out\b|connection refused|connection reset by peer|no such host|ECONNREFUSED|ECONNRESET|ETIMEDOUT|ENOTFOUND|EAI_AGAIN|upstream (?:connect )?error|service unavailable|bad gateway|gateway timeout|TLS handshake timeout|ConnectTimeout|ReadTimeout|HttpRequestException|SocketTimeoutException|UnknownHostException)`)
+	dependencyURLHost        = regexp.MustCompile(`https?://([A-Za-z0-9.-]+)`)
+	dependencyDialHost       = regexp.MustCompile(`\bdial (?:tcp|udp)[46]? ([A-Za-z0-9.-]+):\d+`)
+	dependencyLookupHost     = regexp.MustCompile(`\blookup ([A-Za-z0-9.-]+)`)
+)
+
+// extraDependencyPatterns come from the dependency_patterns config setting.
+var extraDependencyPatterns []*regexp.Regexp
+
+// dependencyErrorTypes are exception types that signal a failing downstream
+// call regardless of the message.
+var dependencyErrorTypes = []string{
+	"Timeout", "ConnectionError", "ConnectError", "HttpRequestException",
+	"SocketException", "SocketTimeoutException", "UnknownHostException",
+	"Net::OpenTimeout", "Net::ReadTimeout", "Faraday::ConnectionFailed",
+}
+
+// enrichDependencyFailure separates failures of third-party dependencies and
+// downstream services (timeouts, refused connections, 502/503 from an
+// upstream) from bugs in the repository itself. The patterns are matched
+// against the error, not its stack frames, where functions such as
+// setTimeout would match.
+func enrichDependencyFailure(errorLog string, analysis *LogAnalysis) {
+	if analysis.Category != "" {
+		return
+	}
+
+	text := dependencyFailureText(errorLog, analysis)
+	matched := dependencyFailurePattern.FindString(text)
+	if status := analysis.Metadata["http_status"]; matched == "" && (status == "502" || status == "503" || status == "504") {
+		matched = "upstream returned " + status
+	}
+	for _, p := range extraDependencyPatterns {
+		if matched != "" {
+			break
+		}
+		matched = p.FindString(text)
+	}
+	if matched == "" {
+		for _, t := range dependencyErrorTypes {
+			if strings.Contains(analysis.ErrorType, t) {
+				matched = analysis.ErrorType
+				break
+			}
+		}
+	}
+	if matched == "" {
+		return
+	}
+
+	analysis.Category = categoryDependency
+	analysis.setMetadata("dependency_failure", strings.ToLower(matched))
+	if host := dependencyHost(errorLog); host != "" {
+		analysis.setMetadata("dependency_host", host)
+	}
+	analysis.addLabel("external-dependency")
+}
+
+// dependencyFailureText is the error of a log: its type, message and the
+// errors wrapping it, or the first line of a log that wasn't parsed.
+func dependencyFailureText(errorLog string, analysis *LogAnalysis) string {
+	if analysis.ErrorType == "" && analysis.Message == "" {
+		return firstLine(errorLog)
+	}
+	return strings.Join(append([]string{analysis.ErrorType, analysis.Message}, analysis.Wrappers...), "\n")
+}
+
+func dependencyHost(errorLog string) string {
+	for _, p := range []*regexp.Regexp{dependencyDialHost, dependencyURLHost, dependencyLookupHost} {
+		if m := p.FindStringSubmatch(errorLog); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	httpRequestPattern = regexp.MustCompile(`\b(GET|POST|PUT|PATCH|DELETE|HEAD|OPTIONS)\s+(/[^\s"?]*)(?:\?\S*)?(?:\s+HTTP/[\d.]+)?"?\s*(?:->|=>|:|returned|status[=:]?)?\s*([45]\d\d)\b`)
+	httpPathPattern    = regexp.MustCompile(`(?:^|\s)(/[\w\-./%~]*\w)(?:\?\S*)?\s+([45]\d\d)\b`)
+	httpKVPathPattern  = regexp.MustCompile(`\b(?:path|url|uri|route)[=:]\s*"?(/[^\s"?]*)`)
+	httpKVStatus       = regexp.MustCompile(`\bstatus(?:_code)?[=:]\s*"?([45]\d\d)\b`)
+	httpKVMethod       = regexp.MustCompile(`\bmethod[=:]\s*"?([A-Z]+)\b`)
+
+	routeNumericSegment = regexp.MustCompile(`^\d+$`)
+	routeHexSegment     = regexp.MustCompile(`(?i)^[0-9a-f]{8,}$`)
+	routeTokenSegment   = regexp.MustCompile(`^[A-Za-z0-9_-]*\d[A-Za-z0-9_-]*$`)
+)
+
+// enrichHTTPEndpoint extracts the failing endpoint and status code from
+// access-log style lines ("GET /api/users/42 500", "path=/x status=502") and
+// turns the path into a route template so that requests for different
+// resources on the same route share a fingerprint.
+func enrichHTTPEndpoint(errorLog string, analysis *LogAnalysis) {
+	method, path, status := findHTTPRequest(errorLog)
+	if path == "" || status == "" {
+		return
+	}
+
+	if method != "" {
+		analysis.setMetadata("http_method", method)
+	}
+	analysis.setMetadata("http_path", path)
+	analysis.setMetadata("http_route", routeTemplate(path))
+	analysis.setMetadata("http_status", status)
+}
+
+func findHTTPRequest(errorLog string) (method, path, status string) {
+	if m := httpRequestPattern.FindStringSubmatch(errorLog); m != nil {
+		return m[1], m[2], m[3]
+	}
+
+	for _, line := range strings.Split(errorLog, "\n") {
+		if m := httpKVPathPattern.FindStringSubmatch(line); m != nil {
+			if s := httpKVStatus.FindStringSubmatch(line); s != nil {
+				if mm := httpKVMethod.FindStringSubmatch(line); mm != nil {
+					method = mm[1]
+				}
+				return method, m[1], s[1]
+			}
+		}
+	}
+
+	if m := httpPathPattern.FindStringSubmatch(errorLog); m != nil {
+		return "", m[1], m[2]
+	}
+	return "", "", ""
+}
+
+// routeTemplate replaces identifier-like path segments with placeholders:
+//
+//	/api/users/42/orders -> /api/users/{id}/orders
+//	/api/images/product/xyz.jpg -> /api/images/product/{file}.jpg
+func routeTemplate(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		switch {
+		case segment == "":
+		case uuidPattern.MatchString(segment) && len(segment) == 36:
+			segments[i] = "{id}"
+		case routeNumericSegment.MatchString(segment),
+			routeHexSegment.MatchString(segment),
+			len(segment) >= 6 && routeTokenSegment.MatchString(segment):
+			// Short tokens like "v1" are versions, not identifiers.
+			segments[i] = "{id}"
+		case i == len(segments)-1 && strings.Contains(segment, "."):
+			segments[i] = "{file}" + segment[strings.LastIndex(segment, "."):]
+		}
+	}
+	return strings.Join(segments, "/")
+}
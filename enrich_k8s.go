@@ -0,0 +1,87 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	k8sReasonPattern    = regexp.MustCompile(`(?i)\breason:?\s*"?(OOMKilled|Error|Evicted|DeadlineExceeded|ContainerStatusUnknown)\b`)
+	k8sExitCodePattern  = regexp.MustCompile(`(?i)\bexit(?:\s*code|Code)?[:=\s]\s*"?(\d{1,3})\b`)
+	k8sContainerPattern = regexp.MustCompile(`(?i)\bcontainer(?:[ _]?name)?[:=]\s*"?([a-z0-9]([-a-z0-9]*[a-z0-9])?)\b`)
+	k8sPodPattern       = regexp.MustCompile(`(?i)\bpod(?:[ _]?name)?[:=/]\s*"?([a-z0-9][-a-z0-9.]*[a-z0-9])\b`)
+	k8sEventPattern     = regexp.MustCompile(`\bcontainer "?([a-z0-9][-a-z0-9]*)"? in pod "?([a-z0-9][-a-z0-9.]*[a-z0-9])`)
+	k8sDescribeName     = regexp.MustCompile(`(?m)^Name:\s+(\S+)`)
+)
+
+// containerExitReasons explains the exit codes that indicate the container
+// was killed from the outside rather than crashing on its own.
+var containerExitReasons = map[string]string{
+	"137": "killed with SIGKILL, usually by the OOM killer or after a failed graceful shutdown",
+	"143": "terminated with SIGTERM, e.g. eviction, preemption or a failing liveness probe",
+}
+
+// enrichContainerTermination recognizes Kubernetes container terminations
+// (OOMKilled, exit codes 137 and 143) in pod descriptions and events. These
+// are resource issues rather than code defects, so they get their own
+// category, labels and issue template, and are fingerprinted on the
+// termination reason and container instead of the log text.
+func enrichContainerTermination(errorLog string, analysis *LogAnalysis) {
+	reason := ""
+	if m := k8sReasonPattern.FindStringSubmatch(errorLog); m != nil {
+		reason = m[1]
+	} else if strings.Contains(errorLog, "OOMKilling") || strings.Contains(errorLog, "Memory cgroup out of memory") {
+		reason = "OOMKilled"
+	}
+
+	exitCode := ""
+	if m := k8sExitCodePattern.FindStringSubmatch(errorLog); m != nil {
+		exitCode = m[1]
+	}
+
+	if reason != "OOMKilled" && containerExitReasons[exitCode] == "" {
+		return
+	}
+	if reason == "" {
+		reason = "Terminated"
+	}
+
+	analysis.Category = categoryResource
+	analysis.setMetadata("container_reason", reason)
+	if exitCode != "" {
+		analysis.setMetadata("exit_code", exitCode)
+		if explanation := containerExitReasons[exitCode]; explanation != "" {
+			analysis.setMetadata("exit_code_meaning", explanation)
+		}
+	}
+
+	container, pod := "", ""
+	if m := k8sEventPattern.FindStringSubmatch(errorLog); m != nil {
+		container, pod = m[1], m[2]
+	}
+	if m := k8sContainerPattern.FindStringSubmatch(errorLog); m != nil && container == "" {
+		container = m[1]
+	}
+	if pod == "" {
+		if m := k8sPodPattern.FindStringSubmatch(errorLog); m != nil {
+			pod = m[1]
+		} else if m := k8sDescribeName.FindStringSubmatch(errorLog); m != nil {
+			pod = m[1]
+		}
+	}
+	if container != "" {
+		analysis.setMetadata("container", container)
+	}
+	if pod != "" {
+		analysis.setMetadata("pod", pod)
+	}
+
+	analysis.addLabel("resource")
+	if reason == "OOMKilled" {
+		analysis.addLabel("oomkilled")
+	}
+
+	if analysis.FingerprintKey == "" {
+		analysis.FingerprintKey = "k8s:" + reason + ":" + exitCode + ":" + container
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fingerprintFrameDepth is how many in-app frames contribute to a fingerprint.
+const fingerprintFrameDepth = 3
+
+// Analysis categories. Each category has its own issue template.
+const (
+	categoryCode       = "code"
+	categoryResource   = "resource"
+	categoryDependency = "dependency"
+	// categoryStaticAnalysis is used for findings from static analysis
+	// tools rather than runtime errors.
+	categoryStaticAnalysis = "static-analysis"
+	// categoryTest is used for failing CI tests.
+	categoryTest = "test"
+	// categoryAlert is used for firing monitoring alerts.
+	categoryAlert = "alert"
+)
+
+type StackFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	InApp    bool   `json:"in_app"`
+}
+
+// LogAnalysis is the structured view of an error log produced before the log
+// is handed to the agent. Frames are ordered innermost call first.
+type LogAnalysis struct {
+	Format    string `json:"format"`
+	Category  string `json:"category"`
+	ErrorType string `json:"error_type,omitempty"`
+	Message   string `json:"message,omitempty"`
+	// OriginalMessage is the message as logged when Message is its English
+	// translation, and Language the language it was logged in.
+	OriginalMessage string `json:"original_message,omitempty"`
+	Language        string `json:"language,omitempty"`
+	// LoggedAt and LoggedUntil are the earliest and latest timestamps in
+	// the log, in UTC.
+	LoggedAt    *time.Time   `json:"logged_at,omitempty"`
+	LoggedUntil *time.Time   `json:"logged_until,omitempty"`
+	Frames      []StackFrame `json:"frames,omitempty"`
+	Wrappers    []string     `json:"wrappers,omitempty"`
+	Fingerprint string       `json:"fingerprint"`
+	// SimHash is a locality-sensitive hash of the normalized log, used to
+	// find near-duplicates with different fingerprints.
+	SimHash string `json:"simhash"`
+	// Cluster and RelatedFingerprints are set when the log is assigned to a
+	// near-duplicate cluster.
+	Cluster             string   `json:"cluster,omitempty"`
+	RelatedFingerprints []string `json:"related_fingerprints,omitempty"`
+	// Lifecycle is the fingerprint's issue lifecycle state, when known.
+	Lifecycle *FingerprintState `json:"lifecycle,omitempty"`
+	// HighlightedLog is the log's ANSI colors rendered as Markdown, for
+	// the issue body, when ANSI_RENDERING is "markdown".
+	HighlightedLog string `json:"highlighted_log,omitempty"`
+	// KnownIssue is the knowledge base entry the error matches, if any.
+	KnownIssue *KnownIssue `json:"known_issue,omitempty"`
+
+	// Metadata holds format-specific details worth surfacing in the issue,
+	// such as the Rails controller involved.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Labels are suggested by the analysis and passed on to the agent.
+	Labels []string `json:"labels,omitempty"`
+
+	// FingerprintKey, when set by an enricher, replaces the error type and
+	// frames as the basis of the fingerprint.
+	FingerprintKey string `json:"-"`
+}
+
+func (a *LogAnalysis) setMetadata(key, value string) {
+	if a.Metadata == nil {
+		a.Metadata = make(map[string]string)
+	}
+	a.Metadata[key] = value
+}
+
+func (a *LogAnalysis) addLabel(label string) {
+	if !slices.Contains(a.Labels, label) {
+		a.Labels = append(a.Labels, label)
+	}
+}
+
+// logParser recognizes and parses one error log format. Parsers are tried in
+// order and the first one whose Detect returns true wins.
+type logParser interface {
+	Format() string
+	Detect(errorLog string) bool
+	Parse(errorLog string) *LogAnalysis
+}
+
+var logParsers = []logParser{
+	goPanicParser{},
+	pythonParser{},
+	dotnetParser{},
+	rustParser{},
+	rubyParser{},
+}
+
+// logEnricher adds format-independent details to an analysis, after the
+// format parser has run.
+type logEnricher func(errorLog string, analysis *LogAnalysis)
+
+var logEnrichers = []logEnricher{
+	enrichDatabaseError,
+	enrichHTTPEndpoint,
+	enrichContainerTermination,
+	enrichDependencyFailure,
+	enrichTimestamps,
+	enrichRelease,
+}
+
+func analyzeErrorLog(errorLog string) *LogAnalysis {
+	var analysis *LogAnalysis
+	for _, p := range logParsers {
+		if !p.Detect(errorLog) {
+			continue
+		}
+		if analysis = p.Parse(errorLog); analysis != nil {
+			analysis.Format = p.Format()
+			break
+		}
+	}
+
+	if analysis == nil {
+		analysis = parseGenericLog(errorLog)
+	}
+
+	for _, enrich := range logEnrichers {
+		enrich(errorLog, analysis)
+	}
+	if analysis.Category == "" {
+		analysis.Category = categoryCode
+	}
+
+	analysis.Fingerprint = computeFingerprint(analysis)
+	analysis.SimHash = fmt.Sprintf("%016x", simhashLog(errorLog))
+	return analysis
+}
+
+func parseGenericLog(errorLog string) *LogAnalysis {
+	analysis := &LogAnalysis{Format: "generic"}
+	for _, line := range strings.Split(errorLog, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			analysis.Message = line
+			break
+		}
+	}
+	return analysis
+}
+
+// computeFingerprint hashes the error type and the top in-app frames. Line
+// numbers are left out so that unrelated edits to a file don't change the
+// fingerprint; the message is only used when there are no in-app frames.
+func computeFingerprint(analysis *LogAnalysis) string {
+	sum := sha256.Sum256([]byte(strings.Join(fingerprintBasis(analysis), "|")))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// fingerprintBasis returns the parts the fingerprint is a hash of.
+func fingerprintBasis(analysis *LogAnalysis) []string {
+	if analysis.FingerprintKey != "" {
+		return []string{analysis.FingerprintKey}
+	}
+
+	parts := []string{analysis.Format, analysis.ErrorType}
+	if route := analysis.Metadata["http_route"]; route != "" {
+		parts = append(parts, analysis.Metadata["http_method"]+" "+route, analysis.Metadata["http_status"])
+	}
+
+	depth := 0
+	for _, f := range analysis.Frames {
+		if !f.InApp {
+			continue
+		}
+		parts = append(parts, frameKey(f))
+		depth++
+		if depth == fingerprintFrameDepth {
+			break
+		}
+	}
+
+	if depth == 0 {
+		msg := analysis.Message
+		if path := analysis.Metadata["http_path"]; path != "" {
+			msg = strings.ReplaceAll(msg, path, analysis.Metadata["http_route"])
+		}
+		parts = append(parts, normalizeMessage(msg))
+	}
+	return parts
+}
+
+// frameKey identifies a frame by function and file name. The directory is left
+// out because it differs between build machines and deployments.
+func frameKey(f StackFrame) string {
+	file := f.File
+	if idx := strings.LastIndexAny(file, `/\`); idx != -1 {
+		file = file[idx+1:]
+	}
+	return f.Function + "@" + file
+}
+
+var uuidPattern = regexp.MustCompile(`(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// buildAgentInput prefixes the raw log with the pre-analysis so the agent can
+// use the fingerprint and the extracted frames when searching and filing.
+func buildAgentInput(errorLog string, analysis *LogAnalysis) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Pre-analysis:\n- Format: %s\n- Category: %s\n", analysis.Format, analysis.Category)
+	if analysis.ErrorType != "" {
+		fmt.Fprintf(&sb, "- Error type: %s\n", analysis.ErrorType)
+	}
+	if analysis.Message != "" {
+		fmt.Fprintf(&sb, "- Message: %s\n", analysis.Message)
+	}
+	if analysis.OriginalMessage != "" {
+		fmt.Fprintf(&sb, "- Original message (%s): %s\n  The message is its English translation: search with the translation, and quote the original in the issue.\n", analysis.Language, analysis.OriginalMessage)
+	}
+	if len(analysis.Wrappers) > 0 {
+		fmt.Fprintf(&sb, "- Wrapped by: %s\n", strings.Join(analysis.Wrappers, " -> "))
+	}
+
+	var inApp []string
+	for _, f := range analysis.Frames {
+		if f.InApp {
+			inApp = append(inApp, fmt.Sprintf("  - %s (%s:%d)", f.Function, f.File, f.Line))
+		}
+	}
+	if len(inApp) > 0 {
+		fmt.Fprintf(&sb, "- Application frames:\n%s\n", strings.Join(inApp, "\n"))
+	}
+	if len(analysis.Metadata) > 0 {
+		keys := make([]string, 0, len(analysis.Metadata))
+		for k := range analysis.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		sb.WriteString("- Metadata:\n")
+		for _, k := range keys {
+			fmt.Fprintf(&sb, "  - %s: %s\n", k, analysis.Metadata[k])
+		}
+	}
+	if len(analysis.Labels) > 0 {
+		fmt.Fprintf(&sb, "- Suggested labels: %s\n", strings.Join(analysis.Labels, ", "))
+	}
+	fmt.Fprintf(&sb, "- Fingerprint: %s\n", analysis.Fingerprint)
+	if len(analysis.RelatedFingerprints) > 0 {
+		fmt.Fprintf(&sb, "- Near-duplicate fingerprints: %s\n", strings.Join(analysis.RelatedFingerprints, ", "))
+	}
+	if k := analysis.KnownIssue; k != nil {
+		fmt.Fprintf(&sb, "- Known issue %s, resolution: %s\n", k.ID, k.Resolution)
+		if len(k.Links) > 0 {
+			fmt.Fprintf(&sb, "  - Links: %s\n", strings.Join(k.Links, ", "))
+		}
+	}
+	if analysis.Lifecycle != nil {
+		st := analysis.Lifecycle
+		fmt.Fprintf(&sb, "- Lifecycle state: %s (seen %d times, first %s, last %s)\n", st.State, st.Occurrences, st.FirstSeen.UTC().Format(issueTimeLayout), st.LastSeen.UTC().Format(issueTimeLayout))
+		fmt.Fprintf(&sb, "\nLifecycle guidance:\n%s\n", lifecycleGuidance(*analysis.Lifecycle))
+	}
+	if issueTemplate != nil {
+		fmt.Fprintf(&sb, "\nIssue body template:\n%s\n\nError log:\n%s", customTemplateGuidance, errorLog)
+	} else {
+		fmt.Fprintf(&sb, "\nIssue body template:\n%s\n\nError log:\n%s", issueTemplates[analysis.Category], errorLog)
+	}
+	if analysis.HighlightedLog != "" {
+		fmt.Fprintf(&sb, "\n\nHighlighted error log (already Markdown; use it as-is instead of a plain code block for the error log in the issue body):\n%s", analysis.HighlightedLog)
+	}
+
+	// The log was sanitized on arrival, but the highlighted log and the
+	// metadata come from elsewhere: nothing reaches the LLM unsanitized.
+	return sanitizeText(sb.String())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readFixture returns a log from testdata.
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// assertFrames compares frames with the expected ones.
+func assertFrames(t *testing.T, got, want []StackFrame) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("got %d frames, want %d:\n%+v", len(got), len(want), got)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("frame %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+// assertSameFingerprint checks that the logs of one error get the same
+// fingerprint, in the given format.
+func assertSameFingerprint(t *testing.T, format string, fixtures ...string) {
+	t.Helper()
+	var first string
+	for _, name := range fixtures {
+		analysis := analyzeErrorLog(readFixture(t, name))
+		if analysis.Format != format {
+			t.Errorf("%s: format = %q, want %q", name, analysis.Format, format)
+		}
+		if first == "" {
+			first = analysis.Fingerprint
+		} else if analysis.Fingerprint != first {
+			t.Errorf("%s: fingerprint %s differs from %s's %s (basis %q)", name, analysis.Fingerprint, fixtures[0], first, fingerprintBasis(analysis))
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// Fallback actions, tried in the configured order when issue creation keeps
+// failing.
+const (
+	fallbackRepo  = "repo"
+	fallbackSlack = "slack"
+	fallbackStore = "store"
+)
+
+// FallbackConfig configures what happens to an issue GitHub refused to
+// create. The Slack webhook URL is a secret and only read from
+// SLACK_WEBHOOK_URL.
+type FallbackConfig struct {
+	Actions   []string `yaml:"actions"`
+	Repo      string   `yaml:"repo"`
+	StorePath string   `yaml:"store_path"`
+}
+
+var (
+	fallbackActions   []string
+	fallbackTarget    *repoTarget
+	slackWebhookURL   string
+	fallbackStorePath = "fallback-issues.jsonl"
+
+	// fallbackStoreMu serializes access to the fallback store file.
+	fallbackStoreMu sync.Mutex
+)
+
+// storedIssue is an issue written to the fallback store for later
+// submission.
+type storedIssue struct {
+	Repository string    `json:"repository"`
+	Title      string    `json:"title"`
+	Body       string    `json:"body"`
+	Labels     []string  `json:"labels"`
+	Error      string    `json:"error"`
+	StoredAt   time.Time `json:"stored_at"`
+}
+
+// createIssueWithRetry creates an issue directly, bypassing the writer
+// queue. It is used by the fallbacks, which already run inside the writer.
+func createIssueWithRetry(ctx context.Context, target repoTarget, req *github.IssueRequest) (*github.Issue, error) {
+	var issue *github.Issue
+	err := retryGitHub(ctx, func() (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		issue, resp, err = ghClient.Issues.Create(ctx, target.Owner, target.Repo, req)
+		return resp, err
+	})
+	return issue, err
+}
+
+// runIssueFallbacks tries the configured fallback actions in order and
+// returns a description of the first one that succeeded.
+func runIssueFallbacks(ctx context.Context, target repoTarget, issue plannedIssue, createErr error) (string, error) {
+	if len(fallbackActions) == 0 {
+		return "", errors.New("no fallback configured")
+	}
+
+	var errs []error
+	for _, action := range fallbackActions {
+		var result string
+		var err error
+		switch action {
+		case fallbackRepo:
+			result, err = fallbackToRepo(ctx, target, issue)
+		case fallbackSlack:
+			result, err = fallbackToSlack(ctx, target, issue, createErr)
+		case fallbackStore:
+			result, err = fallbackToStore(target, issue, createErr)
+		default:
+			err = fmt.Errorf("unknown fallback action %q", action)
+		}
+
+		if err == nil {
+			slog.InfoContext(ctx, "Issue saved via fallback", "repository", target.String(), "fallback", action, "result", result)
+			return result, nil
+		}
+		slog.ErrorContext(ctx, "Fallback failed", "fallback", action, "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", action, err))
+	}
+	return "", errors.Join(errs...)
+}
+
+func fallbackToRepo(ctx context.Context, target repoTarget, issue plannedIssue) (string, error) {
+	if fallbackTarget == nil {
+		return "", errors.New("no fallback repository configured")
+	}
+
+	body := fmt.Sprintf("_Intended for %s, where issue creation failed._\n\n%s", target, issue.Body)
+	created, err := createIssueWithRetry(ctx, *fallbackTarget, &github.IssueRequest{
+		Title:  &issue.Title,
+		Body:   &body,
+		Labels: &issue.Labels,
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("created in fallback repository %s, URL: %s", *fallbackTarget, created.GetHTMLURL()), nil
+}
+
+func fallbackToSlack(ctx context.Context, target repoTarget, issue plannedIssue, createErr error) (string, error) {
+	if slackWebhookURL == "" {
+		return "", errors.New("SLACK_WEBHOOK_URL is not set")
+	}
+
+	text := fmt.Sprintf(":warning: Could not create a GitHub issue in %s: %v\n*%s*\n```%s```", target, createErr, issue.Title, truncate(issue.Body, 2500))
+	payload, _ := json.Marshal(map[string]string{"text": text})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, slackWebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("slack webhook returned %s", resp.Status)
+	}
+	return "posted to Slack", nil
+}
+
+func fallbackToStore(target repoTarget, issue plannedIssue, createErr error) (string, error) {
+	line, err := json.Marshal(storedIssue{
+		Repository: target.String(),
+		Title:      issue.Title,
+		Body:       issue.Body,
+		Labels:     issue.Labels,
+		Error:      createErr.Error(),
+		StoredAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	fallbackStoreMu.Lock()
+	defer fallbackStoreMu.Unlock()
+
+	f, err := os.OpenFile(fallbackStorePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("stored in %s for later submission", fallbackStorePath), nil
+}
+
+type resubmitResult struct {
+	Submitted []string `json:"submitted"`
+	Remaining int      `json:"remaining"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// resubmitStoredIssues tries to create every issue in the fallback store and
+// keeps only the ones that failed again.
+func resubmitStoredIssues(ctx context.Context) (resubmitResult, error) {
+	fallbackStoreMu.Lock()
+	defer fallbackStoreMu.Unlock()
+
+	result := resubmitResult{Submitted: []string{}}
+
+	data, err := os.ReadFile(fallbackStorePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+
+	var remaining []byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var stored storedIssue
+		if err := json.Unmarshal(line, &stored); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("skipping malformed entry: %v", err))
+			remaining = append(remaining, append(line, '\n')...)
+			continue
+		}
+
+		target, err := parseRepoTarget(stored.Repository)
+		if err == nil {
+			var created *github.Issue
+			created, err = createIssueWithRetry(ctx, target, &github.IssueRequest{
+				Title:  &stored.Title,
+				Body:   &stored.Body,
+				Labels: &stored.Labels,
+			})
+			if err == nil {
+				result.Submitted = append(result.Submitted, created.GetHTMLURL())
+				continue
+			}
+		}
+
+		result.Errors = append(result.Errors, fmt.Sprintf("%q: %v", stored.Title, err))
+		remaining = append(remaining, append(line, '\n')...)
+		result.Remaining++
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+
+	return result, os.WriteFile(fallbackStorePath, remaining, 0o600)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "") + "…"
+}
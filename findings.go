@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// finding is one item of a bulk upload (a static-analysis result, a failed
+// test) analysed like an error log.
+type finding struct {
+	// Name identifies the finding to the caller, e.g. a rule ID or a test
+	// name.
+	Name string
+	// Text is the finding rendered as a log for the agent and the run
+	// registry.
+	Text     string
+	Analysis *LogAnalysis
+}
+
+// FindingResult reports what happened to one finding of an upload.
+type FindingResult struct {
+	Name        string `json:"name"`
+	File        string `json:"file,omitempty"`
+	Line        int    `json:"line,omitempty"`
+	Fingerprint string `json:"fingerprint"`
+	// Status is "triaged", "known" (the fingerprint already has an issue),
+	// "duplicate" (repeated in the upload), "skipped" (over the limit) or
+	// "failed".
+	Status   string `json:"status"`
+	IssueURL string `json:"issue_url,omitempty"`
+	RunID    string `json:"run_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// findingsTarget returns the repository for a bulk upload: the default one,
+// or the "repository" query parameter under the same rules as the
+// "repository" request field.
+func findingsTarget(r *http.Request) (repoTarget, int, error) {
+	target := repoTarget{Owner: ghOwner, Repo: ghRepo}
+	if repository := r.URL.Query().Get("repository"); repository != "" {
+		var status int
+		var err error
+		if target, status, err = resolveRepoOverride(r, repository); err != nil {
+			return target, status, err
+		}
+	}
+	if err := authorizeRepo(r, target); err != nil {
+		return target, http.StatusForbidden, err
+	}
+	return target, 0, nil
+}
+
+// triageFindings runs the agent once per new fingerprint, up to limit runs.
+// Findings whose fingerprint already has an issue, and repeats within the
+// upload, are reported but not triaged again. emit, if not nil, is called
+// with each result as soon as it is known.
+func triageFindings(ctx context.Context, target repoTarget, findings []finding, limit int, emit func(FindingResult)) []FindingResult {
+	results := []FindingResult{}
+	seen := make(map[string]bool)
+	triaged := 0
+	for _, f := range findings {
+		a := f.Analysis
+		result := FindingResult{Name: f.Name, Fingerprint: a.Fingerprint}
+		if len(a.Frames) > 0 {
+			result.File, result.Line = a.Frames[0].File, a.Frames[0].Line
+		}
+
+		switch {
+		case seen[a.Fingerprint]:
+			result.Status = "duplicate"
+		case triaged >= limit:
+			result.Status = "skipped"
+		default:
+			seen[a.Fingerprint] = true
+			state, _ := lifecycles.observe(a.Fingerprint, target, "", a)
+			a.Lifecycle = &state
+			trackOccurrences(state)
+			if state.State != stateNew {
+				result.Status = "known"
+				result.IssueURL = state.IssueURL
+				break
+			}
+
+			triaged++
+			a.Message = sanitizeText(a.Message)
+			run := runs.start(triageInput{Target: target, ErrorLog: sanitizeText(f.Text), Analysis: a, RequestID: requestID(ctx), ClientTag: clientTag(ctx), Source: ingestSource(ctx)})
+			result.RunID = run.ID
+			out, err := executeTriage(ctx, run, newToolSession(target, false), a)
+			if err != nil {
+				result.Status = "failed"
+				result.Error = err.Error()
+				break
+			}
+			result.Status = "triaged"
+			result.IssueURL = out.IssueURL
+		}
+		results = append(results, result)
+		if emit != nil {
+			emit(result)
+		}
+	}
+	return results
+}
+
+// wantsStream reports whether the caller asked for a bulk upload's results
+// as newline-delimited JSON, with "Accept: application/x-ndjson".
+func wantsStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+// findingsStream writes a bulk upload's results as newline-delimited JSON:
+// a {"finding": ...} line for each result as it completes, then a
+// {"summary": ...} line holding the response a non-streaming request would
+// have received. Each line is flushed, so a large upload doesn't leave the
+// caller waiting on a silent connection until every run is done.
+type findingsStream struct {
+	rc  *http.ResponseController
+	enc *json.Encoder
+}
+
+type findingsStreamLine struct {
+	Finding *FindingResult `json:"finding,omitempty"`
+	Summary any            `json:"summary,omitempty"`
+}
+
+func newFindingsStream(w http.ResponseWriter) *findingsStream {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	return &findingsStream{rc: http.NewResponseController(w), enc: json.NewEncoder(w)}
+}
+
+func (s *findingsStream) result(res FindingResult) {
+	s.write(findingsStreamLine{Finding: &res})
+}
+
+func (s *findingsStream) summary(v any) {
+	s.write(findingsStreamLine{Summary: v})
+}
+
+func (s *findingsStream) write(line findingsStreamLine) {
+	if err := s.enc.Encode(line); err != nil {
+		slog.Error("Writing streamed result failed", "error", err)
+		return
+	}
+	if err := s.rc.Flush(); err != nil {
+		slog.Error("Flushing streamed result failed", "error", err)
+	}
+}
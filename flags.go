@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Feature flags gate the risky capabilities of the agent, so that they can
+// be enabled gradually per repository and turned off without a redeploy.
+const (
+	flagAutoClose  = "auto_close"
+	flagAutoAssign = "auto_assign"
+	flagDraftPRs   = "draft_prs"
+	flagReopen     = "reopen"
+)
+
+// flagDefaults are the states of the flags that neither the config file
+// nor the admin API set. Capabilities that predate the flags stay on.
+var flagDefaults = map[string]bool{
+	flagAutoClose:  false,
+	flagAutoAssign: true,
+	flagDraftPRs:   false,
+	flagReopen:     true,
+}
+
+func init() {
+	metrics.describe("triage_feature_flag_blocked_total", "counter", "Actions skipped because their feature flag is off, by flag.")
+}
+
+// FeatureFlag turns a capability on or off, with per-repository exceptions.
+type FeatureFlag struct {
+	// Enabled is the state for repositories not listed in Repos. Nil
+	// leaves it to the layer below.
+	Enabled *bool `yaml:"enabled" json:"enabled,omitempty"`
+	// Repos sets the state for single repositories, keyed by "owner/repo".
+	Repos map[string]bool `yaml:"repos" json:"repos,omitempty"`
+}
+
+// state returns the flag's state in a repository, if the flag sets one.
+func (f FeatureFlag) state(target repoTarget) (on, ok bool) {
+	for repo, on := range f.Repos {
+		if strings.EqualFold(repo, target.String()) {
+			return on, true
+		}
+	}
+	if f.Enabled != nil {
+		return *f.Enabled, true
+	}
+	return false, false
+}
+
+func validateFeatureFlag(prefix string, f FeatureFlag) []string {
+	var problems []string
+	for _, repo := range sortedKeys(f.Repos) {
+		if _, err := parseRepoTarget(repo); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.repos: %v", prefix, err))
+		}
+	}
+	return problems
+}
+
+func validateFeatureFlags(cfg map[string]FeatureFlag) []string {
+	var problems []string
+	for _, name := range sortedKeys(cfg) {
+		if _, ok := flagDefaults[name]; !ok {
+			problems = append(problems, fmt.Sprintf("feature_flags: unknown flag %q, expected one of %s", name, strings.Join(sortedKeys(flagDefaults), ", ")))
+		}
+		problems = append(problems, validateFeatureFlag("feature_flags."+name, cfg[name])...)
+	}
+	return problems
+}
+
+// featureFlagsKey is the store key holding the overrides set through the
+// admin API.
+const featureFlagsKey = "feature_flags"
+
+// FlagStatus describes a flag for the admin API.
+type FlagStatus struct {
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+	// Config and Override are the flag's settings in the config file and
+	// through the admin API. An override takes precedence.
+	Config   *FeatureFlag `json:"config,omitempty"`
+	Override *FeatureFlag `json:"override,omitempty"`
+}
+
+// flagRegistry combines the flags of the config file with the overrides
+// set through the admin API, which are kept in the store so that every
+// replica sees a change on its next check.
+type flagRegistry struct {
+	mu         sync.Mutex
+	configured map[string]FeatureFlag
+}
+
+var flags = &flagRegistry{}
+
+// configure sets the flags from the config file.
+func (reg *flagRegistry) configure(cfg map[string]FeatureFlag) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.configured = cfg
+}
+
+func (reg *flagRegistry) overrides() map[string]FeatureFlag {
+	out := make(map[string]FeatureFlag)
+	data, ok, err := store.GetValue(context.Background(), featureFlagsKey)
+	if err != nil || !ok {
+		logStoreError("get feature flags", err)
+		return out
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		logStoreError("get feature flags", err)
+	}
+	return out
+}
+
+// enabled reports whether a flag is on in a repository: by its override,
+// else by the config file, else by its default.
+func (reg *flagRegistry) enabled(name string, target repoTarget) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if on, ok := reg.overrides()[name].state(target); ok {
+		return on
+	}
+	if on, ok := reg.configured[name].state(target); ok {
+		return on
+	}
+	return flagDefaults[name]
+}
+
+// allows is enabled for callers that skip the gated action when the flag
+// is off, counting the skip.
+func (reg *flagRegistry) allows(ctx context.Context, name string, target repoTarget) bool {
+	if reg.enabled(name, target) {
+		return true
+	}
+	metrics.add("triage_feature_flag_blocked_total", labelSet("flag", name), 1)
+	slog.InfoContext(ctx, "Feature flag is off, skipping", "flag", name, "repository", target.String())
+	return false
+}
+
+// list returns all flags by name.
+func (reg *flagRegistry) list() []FlagStatus {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	overrides := reg.overrides()
+	var out []FlagStatus
+	for _, name := range sortedKeys(flagDefaults) {
+		status := FlagStatus{Name: name, Default: flagDefaults[name]}
+		if f, ok := reg.configured[name]; ok {
+			status.Config = &f
+		}
+		if f, ok := overrides[name]; ok {
+			status.Override = &f
+		}
+		out = append(out, status)
+	}
+	return out
+}
+
+// put sets or replaces the override of a flag.
+func (reg *flagRegistry) put(name string, f FeatureFlag) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	all := reg.overrides()
+	all[name] = f
+	return reg.save(all)
+}
+
+// delete removes the override of a flag, returning it to its config.
+func (reg *flagRegistry) delete(name string) (bool, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	all := reg.overrides()
+	if _, ok := all[name]; !ok {
+		return false, nil
+	}
+	delete(all, name)
+	return true, reg.save(all)
+}
+
+func (reg *flagRegistry) save(all map[string]FeatureFlag) error {
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return store.SetValue(context.Background(), featureFlagsKey, data)
+}
+
+func handleAdminListFlags(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, flags.list())
+}
+
+// handleAdminPutFlag overrides a flag, e.g. {"enabled": false} to turn a
+// capability off in every repository at once.
+func handleAdminPutFlag(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if _, ok := flagDefaults[name]; !ok {
+		http.Error(w, "Feature flag not found", http.StatusNotFound)
+		return
+	}
+	var f FeatureFlag
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if problems := validateFeatureFlag("flag", f); len(problems) > 0 {
+		http.Error(w, strings.Join(problems, "\n"), http.StatusBadRequest)
+		return
+	}
+
+	if err := flags.put(name, f); err != nil {
+		http.Error(w, fmt.Sprintf("Saving feature flag failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	log := slog.With("flag", name, "repos", f.Repos)
+	if f.Enabled != nil {
+		log = log.With("enabled", *f.Enabled)
+	}
+	log.InfoContext(r.Context(), "Feature flag overridden")
+	all := flags.list()
+	i := slices.IndexFunc(all, func(s FlagStatus) bool { return s.Name == name })
+	writeJSON(w, http.StatusOK, all[i])
+}
+
+func handleAdminDeleteFlag(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ok, err := flags.delete(name)
+	switch {
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Deleting feature flag override failed: %v", err), http.StatusInternalServerError)
+		return
+	case !ok:
+		http.Error(w, "Feature flag has no override", http.StatusNotFound)
+		return
+	}
+	slog.InfoContext(r.Context(), "Feature flag override removed", "flag", name)
+	w.WriteHeader(http.StatusNoContent)
+}
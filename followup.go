@@ -0,0 +1,33 @@
+package main
+
+// followUpCreatedIssues finishes the issues the run created: it assigns
+// them to the CODEOWNERS users of the application frames, files them in
+// the milestone of the release and adds them to the project board. The
+// service does this after the run rather than the agent, so that the
+// agent's tool chain stays within its iterations.
+func (t *toolSession) followUpCreatedIssues() {
+	t.mu.Lock()
+	created := append([]int(nil), t.createdNumbers...)
+	t.mu.Unlock()
+	if t.dryRun || len(created) == 0 || t.analysis == nil {
+		return
+	}
+
+	files := appFrameFiles(t.analysis)
+	release := t.analysis.Metadata["release"]
+	for _, number := range created {
+		if len(files) > 0 {
+			if err := t.assignIssue(number, files); err != nil {
+				t.log.Error("Assigning the new issue failed", "issue_number", number, "error", err)
+			}
+		}
+		if versionPattern.MatchString(release) {
+			if err := t.setMilestone(number, release); err != nil {
+				t.log.Error("Setting the milestone of the new issue failed", "issue_number", number, "error", err)
+			}
+		}
+		if err := t.addToProject(number); err != nil {
+			t.log.Error("Adding the new issue to the project board failed", "issue_number", number, "error", err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	metrics.describe("triage_github_api_requests_total", "counter", "GitHub API calls, by method, route and status (error when no response was received).")
+	metrics.describe("triage_github_api_request_seconds_total", "counter", "Time spent in GitHub API calls, by method and route.")
+	metrics.describe("triage_github_rate_limit_remaining", "gauge", "Requests left in the current GitHub rate limit window, by resource (core, search, graphql...), as of the last call.")
+}
+
+// githubTransport logs and measures every GitHub API call, with the run it
+// was made for, so that rate-limit burn can be attributed.
+type githubTransport struct {
+	// base defaults to http.DefaultTransport, read per call since it is
+	// wrapped after the GitHub client is built.
+	base http.RoundTripper
+}
+
+func (t *githubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	ctx := req.Context()
+	route := githubRoute(req.URL.Path)
+	args := []any{"method", req.Method, "path", req.URL.Path, "duration_ms", elapsed.Milliseconds()}
+	if id := runID(ctx); id != "" {
+		args = append(args, "run_id", id)
+	}
+	status := "error"
+	if err != nil {
+		slog.WarnContext(ctx, "GitHub API call failed", append(args, "error", err)...)
+	} else {
+		status = strconv.Itoa(resp.StatusCode)
+		remaining := resp.Header.Get("X-RateLimit-Remaining")
+		if n, err := strconv.Atoi(remaining); err == nil {
+			resource := firstNonEmpty(resp.Header.Get("X-RateLimit-Resource"), "core")
+			metrics.set("triage_github_rate_limit_remaining", labelSet("resource", resource), float64(n))
+		}
+		slog.InfoContext(ctx, "GitHub API call", append(args, "status", resp.StatusCode, "rate_limit_remaining", remaining)...)
+	}
+	metrics.add("triage_github_api_requests_total", labelSet("method", req.Method, "route", route, "status", status), 1)
+	metrics.add("triage_github_api_request_seconds_total", labelSet("method", req.Method, "route", route), elapsed.Seconds())
+	return resp, err
+}
+
+// githubRoute replaces the owner, repository, numbers and label names of an
+// API path with placeholders, to keep the metric labels few, e.g.
+// /repos/{owner}/{repo}/issues/{number}/comments.
+func githubRoute(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range parts {
+		switch {
+		case parts[0] == "repos" && i == 1:
+			parts[i] = "{owner}"
+		case parts[0] == "repos" && i == 2:
+			parts[i] = "{repo}"
+		case part != "" && strings.Trim(part, "0123456789") == "":
+			parts[i] = "{number}"
+		case i > 0 && parts[i-1] == "labels":
+			parts[i] = "{name}"
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
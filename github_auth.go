@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	githubAPIURL = "https://api.github.com"
+	// appJWTLifetime is the lifetime of the JWT an app signs to request an
+	// installation token; GitHub allows at most 10 minutes.
+	appJWTLifetime = 9 * time.Minute
+	// installationTokenMargin renews installation tokens this long before
+	// they expire, so a token never runs out mid-run.
+	installationTokenMargin = 5 * time.Minute
+)
+
+// githubAuthDescription says which credential is in use, for messages.
+var githubAuthDescription = "GITHUB_TOKEN"
+
+// githubTokenSource returns the GitHub credentials from the environment:
+// a GitHub App installation when GITHUB_APP_ID is set, otherwise the
+// personal access token in GITHUB_TOKEN.
+func githubTokenSource() (oauth2.TokenSource, error) {
+	if appID := os.Getenv("GITHUB_APP_ID"); appID != "" {
+		src, err := newAppTokenSource(appID, os.Getenv("GITHUB_APP_INSTALLATION_ID"), os.Getenv("GITHUB_APP_PRIVATE_KEY"), os.Getenv("GITHUB_APP_PRIVATE_KEY_PATH"))
+		if err != nil {
+			return nil, fmt.Errorf("GitHub App: %w", err)
+		}
+		githubAuthDescription = "the GitHub App installation"
+		return oauth2.ReuseTokenSource(nil, src), nil
+	}
+
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		return nil, errors.New("GITHUB_TOKEN or GITHUB_APP_ID must be set")
+	}
+	return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}), nil
+}
+
+// appTokenSource exchanges a JWT signed with the app's private key for
+// installation access tokens. Wrapped in oauth2.ReuseTokenSource, a new
+// token is fetched only when the current one is about to expire.
+type appTokenSource struct {
+	appID          string
+	installationID int64
+	key            *rsa.PrivateKey
+	http           *http.Client
+}
+
+func newAppTokenSource(appID, installationID, keyPEM, keyPath string) (*appTokenSource, error) {
+	if installationID == "" {
+		return nil, errors.New("GITHUB_APP_INSTALLATION_ID must be set")
+	}
+	id, err := strconv.ParseInt(installationID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GITHUB_APP_INSTALLATION_ID %q", installationID)
+	}
+
+	if keyPEM == "" {
+		if keyPath == "" {
+			return nil, errors.New("GITHUB_APP_PRIVATE_KEY or GITHUB_APP_PRIVATE_KEY_PATH must be set")
+		}
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM = string(data)
+	}
+	key, err := parseRSAPrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &appTokenSource{
+		appID:          appID,
+		installationID: id,
+		key:            key,
+		http:           &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func parseRSAPrivateKey(keyPEM string) (*rsa.PrivateKey, error) {
+	// Keys passed through environment variables often have their
+	// newlines escaped.
+	block, _ := pem.Decode([]byte(strings.ReplaceAll(keyPEM, `\n`, "\n")))
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// jwt returns an RS256 JWT identifying the app.
+func (s *appTokenSource) jwt() (string, error) {
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(map[string]any{
+		// Backdated to allow for clock drift, as GitHub recommends.
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": s.appID,
+	})
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	sum := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func (s *appTokenSource) Token() (*oauth2.Token, error) {
+	jwt, err := s.jwt()
+	if err != nil {
+		return nil, fmt.Errorf("signing app JWT: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", githubAPIURL, s.installationID)
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting installation token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+		Message   string    `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding installation token: %w", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("requesting installation token: %s: %s", resp.Status, body.Message)
+	}
+
+	return &oauth2.Token{
+		AccessToken: body.Token,
+		TokenType:   "token",
+		Expiry:      body.ExpiresAt.Add(-installationTokenMargin),
+	}, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// graphQLError is an error GitHub's GraphQL API reported for a query,
+// e.g. a path or object that doesn't exist, as opposed to a failed call.
+type graphQLError struct {
+	Messages []string
+}
+
+func (e *graphQLError) Error() string {
+	return "GitHub GraphQL: " + strings.Join(e.Messages, "; ")
+}
+
+// githubGraphQL runs a query against GitHub's GraphQL API with the
+// client's credentials and transport, decoding its data into out. The
+// REST API has no equivalent for some reads, such as blame.
+func githubGraphQL(ctx context.Context, query string, variables map[string]any, out any) error {
+	req, err := ghClient.NewRequest("POST", "graphql", map[string]any{"query": query, "variables": variables})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if _, err := ghClient.Do(ctx, req, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		gqlErr := &graphQLError{}
+		for _, e := range resp.Errors {
+			gqlErr.Messages = append(gqlErr.Messages, e.Message)
+		}
+		return gqlErr
+	}
+	if len(resp.Data) == 0 {
+		return errors.New("GitHub GraphQL: empty response")
+	}
+	if err := json.Unmarshal(resp.Data, out); err != nil {
+		return fmt.Errorf("decoding GitHub GraphQL response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// githubRateLimitAttempts is how many times a rate limited GitHub call
+	// is tried.
+	githubRateLimitAttempts = 5
+	// githubRateLimitMaxWait caps a single wait. Longer waits return the
+	// rate limited response instead.
+	githubRateLimitMaxWait = 15 * time.Minute
+	// githubSecondaryBackoff is the first wait after a secondary rate limit
+	// that doesn't say how long to wait, doubled for each one after it.
+	githubSecondaryBackoff = time.Minute
+	// githubRateLimitBodyLimit caps how much of a 403 or 429 response is
+	// read to tell rate limiting from other refusals.
+	githubRateLimitBodyLimit = 64 << 10
+)
+
+func init() {
+	metrics.describe("triage_github_rate_limit_waits_total", "counter", "GitHub API calls held back or retried because of rate limiting, by limit (primary or secondary).")
+	metrics.describe("triage_github_rate_limit_wait_seconds_total", "counter", "Time GitHub API calls spent waiting for rate limits, by limit.")
+}
+
+// githubRateLimiter holds GitHub API calls back while a rate limit is
+// exhausted and retries calls refused by the primary or secondary (abuse
+// detection) rate limits, so that the agent's tools and the writer see a
+// slow call instead of a rate limit error.
+//
+// go-github refuses calls on its own while it believes a rate limit is
+// exhausted; the reset time is removed from responses so that the waiting
+// is left to the limiter.
+type githubRateLimiter struct {
+	base http.RoundTripper
+
+	mu sync.Mutex
+	// until is when calls may be made again, by rate limit resource. The
+	// empty resource holds back all calls, after a secondary rate limit.
+	until map[string]time.Time
+}
+
+func newGitHubTransport() http.RoundTripper {
+	return &githubRateLimiter{base: &githubTransport{}, until: make(map[string]time.Time)}
+}
+
+// githubResource returns the rate limit resource a call counts against.
+func githubResource(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/search/code"):
+		return "code_search"
+	case strings.HasPrefix(path, "/search/"):
+		return "search"
+	case strings.HasPrefix(path, "/graphql"):
+		return "graphql"
+	}
+	return "core"
+}
+
+func (rl *githubRateLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	resource := githubResource(req.URL.Path)
+	log := slog.With("method", req.Method, "path", req.URL.Path)
+	if id := runID(ctx); id != "" {
+		log = log.With("run_id", id)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := rl.wait(ctx, log, resource); err != nil {
+			return nil, err
+		}
+		resp, err := rl.base.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+
+		limit, wait := rl.observe(resource, resp, attempt)
+		retry := limit != "" && attempt < githubRateLimitAttempts && wait <= githubRateLimitMaxWait &&
+			(req.Body == nil || req.GetBody != nil)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			retry = false
+		}
+		if !retry {
+			resp.Header.Del("X-RateLimit-Reset")
+			return resp, nil
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		log.WarnContext(ctx, "GitHub rate limit hit, retrying", "limit", limit, "attempt", attempt, "wait", wait)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+	}
+}
+
+// wait blocks until calls to the resource may be made again.
+func (rl *githubRateLimiter) wait(ctx context.Context, log *slog.Logger, resource string) error {
+	rl.mu.Lock()
+	until, limit := rl.until[resource], "primary"
+	if global := rl.until[""]; global.After(until) {
+		until, limit = global, "secondary"
+	}
+	rl.mu.Unlock()
+
+	wait := time.Until(until)
+	if wait <= 0 {
+		return nil
+	}
+	log.InfoContext(ctx, "Waiting for GitHub rate limit", "limit", limit, "resource", resource, "wait", wait)
+	metrics.add("triage_github_rate_limit_waits_total", labelSet("limit", limit), 1)
+	metrics.add("triage_github_rate_limit_wait_seconds_total", labelSet("limit", limit), wait.Seconds())
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// observe records the rate limit state of a response. For calls refused by
+// a rate limit it returns which one and how long to wait before retrying.
+func (rl *githubRateLimiter) observe(resource string, resp *http.Response, attempt int) (limit string, wait time.Duration) {
+	resource = firstNonEmpty(resp.Header.Get("X-RateLimit-Resource"), resource)
+	exhausted := resp.Header.Get("X-RateLimit-Remaining") == "0"
+	var reset time.Time
+	if n, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		// A second of slack for clock skew.
+		reset = time.Unix(n, 0).Add(time.Second)
+	}
+	if exhausted && !reset.IsZero() {
+		rl.block(resource, reset)
+	}
+
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return "", 0
+	}
+	switch {
+	case resp.Header.Get("Retry-After") != "":
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		limit, wait = "secondary", time.Duration(max(seconds, 1))*time.Second
+	case exhausted && !reset.IsZero():
+		return "primary", time.Until(reset)
+	case isSecondaryRateLimit(resp):
+		limit, wait = "secondary", githubSecondaryBackoff<<(attempt-1)
+	default:
+		return "", 0
+	}
+	rl.block("", time.Now().Add(wait))
+	return limit, wait
+}
+
+func (rl *githubRateLimiter) block(resource string, until time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if until.After(rl.until[resource]) {
+		rl.until[resource] = until
+	}
+}
+
+// isSecondaryRateLimit reports whether a refusal is GitHub's secondary (or,
+// as it was called, abuse detection) rate limit, leaving the body readable.
+func isSecondaryRateLimit(resp *http.Response) bool {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, githubRateLimitBodyLimit))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+	body := strings.ToLower(string(data))
+	return strings.Contains(body, "secondary rate limit") || strings.Contains(body, "abuse")
+}
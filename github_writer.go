@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	// githubWriteAttempts is how many times a GitHub mutation is tried.
+	githubWriteAttempts = 3
+	githubWriteBackoff  = time.Second
+	// githubWriterConcurrency caps how many issues are written at once.
+	githubWriterConcurrency = 4
+	// intentWaitTimeout is how long a tool waits for its intent to be
+	// written before telling the agent it was queued.
+	intentWaitTimeout = 30 * time.Second
+	// githubWriteTimeout bounds applying an intent, retries included, so
+	// that a hung call doesn't hold its lane and a writer slot.
+	githubWriteTimeout = 2 * time.Minute
+)
+
+// Intent kinds the agent can emit.
+const (
+	intentCreate       = "create"
+	intentComment      = "comment"
+	intentAddLabels    = "add_labels"
+	intentRemoveLabels = "remove_labels"
+	intentReopen       = "reopen"
+	intentAssign       = "assign"
+	intentOccurrences  = "occurrences"
+	intentMilestone    = "milestone"
+	intentProject      = "project"
+)
+
+// githubIntent is an issue tracker mutation requested by the agent. Tools
+// don't write to the tracker themselves: they submit intents to the writer,
+// which applies them in order per issue and batches label changes.
+type githubIntent struct {
+	ID          string
+	Kind        string
+	Target      repoTarget
+	IssueNumber int
+	// Title is the issue title, or the release of a milestone intent.
+	Title string
+	Body  string
+	// Labels are the labels to add or remove, or the users to assign.
+	Labels []string
+	// RunID is the run the intent was submitted by, if any.
+	RunID string
+
+	done chan intentResult
+}
+
+type intentResult struct {
+	URL    string
+	Number int
+	Err    error
+	// Fallback describes where a failed create ended up instead.
+	Fallback string
+	// Jira is the mirrored Jira issue of a create in dual-write mode.
+	Jira *jiraIssue
+	// Milestone is the milestone a milestone intent set, if one matched.
+	Milestone string
+}
+
+// laneKey groups intents that must be applied in order. Creates have no
+// issue yet and each get their own lane.
+func (in *githubIntent) laneKey() string {
+	if in.Kind == intentCreate {
+		return in.Target.String() + "#new-" + in.ID
+	}
+	return in.Target.String() + "#" + strconv.Itoa(in.IssueNumber)
+}
+
+type githubWriter struct {
+	mu    sync.Mutex
+	lanes map[string][]*githubIntent
+	sem   chan struct{}
+}
+
+var writer = &githubWriter{
+	lanes: make(map[string][]*githubIntent),
+	sem:   make(chan struct{}, githubWriterConcurrency),
+}
+
+// submit queues an intent. The returned channel receives its result.
+func (gw *githubWriter) submit(in *githubIntent) <-chan intentResult {
+	in.ID = newID()
+	in.done = make(chan intentResult, 1)
+
+	gw.mu.Lock()
+	key := in.laneKey()
+	_, active := gw.lanes[key]
+	gw.lanes[key] = append(gw.lanes[key], in)
+	gw.mu.Unlock()
+
+	slog.Debug("Queued GitHub intent", "kind", in.Kind, "intent_id", in.ID, "lane", key)
+	if !active {
+		go gw.drain(key)
+	}
+	return in.done
+}
+
+// submitAndWait queues an intent and waits for it up to intentWaitTimeout.
+// The boolean is false if the intent is still queued.
+func (gw *githubWriter) submitAndWait(in *githubIntent) (intentResult, bool) {
+	done := gw.submit(in)
+	select {
+	case res := <-done:
+		return res, true
+	case <-time.After(intentWaitTimeout):
+		return intentResult{}, false
+	}
+}
+
+// drain applies the intents of one lane in order until the lane is empty.
+func (gw *githubWriter) drain(key string) {
+	gw.sem <- struct{}{}
+	defer func() { <-gw.sem }()
+
+	for {
+		gw.mu.Lock()
+		pending := gw.lanes[key]
+		if len(pending) == 0 {
+			delete(gw.lanes, key)
+			gw.mu.Unlock()
+			return
+		}
+		gw.lanes[key] = pending[:0:0]
+		gw.mu.Unlock()
+
+		for _, batch := range batchIntents(pending) {
+			res := gw.apply(batch[0])
+			for _, in := range batch {
+				in.done <- res
+			}
+		}
+	}
+}
+
+// batchIntents merges consecutive label additions on the same issue into a
+// single call, and consecutive occurrence updates into the latest one;
+// everything else is applied on its own.
+func batchIntents(intents []*githubIntent) [][]*githubIntent {
+	var batches [][]*githubIntent
+	for _, in := range intents {
+		if n := len(batches); n > 0 && in.Kind == intentAddLabels && batches[n-1][0].Kind == intentAddLabels {
+			head := batches[n-1][0]
+			head.Labels = append(head.Labels, in.Labels...)
+			batches[n-1] = append(batches[n-1], in)
+			continue
+		}
+		if n := len(batches); n > 0 && in.Kind == intentOccurrences && batches[n-1][0].Kind == intentOccurrences {
+			// Only the latest counts need to be written.
+			batches[n-1][0].Body = in.Body
+			batches[n-1] = append(batches[n-1], in)
+			continue
+		}
+		batches = append(batches, []*githubIntent{in})
+	}
+	return batches
+}
+
+func (gw *githubWriter) apply(in *githubIntent) (res intentResult) {
+	ctx, cancel := context.WithTimeout(contextWithRunID(context.Background(), in.RunID), githubWriteTimeout)
+	defer cancel()
+	defer func() {
+		if rec := recover(); rec != nil {
+			res = intentResult{Err: recoveredPanic(ctx, "writer", rec), Number: in.IssueNumber}
+		}
+	}()
+	t := in.Target
+	// The agent may still have copied a secret or personal data into what
+	// it writes.
+	in.Title = sanitizeText(in.Title)
+	in.Body = sanitizeText(in.Body)
+	if sandboxTarget != nil && t != *sandboxTarget {
+		return gw.applySandboxed(ctx, in)
+	}
+
+	switch in.Kind {
+	case intentCreate:
+		issue := plannedIssue{Title: in.Title, Body: in.Body, Labels: in.Labels}
+		created, recorded, err := createOnce(ctx, t, issue)
+		if err != nil {
+			res := intentResult{Err: err}
+			res.Fallback, _ = runIssueFallbacks(ctx, t, issue, err)
+			return res
+		}
+		res := intentResult{URL: created.URL, Number: created.Number}
+		if jira != nil && !recorded {
+			if mirrored, err := mirrorToJira(ctx, t, created, in); err != nil {
+				slog.Error("Mirroring to Jira failed", "issue_url", res.URL, "error", err)
+			} else {
+				res.Jira = &mirrored
+			}
+		}
+		return res
+
+	case intentComment:
+		url, err := tracker.Comment(ctx, t, in.IssueNumber, in.Body)
+		if err != nil {
+			return intentResult{Err: err}
+		}
+		return intentResult{URL: url, Number: in.IssueNumber}
+
+	case intentAddLabels:
+		err := tracker.Label(ctx, t, in.IssueNumber, in.Labels, nil)
+		return intentResult{Err: err, Number: in.IssueNumber}
+
+	case intentRemoveLabels:
+		err := tracker.Label(ctx, t, in.IssueNumber, nil, in.Labels)
+		return intentResult{Err: err, Number: in.IssueNumber}
+
+	case intentReopen:
+		err := tracker.Reopen(ctx, t, in.IssueNumber)
+		return intentResult{Err: err, Number: in.IssueNumber}
+
+	case intentAssign:
+		// Assignees are GitHub users, so rules only assign on GitHub.
+		if tracker.Name() != trackerGitHub {
+			return intentResult{Err: fmt.Errorf("assigning issues is not supported on %s", tracker.Name()), Number: in.IssueNumber}
+		}
+		err := retryGitHub(ctx, func() (*github.Response, error) {
+			_, resp, err := ghClient.Issues.AddAssignees(ctx, t.Owner, t.Repo, in.IssueNumber, in.Labels)
+			return resp, err
+		})
+		return intentResult{Err: err, Number: in.IssueNumber}
+
+	case intentOccurrences:
+		err := updateOccurrences(ctx, t, in.IssueNumber, in.Body)
+		return intentResult{Err: err, Number: in.IssueNumber}
+
+	case intentMilestone:
+		if tracker.Name() != trackerGitHub {
+			return intentResult{Err: fmt.Errorf("milestones are not supported on %s", tracker.Name()), Number: in.IssueNumber}
+		}
+		milestone, err := setIssueMilestone(ctx, t, in.IssueNumber, in.Title)
+		return intentResult{Err: err, Number: in.IssueNumber, Milestone: milestone}
+
+	case intentProject:
+		if tracker.Name() != trackerGitHub {
+			return intentResult{Err: fmt.Errorf("project boards are not supported on %s", tracker.Name()), Number: in.IssueNumber}
+		}
+		err := addIssueToProject(ctx, t, in.IssueNumber)
+		return intentResult{Err: err, Number: in.IssueNumber}
+	}
+
+	return intentResult{Err: fmt.Errorf("unknown intent kind %q", in.Kind)}
+}
+
+// retryGitHub calls fn until it succeeds, retrying errors that may be
+// transient: network errors, 5xx, and 403/429 rate limiting. It stops
+// waiting to retry when ctx is done.
+func retryGitHub(ctx context.Context, fn func() (*github.Response, error)) error {
+	var lastErr error
+	for attempt := 1; attempt <= githubWriteAttempts; attempt++ {
+		resp, err := fn()
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+
+		if resp != nil && resp.StatusCode < 500 && resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+			break
+		}
+		if attempt < githubWriteAttempts {
+			slog.WarnContext(ctx, "GitHub write failed", "attempt", attempt, "max_attempts", githubWriteAttempts, "error", err)
+			select {
+			case <-ctx.Done():
+				return lastErr
+			case <-time.After(githubWriteBackoff * time.Duration(attempt)):
+			}
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func TestRetryGitHub(t *testing.T) {
+	errFailed := errors.New("failed")
+	response := func(status int) *github.Response {
+		return &github.Response{Response: &http.Response{StatusCode: status}}
+	}
+	tests := []struct {
+		name     string
+		statuses []int
+		// cancelAfter cancels the context after that many calls.
+		cancelAfter int
+		wantCalls   int
+		wantErr     bool
+	}{
+		{name: "succeeds", statuses: []int{http.StatusCreated}, wantCalls: 1},
+		{name: "client error is not retried", statuses: []int{http.StatusUnprocessableEntity}, wantCalls: 1, wantErr: true},
+		{name: "not found is not retried", statuses: []int{http.StatusNotFound}, wantCalls: 1, wantErr: true},
+		{name: "server error is retried", statuses: []int{http.StatusBadGateway, http.StatusCreated}, wantCalls: 2},
+		{name: "rate limit is retried", statuses: []int{http.StatusTooManyRequests, http.StatusCreated}, wantCalls: 2},
+		{name: "network error is retried", statuses: []int{0, http.StatusCreated}, wantCalls: 2},
+		{name: "cancelled while waiting", statuses: []int{http.StatusBadGateway, http.StatusCreated}, cancelAfter: 1, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			calls := 0
+			start := time.Now()
+			err := retryGitHub(ctx, func() (*github.Response, error) {
+				status := tt.statuses[calls]
+				calls++
+				if calls == tt.cancelAfter {
+					cancel()
+				}
+				switch {
+				case status == 0:
+					return nil, errFailed
+				case status >= 400:
+					return response(status), errFailed
+				}
+				return response(status), nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.cancelAfter > 0 && time.Since(start) >= githubWriteBackoff {
+				t.Errorf("retryGitHub waited %v after the context was cancelled", time.Since(start))
+			}
+		})
+	}
+}
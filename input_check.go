@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxRejections is how many rejected submissions are kept for GET /rejections.
+	maxRejections = 100
+	// rejectionSampleLen is how much of a rejected log is kept, hex-encoded.
+	rejectionSampleLen = 64
+)
+
+var (
+	base64BlobPattern = regexp.MustCompile(`[A-Za-z0-9+/]{200,}={0,2}`)
+	// mojibakePattern matches UTF-8 text that was decoded as Latin-1 or
+	// Windows-1252 somewhere on the way, e.g. "Ã©" for "é" or "â€™" for "’".
+	mojibakePattern = regexp.MustCompile(`Ã[\x{0080}-\x{00BF}]|â€[\x{0080}-\x{00BF}\x{2018}-\x{201E}\x{2122}]|Â[\x{00A0}-\x{00BF}]`)
+)
+
+// Rejection records a submission that was refused before reaching the agent,
+// so that the submitter can find out what was wrong with it.
+type Rejection struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Reason     string    `json:"reason"`
+	Length     int       `json:"length"`
+	SampleHex  string    `json:"sample_hex"`
+}
+
+type rejectionLog struct {
+	mu      sync.Mutex
+	entries []Rejection
+}
+
+var rejections = &rejectionLog{}
+
+func (l *rejectionLog) record(r *http.Request, errorLog string, reason string) Rejection {
+	sample := errorLog
+	if len(sample) > rejectionSampleLen {
+		sample = sample[:rejectionSampleLen]
+	}
+
+	rejection := Rejection{
+		ID:         newID(),
+		Time:       time.Now().UTC(),
+		RemoteAddr: r.RemoteAddr,
+		Reason:     reason,
+		Length:     len(errorLog),
+		SampleHex:  hex.EncodeToString([]byte(sample)),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, rejection)
+	if len(l.entries) > maxRejections {
+		l.entries = l.entries[len(l.entries)-maxRejections:]
+	}
+	return rejection
+}
+
+func (l *rejectionLog) list() []Rejection {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := make([]Rejection, len(l.entries))
+	for i, e := range l.entries {
+		out[len(l.entries)-1-i] = e
+	}
+	return out
+}
+
+// checkErrorLogText returns a reason when the log is not usable text: binary
+// data, invalid or truncated UTF-8, mojibake or a large base64 blob. Sending
+// such input to the LLM wastes tokens and produces useless issues.
+func checkErrorLogText(errorLog string) string {
+	if !utf8.ValidString(errorLog) {
+		for i := 0; i < len(errorLog); {
+			r, size := utf8.DecodeRuneInString(errorLog[i:])
+			if r == utf8.RuneError && size <= 1 {
+				return fmt.Sprintf("invalid or truncated UTF-8 sequence at byte %d", i)
+			}
+			i += size
+		}
+	}
+
+	var total, control, replacement int
+	for _, r := range errorLog {
+		total++
+		switch {
+		case r == utf8.RuneError:
+			replacement++
+		case r == '\n' || r == '\r' || r == '\t':
+		case unicode.IsControl(r):
+			control++
+		}
+	}
+
+	// The JSON decoder turns invalid UTF-8 into U+FFFD, so binary data
+	// usually shows up as replacement characters rather than raw bytes.
+	if control > 0 && control*20 > total {
+		return fmt.Sprintf("looks like binary data (%d of %d characters are control characters)", control, total)
+	}
+	if replacement > 0 && replacement*20 > total {
+		return fmt.Sprintf("looks like binary or mis-encoded data (%d of %d characters are U+FFFD replacement characters)", replacement, total)
+	}
+
+	if n := len(mojibakePattern.FindAllStringIndex(errorLog, -1)); n >= 3 {
+		return fmt.Sprintf("looks like mojibake (%d sequences of UTF-8 decoded as Latin-1, e.g. %q)", n, mojibakePattern.FindString(errorLog))
+	}
+
+	blobLen := 0
+	for _, loc := range base64BlobPattern.FindAllStringIndex(errorLog, -1) {
+		blobLen += loc[1] - loc[0]
+	}
+	if blobLen*2 > len(strings.TrimSpace(errorLog)) {
+		return fmt.Sprintf("mostly a base64 blob (%d of %d bytes); decode it before submitting", blobLen, len(errorLog))
+	}
+
+	return ""
+}
+
+// rejectErrorLog records the rejection and tells the submitter why the log
+// was refused and where to find the details.
+func rejectErrorLog(w http.ResponseWriter, r *http.Request, errorLog string, reason string) {
+	rejection := rejections.record(r, errorLog, reason)
+	slog.WarnContext(r.Context(), "Rejected error log", "rejection_id", rejection.ID, "remote_addr", rejection.RemoteAddr, "reason", reason)
+
+	http.Error(w, fmt.Sprintf("Error log rejected (id %s): %s", rejection.ID, reason), http.StatusUnprocessableEntity)
+}
+
+func handleListRejections(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rejections.list())
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// IssueTemplateConfig sets a Go text/template that lays out the body of
+// every issue the agent creates, so that issues follow the organization's
+// format whatever the agent writes. The agent's text is one of its fields.
+type IssueTemplateConfig struct {
+	// Body is the template.
+	Body string `yaml:"body"`
+	// File reads the template from a file instead.
+	File string `yaml:"file"`
+}
+
+// IssueTemplateData are the fields of the issue template.
+type IssueTemplateData struct {
+	// Title and Description are the title and body the agent wrote.
+	Title       string
+	Description string
+	Repository  string
+	Fingerprint string
+	Category    string
+	ErrorType   string
+	Message     string
+	Severity    string
+	Service     string
+	Environment string
+	Release     string
+	ErrorLog    string
+	// FirstSeen and LastSeen are when the error was first and last seen,
+	// and Occurrences how often.
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	Occurrences int
+	Labels      []string
+	// Metadata is the metadata of the pre-analysis, e.g. {{index .Metadata
+	// "pod"}}.
+	Metadata map[string]string
+	RunID    string
+}
+
+// issueTemplate is nil when the agent's body is used as is.
+var issueTemplate *template.Template
+
+var issueTemplateFuncs = template.FuncMap{
+	// codeBlock fences text so that backticks in it can't end the block.
+	"codeBlock": func(text string) string {
+		fence := "```"
+		for strings.Contains(text, fence) {
+			fence += "`"
+		}
+		return fence + "\n" + strings.TrimRight(text, "\n") + "\n" + fence
+	},
+	"timestamp": func(t time.Time) string { return t.UTC().Format(issueTimeLayout) },
+	"join":      strings.Join,
+}
+
+func configIssueTemplate(cfg *Config) IssueTemplateConfig {
+	out := cfg.IssueTemplate
+	out.File = envOr("ISSUE_TEMPLATE_FILE", out.File)
+	return out
+}
+
+// compileIssueTemplate parses the issue template and renders it once with
+// example data, so that unknown fields are reported at start.
+func compileIssueTemplate(ic IssueTemplateConfig) (*template.Template, []string) {
+	text := ic.Body
+	switch {
+	case ic.Body != "" && ic.File != "":
+		return nil, []string{"issue_template: set body or file (or ISSUE_TEMPLATE_FILE), not both"}
+	case ic.File != "":
+		data, err := os.ReadFile(ic.File)
+		if err != nil {
+			return nil, []string{fmt.Sprintf("issue_template.file (or ISSUE_TEMPLATE_FILE): %v", err)}
+		}
+		text = string(data)
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	tmpl, err := template.New("issue").Funcs(issueTemplateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, []string{fmt.Sprintf("issue_template: %v", err)}
+	}
+	now := time.Now()
+	example := IssueTemplateData{Title: "t", Description: "d", FirstSeen: now, LastSeen: now, Occurrences: 1, Metadata: map[string]string{}}
+	if err := tmpl.Execute(&strings.Builder{}, example); err != nil {
+		return nil, []string{fmt.Sprintf("issue_template: %v", err)}
+	}
+	return tmpl, nil
+}
+
+// issueTemplateData collects the fields of the issue template for an issue
+// the agent is creating.
+func issueTemplateData(t *toolSession, title, description string, labels []string) IssueTemplateData {
+	data := IssueTemplateData{
+		Title:       title,
+		Description: description,
+		Repository:  t.target.String(),
+		ErrorLog:    t.errorLog,
+		Labels:      labels,
+		Metadata:    map[string]string{},
+		RunID:       runID(t.ctx),
+		FirstSeen:   time.Now().UTC(),
+		Occurrences: 1,
+	}
+	data.LastSeen = data.FirstSeen
+	a := t.analysis
+	if a == nil {
+		return data
+	}
+	data.Fingerprint, data.Category, data.ErrorType, data.Message = a.Fingerprint, a.Category, a.ErrorType, a.Message
+	for k, v := range a.Metadata {
+		data.Metadata[k] = v
+	}
+	data.Severity = a.Metadata["severity"]
+	data.Service = analysisService(a)
+	data.Environment = analysisEnvironment(a)
+	data.Release = a.Metadata["release"]
+	if a.LoggedAt != nil {
+		data.FirstSeen, data.LastSeen = a.LoggedAt.UTC(), a.LoggedAt.UTC()
+	}
+	if st := a.Lifecycle; st != nil {
+		data.FirstSeen, data.LastSeen, data.Occurrences = st.FirstSeen.UTC(), st.LastSeen.UTC(), max(st.Occurrences, 1)
+	}
+	return data
+}
+
+// analysisEnvironment is the environment an error comes from: the
+// environment request field, or the environment label of an alert.
+func analysisEnvironment(analysis *LogAnalysis) string {
+	return firstNonEmpty(analysis.Metadata["environment"], analysis.Metadata["label_environment"], analysis.Metadata["label_env"])
+}
+
+// renderIssueBody lays out the agent's body with the issue template, if one
+// is configured.
+func (t *toolSession) renderIssueBody(title, body string, labels []string) (string, error) {
+	if issueTemplate == nil {
+		return body, nil
+	}
+	var sb strings.Builder
+	if err := issueTemplate.Execute(&sb, issueTemplateData(t, title, body, labels)); err != nil {
+		return "", fmt.Errorf("rendering issue template: %w", err)
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Jira limits on the fields we write.
+const (
+	maxJiraSummary     = 250
+	maxJiraDescription = 30000
+)
+
+// JiraConfig configures Jira Cloud, either as the issue tracker or as a
+// mirror of GitHub issues. The API token is a secret and only read from
+// JIRA_API_TOKEN.
+type JiraConfig struct {
+	// URL is the Jira site, e.g. https://myorg.atlassian.net.
+	URL   string `yaml:"url"`
+	Email string `yaml:"email"`
+	// Project is the key of the project issues are created in.
+	Project string `yaml:"project"`
+	// IssueType defaults to "Bug".
+	IssueType string `yaml:"issue_type"`
+	// DualWrite creates every GitHub issue in Jira as well, with links
+	// between the two.
+	DualWrite bool `yaml:"dual_write"`
+}
+
+// jira mirrors created issues when dual-write is enabled; nil otherwise.
+var jira *jiraClient
+
+// configJira returns the Jira settings, with JIRA_URL, JIRA_EMAIL,
+// JIRA_PROJECT, JIRA_ISSUE_TYPE and JIRA_DUAL_WRITE taking precedence over
+// the config file.
+func configJira(cfg *Config) JiraConfig {
+	out := JiraConfig{
+		URL:       envOr("JIRA_URL", cfg.Jira.URL),
+		Email:     envOr("JIRA_EMAIL", cfg.Jira.Email),
+		Project:   envOr("JIRA_PROJECT", cfg.Jira.Project),
+		IssueType: envOr("JIRA_ISSUE_TYPE", cfg.Jira.IssueType),
+		DualWrite: cfg.Jira.DualWrite,
+	}
+	if v := os.Getenv("JIRA_DUAL_WRITE"); v != "" {
+		out.DualWrite = v == "true"
+	}
+	if out.IssueType == "" {
+		out.IssueType = "Bug"
+	}
+	return out
+}
+
+// validateJira checks the Jira settings when Jira is used, either as the
+// tracker or for dual-write.
+func validateJira(jc JiraConfig, trackerName string) []string {
+	if !jc.DualWrite && trackerName != trackerJira {
+		return nil
+	}
+	var problems []string
+	if u, err := url.Parse(jc.URL); jc.URL == "" || err != nil || u.Scheme == "" || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("jira.url (or JIRA_URL): %q must be the URL of the Jira site, e.g. https://myorg.atlassian.net", jc.URL))
+	}
+	if jc.Email == "" {
+		problems = append(problems, "jira.email (or JIRA_EMAIL) must be set to use Jira")
+	}
+	if jc.Project == "" {
+		problems = append(problems, "jira.project (or JIRA_PROJECT) must be set to use Jira")
+	}
+	if os.Getenv("JIRA_API_TOKEN") == "" {
+		problems = append(problems, "JIRA_API_TOKEN must be set to use Jira")
+	}
+	return problems
+}
+
+// jiraClient is a minimal client for the Jira Cloud REST API. It is also
+// the Jira IssueTracker, filing every issue in the configured project
+// whatever the target repository.
+type jiraClient struct {
+	*restClient
+	project   string
+	issueType string
+}
+
+func newJiraClient(jc JiraConfig, token string) *jiraClient {
+	return &jiraClient{
+		restClient: newRESTClient("jira", jc.URL, func(req *http.Request) {
+			req.SetBasicAuth(jc.Email, token)
+		}),
+		project:   jc.Project,
+		issueType: jc.IssueType,
+	}
+}
+
+// jiraIssue is an issue created in Jira.
+type jiraIssue struct {
+	Key string `json:"key"`
+	URL string `json:"url"`
+}
+
+func (c *jiraClient) browseURL(key string) string {
+	return c.baseURL + "/browse/" + key
+}
+
+// createIssue creates an issue in the configured project.
+func (c *jiraClient) createIssue(ctx context.Context, summary, description string, labels []string) (jiraIssue, error) {
+	jiraLabels := []string{}
+	for _, label := range labels {
+		jiraLabels = append(jiraLabels, jiraLabel(label))
+	}
+
+	req := map[string]any{
+		"fields": map[string]any{
+			"project":     map[string]string{"key": c.project},
+			"issuetype":   map[string]string{"name": c.issueType},
+			"summary":     truncate(summary, maxJiraSummary),
+			"description": truncate(description, maxJiraDescription),
+			"labels":      jiraLabels,
+		},
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/rest/api/2/issue", req, &created); err != nil {
+		return jiraIssue{}, err
+	}
+	return jiraIssue{Key: created.Key, URL: c.browseURL(created.Key)}, nil
+}
+
+func (c *jiraClient) Name() string { return trackerJira }
+
+// Search runs a JQL text search in the project, newest issues first.
+func (c *jiraClient) Search(ctx context.Context, target repoTarget, query string) ([]TrackerIssue, error) {
+	jql := fmt.Sprintf("project = %s AND text ~ %s ORDER BY created DESC", jqlString(c.project), jqlString(query))
+	params := url.Values{
+		"jql":        {jql},
+		"fields":     {"summary,description,status"},
+		"maxResults": {"20"},
+	}
+	var result struct {
+		Issues []struct {
+			Key    string `json:"key"`
+			Fields struct {
+				Summary     string         `json:"summary"`
+				Description string         `json:"description"`
+				Status      jiraStatusInfo `json:"status"`
+			} `json:"fields"`
+		} `json:"issues"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/rest/api/2/search/jql?"+params.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+
+	var issues []TrackerIssue
+	for _, issue := range result.Issues {
+		issues = append(issues, TrackerIssue{
+			Number: jiraKeyNumber(issue.Key),
+			Key:    issue.Key,
+			Title:  issue.Fields.Summary,
+			Body:   issue.Fields.Description,
+			URL:    c.browseURL(issue.Key),
+			Closed: issue.Fields.Status.done(),
+		})
+	}
+	return issues, nil
+}
+
+func (c *jiraClient) Create(ctx context.Context, target repoTarget, issue plannedIssue) (TrackerIssue, error) {
+	created, err := c.createIssue(ctx, issue.Title, issue.Body, issue.Labels)
+	if err != nil {
+		return TrackerIssue{}, err
+	}
+	return TrackerIssue{
+		Number: jiraKeyNumber(created.Key),
+		Key:    created.Key,
+		Title:  issue.Title,
+		Body:   issue.Body,
+		URL:    created.URL,
+	}, nil
+}
+
+func (c *jiraClient) Comment(ctx context.Context, target repoTarget, number int, body string) (string, error) {
+	key := c.issueKey(number)
+	var comment struct {
+		ID string `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/rest/api/2/issue/"+key+"/comment", map[string]string{"body": body}, &comment); err != nil {
+		return "", err
+	}
+	return c.browseURL(key) + "?focusedCommentId=" + url.QueryEscape(comment.ID), nil
+}
+
+func (c *jiraClient) Label(ctx context.Context, target repoTarget, number int, add, remove []string) error {
+	var ops []map[string]string
+	for _, label := range add {
+		ops = append(ops, map[string]string{"add": jiraLabel(label)})
+	}
+	for _, label := range remove {
+		ops = append(ops, map[string]string{"remove": jiraLabel(label)})
+	}
+	req := map[string]any{"update": map[string]any{"labels": ops}}
+	return c.do(ctx, http.MethodPut, "/rest/api/2/issue/"+c.issueKey(number), req, nil)
+}
+
+// jiraStatusInfo is the status of an issue or the target of a transition.
+// Workflows name statuses freely, but every status is in one of the
+// categories new, indeterminate and done.
+type jiraStatusInfo struct {
+	Name     string `json:"name"`
+	Category struct {
+		Key string `json:"key"`
+	} `json:"statusCategory"`
+}
+
+func (s jiraStatusInfo) done() bool { return s.Category.Key == "done" }
+
+// Reopen applies the first available transition out of the done category,
+// such as "Reopen" or "To Do", as workflows differ between projects.
+func (c *jiraClient) Reopen(ctx context.Context, target repoTarget, number int) error {
+	key := c.issueKey(number)
+	var result struct {
+		Transitions []struct {
+			ID string         `json:"id"`
+			To jiraStatusInfo `json:"to"`
+		} `json:"transitions"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/rest/api/2/issue/"+key+"/transitions", nil, &result); err != nil {
+		return err
+	}
+	for _, t := range result.Transitions {
+		if t.To.done() {
+			continue
+		}
+		req := map[string]any{"transition": map[string]string{"id": t.ID}}
+		return c.do(ctx, http.MethodPost, "/rest/api/2/issue/"+key+"/transitions", req, nil)
+	}
+	return fmt.Errorf("no transition reopens %s", key)
+}
+
+// issueKey returns the key of issue number n in the project, e.g. OPS-42.
+func (c *jiraClient) issueKey(n int) string {
+	return url.PathEscape(fmt.Sprintf("%s-%d", c.project, n))
+}
+
+// jiraKeyNumber returns the number of an issue key, 42 for OPS-42.
+func jiraKeyNumber(key string) int {
+	_, num, _ := strings.Cut(key, "-")
+	n, _ := strconv.Atoi(num)
+	return n
+}
+
+// jiraLabel turns a label into a valid Jira label: Jira labels cannot
+// contain spaces.
+func jiraLabel(label string) string {
+	return strings.Join(strings.Fields(label), "-")
+}
+
+// jqlString quotes s as a JQL string literal.
+func jqlString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// addRemoteLink links a Jira issue to an external URL, shown under "Web
+// links" on the issue.
+func (c *jiraClient) addRemoteLink(ctx context.Context, key, linkURL, title string) error {
+	req := map[string]any{
+		"object": map[string]string{"url": linkURL, "title": title},
+	}
+	return c.do(ctx, http.MethodPost, "/rest/api/2/issue/"+url.PathEscape(key)+"/remotelink", req, nil)
+}
+
+// mirrorToJira creates a Jira issue for a newly created GitHub issue and
+// cross-links the two: the Jira issue gets a web link to GitHub, and the
+// GitHub issue a comment pointing to Jira. Only creating the Jira issue is
+// required to succeed; failed links are logged.
+func mirrorToJira(ctx context.Context, target repoTarget, issue TrackerIssue, in *githubIntent) (jiraIssue, error) {
+	ghRef := issue.Key
+	description := fmt.Sprintf("Mirrored from GitHub issue %s: %s\n\n%s", ghRef, issue.URL, in.Body)
+
+	created, err := jira.createIssue(ctx, in.Title, description, in.Labels)
+	if err != nil {
+		return jiraIssue{}, err
+	}
+	slog.InfoContext(ctx, "Mirrored issue to Jira", "issue", ghRef, "jira_key", created.Key)
+
+	if err := jira.addRemoteLink(ctx, created.Key, issue.URL, "GitHub issue "+ghRef); err != nil {
+		slog.ErrorContext(ctx, "Linking Jira issue to GitHub failed", "jira_key", created.Key, "issue", ghRef, "error", err)
+	}
+
+	comment := fmt.Sprintf("Mirrored to Jira: [%s](%s)", created.Key, created.URL)
+	if _, err := (githubTracker{}).Comment(ctx, target, issue.Number, comment); err != nil {
+		slog.ErrorContext(ctx, "Linking GitHub issue to Jira failed", "issue", ghRef, "jira_key", created.Key, "error", err)
+	}
+	return created, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+const (
+	// pendingJobPrefix prefixes the store keys holding the jobs that
+	// haven't finished, one per job, with their input.
+	pendingJobPrefix = "jobs:pending:"
+	// jobHeartbeatInterval is how often an instance records that it is
+	// alive, and looks for the jobs of instances that aren't.
+	jobHeartbeatInterval = 30 * time.Second
+	// jobOwnerTimeout is how long an instance may go without a heartbeat
+	// before its unfinished jobs are taken over. It leaves room for a
+	// slow store, so that live instances keep their jobs.
+	jobOwnerTimeout = 2 * time.Minute
+)
+
+// instanceID identifies this process as the owner of the jobs it queued.
+var instanceID = newID()
+
+func init() {
+	metrics.describe("triage_jobs_resumed_total", "counter", "Jobs resumed after the instance that accepted them stopped.")
+}
+
+// pendingJob is a job that hasn't finished: queued, or running on
+// Instance. The instance holds the job while its heartbeat is recent: the
+// heartbeat renews the lease on all of its jobs at once, so that a job's
+// input isn't rewritten to keep it.
+type pendingJob struct {
+	ID       string      `json:"id"`
+	Instance string      `json:"instance"`
+	Input    triageInput `json:"input"`
+}
+
+func heartbeatKey(instance string) string {
+	return "jobs:heartbeat:" + instance
+}
+
+func pendingJobKey(id string) string {
+	return pendingJobPrefix + id
+}
+
+// pending returns the unfinished jobs by ID, with their stored values.
+func (q *jobQueue) pending(ctx context.Context) (map[string]pendingJob, map[string][]byte) {
+	out := make(map[string]pendingJob)
+	raw := make(map[string][]byte)
+	values, err := store.ListValues(ctx, pendingJobPrefix)
+	if err != nil {
+		logStoreError("list pending jobs", err)
+		return out, raw
+	}
+	for _, data := range values {
+		var p pendingJob
+		if err := json.Unmarshal(data, &p); err != nil {
+			logStoreError("get pending job", err)
+			continue
+		}
+		out[p.ID], raw[p.ID] = p, data
+	}
+	return out, raw
+}
+
+// savePending records a job as unfinished.
+func (q *jobQueue) savePending(p pendingJob) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		logStoreError("save pending job", err)
+		return
+	}
+	logStoreError("save pending job", store.SetValue(context.Background(), pendingJobKey(p.ID), data))
+}
+
+// deletePending records a job as finished.
+func (q *jobQueue) deletePending(id string) {
+	logStoreError("delete pending job", store.DeleteValue(context.Background(), pendingJobKey(id)))
+}
+
+// pendingFingerprint reports whether an unfinished job triages an error
+// with the fingerprint.
+func (q *jobQueue) pendingFingerprint(fingerprint string) bool {
+	all, _ := q.pending(context.Background())
+	for _, p := range all {
+		if p.Input.Analysis != nil && p.Input.Analysis.Fingerprint == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// startJobRecovery records this instance's heartbeat and resumes the jobs
+// of instances without one, until ctx is done. At start that takes over
+// the jobs a previous run of the service accepted but didn't finish, once
+// its heartbeat has expired, as well as those of replicas that stopped.
+func startJobRecovery(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(jobHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			logStoreError("save job heartbeat", store.SetValue(ctx, heartbeatKey(instanceID), []byte(time.Now().UTC().Format(time.RFC3339))))
+			jobs.resumeOrphans(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ownerAlive reports whether an instance has recorded a heartbeat
+// recently.
+func ownerAlive(ctx context.Context, instance string) bool {
+	if instance == instanceID {
+		return true
+	}
+	data, ok, err := store.GetValue(ctx, heartbeatKey(instance))
+	if err != nil {
+		// Rather than risk running a job twice, wait for the store.
+		logStoreError("get job heartbeat", err)
+		return true
+	}
+	if !ok {
+		return false
+	}
+	at, err := time.Parse(time.RFC3339, string(data))
+	return err == nil && time.Since(at) < jobOwnerTimeout
+}
+
+// resumeOrphans queues the unfinished jobs of instances that stopped, as
+// this instance's. A job is claimed by replacing its owner only if it is
+// unchanged since it was read, so that of several instances looking for
+// orphans one resumes it. Jobs that don't fit in the queue are left for
+// the next round.
+func (q *jobQueue) resumeOrphans(ctx context.Context) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	all, values := q.pending(ctx)
+	for _, id := range sortedKeys(all) {
+		p := all[id]
+		if ownerAlive(ctx, p.Instance) {
+			continue
+		}
+		// Nothing to resume without the analysis.
+		if p.Input.Analysis == nil {
+			q.deletePending(id)
+			continue
+		}
+		// Only submit adds to the queue, under q.mu, so a job claimed
+		// now fits.
+		if len(q.queue) == cap(q.queue) {
+			slog.Warn("Job queue is full, resuming the remaining jobs later")
+			return
+		}
+
+		p.Instance = instanceID
+		data, err := json.Marshal(p)
+		if err != nil {
+			logStoreError("save pending job", err)
+			continue
+		}
+		claimed, err := store.SetValueIf(ctx, pendingJobKey(id), values[id], data)
+		if err != nil || !claimed {
+			// Another instance resumed it, or it finished.
+			logStoreError("claim pending job", err)
+			continue
+		}
+
+		q.queue <- queuedJob{id: p.ID, fn: p.Input.run}
+		if job, ok := q.get(p.ID); ok {
+			job.Status, job.StartedAt = jobStatusQueued, nil
+			q.save(job)
+		}
+		q.done[p.ID] = make(chan struct{})
+		metrics.add("triage_jobs_resumed_total", "", 1)
+		slog.Info("Resuming job of a stopped instance", "job_id", p.ID, "fingerprint", p.Input.Analysis.Fingerprint, "request_id", p.Input.RequestID)
+	}
+}
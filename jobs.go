@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	jobStatusQueued  = "queued"
+	jobStatusRunning = "running"
+	jobStatusDone    = "done"
+	jobStatusFailed  = "failed"
+)
+
+const (
+	// defaultJobWorkers is how many queued jobs run at once.
+	defaultJobWorkers = 4
+	// jobQueueSize bounds the backlog of queued jobs; submissions beyond it
+	// are refused rather than held in memory indefinitely.
+	jobQueueSize = 100
+	// jobRetention is how long a finished job can be queried before it is
+	// deleted.
+	jobRetention = 24 * time.Hour
+	// jobPrefix prefixes the store keys of jobs.
+	jobPrefix = "job:"
+)
+
+var errJobQueueFull = errors.New("job queue is full")
+
+// Job is an asynchronous triage request. Jobs are kept in the store, so
+// with a SQL store any replica can answer a status query.
+type Job struct {
+	ID          string       `json:"id"`
+	Status      string       `json:"status"`
+	Repository  string       `json:"repository"`
+	Fingerprint string       `json:"fingerprint"`
+	IssueURL    string       `json:"issue_url,omitempty"`
+	Result      *APIResponse `json:"result,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	StartedAt   *time.Time   `json:"started_at,omitempty"`
+	FinishedAt  *time.Time   `json:"finished_at,omitempty"`
+}
+
+// JobAccepted is the response to a request accepted for asynchronous
+// processing.
+type JobAccepted struct {
+	Status    string `json:"status"`
+	JobID     string `json:"job_id"`
+	StatusURL string `json:"status_url"`
+}
+
+// jobFunc does the work of a job and returns the response a synchronous
+// request would have received.
+type jobFunc func(ctx context.Context) (APIResponse, error)
+
+type queuedJob struct {
+	id string
+	fn jobFunc
+}
+
+// jobQueue runs jobs on a fixed pool of workers.
+type jobQueue struct {
+	mu    sync.Mutex
+	queue chan queuedJob
+	// done holds a channel per job queued by this instance, closed when
+	// the job finishes.
+	done map[string]chan struct{}
+}
+
+var jobs = &jobQueue{
+	queue: make(chan queuedJob, jobQueueSize),
+	done:  make(map[string]chan struct{}),
+}
+
+// startJobWorkers starts n workers that run queued jobs until ctx is done.
+func startJobWorkers(ctx context.Context, n int) {
+	if n <= 0 {
+		n = defaultJobWorkers
+	}
+	for range n {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case qj := <-jobs.queue:
+					jobs.run(ctx, qj)
+				}
+			}
+		}()
+	}
+}
+
+// submit records a queued job and hands it to the workers. The job's input
+// is kept in the store until it finishes, so that it survives a restart.
+func (q *jobQueue) submit(in triageInput) (Job, error) {
+	job := Job{
+		ID:          newID(),
+		Status:      jobStatusQueued,
+		Repository:  in.Target.String(),
+		Fingerprint: in.Analysis.Fingerprint,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.queue <- queuedJob{id: job.ID, fn: in.run}:
+	default:
+		return Job{}, errJobQueueFull
+	}
+	// Saved while holding the lock so a worker can't pick the job up and
+	// save it as running before it is saved as queued.
+	q.save(job)
+	q.savePending(pendingJob{ID: job.ID, Instance: instanceID, Input: in})
+	q.done[job.ID] = make(chan struct{})
+	return job, nil
+}
+
+func (q *jobQueue) run(ctx context.Context, qj queuedJob) {
+	q.mu.Lock()
+	job, ok := q.get(qj.id)
+	q.mu.Unlock()
+	if !ok {
+		slog.WarnContext(ctx, "Job vanished from the store before it ran", "job_id", qj.id)
+		job = Job{ID: qj.id, CreatedAt: time.Now().UTC()}
+	}
+
+	now := time.Now().UTC()
+	job.Status = jobStatusRunning
+	job.StartedAt = &now
+	q.save(job)
+
+	resp, err := q.call(ctx, qj)
+
+	finished := time.Now().UTC()
+	job.FinishedAt = &finished
+	if err != nil {
+		job.Status = jobStatusFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = jobStatusDone
+		job.IssueURL = resp.IssueURL
+		job.Result = &resp
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.save(job)
+	q.deletePending(job.ID)
+	if done, ok := q.done[job.ID]; ok {
+		close(done)
+		delete(q.done, job.ID)
+	}
+}
+
+// call runs a job, failing it if it panics.
+func (q *jobQueue) call(ctx context.Context, qj queuedJob) (resp APIResponse, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = recoveredPanic(ctx, "job", rec)
+		}
+	}()
+	return qj.fn(ctx)
+}
+
+// wait blocks until the job finishes or ctx is done, and reports whether
+// it finished.
+func (q *jobQueue) wait(ctx context.Context, id string) (Job, bool) {
+	q.mu.Lock()
+	done, ok := q.done[id]
+	q.mu.Unlock()
+	if ok {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return Job{}, false
+		}
+	}
+
+	job, ok := q.get(id)
+	if !ok || (job.Status != jobStatusDone && job.Status != jobStatusFailed) {
+		return Job{}, false
+	}
+	return job, true
+}
+
+func (q *jobQueue) save(job Job) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		logStoreError("save job", err)
+		return
+	}
+	logStoreError("save job", store.SetValue(context.Background(), jobPrefix+job.ID, data))
+}
+
+func (q *jobQueue) get(id string) (Job, bool) {
+	data, ok, err := store.GetValue(context.Background(), jobPrefix+id)
+	if err != nil || !ok {
+		logStoreError("get job", err)
+		return Job{}, false
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		logStoreError("get job", err)
+		return Job{}, false
+	}
+	return job, true
+}
+
+// prune deletes the jobs that finished more than jobRetention before now,
+// and returns how many it deleted.
+func (q *jobQueue) prune(ctx context.Context, now time.Time) int {
+	values, err := store.ListValues(ctx, jobPrefix)
+	if err != nil {
+		logStoreError("list jobs", err)
+		return 0
+	}
+	pruned := 0
+	for key, data := range values {
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			logStoreError("get job", err)
+			continue
+		}
+		if job.FinishedAt == nil || now.Sub(*job.FinishedAt) < jobRetention {
+			continue
+		}
+		if err := store.DeleteValue(ctx, key); err != nil {
+			logStoreError("delete job", err)
+			continue
+		}
+		pruned++
+	}
+	return pruned
+}
+
+// wantsAsync reports whether the caller asked for asynchronous processing,
+// with ?async=true or "Prefer: respond-async".
+func wantsAsync(r *http.Request) bool {
+	return r.URL.Query().Get("async") == "true" || r.Header.Get("Prefer") == "respond-async"
+}
+
+// requestDeadline returns how long the caller is willing to wait for a
+// synchronous response: until the X-Deadline header (an RFC 3339 time), or
+// for max_wait_ms milliseconds. ok is false when neither was given.
+func requestDeadline(r *http.Request, maxWaitMS int) (time.Duration, bool, error) {
+	if v := r.Header.Get("X-Deadline"); v != "" {
+		deadline, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid X-Deadline %q, expected an RFC 3339 time", v)
+		}
+		return time.Until(deadline), true, nil
+	}
+	if v := r.URL.Query().Get("max_wait_ms"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, false, fmt.Errorf("invalid max_wait_ms %q", v)
+		}
+		maxWaitMS = n
+	}
+	if maxWaitMS > 0 {
+		return time.Duration(maxWaitMS) * time.Millisecond, true, nil
+	}
+	return 0, false, nil
+}
+
+// acceptJob queues a triage and answers 202 Accepted with the job's status
+// URL.
+func acceptJob(w http.ResponseWriter, in triageInput) {
+	job, ok := submitJob(w, in)
+	if !ok {
+		return
+	}
+	writeAccepted(w, job)
+}
+
+// awaitJob queues a triage as a job and waits up to wait for it. A job that
+// finishes in time is answered like a synchronous request; otherwise the
+// caller gets 202 Accepted and polls the job, so a slow run turns into an
+// asynchronous one instead of outliving the caller's timeout.
+func awaitJob(w http.ResponseWriter, r *http.Request, in triageInput, wait time.Duration) {
+	job, ok := submitJob(w, in)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+	finished, ok := jobs.wait(ctx, job.ID)
+	switch {
+	case !ok:
+		slog.InfoContext(r.Context(), "Job did not finish in time, answering asynchronously", "job_id", job.ID, "wait", wait.Round(time.Millisecond))
+		if current, ok := jobs.get(job.ID); ok {
+			job = current
+		}
+		writeAccepted(w, job)
+	case finished.Status == jobStatusFailed:
+		http.Error(w, fmt.Sprintf("Agent failed to process error: %s", finished.Error), http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, finished.Result)
+	}
+}
+
+func submitJob(w http.ResponseWriter, in triageInput) (Job, bool) {
+	job, err := jobs.submit(in)
+	if err != nil {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return Job{}, false
+	}
+	slog.Info("Queued job", "job_id", job.ID, "fingerprint", job.Fingerprint)
+	return job, true
+}
+
+func writeAccepted(w http.ResponseWriter, job Job) {
+	statusURL := "/jobs/" + job.ID
+	w.Header().Set("Location", statusURL)
+	writeJSON(w, http.StatusAccepted, JobAccepted{Status: job.Status, JobID: job.ID, StatusURL: statusURL})
+}
+
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	if target, err := parseRepoTarget(job.Repository); err == nil {
+		if err := authorizeRepo(r, target); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, job)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Knowledge base policies: what happens to a submission that matches a
+// known issue.
+const (
+	// kbAnnotate runs the agent as usual with the resolution in the
+	// pre-analysis, so a new issue includes it.
+	kbAnnotate = "annotate"
+	// kbSkip answers with the resolution without running the agent or
+	// filing an issue.
+	kbSkip = "skip"
+)
+
+// KnownIssue is a curated knowledge base entry: a known error and its
+// canned resolution. It matches by fingerprint or like a runbook rule.
+type KnownIssue struct {
+	ID         string `yaml:"id" json:"id"`
+	errorMatch `yaml:",inline" json:"-"`
+	// Fingerprints match exactly, in addition to the matcher fields.
+	Fingerprints []string `yaml:"fingerprints" json:"-"`
+	// Resolution is the canned answer, e.g. "Increase the DB pool size".
+	Resolution string `yaml:"resolution" json:"resolution"`
+	// Links point to further documentation.
+	Links []string `yaml:"links" json:"links,omitempty"`
+	// Policy is "annotate" (default) or "skip".
+	Policy string `yaml:"policy" json:"policy"`
+}
+
+// knowledgeBase comes from the knowledge_base config setting.
+var knowledgeBase []KnownIssue
+
+func init() {
+	metrics.describe("triage_known_issue_matches_total", "counter", "Submissions that matched a knowledge base entry, by entry and policy.")
+}
+
+// compileKnowledgeBase validates the entries and compiles their patterns.
+// Invalid entries are left out.
+func compileKnowledgeBase(entries []KnownIssue) ([]KnownIssue, []string) {
+	var out []KnownIssue
+	var problems []string
+	ids := make(map[string]bool)
+	for i, e := range entries {
+		prefix := fmt.Sprintf("knowledge_base[%d]", i)
+		n := len(problems)
+		if e.ID == "" {
+			problems = append(problems, prefix+".id: must be set")
+		} else if ids[e.ID] {
+			problems = append(problems, fmt.Sprintf("%s.id: duplicate id %q", prefix, e.ID))
+		}
+		ids[e.ID] = true
+		switch {
+		case e.Service != "" || e.Category != "" || e.Error != "":
+			problems = append(problems, e.compile(prefix)...)
+		case len(e.Fingerprints) == 0:
+			problems = append(problems, prefix+": needs fingerprints or at least one of service, category or error")
+		}
+		if e.Resolution == "" {
+			problems = append(problems, prefix+".resolution: must be set")
+		}
+		switch e.Policy {
+		case "":
+			e.Policy = kbAnnotate
+		case kbAnnotate, kbSkip:
+		default:
+			problems = append(problems, fmt.Sprintf("%s.policy: unknown policy %q, expected annotate or skip", prefix, e.Policy))
+		}
+		if len(problems) == n {
+			out = append(out, e)
+		}
+	}
+	return out, problems
+}
+
+func (k KnownIssue) matches(analysis *LogAnalysis) bool {
+	if slices.Contains(k.Fingerprints, analysis.Fingerprint) {
+		return true
+	}
+	if k.Service == "" && k.Category == "" && k.Error == "" {
+		return false
+	}
+	return k.errorMatch.matches(analysis)
+}
+
+// matchKnownIssue returns the first knowledge base entry matching the
+// analysis.
+func matchKnownIssue(analysis *LogAnalysis) (KnownIssue, bool) {
+	for _, k := range knowledgeBase {
+		if k.matches(analysis) {
+			return k, true
+		}
+	}
+	return KnownIssue{}, false
+}
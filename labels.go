@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// defaultLabelColor is the color of created labels that have none
+// configured, GitHub's default.
+const defaultLabelColor = "ededed"
+
+// labelColorPattern is a label color: six hex digits, with or without '#'.
+var labelColorPattern = regexp.MustCompile(`^#?[0-9a-fA-F]{6}$`)
+
+func init() {
+	metrics.describe("triage_labels_created_total", "counter", "Labels of the label taxonomy created in the repository on startup.")
+}
+
+// LabelConfig is the label taxonomy: the labels the agent may apply and
+// remove, and those every issue it creates gets.
+type LabelConfig struct {
+	// Allowed replaces the built-in labels the agent may use.
+	Allowed []LabelDefinition `yaml:"allowed"`
+	// Required are added to every issue the agent creates, and are allowed
+	// too. Defaults to "llm created".
+	Required []string `yaml:"required"`
+	// SkipCreate leaves labels of the taxonomy missing from the
+	// repository uncreated.
+	SkipCreate bool `yaml:"skip_create"`
+}
+
+// LabelDefinition is a label of the taxonomy.
+type LabelDefinition struct {
+	Name string `yaml:"name"`
+	// Color is the color the label is created with, e.g. "d73a4a".
+	Color string `yaml:"color"`
+	// Description is shown to the agent and set on the created label.
+	Description string `yaml:"description"`
+}
+
+var (
+	// labelDefinitions are the configured labels, nil when the built-in
+	// labels are used.
+	labelDefinitions []LabelDefinition
+	// requiredLabels are added to every issue the agent creates.
+	requiredLabels = []string{"llm created"}
+)
+
+func configLabels(cfg *Config) LabelConfig {
+	out := cfg.Labels
+	if out.Required == nil {
+		out.Required = []string{"llm created"}
+	}
+	return out
+}
+
+func validateLabels(lc LabelConfig) []string {
+	var problems []string
+	seen := make(map[string]bool)
+	for i, def := range lc.Allowed {
+		name := strings.ToLower(strings.TrimSpace(def.Name))
+		switch {
+		case name == "":
+			problems = append(problems, fmt.Sprintf("labels.allowed[%d]: name must be set", i))
+		case seen[name]:
+			problems = append(problems, fmt.Sprintf("labels.allowed[%d]: duplicate label %q", i, def.Name))
+		}
+		seen[name] = true
+		if def.Color != "" && !labelColorPattern.MatchString(def.Color) {
+			problems = append(problems, fmt.Sprintf("labels.allowed[%d].color: %q must be six hex digits, e.g. \"d73a4a\"", i, def.Color))
+		}
+	}
+	for i, name := range lc.Required {
+		if strings.TrimSpace(name) == "" {
+			problems = append(problems, fmt.Sprintf("labels.required[%d]: must not be empty", i))
+		}
+	}
+	return problems
+}
+
+// applyLabelConfig makes the configured taxonomy the agent's labels.
+func applyLabelConfig(lc LabelConfig) {
+	requiredLabels = lc.Required
+	if len(lc.Allowed) == 0 {
+		agentLabels = appendNew(agentLabels, requiredLabels...)
+		return
+	}
+	var names []string
+	for _, def := range lc.Allowed {
+		def.Name = strings.TrimSpace(def.Name)
+		def.Color = strings.ToLower(strings.TrimPrefix(def.Color, "#"))
+		labelDefinitions = append(labelDefinitions, def)
+		names = append(names, def.Name)
+	}
+	for _, name := range requiredLabels {
+		if !containsFold(names, name) {
+			labelDefinitions = append(labelDefinitions, LabelDefinition{Name: name})
+			names = append(names, name)
+		}
+	}
+	agentLabels = names
+}
+
+// labelsDescription describes the labels parameter of the labeling tools,
+// with the meaning of the labels that have a description.
+func labelsDescription(what string) string {
+	var described []string
+	for _, def := range labelDefinitions {
+		if def.Description != "" {
+			described = append(described, fmt.Sprintf("'%s' (%s)", def.Name, def.Description))
+		}
+	}
+	if len(described) == 0 {
+		return what
+	}
+	return what + " Labels: " + strings.Join(described, ", ") + "."
+}
+
+// ensureLabels creates the labels of the taxonomy that are missing from a
+// repository, with their configured color and description. Existing
+// labels are left as they are.
+func ensureLabels(ctx context.Context, target repoTarget) error {
+	existing := make(map[string]bool)
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		var labels []*github.Label
+		var resp *github.Response
+		err := retryGitHub(ctx, func() (*github.Response, error) {
+			var err error
+			labels, resp, err = ghClient.Issues.ListLabels(ctx, target.Owner, target.Repo, opts)
+			return resp, err
+		})
+		if err != nil {
+			return err
+		}
+		for _, l := range labels {
+			existing[strings.ToLower(l.GetName())] = true
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	for _, def := range labelDefinitions {
+		if existing[strings.ToLower(def.Name)] {
+			continue
+		}
+		label := &github.Label{Name: github.String(def.Name), Color: github.String(firstNonEmpty(def.Color, defaultLabelColor))}
+		if def.Description != "" {
+			label.Description = github.String(def.Description)
+		}
+		err := retryGitHub(ctx, func() (*github.Response, error) {
+			_, resp, err := ghClient.Issues.CreateLabel(ctx, target.Owner, target.Repo, label)
+			return resp, err
+		})
+		if err != nil {
+			return fmt.Errorf("creating label %q: %w", def.Name, err)
+		}
+		metrics.add("triage_labels_created_total", "", 1)
+		slog.Info("Created label", "repository", target.String(), "label", def.Name)
+	}
+	return nil
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	metrics.describe("triage_duplicate_corrections_total", "counter", "Fingerprints a maintainer remapped to another issue or split from theirs, by kind.")
+}
+
+// Lifecycle states of a fingerprint.
+const (
+	stateNew          = "new"
+	stateFiled        = "filed"
+	stateAcknowledged = "acknowledged"
+	stateFixed        = "fixed"
+	stateVerifying    = "verifying"
+	stateResolved     = "resolved"
+	stateRegressed    = "regressed"
+)
+
+// stateTransitions lists the states each state may move to.
+var stateTransitions = map[string][]string{
+	stateNew:          {stateFiled},
+	stateFiled:        {stateAcknowledged, stateFixed},
+	stateAcknowledged: {stateFixed},
+	stateFixed:        {stateVerifying, stateRegressed},
+	stateVerifying:    {stateResolved, stateRegressed},
+	stateResolved:     {stateRegressed},
+	stateRegressed:    {stateAcknowledged, stateFixed},
+}
+
+const (
+	// maxFingerprints is how many fingerprints the in-memory store keeps
+	// before dropping the least recently seen.
+	maxFingerprints = 10000
+	// maxStateHistory caps the transitions kept per fingerprint.
+	maxStateHistory = 50
+)
+
+// FingerprintState is where a fingerprint is in its issue lifecycle.
+type FingerprintState struct {
+	Fingerprint string `json:"fingerprint"`
+	Repository  string `json:"repository"`
+	State       string `json:"state"`
+	IssueURL    string `json:"issue_url,omitempty"`
+	Occurrences int    `json:"occurrences"`
+	// LastVersion is the release the fingerprint was last seen from, when
+	// callers report it.
+	LastVersion string `json:"last_version,omitempty"`
+	// FixVersion is the release the issue was fixed in, and VerifyUntil the
+	// end of the window in which a reappearance from that or a newer
+	// release counts as a regression.
+	FixVersion  string            `json:"fix_version,omitempty"`
+	VerifyUntil *time.Time        `json:"verify_until,omitempty"`
+	FirstSeen   time.Time         `json:"first_seen"`
+	LastSeen    time.Time         `json:"last_seen"`
+	History     []StateTransition `json:"history"`
+	// MergedFrom is the issue the fingerprint was linked to before a
+	// maintainer marked that issue a duplicate of IssueURL.
+	MergedFrom string `json:"merged_from,omitempty"`
+	// Severity is the severity a maintainer set on the fingerprint's issue,
+	// which overrides the classified one.
+	Severity string `json:"severity,omitempty"`
+	// NotDuplicateOf are issues a maintainer unlinked the fingerprint from;
+	// the agent is told not to link it to them again.
+	NotDuplicateOf []string `json:"not_duplicate_of,omitempty"`
+	// Corrections records the maintainers' remaps and splits, as feedback on
+	// the agent's duplicate detection.
+	Corrections []IssueCorrection `json:"corrections,omitempty"`
+}
+
+// IssueCorrection is a maintainer moving a fingerprint from the issue the
+// agent linked it to, to another issue or, for a split, to none.
+type IssueCorrection struct {
+	From   string    `json:"from,omitempty"`
+	To     string    `json:"to,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+type StateTransition struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// lifecycleRegistry keeps fingerprint states in the store, per repository
+// and fingerprint: an error routed to several repositories has an issue
+// and a lifecycle in each. The mutex makes read-modify-write updates
+// atomic within one instance.
+type lifecycleRegistry struct {
+	mu sync.Mutex
+}
+
+var lifecycles = &lifecycleRegistry{}
+
+// observe records an occurrence of a fingerprint from a release (which may
+// be unknown) and returns its state afterwards. A fingerprint seen again
+// after it was fixed regresses, unless the occurrence comes from a release
+// older than the fix; regressed reports whether this occurrence caused it.
+// The first and last seen times come from the log's timestamps when it has
+// any, else from the time of the call.
+func (reg *lifecycleRegistry) observe(fingerprint string, target repoTarget, version string, analysis *LogAnalysis) (state FingerprintState, regressed bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	first, last := time.Now().UTC(), time.Now().UTC()
+	if analysis != nil && analysis.LoggedAt != nil {
+		first, last = *analysis.LoggedAt, *analysis.LoggedUntil
+	}
+	st, ok := reg.get(target.String(), fingerprint)
+	if !ok {
+		st = FingerprintState{
+			Fingerprint: fingerprint,
+			Repository:  target.String(),
+			State:       stateNew,
+			FirstSeen:   first,
+			LastSeen:    last,
+		}
+	}
+	st.Occurrences++
+	if first.Before(st.FirstSeen) {
+		st.FirstSeen = first
+	}
+	if last.After(st.LastSeen) {
+		st.LastSeen = last
+	}
+	if version != "" {
+		st.LastVersion = version
+	}
+
+	switch st.State {
+	case stateFixed, stateVerifying, stateResolved:
+		if version != "" && st.FixVersion != "" && versionOlder(version, st.FixVersion) {
+			break
+		}
+		reason := "fingerprint reappeared"
+		if version != "" {
+			reason += " in " + version
+		}
+		st.moveLocked(stateRegressed, reason)
+		st.VerifyUntil = nil
+		regressed = true
+	}
+
+	logStoreError("save fingerprint", store.SaveFingerprint(context.Background(), st))
+	return st, regressed
+}
+
+// transition moves a fingerprint of a repository to a new state, if the
+// lifecycle allows it.
+func (reg *lifecycleRegistry) transition(repository, fingerprint, to, reason string) (FingerprintState, error) {
+	return reg.transitionWith(repository, fingerprint, to, reason, nil)
+}
+
+// transitionWith is transition with a hook to update other fields of the
+// state in the same step.
+func (reg *lifecycleRegistry) transitionWith(repository, fingerprint, to, reason string, update func(*FingerprintState)) (FingerprintState, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	st, ok := reg.get(repository, fingerprint)
+	if !ok {
+		return FingerprintState{}, errFingerprintNotFound
+	}
+	if !slices.Contains(stateTransitions[st.State], to) {
+		return st, fmt.Errorf("cannot move from %s to %s; allowed: %v", st.State, to, stateTransitions[st.State])
+	}
+	st.moveLocked(to, reason)
+	if update != nil {
+		update(&st)
+	}
+	return st, store.SaveFingerprint(context.Background(), st)
+}
+
+var (
+	errFingerprintNotFound  = fmt.Errorf("fingerprint not found")
+	errFingerprintAmbiguous = fmt.Errorf("fingerprint is tracked in several repositories; set the repository")
+)
+
+// remap links a fingerprint to another issue, correcting the agent, or
+// with an empty issueURL splits it from its issue so that its next
+// occurrence is triaged as a new error. Either way the agent is told not to
+// link it to the old issue again.
+func (reg *lifecycleRegistry) remap(repository, fingerprint, issueURL, reason string) (FingerprintState, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	st, ok := reg.get(repository, fingerprint)
+	if !ok {
+		return FingerprintState{}, errFingerprintNotFound
+	}
+	if issueURL == st.IssueURL {
+		return st, fmt.Errorf("fingerprint is already linked to %s", firstNonEmpty(issueURL, "no issue"))
+	}
+	from := st.IssueURL
+	if from != "" && !slices.Contains(st.NotDuplicateOf, from) {
+		st.NotDuplicateOf = append(st.NotDuplicateOf, from)
+	}
+	st.NotDuplicateOf = slices.DeleteFunc(st.NotDuplicateOf, func(u string) bool { return u == issueURL })
+	st.Corrections = append(st.Corrections, IssueCorrection{From: from, To: issueURL, Reason: reason, At: time.Now().UTC()})
+	if len(st.Corrections) > maxStateHistory {
+		st.Corrections = st.Corrections[len(st.Corrections)-maxStateHistory:]
+	}
+	st.IssueURL, st.MergedFrom = issueURL, ""
+
+	switch {
+	case issueURL == "":
+		st.moveLocked(stateNew, firstNonEmpty(reason, "split from "+from))
+	case st.State == stateNew:
+		st.moveLocked(stateFiled, "issue "+issueURL)
+	}
+	return st, store.SaveFingerprint(context.Background(), st)
+}
+
+// recordIssue links the issue a run filed or found to the fingerprint and
+// marks a new fingerprint as filed.
+func (reg *lifecycleRegistry) recordIssue(repository, fingerprint, issueURL string) {
+	if issueURL == "" {
+		return
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	st, ok := reg.get(repository, fingerprint)
+	if !ok {
+		return
+	}
+	if slices.Contains(st.NotDuplicateOf, issueURL) {
+		slog.Info("Not linking fingerprint to issue: a maintainer unlinked it", "fingerprint", fingerprint, "issue_url", issueURL)
+		return
+	}
+	st.IssueURL = issueURL
+	if st.State == stateNew {
+		st.moveLocked(stateFiled, "issue "+issueURL)
+	}
+	logStoreError("save fingerprint", store.SaveFingerprint(context.Background(), st))
+}
+
+// seed records a fingerprint found in an existing issue, unless the
+// fingerprint is known. It reports whether it was new.
+func (reg *lifecycleRegistry) seed(fingerprint string, target repoTarget, state, issueURL string, created time.Time) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if _, ok := reg.get(target.String(), fingerprint); ok {
+		return false
+	}
+	st := FingerprintState{
+		Fingerprint: fingerprint,
+		Repository:  target.String(),
+		State:       stateNew,
+		IssueURL:    issueURL,
+		FirstSeen:   created.UTC(),
+		LastSeen:    created.UTC(),
+	}
+	st.moveLocked(state, "bootstrapped from issue "+issueURL)
+	logStoreError("save fingerprint", store.SaveFingerprint(context.Background(), st))
+	return true
+}
+
+// updateWhere updates the fingerprints for which match holds and returns
+// them.
+func (reg *lifecycleRegistry) updateWhere(match func(FingerprintState) bool, update func(*FingerprintState)) ([]string, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	all, err := store.ListFingerprints(context.Background(), "")
+	if err != nil {
+		return nil, err
+	}
+	var updated []string
+	for _, st := range all {
+		if !match(st) {
+			continue
+		}
+		update(&st)
+		if err := store.SaveFingerprint(context.Background(), st); err != nil {
+			return updated, err
+		}
+		updated = append(updated, st.Fingerprint)
+	}
+	return updated, nil
+}
+
+func (reg *lifecycleRegistry) get(repository, fingerprint string) (FingerprintState, bool) {
+	st, ok, err := store.GetFingerprint(context.Background(), repository, fingerprint)
+	logStoreError("get fingerprint", err)
+	return st, ok
+}
+
+// find returns the state of a fingerprint in a repository. Without a
+// repository, it returns the fingerprint's only state, if it is tracked
+// in a single repository.
+func (reg *lifecycleRegistry) find(repository, fingerprint string) (FingerprintState, error) {
+	if repository != "" {
+		if st, ok := reg.get(repository, fingerprint); ok {
+			return st, nil
+		}
+		return FingerprintState{}, errFingerprintNotFound
+	}
+	var found []FingerprintState
+	for _, st := range reg.list("") {
+		if st.Fingerprint == fingerprint {
+			found = append(found, st)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return FingerprintState{}, errFingerprintNotFound
+	case 1:
+		return found[0], nil
+	}
+	return FingerprintState{}, errFingerprintAmbiguous
+}
+
+// list returns fingerprints most recently seen first, optionally filtered
+// by state.
+func (reg *lifecycleRegistry) list(state string) []FingerprintState {
+	out, err := store.ListFingerprints(context.Background(), state)
+	logStoreError("list fingerprints", err)
+	if out == nil {
+		out = []FingerprintState{}
+	}
+	return out
+}
+
+func (st *FingerprintState) moveLocked(to, reason string) {
+	st.History = append(st.History, StateTransition{From: st.State, To: to, Reason: reason, At: time.Now().UTC()})
+	if len(st.History) > maxStateHistory {
+		st.History = st.History[len(st.History)-maxStateHistory:]
+	}
+	st.State = to
+}
+
+// lifecycleGuidance tells the agent what to do with a log given its
+// fingerprint's state.
+func lifecycleGuidance(st FingerprintState) string {
+	guidance := stateGuidance(st)
+	if len(st.NotDuplicateOf) > 0 {
+		guidance += fmt.Sprintf(" A maintainer determined that this error is not the one reported in %s; do not cite, comment on or reopen those issues for it.", strings.Join(st.NotDuplicateOf, ", "))
+	}
+	return guidance
+}
+
+func stateGuidance(st FingerprintState) string {
+	switch st.State {
+	case stateFiled:
+		return fmt.Sprintf("An issue is already filed for this fingerprint: %s. Do not create another issue; cite it, and comment on it only if this log adds new information.", st.IssueURL)
+	case stateAcknowledged:
+		return fmt.Sprintf("The issue for this fingerprint (%s) has been acknowledged and is being worked on. Do not create another issue; cite it.", st.IssueURL)
+	case stateFixed, stateVerifying:
+		return fmt.Sprintf("A fix for this fingerprint's issue (%s) has been released and is being verified; this occurrence comes from a release older than the fix. Do not create another issue; cite it.", st.IssueURL)
+	case stateRegressed:
+		if st.IssueURL == "" {
+			return "This error was fixed but has reappeared. Search for the original issue and treat this as a regression."
+		}
+		return fmt.Sprintf("This error was fixed (%s) but has reappeared. The issue has been reopened with a regression note. Do not create a new issue; cite it.", st.IssueURL)
+	default:
+		return "No issue is known for this fingerprint yet. Search for existing issues and create one if none covers the error."
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/luisya22/swarmlet"
+)
+
+// LLM providers.
+const (
+	llmOpenAI    = "openai"
+	llmAnthropic = "anthropic"
+	llmAzure     = "azure"
+)
+
+// defaultAzureAPIVersion is the Azure OpenAI API version used when none is
+// configured.
+const defaultAzureAPIVersion = "2024-10-21"
+
+// openAIHost is where swarmlet's OpenAI client sends its requests.
+const openAIHost = "api.openai.com"
+
+// llmProvider describes a provider: the variable holding its API key, its
+// default model and, for providers other than OpenAI, its OpenAI-compatible
+// API.
+type llmProvider struct {
+	keyEnv    string
+	model     string
+	apiURL    string
+	maxTokens int
+}
+
+var llmProviders = map[string]llmProvider{
+	llmOpenAI:    {keyEnv: "OPENAI_API_KEY", model: "gpt-4o-mini"},
+	llmAnthropic: {keyEnv: "ANTHROPIC_API_KEY", model: "claude-haiku-4-5", apiURL: "https://api.anthropic.com/v1", maxTokens: 4096},
+	// Azure OpenAI's API is per resource and deployment, see azureRoute.
+	llmAzure: {keyEnv: "AZURE_OPENAI_API_KEY"},
+}
+
+// LLMConfig selects the model the triage agent runs on. The API key is a
+// secret and only read from the provider's variable: OPENAI_API_KEY,
+// ANTHROPIC_API_KEY or AZURE_OPENAI_API_KEY.
+type LLMConfig struct {
+	// Provider is openai (default), anthropic or azure.
+	Provider string `yaml:"provider"`
+	// Model defaults to gpt-4o-mini for openai and claude-haiku-4-5 for
+	// anthropic. Azure uses the deployment's model. It must be set with a
+	// base URL.
+	Model string `yaml:"model"`
+	// BaseURL points the client at another OpenAI-compatible API, e.g. a
+	// local Ollama (http://localhost:11434/v1), vLLM or LM Studio server.
+	// The API key is optional then.
+	BaseURL string `yaml:"base_url"`
+	// Endpoint is the Azure OpenAI resource, e.g.
+	// https://acme.openai.azure.com.
+	Endpoint string `yaml:"endpoint"`
+	// Deployment is the Azure OpenAI deployment the requests go to.
+	Deployment string `yaml:"deployment"`
+	// APIVersion is the Azure OpenAI API version (default 2024-10-21).
+	APIVersion string `yaml:"api_version"`
+	// MaxTokens caps each response (default 4096 for anthropic, which
+	// requires one; unlimited for openai).
+	MaxTokens int `yaml:"max_tokens"`
+}
+
+// configLLM returns the LLM settings, with LLM_PROVIDER, LLM_MODEL,
+// LLM_BASE_URL and the AZURE_OPENAI_ variables taking precedence over the
+// config file.
+func configLLM(cfg *Config) LLMConfig {
+	out := cfg.LLM
+	out.Provider = envOr("LLM_PROVIDER", out.Provider)
+	out.Model = envOr("LLM_MODEL", out.Model)
+	out.BaseURL = envOr("LLM_BASE_URL", out.BaseURL)
+	if out.Provider == llmAzure {
+		out.Endpoint = envOr("AZURE_OPENAI_ENDPOINT", out.Endpoint)
+		out.Deployment = envOr("AZURE_OPENAI_DEPLOYMENT", out.Deployment)
+		out.APIVersion = envOr("AZURE_OPENAI_API_VERSION", out.APIVersion)
+	}
+	return withLLMDefaults(out)
+}
+
+// withLLMDefaults fills in the provider's defaults.
+func withLLMDefaults(out LLMConfig) LLMConfig {
+	out.Provider = firstNonEmpty(out.Provider, llmOpenAI)
+	if out.Provider == llmAzure {
+		out.APIVersion = firstNonEmpty(out.APIVersion, defaultAzureAPIVersion)
+		out.Model = firstNonEmpty(out.Model, out.Deployment)
+	}
+	if p, ok := llmProviders[out.Provider]; ok {
+		if out.BaseURL == "" {
+			out.Model = firstNonEmpty(out.Model, p.model)
+		}
+		if out.MaxTokens == 0 {
+			out.MaxTokens = p.maxTokens
+		}
+	}
+	return out
+}
+
+func validateLLM(lc LLMConfig) []string {
+	var problems []string
+	if _, ok := llmProviders[lc.Provider]; !ok {
+		problems = append(problems, fmt.Sprintf("llm.provider (or LLM_PROVIDER): unknown provider %q, expected openai, anthropic or azure", lc.Provider))
+	}
+	if lc.Provider == llmAzure {
+		if u, err := url.Parse(lc.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("llm.endpoint (or AZURE_OPENAI_ENDPOINT): %q is not a URL", lc.Endpoint))
+		}
+		if lc.Deployment == "" {
+			problems = append(problems, "llm.deployment (or AZURE_OPENAI_DEPLOYMENT) must be set for azure")
+		}
+	}
+	if lc.BaseURL != "" {
+		if u, err := url.Parse(lc.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("llm.base_url (or LLM_BASE_URL): %q is not a URL", lc.BaseURL))
+		}
+		if lc.Provider == llmAzure {
+			problems = append(problems, "llm.base_url (or LLM_BASE_URL) can't be used with azure, set llm.endpoint")
+		}
+		if lc.Model == "" {
+			problems = append(problems, "llm.model (or LLM_MODEL) must be set with llm.base_url")
+		}
+	}
+	if lc.MaxTokens < 0 {
+		problems = append(problems, fmt.Sprintf("llm.max_tokens: must not be negative, got %d", lc.MaxTokens))
+	}
+	return problems
+}
+
+// llmAPIKey returns the API key of the provider and the variable it is
+// read from.
+func llmAPIKey(lc LLMConfig) (key, env string) {
+	env = llmProviders[lc.Provider].keyEnv
+	return os.Getenv(env), env
+}
+
+// installLLMTransport prepares the default transport for the configured
+// provider, and the canary's if one is configured. swarmlet only ships an
+// OpenAI client, which can't be given a base URL or an HTTP client, so
+// other providers and base URLs are reached through their
+// OpenAI-compatible API by rerouting the client's requests. The token
+// usage of runs is recorded there too.
+func installLLMTransport(lc LLMConfig, canary *LLMConfig) {
+	t := &llmTransport{base: http.DefaultTransport, route: llmRoute(lc)}
+	if canary != nil {
+		t.canaryRoute = llmRoute(*canary)
+	}
+	if t.route != nil || t.canaryRoute != nil {
+		http.DefaultTransport = t
+	}
+	http.DefaultTransport = &usageTransport{base: http.DefaultTransport}
+}
+
+// llmRoute returns how requests for the OpenAI API are rerouted to a
+// provider, or nil for OpenAI itself.
+func llmRoute(lc LLMConfig) func(*http.Request) {
+	switch p := llmProviders[lc.Provider]; {
+	case lc.Provider == llmAzure:
+		return azureRoute(lc)
+	case lc.BaseURL != "" || p.apiURL != "":
+		u, _ := url.Parse(firstNonEmpty(lc.BaseURL, p.apiURL))
+		return func(req *http.Request) {
+			req.URL.Scheme, req.URL.Host = u.Scheme, u.Host
+			req.URL.Path = strings.TrimSuffix(u.Path, "/") + strings.TrimPrefix(req.URL.Path, "/v1")
+		}
+	}
+	return nil
+}
+
+// newLLM returns a client of the configured model for one run. The run ID
+// travels with the API key, see runKeyTag.
+func newLLM(lc LLMConfig, apiKey, runID string) swarmlet.LLM {
+	return tracedLLM{LLM: swarmlet.NewOpenAILLM(tagRunKey(apiKey, runID), lc.Model), provider: lc.Provider, model: lc.Model}
+}
+
+// azureRoute sends OpenAI requests to an Azure OpenAI deployment, which
+// takes the API key in the api-key header rather than as a bearer token.
+func azureRoute(lc LLMConfig) func(*http.Request) {
+	u, _ := url.Parse(lc.Endpoint)
+	base := strings.TrimSuffix(u.Path, "/") + "/openai/deployments/" + url.PathEscape(lc.Deployment)
+	return func(req *http.Request) {
+		req.URL.Scheme, req.URL.Host = u.Scheme, u.Host
+		req.URL.Path = base + strings.TrimPrefix(req.URL.Path, "/v1")
+		req.URL.RawQuery = url.Values{"api-version": {lc.APIVersion}}.Encode()
+		key, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		req.Header.Del("Authorization")
+		req.Header.Set("api-key", key)
+	}
+}
+
+// llmTransport reroutes requests for the OpenAI API to the configured
+// provider, or those of canary runs to the canary's. Requests to other
+// hosts pass through.
+type llmTransport struct {
+	base        http.RoundTripper
+	route       func(*http.Request)
+	canaryRoute func(*http.Request)
+}
+
+func (t *llmTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	route := t.route
+	if isCanaryRun(runID(req.Context())) {
+		route = t.canaryRoute
+	}
+	if req.URL.Host != openAIHost || route == nil {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	route(req)
+	req.Host = ""
+	return t.base.RoundTrip(req)
+}
@@ -4,15 +4,112 @@ var agentSystemPrompt = `
 You are an automated GitHub Issue Triage Agent. Your task is to process incoming error logs.
 	You have access to tools to interact with the GitHub repository %s/%s.
 
+	Each error log comes with a pre-analysis (format, error type, application frames and a fingerprint) computed before it reaches you. Prefer the application frames over runtime or library frames when describing the error.
+
 	Here's your workflow:
-	1.  **First, always search for existing issues.** Use the 'search_github_issues' tool with a concise query derived from the error log to see if this bug or a similar one has already been reported.
+	1.  **First, always search for existing issues.** Use the 'search_github_issues' tool with the fingerprint from the pre-analysis as the query, then with each near-duplicate fingerprint if the pre-analysis lists any, then with a concise query derived from the error log, to see if this bug or a similar one has already been reported.
 	2.  **Analyze search results.**
 		* If an existing relevant issue is found, respond by citing the issue URL(s) and state that the issue has already been reported.
+		* You may use 'comment_on_issue' to note the new occurrence on the existing issue, and 'add_labels' or 'remove_labels' to adjust its labels (for example to escalate an issue that keeps recurring). Only do this when it adds information.
+		* If the relevant issue is closed (the search result says "State: closed"), the error has recurred: call 'reopen_issue' with its number and a short note instead of creating a new issue, and report it as a duplicate.
 		* If no relevant issue is found, proceed to create a new one.
 	3.  **Create a new issue if necessary.** If no existing issue covers the error, use the 'create_github_issue' tool.
 		* The 'title' should be a concise summary of the error, clearly indicating it's a bug.
 		* The 'body' should include the full error log provided by the user, along with any other relevant details you can infer.
-		* Always apply the labels 'bug' and 'llm created' to new issues.
-	4.  **Confirm issue creation.** If you successfully create an issue, provide the title and URL of the newly created issue.
-	5.  **If a tool call fails**, report the failure back to the user clearly.	
+		* If the pre-analysis lists metadata (for example the affected controller or model), include it in the 'body' as a short "Details" list.
+		* Write times in the 'body' in UTC, in the form "2024-03-05 10:00 UTC" like the 'logged_at' metadata, never as they appear in the log. Quote the error log itself unchanged.
+		* Call the source tools below ('suggest_owners', 'get_file_snippet', 'list_recent_commits', 'blame_line') together, in a single turn, then create the issue in the next one.
+		* If the pre-analysis lists application frames, call 'suggest_owners' with their files and add the owners and contributors it returns to the 'body' under "Suggested owners". Mention the CODEOWNERS teams it returns with their @org/team handle, as teams can't be assigned.
+		* If the pre-analysis lists application frames with line numbers, call 'get_file_snippet' for the innermost one and include the code it returns in the 'body' under "Code". Use it to write a more precise 'title', e.g. naming the function and what failed in it.
+		* Call 'list_recent_commits' for the file of the innermost application frame. If it changed recently, note it in the 'body' under "Recent changes", e.g. "internal/db/conn.go changed 2 days ago in commit abc1234 (link)", as a possible cause.
+		* Optionally call 'blame_line' for the line of the innermost application frame. If a person (not a bot) with a GitHub login changed it in the last 90 days, add "Suggested assignee: @login" with the commit and its date under "Suggested owners". This is a suggestion only.
+		* If the pre-analysis lists a known issue, add its resolution and links to the 'body' under "Known resolution".
+		* If the pre-analysis metadata lists service owners, list them first under "Suggested owners" (if it also lists an on-call, they are the current on-call for the service; say so). If it lists runbooks, add them as links under "Runbooks". If it gives a severity, state it near the top of the 'body'.
+		* End the 'body' with a line of the form "Fingerprint: <fingerprint>" using the fingerprint from the pre-analysis.
+		* Structure the 'body' following the issue body template given after the pre-analysis.
+		* Apply any suggested labels from the pre-analysis to new issues. Apply 'bug' only when the category is 'code'. The required labels, such as 'llm created', are added for you.
+	4.  **Confirm issue creation.** If you successfully create an issue, provide the title and URL of the newly created issue. Assigning it to its CODEOWNERS, its milestone and the project board are taken care of for you.
+	5.  **If a tool call fails or is refused**, report the failure back to the user clearly. A refusal comes from the repository's action policy; do not retry the same action.
+	6.  **Report the result.** Before your final answer, call 'report_result' exactly once: 'created' with the URL of the issue you created, 'duplicate' with the URL of the existing issue that covers the error, or 'none' if you did neither.
 `
+
+// customTemplateGuidance replaces the issue body layout when the config
+// sets an issue template, which the agent's body is inserted into.
+const customTemplateGuidance = `The 'body' you write is inserted into the organization's issue template as its description. Write the summary, the likely cause and the suggested next steps, with the code, recent changes, owners, runbooks and known resolution you found. The template adds the error log, service, environment and when the error was seen: don't repeat them.`
+
+// issueTemplates is the issue body layout the agent follows for each analysis
+// category.
+var issueTemplates = map[string]string{
+	categoryCode: `## Summary
+<one or two sentences describing the error>
+
+## Error log
+<the full error log in a code block>
+
+## Code
+<the code around the innermost application frame from get_file_snippet, if any>
+
+## Recent changes
+<recent commits to that file from list_recent_commits, if any>
+
+## Details
+<metadata from the pre-analysis and anything else relevant>`,
+
+	categoryDependency: `## Summary
+<which dependency or downstream service failed and how, e.g. timeout or connection refused>
+
+## Dependency
+<host, failure kind and anything known about the call from the pre-analysis>
+
+## Impact
+<what the application was doing when the call failed>
+
+## Error log
+<the full error log in a code block>`,
+
+	categoryResource: `## Summary
+<which workload was terminated and why, e.g. OOMKilled or exit code 137>
+
+## Termination
+<pod, container, reason, exit code and its meaning from the pre-analysis>
+
+## Suggested next steps
+<e.g. review memory requests/limits, check for leaks or load spikes, check eviction and probe settings>
+
+## Raw events
+<the submitted log in a code block>`,
+
+	categoryStaticAnalysis: `## Summary
+<the finding in one sentence: what the rule flags and where>
+
+## Finding
+<tool, rule ID and description, severity level, file and line from the pre-analysis, and the rule's help link if given>
+
+## Code
+<the flagged snippet, if any, in a code block>
+
+## Suggested fix
+<how to address the finding>`,
+	categoryTest: `## Summary
+<which test fails and the failure message>
+
+## Failure
+<the assertion or error from the test output, in a code block>
+
+## History
+<the test's pass/fail history from the pre-analysis; if it is flaky, say so and that it fails intermittently rather than consistently>
+
+## Suggested next steps
+<e.g. look for timing, ordering or shared-state dependencies for a flaky test, or the recent change that broke it>`,
+	categoryAlert: `## Summary
+<which alert fires and what it means, from its summary and description>
+
+## Alert
+<alert name, severity, the labels identifying the affected service and instances, when it started and the link to its source>
+
+## Runbook
+<the runbook link from the pre-analysis, if any>
+
+## Suggested next steps
+<what to check first, based on the alert's description>`,
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader carries the request ID. A caller's ID is kept so that its
+// logs and ours can be joined; otherwise one is generated.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits the request IDs accepted from callers, which end up
+// in every log line of the request.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// LoggingConfig selects the log format and level.
+type LoggingConfig struct {
+	// Format is text (default) or json.
+	Format string `yaml:"format"`
+	// Level is debug, info (default), warn or error.
+	Level string `yaml:"level"`
+}
+
+// configLogging returns the logging settings, with LOG_FORMAT and LOG_LEVEL
+// taking precedence over the config file.
+func configLogging(cfg *Config) LoggingConfig {
+	out := cfg.Logging
+	out.Format = envOr("LOG_FORMAT", firstNonEmpty(out.Format, "text"))
+	out.Level = envOr("LOG_LEVEL", firstNonEmpty(out.Level, "info"))
+	return out
+}
+
+func validateLogging(lc LoggingConfig) []string {
+	var problems []string
+	if lc.Format != "text" && lc.Format != "json" {
+		problems = append(problems, fmt.Sprintf("logging.format (or LOG_FORMAT): unknown format %q, expected text or json", lc.Format))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(lc.Level)); err != nil {
+		problems = append(problems, fmt.Sprintf("logging.level (or LOG_LEVEL): unknown level %q, expected debug, info, warn or error", lc.Level))
+	}
+	return problems
+}
+
+// initLogging installs the default logger. The standard log package,
+// which swarmlet logs with, is routed through it as well.
+func initLogging(lc LoggingConfig) {
+	var level slog.Level
+	level.UnmarshalText([]byte(lc.Level))
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if lc.Format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(contextHandler{handler}))
+}
+
+// fatal logs an error and exits, like log.Fatalf.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type requestIDKey struct{}
+
+type runIDKey struct{}
+
+// withRequestID gives each request an ID, returned in the X-Request-ID
+// header and added to everything logged with the request's context.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(contextWithRequestID(r.Context(), id)))
+	})
+}
+
+func contextWithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextWithRunID marks ctx as belonging to an agent run, for the GitHub
+// API calls made with it.
+func contextWithRunID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, runIDKey{}, id)
+}
+
+func runID(ctx context.Context) string {
+	id, _ := ctx.Value(runIDKey{}).(string)
+	return id
+}
+
+// requestLogger returns a logger carrying the request ID and client tag of
+// ctx, for code that has no context to log with, such as the tool
+// executors.
+func requestLogger(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if id := requestID(ctx); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	if tag := clientTag(ctx); tag != "" {
+		logger = logger.With("client_tag", tag)
+	}
+	return logger
+}
+
+// contextHandler adds the request ID, the client tag and the trace of the
+// context to each record, so that logs can be queried by request and
+// joined with traces.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if tag := clientTag(ctx); tag != "" {
+		r.AddAttrs(slog.String("client_tag", tag))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
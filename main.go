@@ -5,227 +5,623 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/google/go-github/github"
 	"github.com/joho/godotenv"
 	"github.com/luisya22/swarmlet"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/oauth2"
 )
 
 type ErrorLogRequest struct {
 	ErrorLog string `json:"error_log"`
+	// Repository optionally overrides the target repository ("owner/repo").
+	// It must be in ALLOWED_REPOS.
+	Repository string `json:"repository,omitempty"`
+	// Version is the release that produced the log, used for release
+	// verification and stability metrics.
+	Version string `json:"version,omitempty"`
+	// Service names the service that produced the log for stability
+	// metrics. It defaults to the target repository.
+	Service string `json:"service,omitempty"`
+	// Environment names the environment the log comes from, e.g.
+	// "production", for the issue template.
+	Environment string `json:"environment,omitempty"`
+	// MaxWaitMS bounds how long the request waits for the agent. A run
+	// that takes longer continues as a job and the response is 202 with
+	// the job ID. The X-Deadline header does the same with a fixed time.
+	MaxWaitMS int `json:"max_wait_ms,omitempty"`
+	// DryRun runs the full pipeline without writing to the tracker and
+	// returns what would have been written. The dry_run=true query
+	// parameter does the same.
+	DryRun bool `json:"dry_run,omitempty"`
 }
 
 type APIResponse struct {
-	Status   string `json:"status"`
-	Message  string `json:"message"`
-	IssueURL string `json:"issue_url,omitempty"`
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+	IssueURL    string `json:"issue_url,omitempty"`
+	RunID       string `json:"run_id,omitempty"`
+	Repository  string `json:"repository,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Result is the structured outcome the agent reported.
+	Result *TriageResult `json:"result,omitempty"`
+	// KnownIssue is the knowledge base entry the error matched, with its
+	// resolution.
+	KnownIssue *KnownIssue `json:"known_issue,omitempty"`
+	// Cached is set when the response was reused from a recent run for the
+	// same fingerprint instead of running the agent again.
+	Cached bool `json:"cached,omitempty"`
+	// Collapsed is set when the error joined the run of an identical error
+	// in flight or just finished.
+	Collapsed bool `json:"collapsed,omitempty"`
+	// DryRun is set on the response to a dry run, with Preview the issues
+	// and comments it would have written.
+	DryRun  bool           `json:"dry_run,omitempty"`
+	Preview *DryRunPreview `json:"preview,omitempty"`
 }
 
 var (
-	ghClient    *github.Client
-	ghOwner     string
-	ghRepo      string
-	llmPipeline *swarmlet.Pipeline
+	ghClient *github.Client
+	ghOwner  string
+	ghRepo   string
+	memory   swarmlet.Memory
+
+	// llmConfig and llmKey select the model each run gets a client of, with
+	// llmOptions its generation options.
+	llmConfig  LLMConfig
+	llmKey     string
+	llmOptions swarmlet.LLMOptions
+
+	// externalDepsTarget receives issues classified as dependency failures
+	// when EXTERNAL_DEPS_REPO is set.
+	externalDepsTarget *repoTarget
 )
 
 func main() {
 	err := godotenv.Load()
 	if err != nil {
-		log.Printf("Warning: No .env file found or error loading: %v", err)
+		slog.Warn("No .env file found or error loading it", "error", err)
 	}
 
-	openaiAPIKey := os.Getenv("OPENAI_API_KEY")
-	githubToken := os.Getenv("GITHUB_TOKEN")
-	ghOwner = os.Getenv("GITHUB_OWNER")
-	ghRepo = os.Getenv("GITHUB_REPO")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "admin":
+			os.Exit(runAdminCLI(os.Args[2:]))
+		case "config":
+			os.Exit(runConfigCLI(os.Args[2:]))
+		case "db":
+			os.Exit(runDBCLI(os.Args[2:]))
+		}
+	}
 
-	if openaiAPIKey == "" || githubToken == "" || ghOwner == "" || ghRepo == "" {
-		log.Fatal("Error: OPENAI_API_KEY, GITHUB_TOKEN, GITHUB_OWNER, and GITHUB_REPO environment variables must be set.")
+	cfg, err := loadConfig(configPath())
+	if err != nil {
+		fatal("Loading config failed", "path", configPath(), "error", err)
+	}
+	initLogging(configLogging(cfg))
+	if problems := validateConfig(cfg); len(problems) > 0 {
+		fatal("Invalid configuration", "problems", problems)
 	}
 
-	ctx := context.Background()
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: githubToken},
-	)
-	tc := oauth2.NewClient(ctx, ts)
-	ghClient = github.NewClient(tc)
+	shutdownTracing, err := initTracing(configTracing(cfg))
+	if err != nil {
+		fatal("Setting up tracing failed", "error", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	llmCfg := configLLM(cfg)
+	apiKey, keyEnv := llmAPIKey(llmCfg)
+	ghOwner = envOr("GITHUB_OWNER", cfg.GitHub.Owner)
+	ghRepo = envOr("GITHUB_REPO", cfg.GitHub.Repo)
+
+	if apiKey == "" && llmCfg.BaseURL == "" {
+		fatal(keyEnv + " environment variable must be set")
+	}
+	// GitHub credentials are optional with another tracker; without them
+	// owners can't be suggested.
+	githubAuth, err := githubTokenSource()
+	if err != nil && configTracker(cfg) == trackerGitHub {
+		fatal("GitHub credentials are missing", "error", err)
+	} else if err != nil {
+		slog.Warn("GitHub credentials are missing, owners will not be suggested", "error", err)
+	}
+
+	if depsRepo := envOr("EXTERNAL_DEPS_REPO", cfg.ExternalDepsRepo); depsRepo != "" {
+		target, err := parseRepoTarget(depsRepo)
+		if err != nil {
+			fatal("Invalid EXTERNAL_DEPS_REPO", "error", err)
+		}
+		externalDepsTarget = &target
+	}
+
+	allowedRepos, err = parseAllowedRepos(envOr("ALLOWED_REPOS", strings.Join(cfg.AllowedRepos, ",")))
+	if err != nil {
+		fatal("Invalid ALLOWED_REPOS", "error", err)
+	}
+	for _, pattern := range cfg.DependencyPatterns {
+		extraDependencyPatterns = append(extraDependencyPatterns, regexp.MustCompile(pattern))
+	}
+	repoOverrideToken = os.Getenv("REPO_OVERRIDE_TOKEN")
+
+	fallbackActions = configFallbackActions(cfg)
+	if repo := envOr("FALLBACK_REPO", cfg.Fallback.Repo); repo != "" {
+		target, _ := parseRepoTarget(repo)
+		fallbackTarget = &target
+	}
+	if path := envOr("FALLBACK_STORE_PATH", cfg.Fallback.StorePath); path != "" {
+		fallbackStorePath = path
+	}
+	slackWebhookURL = os.Getenv("SLACK_WEBHOOK_URL")
+	actionPolicies = cfg.ActionPolicy
+	teams, _ = parseTeams(cfg.Teams)
+	services.configure(cfg.Services)
+	alertmanagerFilter = configAlertmanager(cfg)
+	runbookRules, _ = compileRunbookRules(cfg.Runbooks)
+	knowledgeBase, _ = compileKnowledgeBase(cfg.KnowledgeBase)
+	routeRules, _ = compileRouteRules(cfg.Routes)
+	rules, _ = compileRules(cfg.Rules)
+	notifications, _ = compileNotifications(cfg.Notifications)
+	secrets, _ = compileRedaction(cfg.Redaction)
+	translationDisabled = cfg.Translation.Disabled
+	milestoneConfig = cfg.Milestones
+	projectConfig = configProject(cfg)
+	issueTemplate, _ = compileIssueTemplate(configIssueTemplate(cfg))
+	labelCfg := configLabels(cfg)
+	applyLabelConfig(labelCfg)
+	if zone := configTimestamps(cfg).DefaultZone; zone != "" {
+		logLocation, _ = time.LoadLocation(zone)
+	}
+	normalizers, _ = compileNormalization(cfg.Normalization)
+	pii, _ = compilePII(cfg.PII)
+	if oc := configOnCall(cfg); oc.Provider != "" {
+		onCall = newOnCallClient(oc)
+	}
+	if sc := configServiceNow(cfg); sc.Instance != "" {
+		serviceNow = newServiceNowClient(sc, os.Getenv("SERVICENOW_PASSWORD"))
+	}
+	tracker = newTracker(cfg)
+	if jc := configJira(cfg); jc.DualWrite {
+		jira = newJiraClient(jc, os.Getenv("JIRA_API_TOKEN"))
+	}
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	webhookSecret = os.Getenv("GITHUB_WEBHOOK_SECRET")
+	signingSecrets = loadSigningSecrets()
+	if len(signingSecrets) == 0 {
+		slog.Warn("SIGNING_SECRET is not set: ingestion endpoints accept unsigned requests")
+	}
+	apiKeys, _ = compileAPIKeys(cfg.APIKeys, os.Getenv("API_KEYS"))
+	if len(apiKeys) == 0 {
+		slog.Warn("No API keys are configured: ingestion and read endpoints accept anonymous requests")
+	}
+	rateLimitCfg := configRateLimit(cfg)
+	limiter = newRateLimiter(rateLimitCfg)
+	floods.window, _ = time.ParseDuration(rateLimitCfg.CollapseWindow)
+	toolLimits = resolveToolLimits(configToolLimits(cfg))
+	installUserAgent(configUserAgent(cfg))
+	batchConfig = configBatch(cfg)
+	flags.configure(cfg.FeatureFlags)
+	if sc := configSandbox(cfg); sc.Repo != "" {
+		target, _ := parseRepoTarget(sc.Repo)
+		sandboxTarget = &target
+		slog.Warn("Sandbox mode: all issue tracker writes go to the sandbox repository", "sandbox", sc.Repo)
+	}
+	ansiRendering = envOr("ANSI_RENDERING", cfg.ANSIRendering)
+	if ansiRendering == "" {
+		ansiRendering = ansiStrip
+	}
+
+	store, err = openStore(configStore(cfg))
+	if err != nil {
+		fatal("Opening store failed", "error", err)
+	}
+	defer store.Close()
+
+	cacheCfg := configCache(cfg)
+	cache, err = openCache(cacheCfg)
+	if err != nil {
+		fatal("Opening cache failed", "error", err)
+	}
+	if cacheCfg.ResponseTTL != "" {
+		responseTTL, _ = time.ParseDuration(cacheCfg.ResponseTTL)
+	}
 
-	initializeAIPipeline(openaiAPIKey)
+	if githubAuth != nil {
+		ghClient = newGitHubClient(githubAuth)
+	} else {
+		ghClient = github.NewClient(&http.Client{Transport: newGitHubTransport()})
+	}
+
+	if len(labelDefinitions) > 0 && !labelCfg.SkipCreate && tracker.Name() == trackerGitHub {
+		target := repoTarget{Owner: ghOwner, Repo: ghRepo}
+		if sandboxTarget != nil {
+			target = *sandboxTarget
+		}
+		go func() {
+			if err := ensureLabels(context.Background(), target); err != nil {
+				slog.Error("Creating missing labels failed", "repository", target.String(), "error", err)
+			}
+		}()
+	}
+
+	bootstrap = newBootstrapper(configBootstrap(cfg))
+	if githubAuth != nil {
+		bootstrap.ensure(repoTarget{Owner: ghOwner, Repo: ghRepo})
+	}
 
-	http.HandleFunc("POST /process_error", handleProcessError)
+	startJobWorkers(context.Background(), configJobWorkers(cfg))
+
+	canary = newCanaryRunner(configCanary(cfg))
+	initializeAIPipeline(llmCfg, apiKey)
+	retries = newRetryPolicy(configRetry(cfg))
+	startRetrier(context.Background())
+	startJobRecovery(context.Background())
+	startReconciler(context.Background())
+	startPruning(context.Background())
+	telemetryConfig = configTelemetry(cfg)
+	startTelemetry(context.Background())
+
+	if interval := envOr("SELFTEST_INTERVAL", cfg.SelfTest.Interval); interval != "" {
+		d, _ := time.ParseDuration(interval)
+		startSelfTest(context.Background(), d)
+	}
+
+	if interval := envOr("VERIFY_INTERVAL", cfg.Verification.Interval); interval != "" {
+		d, _ := time.ParseDuration(interval)
+		if window := envOr("VERIFY_WINDOW", cfg.Verification.Window); window != "" {
+			verifyWindow, _ = time.ParseDuration(window)
+		}
+		startReleaseVerifier(context.Background(), d)
+	}
+
+	if webhookSecret != "" {
+		startSnoozeReminders(context.Background())
+	}
+
+	features, configHash = enabledFeatures(cfg), hashConfig(cfg)
+
+	http.Handle("POST /process_error", ingest(sourceProcessError, handleProcessError))
+	http.Handle("POST /process_errors", ingest(sourceProcessError, handleProcessErrors))
+	http.Handle("GET /jobs/{id}", requireAPIKey(sourceRead, http.HandlerFunc(handleGetJob)))
+	http.Handle("GET /runs/{id}", requireAPIKey(sourceRead, http.HandlerFunc(handleGetRun)))
+	http.Handle("POST /process_sarif", ingest(sourceSARIF, handleProcessSARIF))
+	http.Handle("POST /process_test_results", ingest(sourceTestResults, handleProcessTestResults))
+	http.Handle("POST /ingest/alertmanager", ingest(sourceAlertmanager, handleIngestAlertmanager))
+	http.Handle("GET /tests", requireAPIKey(sourceRead, http.HandlerFunc(handleListTestStats)))
+	http.Handle("POST /sessions", ingest(sourceSessions, handleReportSessions))
+	http.Handle("GET /stability", requireAPIKey(sourceRead, http.HandlerFunc(handleListStability)))
+	http.Handle("GET /rejections", requireAPIKey(sourceRead, http.HandlerFunc(handleListRejections)))
+	http.Handle("GET /reports/canary", requireAPIKey(sourceRead, http.HandlerFunc(handleCanaryReport)))
+	http.HandleFunc("GET /metrics", handleMetrics)
+	http.HandleFunc("GET /version", handleVersion)
+	http.HandleFunc("POST /webhooks/github", handleGitHubWebhook)
+	registerAdminRoutes(http.DefaultServeMux)
 	port := ":8000"
-	log.Printf("Starting API server on port %s", port)
-	log.Fatal(http.ListenAndServe(port, nil))
+	slog.Info("Starting API server", "addr", port, "version", version, "config_hash", configHash)
+	fatal("API server stopped", "error", http.ListenAndServe(port, withRequestID(recoverPanics(withClientTag(traceHandler(http.DefaultServeMux))))))
+}
+
+func newGitHubClient(ts oauth2.TokenSource) *github.Client {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: newGitHubTransport()})
+	tc := oauth2.NewClient(ctx, ts)
+	return github.NewClient(tc)
+}
+
+func initializeAIPipeline(lc LLMConfig, apiKey string) {
+	var canaryLLM *LLMConfig
+	if canary != nil {
+		canaryLLM = &canary.llm
+	}
+	installLLMTransport(lc, canaryLLM)
+	llmConfig, llmKey = lc, apiKey
+	llmOptions = llmOptionsFor(lc)
+	memory = storeMemoryAdapter{store: store}
+}
+
+// llmOptionsFor returns the generation options of the agent on a model.
+func llmOptionsFor(lc LLMConfig) swarmlet.LLMOptions {
+	options := swarmlet.LLMOptions{Temperature: 0.5, MaxTokens: -1}
+	if lc.MaxTokens > 0 {
+		options.MaxTokens = lc.MaxTokens
+	}
+	return options
 }
 
-func initializeAIPipeline(openaiAPIKey string) {
-	tools := []swarmlet.LLMTool{
-		{
-			Name:        "search_github_issues",
-			Description: "Searches for existing GitHub issues in the repository based on a query. Returns a list of issue titles and URLs if found, otherwise indicates no issues found.",
-			Params: map[string]swarmlet.LLMToolFieldProperty{
-				"query": {
-					Type:        "string",
-					Description: "The search query for GitHub issues, e.g., 'bug in login module' or 'database connection error'.",
-				},
-			},
-			Executor: searchGithubIssues,
-		},
-		{
-			Name:        "create_github_issue",
-			Description: "Creates a new GitHub issue in the specified repository. Provide a title, detailed body, and labels.",
-			Params: map[string]swarmlet.LLMToolFieldProperty{
-				"title": {
-					Type:        "string",
-					Description: "The title of the new GitHub issue (e.g., 'Bug: Login failure on homepage').",
-				},
-				"body": {
-					Type:        "string",
-					Description: "The detailed description for the GitHub issue, including stack traces or context.",
-				},
-				"labels": {
-					Type:        "array",
-					Description: "An array of labels to apply to the issue, e.g., ['bug', 'llm created'].",
-					Enum:        []string{"bug", "llm created", "enhancement"},
-				},
-			},
-			Executor: createGithubIssues,
-		},
-	}
-
-	systemPrompt := fmt.Sprintf(agentSystemPrompt, ghOwner, ghRepo)
-
-	llm := swarmlet.NewOpenAILLM(openaiAPIKey, "gpt-4o-mini")
-	memory := swarmlet.NewDummyMemory()
+// newTriagePipeline builds the agent pipeline for one run. The tools are
+// bound to the run's session, limited and traced under ctx, and the LLM
+// client records the run's token usage, so the pipeline is built per
+// request rather than shared.
+func newTriagePipeline(ctx context.Context, session *toolSession, llm swarmlet.LLM, options swarmlet.LLMOptions) *swarmlet.Pipeline {
+	systemPrompt := fmt.Sprintf(agentSystemPrompt, session.target.Owner, session.target.Repo)
 
 	augmentedNode := swarmlet.NewAugmentedLLMNode(
 		swarmlet.WithAugmentedID("github-triage-agent"),
 		swarmlet.WithAugmentedSystemPrompt(systemPrompt),
-		swarmlet.WithAugmentedTools(tools...),
+		swarmlet.WithAugmentedTools(traceTools(ctx, recordToolCalls(session.ctx, limitTools(session.ctx, session.log, session.tools())))...),
+		swarmlet.WithAugmentedLLMOptions(options),
 	)
 
-	llmPipeline = swarmlet.NewPipeline("GitHubIssueTriage", augmentedNode, llm, memory)
+	return swarmlet.NewPipeline("GitHubIssueTriage", augmentedNode, llm, memory)
 }
 
 func handleProcessError(w http.ResponseWriter, r *http.Request) {
 	var req ErrorLogRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	data, err := io.ReadAll(r.Body)
+	if err == nil {
+		data, err = decodeText(data, r.Header.Get("Content-Type"))
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &req)
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
 		return
 	}
+	processErrorLog(w, r, req)
+}
+
+// processErrorLog triages one error log of a /process_error or
+// /process_errors request and writes its response.
+func processErrorLog(w http.ResponseWriter, r *http.Request, req ErrorLogRequest) {
+	var err error
+	rawLog := req.ErrorLog
+	req.ErrorLog = normalizeLogText(req.ErrorLog)
 
 	if req.ErrorLog == "" {
 		http.Error(w, "Error log cannot be empty", http.StatusBadRequest)
 		return
 	}
 
-	var outputBuffer bytes.Buffer
-	finalOutput, err := llmPipeline.Run(r.Context(), req.ErrorLog, "run-id"+req.ErrorLog[:10], &outputBuffer)
-	if err != nil {
-		log.Printf("Pipeline execution failed: %v", err)
-		http.Error(w, fmt.Sprintf("Agent failed to process error: %v", err), http.StatusInternalServerError)
+	// Checked before redaction, which would hide base64 blobs.
+	if reason := checkErrorLogText(req.ErrorLog); reason != "" {
+		rejectErrorLog(w, r, sanitizeText(req.ErrorLog), reason)
 		return
 	}
+	req.ErrorLog = sanitizeText(req.ErrorLog)
 
-	log.Printf("Agent's final response: %s", finalOutput)
-
-	resp := APIResponse{
-		Status:  "success",
-		Message: finalOutput,
+	analysis := analyzeErrorLog(req.ErrorLog)
+	translateAnalysis(r.Context(), analysis)
+	if req.Version != "" {
+		analysis.setMetadata("release", req.Version)
 	}
-
-	// Try to parse the issue URL from the final output for convenience
-	if strings.Contains(finalOutput, "GitHub issue created successfully!") {
-		if idx := strings.Index(finalOutput, "URL: "); idx != -1 {
-			if endIdx := strings.IndexAny(finalOutput[idx+5:], " \n"); endIdx != -1 {
-				resp.IssueURL = strings.TrimSpace(finalOutput[idx+5 : idx+5+endIdx])
-			} else {
-				resp.IssueURL = strings.TrimSpace(finalOutput[idx+5:])
-			}
-		}
-	} else if strings.Contains(finalOutput, "Found existing issues:") {
-		// If it's an existing issue, try to extract the first URL if present
-		if idx := strings.Index(finalOutput, "URL: "); idx != -1 {
-			if endIdx := strings.IndexAny(finalOutput[idx+5:], " \n"); endIdx != -1 {
-				resp.IssueURL = strings.TrimSpace(finalOutput[idx+5 : idx+5+endIdx])
-			} else {
-				resp.IssueURL = strings.TrimSpace(finalOutput[idx+5:])
-			}
+	if req.Environment != "" {
+		analysis.setMetadata("environment", req.Environment)
+	}
+	svc, hasService := services.get(req.Service)
+	if hasService {
+		svc.apply(analysis)
+		applyOnCall(r.Context(), svc, analysis)
+	} else if req.Service != "" {
+		analysis.setMetadata("service", req.Service)
+	}
+	clusters.assign(analysis)
+	if ansiRendering == ansiMarkdown {
+		analysis.HighlightedLog = renderANSIMarkdown(rawLog)
+	}
+	slog.InfoContext(r.Context(), "Parsed error log", "format", analysis.Format, "category", analysis.Category, "fingerprint", analysis.Fingerprint, "cluster", analysis.Cluster)
+
+	var target repoTarget
+	switch {
+	case req.Repository != "":
+		var status int
+		target, status, err = resolveRepoOverride(r, req.Repository)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
 		}
+		slog.InfoContext(r.Context(), "Using repository override", "repository", target.String())
+	case hasService && svc.Repository != "":
+		target, _ = parseRepoTarget(svc.Repository)
+		slog.InfoContext(r.Context(), "Using repository of service", "repository", target.String(), "service", svc.Name)
+	default:
+		target = routeTarget(req.ErrorLog, analysis)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
-}
-
-func searchGithubIssues(args map[string]any) (string, error) {
-	query, ok := args["query"].(string)
-	if !ok {
-		return "", fmt.Errorf("missing or invalid 'query' argument for search_github_issues")
+	if err := authorizeRepo(r, target); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
 	}
-	log.Printf("Tool Call: Searching for GitHub issues for query: '%s'", query)
 
-	searchQuery := fmt.Sprintf("%s is:issue in:title,body repo:%s/%s", query, ghOwner, ghRepo)
-	issues, _, err := ghClient.Search.Issues(context.Background(), searchQuery, nil)
+	bootstrap.ensure(target)
+
+	wait, hasDeadline, err := requestDeadline(r, req.MaxWaitMS)
 	if err != nil {
-		log.Printf("Error searching GitHub issues: %v", err)
-		return fmt.Sprintf("Error searching GitHub issues: %v", err), err
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if len(issues.Issues) == 0 {
-		return "No existing issues found for this query.", nil
+	dryRun := wantsDryRun(r, req)
+	service := req.Service
+	if service == "" {
+		service = target.String()
+	}
+	if !dryRun {
+		stability.recordError(service, req.Version)
 	}
 
-	var results []string
-	for _, issue := range issues.Issues {
-		results = append(results, fmt.Sprintf("- Title: \"%s\", URL: %s", *issue.Title, *issue.HTMLURL))
+	if known, ok := matchKnownIssue(analysis); ok {
+		slog.InfoContext(r.Context(), "Fingerprint matches known issue", "fingerprint", analysis.Fingerprint, "known_issue", known.ID, "policy", known.Policy)
+		metrics.add("triage_known_issue_matches_total", labelSet("id", known.ID, "policy", known.Policy), 1)
+		if known.Policy == kbSkip {
+			writeJSON(w, http.StatusOK, APIResponse{
+				Status:      "known_issue",
+				Message:     known.Resolution,
+				Repository:  target.String(),
+				Fingerprint: analysis.Fingerprint,
+				KnownIssue:  &known,
+			})
+			return
+		}
+		analysis.KnownIssue = &known
 	}
-	return fmt.Sprintf("Found %d existing issues:\n%s", len(issues.Issues), strings.Join(results, "\n")), nil
 
-}
+	// A dry run sees the fingerprint's lifecycle but doesn't change it,
+	// and always runs the agent rather than reusing a cached response.
+	if dryRun {
+		if state, ok := lifecycles.get(target.String(), analysis.Fingerprint); ok {
+			analysis.Lifecycle = &state
+		}
+	} else {
+		state, regressed := lifecycles.observe(analysis.Fingerprint, target, req.Version, analysis)
+		analysis.Lifecycle = &state
+		trackOccurrences(state)
+		if regressed {
+			reopenRegression(state, req.Version, req.ErrorLog)
+			forgetResponse(r.Context(), target, analysis.Fingerprint)
+		} else if resp, ok := cachedResponse(r.Context(), target, analysis.Fingerprint); ok {
+			slog.InfoContext(r.Context(), "Reusing cached response", "fingerprint", analysis.Fingerprint, "run_id", resp.RunID, "issue_url", resp.IssueURL)
+			resp.Cached = true
+			writeJSON(w, http.StatusOK, resp)
+			return
+		}
+	}
 
-func createGithubIssues(args map[string]any) (string, error) {
-	title, ok := args["title"].(string)
-	if !ok {
-		return "", fmt.Errorf("missing or invalid 'title' argument for create_github_issue")
+	in := triageInput{
+		Target:    target,
+		ErrorLog:  req.ErrorLog,
+		Analysis:  analysis,
+		DryRun:    dryRun,
+		RequestID: requestID(r.Context()),
+		ClientTag: clientTag(r.Context()),
+		Source:    ingestSource(r.Context()),
+	}
+	if wantsAsync(r) {
+		acceptJob(w, in)
+		return
+	}
+	if hasDeadline {
+		awaitJob(w, r, in, wait)
+		return
 	}
 
-	body, ok := args["body"].(string)
-	if !ok {
-		return "", fmt.Errorf("missing or invalid 'body' argument for create_github_issue")
+	resp, err := in.run(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Agent failed to process error: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	labelsRaw, ok := args["labels"].([]any)
-	if !ok {
-		labelsRaw = []any{}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// triageInput is what a triage needs once its request has been checked and
+// analysed. Jobs keep it in the store until they finish, so that they can
+// be resumed after a restart.
+type triageInput struct {
+	Target   repoTarget   `json:"target"`
+	ErrorLog string       `json:"error_log"`
+	Analysis *LogAnalysis `json:"analysis"`
+	DryRun   bool         `json:"dry_run,omitempty"`
+	// RequestID, ClientTag and Source are those of the request, which
+	// jobs run outside of.
+	RequestID string `json:"request_id,omitempty"`
+	ClientTag string `json:"client_tag,omitempty"`
+	Source    string `json:"source,omitempty"`
+}
+
+// run triages the error, or previews it in a dry run.
+func (in triageInput) run(ctx context.Context) (APIResponse, error) {
+	// Jobs run outside the request, but their logs and calls still belong
+	// to it.
+	ctx = contextWithClientTag(contextWithRequestID(ctx, in.RequestID), in.ClientTag)
+	if in.DryRun {
+		return dryRunTriage(ctx, in.ErrorLog, in.Target, in.Analysis)
 	}
+	return floods.triage(ctx, in.Target, in.Analysis.Fingerprint, func(ctx context.Context) (APIResponse, error) {
+		run := runs.start(in)
+		resp, err := executeTriage(ctx, run, newToolSession(in.Target, false), in.Analysis)
+		if err != nil {
+			return APIResponse{}, err
+		}
+		cacheResponse(ctx, in.Target, resp)
+		return resp, nil
+	})
+}
 
-	var labels []string
-	for _, l := range labelsRaw {
-		if s, isString := l.(string); isString {
-			labels = append(labels, s)
+// executeTriage runs the agent (or opens an incident) for a recorded run,
+// stores the outcome in the run registry and applies the matching rules.
+func executeTriage(ctx context.Context, run TriageRun, session *toolSession, analysis *LogAnalysis) (resp APIResponse, err error) {
+	ctx, span := tracer.Start(ctx, "triage", trace.WithAttributes(
+		attribute.String("triage.run_id", run.ID),
+		attribute.String("triage.repository", session.target.String()),
+		attribute.String("triage.fingerprint", analysis.Fingerprint),
+		attribute.String("triage.format", analysis.Format),
+		attribute.Bool("triage.dry_run", session.dryRun),
+	))
+	defer func() {
+		// A bug in one run fails the run, not the server, and doesn't
+		// leave identical errors waiting on it forever.
+		if rec := recover(); rec != nil {
+			err = recoveredPanic(ctx, "triage", rec)
+			runs.finish(run.ID, nil, "", err)
 		}
+		span.SetAttributes(attribute.String("triage.issue_url", resp.IssueURL))
+		endSpan(span, err)
+	}()
+	ctx = contextWithRunID(ctx, run.ID)
+	session.ctx = ctx
+	session.log = requestLogger(ctx).With("run_id", run.ID)
+
+	classifySeverity(run.ErrorLog, analysis)
+	linkRunbooks(analysis)
+	matched := matchRules(analysis, session.target)
+
+	if serviceNow != nil && serviceNow.handles(analysis) {
+		resp, err = openIncidentRun(ctx, run, session, analysis)
+	} else {
+		resp, err = runTriageAgent(ctx, run, session, analysis)
 	}
-
-	log.Printf("Tool Call: Creating GitHub issue - Title: '%s', Labels: %v", title, labels)
-
-	newIssue := &github.IssueRequest{
-		Title:  &title,
-		Body:   &body,
-		Labels: &labels,
+	if err == nil && len(matched) > 0 && !session.dryRun {
+		applyRules(ctx, matched, session.target, analysis, *resp.Result)
 	}
+	return resp, err
+}
+
+// runTriageAgent runs the agent on an analysed error.
+func runTriageAgent(ctx context.Context, run TriageRun, session *toolSession, analysis *LogAnalysis) (APIResponse, error) {
+	session.allowLabels(analysis.Labels...)
+	session.analysis, session.errorLog = analysis, run.ErrorLog
 
-	issue, _, err := ghClient.Issues.Create(context.Background(), ghOwner, ghRepo, newIssue)
+	var outputBuffer bytes.Buffer
+	finalOutput, err := newTriagePipeline(ctx, session, newLLM(llmConfig, llmKey, run.ID), llmOptions).Run(ctx, buildAgentInput(run.ErrorLog, analysis), run.ID, &outputBuffer)
 	if err != nil {
-		log.Printf("Error creating GitHub issue: %v", err)
-		return fmt.Sprintf("Error creating GitHub issue: %v", err), err
+		slog.ErrorContext(ctx, "Pipeline execution failed", "run_id", run.ID, "error", err)
+		runs.finish(run.ID, nil, "", err)
+		return APIResponse{}, err
 	}
 
-	return fmt.Sprintf("GitHub issue created successfully! Title: \"%s\", URL: %s", *issue.Title, *issue.HTMLURL), nil
+	slog.DebugContext(ctx, "Agent's final response", "run_id", run.ID, "response", finalOutput)
+
+	session.followUpCreatedIssues()
+	result := session.triageResult()
+	resp := APIResponse{
+		Status:      "success",
+		Message:     finalOutput,
+		IssueURL:    result.IssueURL,
+		RunID:       run.ID,
+		Repository:  session.target.String(),
+		Fingerprint: analysis.Fingerprint,
+		Result:      &result,
+		KnownIssue:  analysis.KnownIssue,
+	}
+	runs.finish(run.ID, &result, finalOutput, nil)
+	slog.InfoContext(ctx, "Triage finished", "run_id", run.ID, "action", result.Action, "fingerprint", analysis.Fingerprint, "issue_url", resp.IssueURL)
+	if canary != nil && !session.dryRun {
+		go canary.pair(context.WithoutCancel(ctx), run, session.target, analysis, result)
+	}
+	if !session.dryRun {
+		lifecycles.recordIssue(session.target.String(), analysis.Fingerprint, resp.IssueURL)
+		if st, ok := lifecycles.get(session.target.String(), analysis.Fingerprint); ok && (analysis.Lifecycle == nil || analysis.Lifecycle.IssueURL != st.IssueURL) {
+			// The issue is new to the fingerprint and has no counts yet.
+			trackOccurrences(st)
+		}
+	}
+	return resp, nil
 }
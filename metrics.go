@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricsRegistry is a minimal Prometheus text-format registry. Series are
+// keyed by name and a preformatted label string like `result="success"`.
+type metricsRegistry struct {
+	mu     sync.Mutex
+	help   map[string]string
+	kinds  map[string]string
+	values map[string]map[string]float64
+}
+
+var metrics = &metricsRegistry{
+	help:   make(map[string]string),
+	kinds:  make(map[string]string),
+	values: make(map[string]map[string]float64),
+}
+
+// describe registers a metric's type ("counter" or "gauge") and help text.
+func (m *metricsRegistry) describe(name, kind, help string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.kinds[name] = kind
+	m.help[name] = help
+}
+
+func (m *metricsRegistry) add(name, labels string, delta float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seriesLocked(name)[labels] += delta
+}
+
+func (m *metricsRegistry) set(name, labels string, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seriesLocked(name)[labels] = value
+}
+
+// remove drops one series, e.g. when the thing it measures is forgotten.
+func (m *metricsRegistry) remove(name, labels string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values[name], labels)
+}
+
+// labelSet formats label name/value pairs as a series label string,
+// escaping the values.
+func labelSet(pairs ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], v))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *metricsRegistry) seriesLocked(name string) map[string]float64 {
+	series, ok := m.values[name]
+	if !ok {
+		series = make(map[string]float64)
+		m.values[name] = series
+	}
+	return series
+}
+
+func (m *metricsRegistry) writeTo(sb *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.values))
+	for name := range m.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if help := m.help[name]; help != "" {
+			fmt.Fprintf(sb, "# HELP %s %s\n", name, help)
+		}
+		if kind := m.kinds[name]; kind != "" {
+			fmt.Fprintf(sb, "# TYPE %s %s\n", name, kind)
+		}
+
+		series := m.values[name]
+		labels := make([]string, 0, len(series))
+		for l := range series {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+		for _, l := range labels {
+			if l == "" {
+				fmt.Fprintf(sb, "%s %g\n", name, series[l])
+			} else {
+				fmt.Fprintf(sb, "%s{%s} %g\n", name, l, series[l])
+			}
+		}
+	}
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var sb strings.Builder
+	metrics.writeTo(&sb)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(sb.String()))
+}
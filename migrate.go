@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are numbered SQL files, "0001_name.sql", applied in order.
+// Applied migrations are never edited: schema changes go in a new file.
+// Statements are separated by ";" at the end of a line and must work on
+// both SQLite and Postgres.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationSteps are the parts of migrations SQL can't do on both SQLite
+// and Postgres, by version. They run in the migration's transaction,
+// after its SQL.
+var migrationSteps = map[int]func(ctx context.Context, s *sqlStore, tx *sql.Tx) error{
+	2: fillRunRetryColumns,
+	3: moveFingerprintStates,
+}
+
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var out []migration
+	for _, e := range entries {
+		num, name, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s: expected NNNN_name.sql", e.Name())
+		}
+		data, err := migrationFiles.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, migration{Version: version, Name: name, SQL: string(data)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// schemaVersion returns the highest applied migration, creating the
+// bookkeeping table if needed.
+func (s *sqlStore) schemaVersion(ctx context.Context) (int, error) {
+	if err := s.exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at BIGINT NOT NULL
+	)`); err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// migrate applies pending migrations, each in its own transaction, and
+// returns the ones it applied. It refuses to run against a schema newer
+// than this binary knows, since the code may not understand it.
+func (s *sqlStore) migrate(ctx context.Context) ([]migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.schemaVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading schema version: %w", err)
+	}
+	if latest := migrations[len(migrations)-1].Version; current > latest {
+		return nil, fmt.Errorf("database schema is at version %d, newer than this binary's %d; upgrade the binary", current, latest)
+	}
+
+	var applied []migration
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := s.apply(ctx, m); err != nil {
+			return applied, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// checkSchema fails unless every known migration has been applied.
+func (s *sqlStore) checkSchema(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	current, err := s.schemaVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	if latest := migrations[len(migrations)-1].Version; current != latest {
+		return fmt.Errorf("database schema is at version %d, this binary expects %d; run \"triage db migrate\"", current, latest)
+	}
+	return nil
+}
+
+func (s *sqlStore) apply(ctx context.Context, m migration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range strings.Split(m.SQL, ";\n") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if step, ok := migrationSteps[m.Version]; ok {
+		if err := step(ctx, s, tx); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`),
+		m.Version, m.Name, time.Now().UnixNano()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// fillRunRetryColumns copies the attempts and retry times of the runs
+// stored before migration 0002 from their JSON into its columns.
+func fillRunRetryColumns(ctx context.Context, s *sqlStore, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT data FROM runs`)
+	if err != nil {
+		return err
+	}
+	var all []TriageRun
+	for rows.Next() {
+		var data string
+		var run TriageRun
+		if err := rows.Scan(&data); err != nil {
+			rows.Close()
+			return err
+		}
+		if err := json.Unmarshal([]byte(data), &run); err != nil {
+			rows.Close()
+			return err
+		}
+		all = append(all, run)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, run := range all {
+		if _, err := tx.ExecContext(ctx, s.rebind(`UPDATE runs SET attempts = ?, next_retry_at = ? WHERE id = ?`),
+			run.Attempts, runRetryAt(run), run.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveFingerprintStates copies the fingerprint states stored before
+// migration 0003, keyed by fingerprint alone, to the table keyed by
+// repository and fingerprint, and drops the old table.
+func moveFingerprintStates(ctx context.Context, s *sqlStore, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT data FROM fingerprints`)
+	if err != nil {
+		return err
+	}
+	var all []FingerprintState
+	for rows.Next() {
+		var data string
+		var st FingerprintState
+		if err := rows.Scan(&data); err != nil {
+			rows.Close()
+			return err
+		}
+		if err := json.Unmarshal([]byte(data), &st); err != nil {
+			rows.Close()
+			return err
+		}
+		all = append(all, st)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, st := range all {
+		data, err := json.Marshal(st)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO fingerprint_states (repository, fingerprint, state, last_seen, data) VALUES (?, ?, ?, ?, ?)`),
+			strings.ToLower(st.Repository), st.Fingerprint, st.State, st.LastSeen.UnixNano(), string(data)); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `DROP TABLE fingerprints`)
+	return err
+}
+
+// runDBCLI implements "triage db migrate" and returns the exit code.
+func runDBCLI(args []string) int {
+	if len(args) == 0 || args[0] != "migrate" {
+		fmt.Fprintln(os.Stderr, "Usage: triage db migrate [-config path]")
+		return 2
+	}
+
+	fset := flag.NewFlagSet("db migrate", flag.ContinueOnError)
+	path := fset.String("config", configPath(), "config file with the store settings")
+	if err := fset.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: loading config %s: %v\n", *path, err)
+		return 1
+	}
+	sc := configStore(cfg)
+	if sc.Driver == "" || sc.Driver == storeMemory {
+		fmt.Println("The memory store has no schema to migrate.")
+		return 0
+	}
+
+	if sc.Driver != storeSQLite && sc.Driver != storePostgres {
+		fmt.Fprintf(os.Stderr, "Error: unknown store driver %q\n", sc.Driver)
+		return 1
+	}
+	s, err := openSQLStore(sc.Driver, sc.dsn())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer s.Close()
+
+	applied, err := s.migrate(context.Background())
+	for _, m := range applied {
+		fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	version, _ := s.schemaVersion(context.Background())
+	if len(applied) == 0 {
+		fmt.Printf("Schema is up to date at version %d.\n", version)
+	} else {
+		fmt.Printf("Schema is now at version %d.\n", version)
+	}
+	return 0
+}
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS runs (
+	id TEXT PRIMARY KEY,
+	status TEXT NOT NULL,
+	started_at BIGINT NOT NULL,
+	data TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS runs_status_started_at ON runs (status, started_at);
+
+CREATE TABLE IF NOT EXISTS fingerprints (
+	fingerprint TEXT PRIMARY KEY,
+	state TEXT NOT NULL,
+	last_seen BIGINT NOT NULL,
+	data TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS fingerprints_state_last_seen ON fingerprints (state, last_seen);
+
+CREATE TABLE IF NOT EXISTS kv (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
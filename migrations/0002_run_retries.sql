@@ -0,0 +1,5 @@
+ALTER TABLE runs ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE runs ADD COLUMN next_retry_at BIGINT;
+
+CREATE INDEX IF NOT EXISTS runs_status_next_retry_at ON runs (status, next_retry_at);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS fingerprint_states (
+	repository TEXT NOT NULL,
+	fingerprint TEXT NOT NULL,
+	state TEXT NOT NULL,
+	last_seen BIGINT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (repository, fingerprint)
+);
+
+CREATE INDEX IF NOT EXISTS fingerprint_states_state_last_seen ON fingerprint_states (state, last_seen);
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+func init() {
+	metrics.describe("triage_milestones_set_total", "counter", "Milestones set on new issues, by result (matched, created or failed).")
+}
+
+// MilestoneConfig configures how new issues are attached to the milestone
+// of the release they were logged from.
+type MilestoneConfig struct {
+	// Create creates a milestone for a release that has none, rather than
+	// leaving the issue without one.
+	Create bool `yaml:"create"`
+}
+
+var milestoneConfig MilestoneConfig
+
+var (
+	// logReleasePattern finds the release in a log, e.g. "version=1.4.2",
+	// "app_version: v2.0.0-rc1" or "release 1.4".
+	logReleasePattern = regexp.MustCompile(`(?i)(\w*)\s*\b(?:app[_.-]?version|service[_.-]?version|version|release)["']?\s*[:=]?\s*["']?(v?\d+\.\d+(?:\.\d+)*(?:-[0-9A-Za-z.]+)?)\b`)
+	// runtimeNames precede the versions of runtimes rather than of the
+	// application, e.g. "Python version 3.11.2".
+	runtimeNames = []string{"go", "python", "java", "jvm", "jre", "jdk", "node", "ruby", "rust", "dotnet", "net", "php", "kernel", "os", "runtime", "tls", "http", "api", "schema", "protocol"}
+)
+
+// enrichRelease records the release a log names as the "release" metadata,
+// for the issue's milestone. A version sent with the log takes precedence.
+func enrichRelease(errorLog string, analysis *LogAnalysis) {
+	for _, m := range logReleasePattern.FindAllStringSubmatch(errorLog, -1) {
+		prev := strings.ToLower(m[1])
+		if containsFold(runtimeNames, prev) {
+			continue
+		}
+		analysis.setMetadata("release", m[2])
+		return
+	}
+}
+
+// containsFold reports whether s is in list, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// findMilestone returns the milestone of a release: one titled with the
+// version, with or without a "v", or else the one of its release line,
+// e.g. "1.4" for 1.4.2. Closed milestones count too, as errors keep coming
+// from released versions.
+func findMilestone(ctx context.Context, target repoTarget, version string) (*github.Milestone, error) {
+	want := strings.TrimPrefix(strings.ToLower(version), "v")
+	opts := &github.MilestoneListOptions{State: "all", ListOptions: github.ListOptions{PerPage: 100}}
+	var line *github.Milestone
+	for {
+		milestones, resp, err := ghClient.Issues.ListMilestones(ctx, target.Owner, target.Repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range milestones {
+			title := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(m.GetTitle())), "v")
+			if title == want {
+				return m, nil
+			}
+			// The longest release line wins: 1.4 over 1.
+			if versionPattern.MatchString(title) && strings.HasPrefix(want, title+".") &&
+				(line == nil || len(title) > len(strings.TrimPrefix(strings.ToLower(line.GetTitle()), "v"))) {
+				line = m
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return line, nil
+}
+
+// setIssueMilestone attaches an issue to the milestone of a release,
+// creating the milestone if it is missing and milestones.create is on. It
+// returns the milestone's title, or "" if there is none.
+func setIssueMilestone(ctx context.Context, target repoTarget, number int, version string) (string, error) {
+	milestone, err := findMilestone(ctx, target, version)
+	if err != nil {
+		return "", err
+	}
+	result := "matched"
+	if milestone == nil {
+		if !milestoneConfig.Create {
+			return "", nil
+		}
+		err := retryGitHub(ctx, func() (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			milestone, resp, err = ghClient.Issues.CreateMilestone(ctx, target.Owner, target.Repo, &github.Milestone{Title: github.String(version)})
+			return resp, err
+		})
+		if err != nil {
+			return "", fmt.Errorf("creating milestone %s: %w", version, err)
+		}
+		result = "created"
+	}
+
+	err = retryGitHub(ctx, func() (*github.Response, error) {
+		_, resp, err := ghClient.Issues.Edit(ctx, target.Owner, target.Repo, number, &github.IssueRequest{Milestone: milestone.Number})
+		return resp, err
+	})
+	if err != nil {
+		return "", err
+	}
+	metrics.add("triage_milestones_set_total", labelSet("result", result), 1)
+	return milestone.GetTitle(), nil
+}
+
+// setMilestone attaches an issue the run created to the milestone of the
+// release the error was logged from.
+func (t *toolSession) setMilestone(number int, version string) error {
+	if err := checkAction(t.target, actionSetMilestone, nil); err != nil {
+		t.log.Info("Not setting the milestone of the new issue", "issue_number", number, "reason", err)
+		return nil
+	}
+	if tracker.Name() != trackerGitHub {
+		return nil
+	}
+
+	res, done := writer.submitAndWait(&githubIntent{
+		Kind:        intentMilestone,
+		Target:      t.target,
+		IssueNumber: number,
+		Title:       version,
+		RunID:       runID(t.ctx),
+	})
+	if done && res.Err != nil {
+		metrics.add("triage_milestones_set_total", `result="failed"`, 1)
+		return fmt.Errorf("setting the milestone of issue #%d: %w", number, res.Err)
+	}
+	t.log.Info("Set the milestone of the new issue", "issue_number", number, "release", version, "milestone", res.Milestone, "queued", !done)
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// NormalizationRule replaces a kind of high-cardinality value in error
+// messages before they are fingerprinted, so that the same error with
+// different IDs or times gets the same fingerprint. A rule is either one of
+// the built-in rules, by name, or a custom regular expression.
+type NormalizationRule struct {
+	Name string `yaml:"name"`
+	// Pattern is the regular expression of a custom rule.
+	Pattern string `yaml:"pattern"`
+	// Replace is what matches are replaced with (default "<name>"). It may
+	// refer to groups, e.g. "${1}=<id>".
+	Replace string `yaml:"replace"`
+}
+
+// builtinNormalizationRules can be used by name. Rules run in the
+// configured order, so e.g. port has to come before number.
+var builtinNormalizationRules = map[string]NormalizationRule{
+	"uuid":       {Pattern: uuidPattern.String(), Replace: "<uuid>"},
+	"timestamp":  {Pattern: `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?`, Replace: "<timestamp>"},
+	"hex":        {Pattern: `(?i)0x[0-9a-f]+`, Replace: "<hex>"},
+	"request_id": {Pattern: `(?i)\b((?:request|req|trace|span|correlation)[_-]?id)(["']?\s*[:=]\s*["']?)[\w-]+`, Replace: "${1}${2}<id>"},
+	"ip":         {Pattern: `\b(?:\d{1,3}\.){3}\d{1,3}\b`, Replace: "<ip>"},
+	"port":       {Pattern: `:\d{2,5}\b`, Replace: ":<port>"},
+	"number":     {Pattern: `\d+`, Replace: "<n>"},
+}
+
+// defaultNormalizationRules are used when none are configured. Changing
+// them changes existing fingerprints.
+var defaultNormalizationRules = []NormalizationRule{{Name: "uuid"}, {Name: "hex"}, {Name: "number"}}
+
+type messageNormalizer struct {
+	name    string
+	pattern *regexp.Regexp
+	replace string
+}
+
+// normalizers come from the normalization config setting.
+var normalizers, _ = compileNormalization(nil)
+
+// compileNormalization validates the normalization rules and compiles them
+// in order. Invalid rules are left out.
+func compileNormalization(rules []NormalizationRule) ([]messageNormalizer, []string) {
+	if len(rules) == 0 {
+		rules = defaultNormalizationRules
+	}
+	var out []messageNormalizer
+	var problems []string
+	for i, rule := range rules {
+		prefix := fmt.Sprintf("normalization[%d]", i)
+		if rule.Name == "" {
+			problems = append(problems, prefix+".name must be set")
+			continue
+		}
+		if rule.Pattern == "" {
+			builtin, ok := builtinNormalizationRules[rule.Name]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a built-in rule (%s); custom rules need a pattern", prefix, rule.Name, strings.Join(sortedKeys(builtinNormalizationRules), ", ")))
+				continue
+			}
+			rule.Pattern = builtin.Pattern
+			rule.Replace = firstNonEmpty(rule.Replace, builtin.Replace)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s.pattern: invalid regular expression %q", prefix, rule.Pattern))
+			continue
+		}
+		out = append(out, messageNormalizer{name: rule.Name, pattern: re, replace: firstNonEmpty(rule.Replace, "<"+rule.Name+">")})
+	}
+	return out, problems
+}
+
+// normalizeMessage removes high-cardinality values from a message so that
+// the same error with different IDs or addresses produces the same string.
+func normalizeMessage(msg string) string {
+	for _, n := range normalizers {
+		msg = n.pattern.ReplaceAllString(msg, n.replace)
+	}
+	return strings.TrimSpace(msg)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ansiPattern matches ANSI escape sequences: CSI sequences such as colors
+// and cursor movement, OSC sequences such as hyperlinks and window titles,
+// and two-character escapes.
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// decodeText converts a request body to UTF-8. A byte order mark wins,
+// then the charset of the Content-Type. Without either, a body that isn't
+// valid UTF-8 is taken as UTF-16 if it looks like it, otherwise it is left
+// as is, for the input check to reject. Latin-1 and Windows-1252 bodies
+// must declare their charset.
+func decodeText(data []byte, contentType string) ([]byte, error) {
+	var enc encoding.Encoding
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		if enc, err = htmlindex.Get(params["charset"]); err != nil {
+			return nil, fmt.Errorf("unsupported charset %q", params["charset"])
+		}
+	}
+
+	switch {
+	case hasBOM(data):
+		// BOMOverride uses the BOM's encoding and drops the BOM.
+		var fallback transform.Transformer = encoding.Nop.NewDecoder()
+		if enc != nil {
+			fallback = enc.NewDecoder()
+		}
+		out, _, err := transform.Bytes(unicode.BOMOverride(fallback), data)
+		return out, err
+	case enc != nil:
+	case utf8.Valid(data):
+		return data, nil
+	case looksLikeUTF16(data, 1):
+		enc = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case looksLikeUTF16(data, 0):
+		enc = unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	default:
+		return data, nil
+	}
+	return enc.NewDecoder().Bytes(data)
+}
+
+func hasBOM(data []byte) bool {
+	return bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}) ||
+		bytes.HasPrefix(data, []byte{0xFF, 0xFE}) ||
+		bytes.HasPrefix(data, []byte{0xFE, 0xFF})
+}
+
+// looksLikeUTF16 reports whether mostly-ASCII UTF-16 text is likely: at
+// least a third of the bytes at the given parity (1 for little endian, 0
+// for big endian) are NUL, as the high bytes of ASCII characters are.
+func looksLikeUTF16(data []byte, parity int) bool {
+	if len(data) < 2 || len(data)%2 != 0 {
+		return false
+	}
+	zeros := 0
+	for i := parity; i < len(data); i += 2 {
+		if data[i] == 0 {
+			zeros++
+		}
+	}
+	return zeros*3 >= len(data)/2
+}
+
+// normalizeLogText makes submitted text consistent before it is checked,
+// parsed and fingerprinted: it drops a leading BOM and ANSI escape
+// sequences from colored loggers, turns CRLF and CR line endings into LF,
+// and composes Unicode to NFC so that visually identical messages hash
+// the same.
+func normalizeLogText(s string) string {
+	s = strings.TrimPrefix(s, "\uFEFF")
+	if strings.Contains(s, "\x1b") {
+		s = ansiPattern.ReplaceAllString(s, "")
+	}
+	if strings.Contains(s, "\r") {
+		s = strings.ReplaceAll(s, "\r\n", "\n")
+		s = strings.ReplaceAll(s, "\r", "\n")
+	}
+	return norm.NFC.String(s)
+}
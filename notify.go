@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	slackPostMessageURL = "https://slack.com/api/chat.postMessage"
+	pagerDutyEventsURL  = "https://events.pagerduty.com/v2/enqueue"
+	// channelPagerDuty as a notification channel triggers a PagerDuty
+	// incident through the Events API.
+	channelPagerDuty = "pagerduty"
+)
+
+// When a channel is notified.
+const (
+	notifyAlways        = "always"
+	notifyBusinessHours = "business_hours"
+	notifyAfterHours    = "after_hours"
+)
+
+const defaultBusinessHours = "09:00-17:00"
+
+var defaultBusinessDays = []string{"mon", "tue", "wed", "thu", "fri"}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// severityRanks orders severities for min_severity. Alert severities rank
+// with their closest issue severity; errors without a severity rank below
+// all of them.
+var severityRanks = map[string]int{
+	"critical": 4,
+	"high":     3, "error": 3,
+	"medium": 2, "warning": 2,
+	"low": 1, "info": 1,
+}
+
+func init() {
+	metrics.describe("triage_notifications_total", "counter", "Notifications of rules, by channel and outcome (sent, suppressed or failed).")
+}
+
+// NotificationConfig decides when the notification channels of rules are
+// notified, so that nobody is paged for a low-severity error at 3am.
+// Suppressed notifications are dropped, not delayed.
+type NotificationConfig struct {
+	// BusinessHours are the default business hours.
+	BusinessHours BusinessHours `yaml:"business_hours"`
+	// Teams override the business hours for the teams of the teams
+	// setting, e.g. to use their timezone.
+	Teams map[string]BusinessHours `yaml:"teams"`
+	// Channels are the policies of notification channels ("#channel" or
+	// "pagerduty"). Channels without a policy are always notified.
+	Channels map[string]ChannelPolicy `yaml:"channels"`
+}
+
+// BusinessHours is a working week in a timezone.
+type BusinessHours struct {
+	// Timezone is an IANA timezone, e.g. Europe/Berlin (default UTC).
+	Timezone string `yaml:"timezone"`
+	// Hours is the working day, e.g. "09:00-17:00" (the default). An end
+	// before the start spans midnight.
+	Hours string `yaml:"hours"`
+	// Days are the working days (default mon to fri).
+	Days []string `yaml:"days"`
+}
+
+// ChannelPolicy limits when a channel is notified.
+type ChannelPolicy struct {
+	// When is always (the default), business_hours or after_hours.
+	When string `yaml:"when"`
+	// MinSeverity is the lowest severity notified: critical, high, medium
+	// or low. Errors without a severity are then not notified.
+	MinSeverity string `yaml:"min_severity"`
+	// Team is the team whose business hours apply. By default, the team
+	// owning the target repository, else the default hours.
+	Team string `yaml:"team"`
+}
+
+// workWeek is compiled BusinessHours.
+type workWeek struct {
+	loc        *time.Location
+	start, end int // minutes after midnight
+	days       []time.Weekday
+}
+
+// contains reports whether t is within business hours.
+func (w workWeek) contains(t time.Time) bool {
+	t = t.In(w.loc)
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start <= w.end {
+		return slices.Contains(w.days, day) && minute >= w.start && minute < w.end
+	}
+	// The working day spans midnight: the early hours belong to the
+	// previous day.
+	if minute >= w.start {
+		return slices.Contains(w.days, day)
+	}
+	return minute < w.end && slices.Contains(w.days, (day+6)%7)
+}
+
+// notificationPolicy is the compiled NotificationConfig.
+type notificationPolicy struct {
+	hours    workWeek
+	teams    map[string]workWeek
+	channels map[string]ChannelPolicy
+}
+
+var notifications = newNotificationPolicy(NotificationConfig{})
+
+func compileBusinessHours(prefix string, bh BusinessHours) (workWeek, []string) {
+	var problems []string
+	w := workWeek{loc: time.UTC, start: 9 * 60, end: 17 * 60}
+	if bh.Timezone != "" {
+		loc, err := time.LoadLocation(bh.Timezone)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s.timezone: unknown timezone %q", prefix, bh.Timezone))
+		} else {
+			w.loc = loc
+		}
+	}
+	hours := firstNonEmpty(bh.Hours, defaultBusinessHours)
+	from, to, _ := strings.Cut(hours, "-")
+	start, err1 := time.Parse("15:04", strings.TrimSpace(from))
+	end, err2 := time.Parse("15:04", strings.TrimSpace(to))
+	if err1 != nil || err2 != nil || start.Equal(end) {
+		problems = append(problems, fmt.Sprintf("%s.hours: %q must be a range like 09:00-17:00", prefix, hours))
+	} else {
+		w.start, w.end = start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+	}
+	days := bh.Days
+	if len(days) == 0 {
+		days = defaultBusinessDays
+	}
+	for i, name := range days {
+		day, ok := weekdays[strings.ToLower(name)]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s.days[%d]: unknown day %q, expected mon, tue, wed, thu, fri, sat or sun", prefix, i, name))
+			continue
+		}
+		w.days = append(w.days, day)
+	}
+	return w, problems
+}
+
+// compileNotifications validates the notification config and builds the
+// policy. Invalid settings fall back to their defaults.
+func compileNotifications(cfg NotificationConfig) (*notificationPolicy, []string) {
+	p := &notificationPolicy{teams: make(map[string]workWeek), channels: cfg.Channels}
+	var problems []string
+	p.hours, problems = compileBusinessHours("notifications.business_hours", cfg.BusinessHours)
+	for _, team := range sortedKeys(cfg.Teams) {
+		w, teamProblems := compileBusinessHours("notifications.teams."+team, cfg.Teams[team])
+		problems = append(problems, teamProblems...)
+		p.teams[team] = w
+	}
+	for _, channel := range sortedKeys(cfg.Channels) {
+		policy := cfg.Channels[channel]
+		prefix := "notifications.channels." + channel
+		problems = append(problems, validateNotifyChannel(prefix, channel)...)
+		switch policy.When {
+		case "", notifyAlways, notifyBusinessHours, notifyAfterHours:
+		default:
+			problems = append(problems, fmt.Sprintf("%s.when: unknown value %q, expected always, business_hours or after_hours", prefix, policy.When))
+		}
+		if policy.MinSeverity != "" && !slices.Contains(severities, policy.MinSeverity) {
+			problems = append(problems, fmt.Sprintf("%s.min_severity: unknown severity %q, expected one of %s", prefix, policy.MinSeverity, strings.Join(severities, ", ")))
+		}
+		if _, ok := cfg.Teams[policy.Team]; policy.Team != "" && !ok {
+			problems = append(problems, fmt.Sprintf("%s.team: team %q has no business hours under notifications.teams", prefix, policy.Team))
+		}
+	}
+	return p, problems
+}
+
+func newNotificationPolicy(cfg NotificationConfig) *notificationPolicy {
+	p, _ := compileNotifications(cfg)
+	return p
+}
+
+// validateNotifyChannel checks a notification channel and that its
+// credentials are set.
+func validateNotifyChannel(prefix, channel string) []string {
+	switch {
+	case channel == channelPagerDuty:
+		if os.Getenv("PAGERDUTY_ROUTING_KEY") == "" {
+			return []string{prefix + ": PAGERDUTY_ROUTING_KEY must be set to notify PagerDuty"}
+		}
+	case strings.HasPrefix(channel, "#") && len(channel) > 1:
+		if os.Getenv("SLACK_BOT_TOKEN") == "" {
+			return []string{prefix + ": SLACK_BOT_TOKEN must be set to notify Slack channels"}
+		}
+	default:
+		return []string{fmt.Sprintf("%s: %q must be a Slack channel, e.g. #payments-oncall, or pagerduty", prefix, channel)}
+	}
+	return nil
+}
+
+// workWeek returns the business hours that apply to a channel's
+// notifications for a target.
+func (p *notificationPolicy) workWeek(policy ChannelPolicy, target repoTarget) workWeek {
+	if w, ok := p.teams[policy.Team]; ok {
+		return w
+	}
+	for _, team := range sortedKeys(teams) {
+		if w, ok := p.teams[team]; ok && repoMatches(teams[team], target) {
+			return w
+		}
+	}
+	return p.hours
+}
+
+// allows reports whether a channel may be notified now of an error of the
+// given severity, and if not, why.
+func (p *notificationPolicy) allows(channel, severity string, target repoTarget, now time.Time) (bool, string) {
+	policy, ok := p.channels[channel]
+	if !ok {
+		return true, ""
+	}
+	if policy.MinSeverity != "" && severityRanks[strings.ToLower(severity)] < severityRanks[policy.MinSeverity] {
+		return false, fmt.Sprintf("severity %q is below %s", severity, policy.MinSeverity)
+	}
+	switch policy.When {
+	case notifyBusinessHours:
+		if !p.workWeek(policy, target).contains(now) {
+			return false, "outside business hours"
+		}
+	case notifyAfterHours:
+		if p.workWeek(policy, target).contains(now) {
+			return false, "during business hours"
+		}
+	}
+	return true, ""
+}
+
+// notify sends the notification of a run to a channel, if its policy
+// allows it now.
+func notify(ctx context.Context, channel string, analysis *LogAnalysis, target repoTarget, result TriageResult) {
+	severity := analysis.Metadata["severity"]
+	if ok, reason := notifications.allows(channel, severity, target, time.Now()); !ok {
+		slog.InfoContext(ctx, "Notification suppressed", "channel", channel, "fingerprint", analysis.Fingerprint, "reason", reason)
+		metrics.add("triage_notifications_total", labelSet("channel", channel, "outcome", "suppressed"), 1)
+		return
+	}
+
+	var err error
+	if channel == channelPagerDuty {
+		err = triggerPagerDuty(ctx, analysis, target, result)
+	} else {
+		err = postSlackMessage(ctx, channel, ruleNotification(analysis, target, result))
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Notification failed", "channel", channel, "error", err)
+		metrics.add("triage_notifications_total", labelSet("channel", channel, "outcome", "failed"), 1)
+		return
+	}
+	metrics.add("triage_notifications_total", labelSet("channel", channel, "outcome", "sent"), 1)
+}
+
+func ruleNotification(analysis *LogAnalysis, target repoTarget, result TriageResult) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, ":rotating_light: *%s* in %s", incidentTitle(analysis), target)
+	if severity := analysis.Metadata["severity"]; severity != "" {
+		fmt.Fprintf(&sb, " (severity %s)", severity)
+	}
+	switch {
+	case result.IssueURL != "" && result.Duplicate:
+		fmt.Fprintf(&sb, "\nExisting issue: %s", result.IssueURL)
+	case result.IssueURL != "":
+		fmt.Fprintf(&sb, "\nNew issue: %s", result.IssueURL)
+	default:
+		sb.WriteString("\nNo issue was filed.")
+	}
+	return sanitizeText(sb.String())
+}
+
+// postJSON posts payload to url and returns the response, which the caller
+// must close.
+func postJSON(ctx context.Context, url string, payload any, header http.Header) (*http.Response, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	return http.DefaultClient.Do(req)
+}
+
+// postSlackMessage posts text to a channel with chat.postMessage.
+func postSlackMessage(ctx context.Context, channel, text string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	payload := map[string]string{"channel": strings.TrimPrefix(channel, "#"), "text": text}
+	resp, err := postJSON(ctx, slackPostMessageURL, payload, http.Header{"Authorization": {"Bearer " + os.Getenv("SLACK_BOT_TOKEN")}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Slack reports most errors with a 200 and ok=false.
+	var out struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("slack returned %s", resp.Status)
+	}
+	if !out.OK {
+		return errors.New("slack: " + out.Error)
+	}
+	return nil
+}
+
+// pagerDutySeverity maps a severity to the Events API's critical, error,
+// warning or info.
+func pagerDutySeverity(severity string) string {
+	switch severityRanks[strings.ToLower(severity)] {
+	case 4:
+		return "critical"
+	case 3:
+		return "error"
+	case 2:
+		return "warning"
+	}
+	return "info"
+}
+
+// triggerPagerDuty triggers an alert with the routing key in
+// PAGERDUTY_ROUTING_KEY. The fingerprint is the dedup key, so recurrences
+// of an open alert don't page again.
+func triggerPagerDuty(ctx context.Context, analysis *LogAnalysis, target repoTarget, result TriageResult) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	details := map[string]any{"fingerprint": analysis.Fingerprint, "category": analysis.Category}
+	if result.IssueURL != "" {
+		details["issue_url"] = result.IssueURL
+	}
+	event := map[string]any{
+		"routing_key":  os.Getenv("PAGERDUTY_ROUTING_KEY"),
+		"event_action": "trigger",
+		"dedup_key":    analysis.Fingerprint,
+		"payload": map[string]any{
+			"summary":        truncate(sanitizeText(incidentTitle(analysis)), 1000),
+			"source":         firstNonEmpty(analysisService(analysis), target.String()),
+			"severity":       pagerDutySeverity(analysis.Metadata["severity"]),
+			"class":          analysis.ErrorType,
+			"custom_details": details,
+		},
+	}
+	if result.IssueURL != "" {
+		event["links"] = []map[string]string{{"href": result.IssueURL, "text": "Issue"}}
+	}
+	resp, err := postJSON(ctx, pagerDutyEventsURL, event, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pagerduty returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// occurrenceBlock finds the occurrence block in an issue body.
+var occurrenceBlock = regexp.MustCompile(`(?s)<!-- triage:occurrences .*?<!-- /triage:occurrences -->`)
+
+// occurrenceStats is the machine-readable part of the occurrence block.
+type occurrenceStats struct {
+	Fingerprint string    `json:"fingerprint"`
+	Count       int       `json:"count"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// renderOccurrences returns the occurrence block of a fingerprint: its
+// counts as JSON in an HTML comment, for tools, followed by a line for
+// people.
+func renderOccurrences(st FingerprintState) string {
+	stats, _ := json.Marshal(occurrenceStats{
+		Fingerprint: st.Fingerprint,
+		Count:       st.Occurrences,
+		FirstSeen:   st.FirstSeen.UTC().Truncate(time.Second),
+		LastSeen:    st.LastSeen.UTC().Truncate(time.Second),
+	})
+	times := "time"
+	if st.Occurrences != 1 {
+		times = "times"
+	}
+	return fmt.Sprintf("<!-- triage:occurrences %s -->\n**Occurrences:** seen %d %s, first %s, last %s.\n<!-- /triage:occurrences -->",
+		stats, st.Occurrences, times, st.FirstSeen.UTC().Format(issueTimeLayout), st.LastSeen.UTC().Format(issueTimeLayout))
+}
+
+// withOccurrences replaces the occurrence block of body, or appends one.
+func withOccurrences(body, block string) string {
+	if occurrenceBlock.MatchString(body) {
+		return occurrenceBlock.ReplaceAllLiteralString(body, block)
+	}
+	return strings.TrimRight(body, "\n") + "\n\n" + block
+}
+
+// trackOccurrences queues an update of the occurrence block of the
+// fingerprint's issue. Other trackers have no body edit in IssueTracker, so
+// only GitHub issues carry the block; the counts are always in the
+// fingerprint state.
+func trackOccurrences(st FingerprintState) {
+	if tracker.Name() != trackerGitHub {
+		return
+	}
+	target, err := parseRepoTarget(st.Repository)
+	if err != nil {
+		return
+	}
+	number, ok := issueNumberFromURL(st.IssueURL)
+	if !ok {
+		return
+	}
+	writer.submit(&githubIntent{Kind: intentOccurrences, Target: target, IssueNumber: number, Body: renderOccurrences(st)})
+}
+
+// updateOccurrences writes an occurrence block into a GitHub issue body.
+func updateOccurrences(ctx context.Context, target repoTarget, number int, block string) error {
+	var issue *github.Issue
+	err := retryGitHub(ctx, func() (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		issue, resp, err = ghClient.Issues.Get(ctx, target.Owner, target.Repo, number)
+		return resp, err
+	})
+	if err != nil {
+		return err
+	}
+	body := withOccurrences(issue.GetBody(), block)
+	if body == issue.GetBody() {
+		return nil
+	}
+	return retryGitHub(ctx, func() (*github.Response, error) {
+		_, resp, err := ghClient.Issues.Edit(ctx, target.Owner, target.Repo, number, &github.IssueRequest{Body: &body})
+		return resp, err
+	})
+}
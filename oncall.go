@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// On-call providers.
+const (
+	onCallPagerDuty = "pagerduty"
+	onCallOpsgenie  = "opsgenie"
+)
+
+const (
+	pagerDutyAPIURL     = "https://api.pagerduty.com"
+	opsgenieAPIURL      = "https://api.opsgenie.com"
+	defaultOnCallMaxAge = 5 * time.Minute
+	// onCallMention in a rule's assign list stands for the service's
+	// current on-call.
+	onCallMention = "@oncall"
+)
+
+// OnCallConfig looks up who is on call for a service in PagerDuty or
+// Opsgenie, so issues mention and rules assign them instead of the
+// service's static owners. The API key is a secret and only read from
+// PAGERDUTY_TOKEN or OPSGENIE_API_KEY.
+type OnCallConfig struct {
+	// Provider is pagerduty or opsgenie. On-call lookup is off when it is
+	// empty.
+	Provider string `yaml:"provider"`
+	// APIURL overrides the provider's API, e.g. https://api.eu.opsgenie.com.
+	APIURL string `yaml:"api_url"`
+	// Users maps the email addresses of on-call users to GitHub handles.
+	Users map[string]string `yaml:"users"`
+	// MaxAge is how long a looked up on-call is reused (default 5m).
+	MaxAge string `yaml:"max_age"`
+}
+
+// onCall looks up on-call users when configured; nil otherwise.
+var onCall *onCallClient
+
+// configOnCall returns the on-call settings, with ONCALL_PROVIDER taking
+// precedence over the config file.
+func configOnCall(cfg *Config) OnCallConfig {
+	out := cfg.OnCall
+	out.Provider = envOr("ONCALL_PROVIDER", out.Provider)
+	if out.APIURL == "" {
+		switch out.Provider {
+		case onCallPagerDuty:
+			out.APIURL = pagerDutyAPIURL
+		case onCallOpsgenie:
+			out.APIURL = opsgenieAPIURL
+		}
+	}
+	return out
+}
+
+func validateOnCall(oc OnCallConfig) []string {
+	var problems []string
+	switch oc.Provider {
+	case "":
+		return nil
+	case onCallPagerDuty:
+		if os.Getenv("PAGERDUTY_TOKEN") == "" {
+			problems = append(problems, "PAGERDUTY_TOKEN must be set to look up on-call schedules in PagerDuty")
+		}
+	case onCallOpsgenie:
+		if os.Getenv("OPSGENIE_API_KEY") == "" {
+			problems = append(problems, "OPSGENIE_API_KEY must be set to look up on-call schedules in Opsgenie")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("oncall.provider (or ONCALL_PROVIDER): unknown provider %q, expected pagerduty or opsgenie", oc.Provider))
+	}
+	if u, err := url.Parse(oc.APIURL); err != nil || u.Scheme == "" || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("oncall.api_url: %q is not a URL", oc.APIURL))
+	}
+	if oc.MaxAge != "" {
+		if d, err := time.ParseDuration(oc.MaxAge); err != nil || d < 0 {
+			problems = append(problems, fmt.Sprintf("oncall.max_age: invalid duration %q", oc.MaxAge))
+		}
+	}
+	for _, email := range sortedKeys(oc.Users) {
+		if handle := oc.Users[email]; strings.TrimPrefix(handle, "@") == "" || strings.ContainsAny(handle, " \t/") {
+			problems = append(problems, fmt.Sprintf("oncall.users.%s: %q is not a GitHub handle", email, handle))
+		}
+	}
+	return problems
+}
+
+// onCallClient looks up the current on-call of schedules. Results are
+// cached for maxAge, as every error of a service asks for the same
+// schedule.
+type onCallClient struct {
+	*restClient
+	provider string
+	users    map[string]string
+	maxAge   time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedOnCall
+}
+
+type cachedOnCall struct {
+	handles []string
+	at      time.Time
+}
+
+func newOnCallClient(oc OnCallConfig) *onCallClient {
+	var auth func(*http.Request)
+	switch oc.Provider {
+	case onCallPagerDuty:
+		token := os.Getenv("PAGERDUTY_TOKEN")
+		auth = func(req *http.Request) { req.Header.Set("Authorization", "Token token="+token) }
+	default:
+		key := os.Getenv("OPSGENIE_API_KEY")
+		auth = func(req *http.Request) { req.Header.Set("Authorization", "GenieKey "+key) }
+	}
+	maxAge := defaultOnCallMaxAge
+	if d, err := time.ParseDuration(oc.MaxAge); err == nil {
+		maxAge = d
+	}
+
+	users := make(map[string]string, len(oc.Users))
+	for email, handle := range oc.Users {
+		users[strings.ToLower(email)] = "@" + strings.TrimPrefix(handle, "@")
+	}
+	return &onCallClient{
+		restClient: newRESTClient(oc.Provider, oc.APIURL, auth),
+		provider:   oc.Provider,
+		users:      users,
+		maxAge:     maxAge,
+		cache:      make(map[string]cachedOnCall),
+	}
+}
+
+// handles returns the GitHub handles of whoever is on call for a schedule.
+// On-call users without a GitHub handle in oncall.users are left out.
+func (c *onCallClient) handles(ctx context.Context, schedule string) ([]string, error) {
+	c.mu.Lock()
+	cached, ok := c.cache[schedule]
+	c.mu.Unlock()
+	if ok && time.Since(cached.at) < c.maxAge {
+		return cached.handles, nil
+	}
+
+	var emails []string
+	var err error
+	switch c.provider {
+	case onCallPagerDuty:
+		emails, err = c.pagerDutyOnCall(ctx, schedule)
+	default:
+		emails, err = c.opsgenieOnCall(ctx, schedule)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var handles []string
+	for _, email := range emails {
+		handle, ok := c.users[strings.ToLower(email)]
+		if !ok {
+			slog.WarnContext(ctx, "On-call user has no GitHub handle in oncall.users", "email", email, "schedule", schedule)
+			continue
+		}
+		handles = appendNew(handles, handle)
+	}
+
+	c.mu.Lock()
+	c.cache[schedule] = cachedOnCall{handles: handles, at: time.Now()}
+	c.mu.Unlock()
+	return handles, nil
+}
+
+// pagerDutyOnCall returns the emails of the users on call for a schedule.
+func (c *onCallClient) pagerDutyOnCall(ctx context.Context, schedule string) ([]string, error) {
+	params := url.Values{"schedule_ids[]": {schedule}, "include[]": {"users"}, "earliest": {"true"}}
+	var result struct {
+		OnCalls []struct {
+			User struct {
+				Email string `json:"email"`
+			} `json:"user"`
+		} `json:"oncalls"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/oncalls?"+params.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	var emails []string
+	for _, oc := range result.OnCalls {
+		if oc.User.Email != "" {
+			emails = append(emails, oc.User.Email)
+		}
+	}
+	return emails, nil
+}
+
+// opsgenieOnCall returns the emails of the users on call for a schedule,
+// named by ID or by name.
+func (c *onCallClient) opsgenieOnCall(ctx context.Context, schedule string) ([]string, error) {
+	idType := "name"
+	if uuidPattern.MatchString(schedule) && len(schedule) == 36 {
+		idType = "id"
+	}
+	params := url.Values{"scheduleIdentifierType": {idType}, "flat": {"true"}}
+	var result struct {
+		Data struct {
+			OnCallRecipients []string `json:"onCallRecipients"`
+		} `json:"data"`
+	}
+	path := "/v2/schedules/" + url.PathEscape(schedule) + "/on-calls?" + params.Encode()
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Data.OnCallRecipients, nil
+}
+
+// applyOnCall replaces the static owners of a service with whoever is on
+// call for it, and records them in the "oncall" metadata for rules. If the
+// lookup fails or finds no one with a GitHub handle, the static owners
+// stay.
+func applyOnCall(ctx context.Context, svc Service, analysis *LogAnalysis) {
+	if onCall == nil || svc.OnCall == "" {
+		return
+	}
+	handles, err := onCall.handles(ctx, svc.OnCall)
+	if err != nil {
+		slog.ErrorContext(ctx, "On-call lookup failed", "service", svc.Name, "error", err)
+		return
+	}
+	if len(handles) == 0 {
+		return
+	}
+	analysis.setMetadata("oncall", strings.Join(handles, ", "))
+	analysis.setMetadata("service_owners", strings.Join(handles, ", "))
+}
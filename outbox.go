@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	outboxPending = "pending"
+	outboxDone    = "done"
+)
+
+const (
+	// outboxTTL is how long a completed create keeps another create of the
+	// same fingerprint from filing a second issue. Later, the lifecycle
+	// and the agent's search take over.
+	outboxTTL = 24 * time.Hour
+	// outboxLease is how long a claim on a create holds. Until it expires,
+	// other runs wait for the create instead of filing the issue
+	// themselves. It outlasts a create and its retries, bounded by
+	// githubWriteTimeout.
+	outboxLease = 5 * time.Minute
+	// outboxPoll is how often a run waiting for another run's create
+	// checks on it.
+	outboxPoll = 2 * time.Second
+	// outboxLookback widens the search for an issue an interrupted create
+	// may have filed, for clock skew between the service and GitHub.
+	outboxLookback = time.Minute
+	// maxOutboxScan caps how many recent issues are read looking for it.
+	maxOutboxScan = 200
+	// outboxPendingPrefix prefixes the keys indexing the claimed entries,
+	// one per entry, for the reconciler.
+	outboxPendingPrefix = "outbox-pending:"
+	// outboxPrefix prefixes the keys of the entries.
+	outboxPrefix = "outbox:"
+)
+
+func init() {
+	metrics.describe("triage_outbox_deduplicated_total", "counter", "Issue creations answered with an issue an earlier attempt already created, by how it was found (recorded or reconciled).")
+}
+
+// outboxEntry records a tracker mutation before it is made, keyed by
+// repository, fingerprint and intent, and its result once it succeeds. A
+// create that times out may still have filed the issue: retries, a
+// resumed job or another run of the same error find the entry and the
+// issue instead of filing it again.
+//
+// A pending entry is a claim: it is written only if the entry is absent
+// or unchanged since it was read, so that of several runs or replicas
+// creating the same issue one makes the call. The claim holds until
+// LeaseUntil.
+type outboxEntry struct {
+	Repository  string `json:"repository"`
+	Fingerprint string `json:"fingerprint"`
+	Kind        string `json:"kind"`
+	State       string `json:"state"`
+	// RunID is the run that made the mutation, from the provenance footer,
+	// and Instance the instance it ran on.
+	RunID       string     `json:"run_id,omitempty"`
+	Instance    string     `json:"instance,omitempty"`
+	IssueURL    string     `json:"issue_url,omitempty"`
+	IssueNumber int        `json:"issue_number,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	LeaseUntil  time.Time  `json:"lease_until,omitempty"`
+	DoneAt      *time.Time `json:"done_at,omitempty"`
+}
+
+// leased reports whether the entry is a claim that hasn't expired.
+func (e outboxEntry) leased() bool {
+	return e.State == outboxPending && time.Now().Before(e.LeaseUntil)
+}
+
+func outboxKey(target repoTarget, fingerprint, kind string) string {
+	return fmt.Sprintf("%s%s:%s:%s", outboxPrefix, strings.ToLower(target.String()), fingerprint, kind)
+}
+
+// readOutbox returns an entry with its stored value, which swapOutbox
+// compares against.
+func readOutbox(ctx context.Context, key string) (outboxEntry, []byte, bool, error) {
+	data, ok, err := store.GetValue(ctx, key)
+	if err != nil || !ok {
+		return outboxEntry{}, nil, false, err
+	}
+	var e outboxEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return outboxEntry{}, nil, false, err
+	}
+	return e, data, true, nil
+}
+
+// swapOutbox replaces an entry if its stored value is still old (absent
+// for nil), keeping the pending index in step. It returns the new value,
+// or nil if another run changed the entry first.
+func swapOutbox(ctx context.Context, key string, old []byte, e outboxEntry) ([]byte, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	swapped, err := store.SetValueIf(ctx, key, old, data)
+	if err != nil || !swapped {
+		return nil, err
+	}
+	if e.State == outboxPending {
+		logStoreError("save outbox index", store.SetValue(ctx, outboxPendingPrefix+key, []byte(key)))
+	} else {
+		logStoreError("delete outbox index", store.DeleteValue(ctx, outboxPendingPrefix+key))
+	}
+	return data, nil
+}
+
+// forgetOutbox lets a fingerprint be filed again, e.g. after it was split
+// from its issue.
+func forgetOutbox(ctx context.Context, target repoTarget, fingerprint, kind string) {
+	key := outboxKey(target, fingerprint, kind)
+	e, data, ok, err := readOutbox(ctx, key)
+	if err != nil || !ok || e.State != outboxDone {
+		logStoreError("get outbox entry", err)
+		return
+	}
+	e.State, e.DoneAt = "", nil
+	_, err = swapOutbox(ctx, key, data, e)
+	logStoreError("save outbox entry", err)
+}
+
+// pruneOutbox deletes the entries that stopped mattering more than
+// outboxTTL before now: completed creates, and released or forgotten
+// claims. Claims are left to the reconciler. An entry is only deleted if
+// it is unchanged, so that a claim made meanwhile is kept. It returns how
+// many entries it deleted.
+func pruneOutbox(ctx context.Context, now time.Time) int {
+	values, err := store.ListValues(ctx, outboxPrefix)
+	if err != nil {
+		logStoreError("list outbox entries", err)
+		return 0
+	}
+	pruned := 0
+	for key, data := range values {
+		var e outboxEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			logStoreError("get outbox entry", err)
+			continue
+		}
+		since := e.StartedAt
+		if e.DoneAt != nil {
+			since = *e.DoneAt
+		}
+		if e.State == outboxPending || now.Sub(since) < outboxTTL {
+			continue
+		}
+		deleted, err := store.DeleteValueIf(ctx, key, data)
+		logStoreError("delete outbox entry", err)
+		if deleted {
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// createOnce creates an issue for the fingerprint in its provenance footer
+// at most once. It claims the create first: while another run's claim
+// holds, it waits for that create. If an interrupted create turns out to
+// have filed the issue, that issue is returned. If an earlier create
+// completed, its issue is returned with recorded set, as everything done
+// after creating it has been done too. Issues without a fingerprint are
+// created as they are.
+func createOnce(ctx context.Context, target repoTarget, issue plannedIssue) (created TrackerIssue, recorded bool, err error) {
+	p, _ := parseProvenance(issue.Body)
+	if p.Fingerprint == "" {
+		created, err = tracker.Create(ctx, target, issue)
+		return created, false, err
+	}
+
+	key := outboxKey(target, p.Fingerprint, intentCreate)
+	var claim []byte
+	var e outboxEntry
+	for claim == nil {
+		current, data, ok, err := readOutbox(ctx, key)
+		if err != nil {
+			return TrackerIssue{}, false, fmt.Errorf("reading outbox: %w", err)
+		}
+		switch {
+		case ok && current.State == outboxDone && current.DoneAt != nil && time.Since(*current.DoneAt) < outboxTTL:
+			slog.InfoContext(ctx, "Issue was already created for the fingerprint", "fingerprint", p.Fingerprint, "issue_url", current.IssueURL)
+			metrics.add("triage_outbox_deduplicated_total", `found="recorded"`, 1)
+			return TrackerIssue{Number: current.IssueNumber, URL: current.IssueURL, Title: issue.Title}, true, nil
+		case current.leased():
+			// Another run is creating the issue.
+			select {
+			case <-ctx.Done():
+				return TrackerIssue{}, false, ctx.Err()
+			case <-time.After(outboxPoll):
+			}
+			continue
+		case ok && current.State == outboxPending:
+			// The claim expired: the run that made it was interrupted.
+			if existing, found := findCreatedIssue(ctx, target, p.Fingerprint, current.StartedAt); found {
+				if finishOutbox(ctx, key, data, current, existing) {
+					slog.InfoContext(ctx, "Found the issue an interrupted create filed", "fingerprint", p.Fingerprint, "issue_url", existing.URL)
+					metrics.add("triage_outbox_deduplicated_total", `found="reconciled"`, 1)
+					return existing, false, nil
+				}
+				continue
+			}
+		}
+
+		now := time.Now().UTC()
+		e = outboxEntry{
+			Repository:  target.String(),
+			Fingerprint: p.Fingerprint,
+			Kind:        intentCreate,
+			State:       outboxPending,
+			RunID:       p.RunID,
+			Instance:    instanceID,
+			StartedAt:   now,
+			LeaseUntil:  now.Add(outboxLease),
+		}
+		if claim, err = swapOutbox(ctx, key, data, e); err != nil {
+			return TrackerIssue{}, false, fmt.Errorf("claiming issue creation: %w", err)
+		}
+	}
+
+	created, err = tracker.Create(ctx, target, issue)
+	if err != nil {
+		// A failed or timed out call may have created the issue anyway.
+		if existing, ok := findCreatedIssue(ctx, target, p.Fingerprint, e.StartedAt); ok {
+			slog.WarnContext(ctx, "Issue creation reported an error but the issue was created", "fingerprint", p.Fingerprint, "issue_url", existing.URL, "error", err)
+			metrics.add("triage_outbox_deduplicated_total", `found="reconciled"`, 1)
+			finishOutbox(ctx, key, claim, e, existing)
+			return existing, false, nil
+		}
+		// Past the deadline GitHub couldn't be checked: the claim is left
+		// to expire and be reconciled.
+		if ctx.Err() == nil {
+			releaseOutbox(ctx, key, claim, e)
+		}
+		return TrackerIssue{}, false, err
+	}
+	finishOutbox(ctx, key, claim, e, created)
+	return created, false, nil
+}
+
+// finishOutbox records the issue of a claimed create, unless the claim was
+// taken over, and reports whether it did.
+func finishOutbox(ctx context.Context, key string, claim []byte, e outboxEntry, issue TrackerIssue) bool {
+	now := time.Now().UTC()
+	e.State, e.IssueURL, e.IssueNumber, e.DoneAt = outboxDone, issue.URL, issue.Number, &now
+	data, err := swapOutbox(ctx, key, claim, e)
+	if err == nil && data == nil {
+		slog.WarnContext(ctx, "Outbox claim was taken over before the create finished", "fingerprint", e.Fingerprint, "issue_url", issue.URL)
+	}
+	logStoreError("save outbox entry", err)
+	return data != nil
+}
+
+// releaseOutbox gives up a claimed create that didn't file the issue, so
+// that the next create of the fingerprint needn't wait for the lease.
+func releaseOutbox(ctx context.Context, key string, claim []byte, e outboxEntry) bool {
+	e.State = ""
+	data, err := swapOutbox(ctx, key, claim, e)
+	logStoreError("save outbox entry", err)
+	return data != nil
+}
+
+// findCreatedIssue looks for an issue with the fingerprint in its
+// provenance footer created since the given time. It only looks on
+// GitHub, where listing issues is consistent right away, unlike search.
+func findCreatedIssue(ctx context.Context, target repoTarget, fingerprint string, since time.Time) (TrackerIssue, bool) {
+	if tracker.Name() != trackerGitHub {
+		return TrackerIssue{}, false
+	}
+	issue, err := findGitHubIssueByFingerprint(ctx, target, fingerprint, since.Add(-outboxLookback))
+	if err != nil {
+		slog.WarnContext(ctx, "Looking for an already created issue failed", "fingerprint", fingerprint, "error", err)
+		return TrackerIssue{}, false
+	}
+	if issue == nil {
+		return TrackerIssue{}, false
+	}
+	return githubTrackerIssue(target, issue), true
+}
+
+// findGitHubIssueByFingerprint reads the issues created since the given
+// time, newest first, for one the agent filed for the fingerprint.
+func findGitHubIssueByFingerprint(ctx context.Context, target repoTarget, fingerprint string, since time.Time) (*github.Issue, error) {
+	opts := &github.IssueListByRepoOptions{
+		State:       "all",
+		Sort:        "created",
+		Direction:   "desc",
+		Since:       since,
+		ListOptions: github.ListOptions{PerPage: 50},
+	}
+	for scanned := 0; scanned < maxOutboxScan; {
+		issues, resp, err := ghClient.Issues.ListByRepo(ctx, target.Owner, target.Repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, issue := range issues {
+			scanned++
+			if issue.GetCreatedAt().Before(since) {
+				return nil, nil
+			}
+			if p, ok := parseProvenance(issue.GetBody()); ok && !issue.IsPullRequest() && p.Fingerprint == fingerprint {
+				return issue, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return nil, nil
+}
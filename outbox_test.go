@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+var testTarget = repoTarget{Owner: "acme", Repo: "api"}
+
+// stubTracker counts creates, failing them with err. Only Name and Create
+// are implemented.
+type stubTracker struct {
+	IssueTracker
+	mu      sync.Mutex
+	creates int
+	err     error
+}
+
+func (s *stubTracker) Name() string { return trackerGitHub }
+
+func (s *stubTracker) Create(ctx context.Context, target repoTarget, issue plannedIssue) (TrackerIssue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creates++
+	if s.err != nil {
+		return TrackerIssue{}, s.err
+	}
+	return TrackerIssue{Number: 100, URL: "https://github.com/acme/api/issues/100", Title: issue.Title}, nil
+}
+
+// fakeGitHubIssue is an issue served by the fake GitHub API.
+type fakeGitHubIssue struct {
+	number      int
+	fingerprint string
+	created     time.Time
+	pullRequest bool
+}
+
+// useFakeGitHub points ghClient at a server listing the issues, newest
+// first, two per page. It returns the number of list requests made.
+func useFakeGitHub(t *testing.T, issues []fakeGitHubIssue) *int {
+	t.Helper()
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		page = max(page, 1)
+		start, end := min((page-1)*2, len(issues)), min(page*2, len(issues))
+		if end < len(issues) {
+			next := *r.URL
+			q := next.Query()
+			q.Set("page", strconv.Itoa(page+1))
+			next.RawQuery = q.Encode()
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s>; rel="next"`, r.Host, next.RequestURI()))
+		}
+		var out []*github.Issue
+		for _, fi := range issues[start:end] {
+			issue := &github.Issue{
+				Number:    github.Int(fi.number),
+				HTMLURL:   github.String(fmt.Sprintf("https://github.com/acme/api/issues/%d", fi.number)),
+				Body:      github.String("Body" + issueFooter(Provenance{Fingerprint: fi.fingerprint})),
+				CreatedAt: &fi.created,
+			}
+			if fi.pullRequest {
+				issue.PullRequestLinks = &github.PullRequestLinks{URL: github.String("https://api.github.com/pulls/1")}
+			}
+			out = append(out, issue)
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	t.Cleanup(srv.Close)
+
+	prev := ghClient
+	t.Cleanup(func() { ghClient = prev })
+	ghClient = github.NewClient(nil)
+	ghClient.BaseURL, _ = url.Parse(srv.URL + "/")
+	return &requests
+}
+
+// useTestState swaps the store and tracker for a memory store and a stub.
+func useTestState(t *testing.T, tr *stubTracker) {
+	t.Helper()
+	prevStore, prevTracker := store, tracker
+	t.Cleanup(func() { store, tracker = prevStore, prevTracker })
+	store, tracker = newMemoryStore(), tr
+}
+
+func putOutbox(t *testing.T, fingerprint string, e outboxEntry) {
+	t.Helper()
+	data, _ := json.Marshal(e)
+	if err := store.SetValue(context.Background(), outboxKey(testTarget, fingerprint, intentCreate), data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getOutbox(t *testing.T, fingerprint string) (outboxEntry, bool) {
+	t.Helper()
+	e, _, ok, err := readOutbox(context.Background(), outboxKey(testTarget, fingerprint, intentCreate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, ok
+}
+
+func TestCreateOnce(t *testing.T) {
+	const fp = "abc123"
+	now := time.Now().UTC()
+	ago := func(d time.Duration) *time.Time { at := now.Add(-d); return &at }
+	errCreate := errors.New("create failed")
+
+	tests := []struct {
+		name   string
+		entry  *outboxEntry
+		github []fakeGitHubIssue
+		// createErr fails the tracker's create.
+		createErr error
+
+		wantCreates  int
+		wantNumber   int
+		wantRecorded bool
+		wantErr      bool
+		// wantState is the entry's state afterwards, and wantPending
+		// whether it is in the pending index.
+		wantState   string
+		wantPending bool
+	}{
+		{
+			name:        "claims and creates",
+			wantCreates: 1, wantNumber: 100, wantState: outboxDone,
+		},
+		{
+			name:        "returns the recorded issue",
+			entry:       &outboxEntry{State: outboxDone, IssueNumber: 7, IssueURL: "https://github.com/acme/api/issues/7", DoneAt: ago(time.Hour)},
+			wantCreates: 0, wantNumber: 7, wantRecorded: true, wantState: outboxDone,
+		},
+		{
+			name:        "creates again after the TTL",
+			entry:       &outboxEntry{State: outboxDone, IssueNumber: 7, DoneAt: ago(outboxTTL + time.Hour)},
+			wantCreates: 1, wantNumber: 100, wantState: outboxDone,
+		},
+		{
+			name:        "creates again once forgotten",
+			entry:       &outboxEntry{IssueNumber: 7, StartedAt: now.Add(-time.Hour)},
+			wantCreates: 1, wantNumber: 100, wantState: outboxDone,
+		},
+		{
+			name:        "reconciles an expired claim that filed the issue",
+			entry:       &outboxEntry{State: outboxPending, StartedAt: now.Add(-10 * time.Minute), LeaseUntil: now.Add(-5 * time.Minute)},
+			github:      []fakeGitHubIssue{{number: 9, fingerprint: "other", created: now.Add(-time.Minute)}, {number: 8, fingerprint: fp, created: now.Add(-8 * time.Minute)}},
+			wantCreates: 0, wantNumber: 8, wantState: outboxDone,
+		},
+		{
+			name:        "takes over an expired claim that filed nothing",
+			entry:       &outboxEntry{State: outboxPending, StartedAt: now.Add(-10 * time.Minute), LeaseUntil: now.Add(-5 * time.Minute)},
+			github:      []fakeGitHubIssue{{number: 9, fingerprint: "other", created: now.Add(-time.Minute)}},
+			wantCreates: 1, wantNumber: 100, wantState: outboxDone,
+		},
+		{
+			name:        "releases the claim when the create fails",
+			createErr:   errCreate,
+			wantCreates: 1, wantErr: true, wantState: "",
+		},
+		{
+			name:        "reconciles a failed create that filed the issue",
+			createErr:   errCreate,
+			github:      []fakeGitHubIssue{{number: 12, fingerprint: fp, created: now.Add(time.Second)}},
+			wantCreates: 1, wantNumber: 12, wantState: outboxDone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &stubTracker{err: tt.createErr}
+			useTestState(t, tr)
+			useFakeGitHub(t, tt.github)
+			if tt.entry != nil {
+				tt.entry.Repository, tt.entry.Fingerprint, tt.entry.Kind = testTarget.String(), fp, intentCreate
+				putOutbox(t, fp, *tt.entry)
+			}
+
+			issue := plannedIssue{Title: "panic: boom", Body: "Body" + issueFooter(Provenance{RunID: "run1", Fingerprint: fp})}
+			created, recorded, err := createOnce(context.Background(), testTarget, issue)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tr.creates != tt.wantCreates {
+				t.Errorf("creates = %d, want %d", tr.creates, tt.wantCreates)
+			}
+			if created.Number != tt.wantNumber || recorded != tt.wantRecorded {
+				t.Errorf("created #%d (recorded %v), want #%d (recorded %v)", created.Number, recorded, tt.wantNumber, tt.wantRecorded)
+			}
+			e, ok := getOutbox(t, fp)
+			if !ok || e.State != tt.wantState {
+				t.Errorf("entry state = %q (present %v), want %q", e.State, ok, tt.wantState)
+			}
+			if tt.wantState == outboxDone && e.IssueNumber != tt.wantNumber {
+				t.Errorf("entry issue = #%d, want #%d", e.IssueNumber, tt.wantNumber)
+			}
+			_, pending, _ := store.GetValue(context.Background(), outboxPendingPrefix+outboxKey(testTarget, fp, intentCreate))
+			if pending != tt.wantPending {
+				t.Errorf("pending index = %v, want %v", pending, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestCreateOnceWaitsForALeasedClaim(t *testing.T) {
+	const fp = "abc123"
+	now := time.Now().UTC()
+	claim := outboxEntry{Repository: testTarget.String(), Fingerprint: fp, Kind: intentCreate, State: outboxPending, StartedAt: now, LeaseUntil: now.Add(outboxLease)}
+	issue := plannedIssue{Title: "panic: boom", Body: "Body" + issueFooter(Provenance{Fingerprint: fp})}
+
+	t.Run("gives up when cancelled", func(t *testing.T) {
+		tr := &stubTracker{}
+		useTestState(t, tr)
+		putOutbox(t, fp, claim)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if _, _, err := createOnce(ctx, testTarget, issue); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+		}
+		if tr.creates != 0 {
+			t.Errorf("creates = %d, want 0", tr.creates)
+		}
+	})
+
+	t.Run("returns the issue the claim created", func(t *testing.T) {
+		tr := &stubTracker{}
+		useTestState(t, tr)
+		putOutbox(t, fp, claim)
+
+		key := outboxKey(testTarget, fp, intentCreate)
+		_, data, _, _ := readOutbox(context.Background(), key)
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			finishOutbox(context.Background(), key, data, claim, TrackerIssue{Number: 5, URL: "https://github.com/acme/api/issues/5"})
+		}()
+		created, recorded, err := createOnce(context.Background(), testTarget, issue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created.Number != 5 || !recorded || tr.creates != 0 {
+			t.Errorf("created #%d (recorded %v) with %d creates, want #5 (recorded true) with none", created.Number, recorded, tr.creates)
+		}
+	})
+}
+
+func TestSwapOutbox(t *testing.T) {
+	ctx := context.Background()
+	key := outboxKey(testTarget, "abc123", intentCreate)
+	pending := outboxEntry{State: outboxPending, StartedAt: time.Now().UTC()}
+	done := pending
+	done.State = outboxDone
+
+	tests := []struct {
+		name string
+		// stored is the entry in the store beforehand, and stale swaps
+		// against another value than the stored one.
+		stored      *outboxEntry
+		stale       bool
+		entry       outboxEntry
+		wantSwapped bool
+		wantPending bool
+	}{
+		{name: "claims an absent entry", entry: pending, wantSwapped: true, wantPending: true},
+		{name: "finishes a claim", stored: &pending, entry: done, wantSwapped: true, wantPending: false},
+		{name: "loses to a changed entry", stored: &pending, stale: true, entry: done, wantSwapped: false, wantPending: true},
+		{name: "loses to an entry made meanwhile", stored: &done, stale: true, entry: pending, wantSwapped: false, wantPending: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestState(t, &stubTracker{})
+			var old []byte
+			if tt.stored != nil {
+				data, err := swapOutbox(ctx, key, nil, *tt.stored)
+				if err != nil || data == nil {
+					t.Fatalf("storing the entry: %v", err)
+				}
+				old = data
+				if tt.stale {
+					// The caller read the entry before a change, or
+					// before it was made at all.
+					old = nil
+					if tt.stored.State == outboxPending {
+						old = []byte(`{"state":"pending"}`)
+					}
+				}
+			}
+			data, err := swapOutbox(ctx, key, old, tt.entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if swapped := data != nil; swapped != tt.wantSwapped {
+				t.Errorf("swapped = %v, want %v", swapped, tt.wantSwapped)
+			}
+			_, inIndex, _ := store.GetValue(ctx, outboxPendingPrefix+key)
+			if inIndex != tt.wantPending {
+				t.Errorf("pending index = %v, want %v", inIndex, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestFindGitHubIssueByFingerprint(t *testing.T) {
+	const fp = "abc123"
+	now := time.Now().UTC()
+	since := now.Add(-time.Hour)
+
+	tests := []struct {
+		name         string
+		issues       []fakeGitHubIssue
+		want         int
+		wantRequests int
+	}{
+		{
+			name:         "first page",
+			issues:       []fakeGitHubIssue{{number: 3, fingerprint: "other", created: now}, {number: 2, fingerprint: fp, created: now}},
+			want:         2,
+			wantRequests: 1,
+		},
+		{
+			name: "later page",
+			issues: []fakeGitHubIssue{
+				{number: 5, fingerprint: "other", created: now}, {number: 4, fingerprint: "other", created: now},
+				{number: 3, fingerprint: fp, created: now}, {number: 2, fingerprint: "other", created: now},
+			},
+			want:         3,
+			wantRequests: 2,
+		},
+		{
+			name:         "skips pull requests",
+			issues:       []fakeGitHubIssue{{number: 3, fingerprint: fp, created: now, pullRequest: true}, {number: 2, fingerprint: fp, created: now}},
+			want:         2,
+			wantRequests: 1,
+		},
+		{
+			name: "stops at issues created before",
+			issues: []fakeGitHubIssue{
+				{number: 5, fingerprint: "other", created: now}, {number: 4, fingerprint: "other", created: since.Add(-time.Minute)},
+				{number: 3, fingerprint: fp, created: since.Add(-time.Hour)},
+			},
+			want:         0,
+			wantRequests: 1,
+		},
+		{
+			name:         "none",
+			issues:       []fakeGitHubIssue{{number: 2, fingerprint: "other", created: now}},
+			want:         0,
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := useFakeGitHub(t, tt.issues)
+			issue, err := findGitHubIssueByFingerprint(context.Background(), testTarget, fp, since)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := issue.GetNumber(); got != tt.want {
+				t.Errorf("found #%d, want #%d", got, tt.want)
+			}
+			if *requests != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", *requests, tt.wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	// ownerHistoryWindow is how far back commits count towards ownership.
+	ownerHistoryWindow = 180 * 24 * time.Hour
+	// maxOwnerFiles caps how many files one suggest_owners call looks up.
+	maxOwnerFiles = 5
+	// maxOwnerSuggestions is how many contributors are suggested.
+	maxOwnerSuggestions = 3
+)
+
+// contributor is a commit author and how many recent commits they made to
+// the files being looked up.
+type contributor struct {
+	Login   string
+	Commits int
+}
+
+// repoPathCandidates turns a file path from a stack trace into paths that
+// may exist in the repository. Traces usually carry build or container
+// paths ("/app/internal/db/conn.go", "/home/runner/work/x/x/pkg/a.go"), so
+// leading directories are dropped one at a time, longest candidate first.
+func repoPathCandidates(file string) []string {
+	file = strings.TrimPrefix(path.Clean(strings.ReplaceAll(file, "\\", "/")), "/")
+	parts := strings.Split(file, "/")
+	var candidates []string
+	for i := 0; i < len(parts) && len(candidates) < 4; i++ {
+		candidates = append(candidates, strings.Join(parts[i:], "/"))
+	}
+	return candidates
+}
+
+// recentContributors counts commit authors of the given files over the
+// ownership window, most active first. Files that can't be matched to a
+// repository path are skipped.
+func recentContributors(ctx context.Context, target repoTarget, files []string) ([]contributor, []string, error) {
+	counts := make(map[string]int)
+	var matched []string
+
+	for _, file := range files {
+		for _, candidate := range repoPathCandidates(file) {
+			commits, _, err := ghClient.Repositories.ListCommits(ctx, target.Owner, target.Repo, &github.CommitsListOptions{
+				Path:        candidate,
+				Since:       time.Now().Add(-ownerHistoryWindow),
+				ListOptions: github.ListOptions{PerPage: 50},
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(commits) == 0 {
+				continue
+			}
+
+			matched = append(matched, candidate)
+			for _, c := range commits {
+				login := c.GetAuthor().GetLogin()
+				if login == "" || strings.HasSuffix(login, "[bot]") {
+					continue
+				}
+				counts[login]++
+			}
+			break
+		}
+	}
+
+	var out []contributor
+	for login, n := range counts {
+		out = append(out, contributor{Login: login, Commits: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Commits != out[j].Commits {
+			return out[i].Commits > out[j].Commits
+		}
+		return out[i].Login < out[j].Login
+	})
+	return out, matched, nil
+}
+
+// suggestOwners lists the CODEOWNERS owners of the files, if the
+// repository has a CODEOWNERS file, and their most active recent
+// contributors.
+func (t *toolSession) suggestOwners(args map[string]any) (string, error) {
+	files := stringListArg(args, "files")
+	if len(files) == 0 {
+		return "", fmt.Errorf("missing or invalid 'files' argument for suggest_owners")
+	}
+	if len(files) > maxOwnerFiles {
+		files = files[:maxOwnerFiles]
+	}
+
+	t.log.Info("Tool call", "tool", "suggest_owners", "files", files)
+
+	var sections []string
+	// Without CODEOWNERS, the contributors are still worth suggesting.
+	if owned, err := resolveCodeOwners(t.ctx, t.target, files); err != nil {
+		t.log.Warn("Reading CODEOWNERS failed", "error", err)
+	} else if len(owned) > 0 {
+		sections = append(sections, "Owners in CODEOWNERS:\n"+formatCodeOwners(owned))
+	}
+
+	contributors, matched, err := recentContributors(t.ctx, t.target, files)
+	if err != nil {
+		t.log.Error("Listing commits failed", "error", err)
+		return fmt.Sprintf("Error listing commits: %v", err), err
+	}
+	if len(contributors) > maxOwnerSuggestions {
+		contributors = contributors[:maxOwnerSuggestions]
+	}
+	if len(contributors) > 0 {
+		var lines []string
+		for _, c := range contributors {
+			lines = append(lines, fmt.Sprintf("- @%s (%d commits)", c.Login, c.Commits))
+		}
+		sections = append(sections, fmt.Sprintf("Most active contributors in the last %d days to %s:\n%s",
+			int(ownerHistoryWindow.Hours()/24), strings.Join(matched, ", "), strings.Join(lines, "\n")))
+	}
+	if len(sections) == 0 {
+		return "No owners or recent contributors found for these files.", nil
+	}
+	return strings.Join(sections, "\n\n"), nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const dotnetInnerStackEnd = "--- End of inner exception stack trace ---"
+
+var (
+	dotnetFramePattern    = regexp.MustCompile(`^\s+at (.+?)(?: in (.+):line (\d+))?\s*$`)
+	dotnetStateMachine    = regexp.MustCompile(`<([^>]+)>d__\d+\.MoveNext$`)
+	dotnetLambda          = regexp.MustCompile(`<>c(?:__DisplayClass[\d_]+)?\.<([^>]+)>b__[\d_]+$`)
+	dotnetExceptionHeader = regexp.MustCompile(`^([\w.+` + "`" + `]+?)(?: \(0x[0-9A-Fa-f]+\)| \(-?\d+\))?(?::\s*(.*))?$`)
+	dotnetDetectPattern   = regexp.MustCompile(`(?m)^\s+at [\w.<>` + "`" + `\[\]+,]+\(.*\)(?: in .+:line \d+)?\s*$`)
+	javaFramePattern      = regexp.MustCompile(`\((?:\w+\.(?:java|kt|scala):\d+|Unknown Source|Native Method)\)`)
+)
+
+// dotnetParser handles .NET exception strings as produced by
+// Exception.ToString(). Inner exceptions are inlined by .NET with " ---> ";
+// the innermost one is the root cause and is what gets fingerprinted. Async
+// state machine and lambda frames are folded back into the method that
+// declared them so that the same failure through different await points
+// still matches.
+type dotnetParser struct{}
+
+func (dotnetParser) Format() string { return "dotnet" }
+
+func (dotnetParser) Detect(errorLog string) bool {
+	return dotnetDetectPattern.MatchString(errorLog) && !javaFramePattern.MatchString(errorLog)
+}
+
+func (dotnetParser) Parse(errorLog string) *LogAnalysis {
+	var header []string
+	var frames []StackFrame
+	inRootStack := true
+
+	for _, line := range strings.Split(errorLog, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			continue
+		case trimmed == dotnetInnerStackEnd:
+			inRootStack = false
+			continue
+		case strings.HasPrefix(trimmed, "--- End of stack trace"):
+			continue
+		}
+
+		m := dotnetFramePattern.FindStringSubmatch(line)
+		if m == nil {
+			if len(frames) == 0 && inRootStack {
+				header = append(header, trimmed)
+			}
+			continue
+		}
+		if !inRootStack {
+			continue
+		}
+
+		frame := StackFrame{
+			Function: normalizeDotnetMethod(m[1]),
+			File:     m[2],
+		}
+		frame.Line, _ = strconv.Atoi(m[3])
+		if isDotnetPlumbingFrame(frame.Function) {
+			continue
+		}
+		frame.InApp = !isDotnetFrameworkFrame(frame.Function)
+		frames = append(frames, frame)
+	}
+
+	// Header is "Outer: msg ---> Inner: msg ---> Root: msg", possibly split
+	// across lines.
+	chain := strings.Split(strings.Join(header, " "), "--->")
+	analysis := &LogAnalysis{Frames: frames}
+	for i := len(chain) - 1; i >= 0; i-- {
+		errorType, message := parseDotnetException(strings.TrimSpace(chain[i]))
+		if i == len(chain)-1 {
+			analysis.ErrorType = errorType
+			analysis.Message = message
+			continue
+		}
+		analysis.Wrappers = append(analysis.Wrappers, errorType)
+	}
+	return analysis
+}
+
+func parseDotnetException(s string) (string, string) {
+	m := dotnetExceptionHeader.FindStringSubmatch(s)
+	if m == nil {
+		return "", s
+	}
+	return m[1], strings.TrimSpace(m[2])
+}
+
+// normalizeDotnetMethod strips parameters and generic arguments and folds
+// compiler-generated async and lambda methods into their declaring method:
+//
+//	Acme.OrderService.<GetAsync>d__5.MoveNext() -> Acme.OrderService.GetAsync
+//	Acme.OrderService.<>c.<Load>b__3_0(Order o) -> Acme.OrderService.Load
+//	System.Linq.Enumerable.First[TSource](IEnumerable`1 source) -> System.Linq.Enumerable.First
+func normalizeDotnetMethod(method string) string {
+	if idx := strings.Index(method, "("); idx != -1 {
+		method = method[:idx]
+	}
+	if idx := strings.Index(method, "["); idx != -1 {
+		method = method[:idx]
+	}
+	method = dotnetStateMachine.ReplaceAllString(method, "$1")
+	method = dotnetLambda.ReplaceAllString(method, "$1")
+	return method
+}
+
+// isDotnetPlumbingFrame reports frames that only exist because of await or
+// exception rethrow machinery.
+func isDotnetPlumbingFrame(method string) bool {
+	for _, prefix := range []string{
+		"System.Runtime.CompilerServices.",
+		"System.Runtime.ExceptionServices.",
+		"System.Threading.Tasks.",
+		"System.Threading.ExecutionContext.",
+	} {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDotnetFrameworkFrame(method string) bool {
+	return strings.HasPrefix(method, "System.") || strings.HasPrefix(method, "Microsoft.")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDotnetParser(t *testing.T) {
+	tests := []struct {
+		fixture       string
+		wantErrorType string
+		wantMessage   string
+		wantWrappers  []string
+		wantFrames    []StackFrame
+	}{
+		{
+			// The inner exception on its own line, with await and lambda
+			// frames; the outer exception's frames are left out.
+			fixture:       "dotnet/inner.log",
+			wantErrorType: "System.Collections.Generic.KeyNotFoundException",
+			wantMessage:   "The given key '42' was not present in the dictionary.",
+			wantWrappers:  []string{"System.InvalidOperationException"},
+			wantFrames: []StackFrame{
+				{Function: "System.Collections.Generic.Dictionary`2.get_Item"},
+				{Function: "Acme.Orders.OrderCache.Get", File: "/src/Acme.Orders/OrderCache.cs", Line: 27, InApp: true},
+				{Function: "Acme.Orders.OrderService.LoadAsync", File: "/src/Acme.Orders/OrderService.cs", Line: 41, InApp: true},
+				{Function: "Acme.Orders.OrderService.Preload", File: "/src/Acme.Orders/OrderService.cs", Line: 58, InApp: true},
+			},
+		},
+		{
+			// Two levels of inner exceptions inlined on the first line.
+			fixture:       "dotnet/inner_lambda.log",
+			wantErrorType: "System.Collections.Generic.KeyNotFoundException",
+			wantMessage:   "The given key '7' was not present in the dictionary.",
+			wantWrappers:  []string{"System.InvalidOperationException", "System.AggregateException"},
+			wantFrames: []StackFrame{
+				{Function: "System.Collections.Generic.Dictionary`2.get_Item"},
+				{Function: "Acme.Orders.OrderCache.Get", File: `C:\build\Acme.Orders\OrderCache.cs`, Line: 29, InApp: true},
+				{Function: "Acme.Orders.OrderService.LoadAsync", File: `C:\build\Acme.Orders\OrderService.cs`, Line: 43, InApp: true},
+				{Function: "Acme.Orders.OrderService.Preload", File: `C:\build\Acme.Orders\OrderService.cs`, Line: 60, InApp: true},
+			},
+		},
+		{
+			// An HRESULT after the type, and a release build without files.
+			fixture:       "dotnet/hresult.log",
+			wantErrorType: "System.IO.IOException",
+			wantMessage:   `The process cannot access the file 'C:\data\export.csv' because it is being used by another process.`,
+			wantFrames: []StackFrame{
+				{Function: "System.IO.FileSystem.CopyFile"},
+				{Function: "Acme.Export.CsvWriter.Publish", InApp: true},
+				{Function: "Acme.Export.Program.Main", InApp: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			log := readFixture(t, tt.fixture)
+			if !(dotnetParser{}).Detect(log) {
+				t.Fatal("not detected")
+			}
+			analysis := dotnetParser{}.Parse(log)
+			if analysis.ErrorType != tt.wantErrorType || analysis.Message != tt.wantMessage {
+				t.Errorf("got %q: %q, want %q: %q", analysis.ErrorType, analysis.Message, tt.wantErrorType, tt.wantMessage)
+			}
+			if !slices.Equal(analysis.Wrappers, tt.wantWrappers) {
+				t.Errorf("wrappers = %q, want %q", analysis.Wrappers, tt.wantWrappers)
+			}
+			assertFrames(t, analysis.Frames, tt.wantFrames)
+		})
+	}
+}
+
+func TestNormalizeDotnetMethod(t *testing.T) {
+	tests := []struct{ method, want string }{
+		{"Acme.OrderService.<GetAsync>d__5.MoveNext()", "Acme.OrderService.GetAsync"},
+		{"Acme.OrderService.<>c.<Load>b__3_0(Order o)", "Acme.OrderService.Load"},
+		{"Acme.OrderService.<>c__DisplayClass4_0.<Load>b__0(Order o)", "Acme.OrderService.Load"},
+		{"System.Linq.Enumerable.First[TSource](IEnumerable`1 source)", "System.Linq.Enumerable.First"},
+		{"Acme.OrderService.Get(Int32 id)", "Acme.OrderService.Get"},
+	}
+	for _, tt := range tests {
+		if got := normalizeDotnetMethod(tt.method); got != tt.want {
+			t.Errorf("normalizeDotnetMethod(%q) = %q, want %q", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestDotnetFingerprint(t *testing.T) {
+	// The same failure through other await points and lambdas, wrapped
+	// differently and built on Windows.
+	assertSameFingerprint(t, "dotnet", "dotnet/inner.log", "dotnet/inner_lambda.log")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	goPanicLinePattern     = regexp.MustCompile(`(?m)^(panic|fatal error): (.+)$`)
+	goGoroutineHeader      = regexp.MustCompile(`^goroutine \d+ [^\[]*\[([^\]]+)\]:`)
+	goFrameLocationPattern = regexp.MustCompile(`^\s+(\S+\.go):(\d+)`)
+)
+
+// goPanicParser handles Go panics, fatal errors and full goroutine dumps
+// (GOTRACEBACK=all or SIGQUIT). Only the panicking goroutine is kept, and
+// runtime frames are dropped before fingerprinting.
+type goPanicParser struct{}
+
+func (goPanicParser) Format() string { return "go" }
+
+func (goPanicParser) Detect(errorLog string) bool {
+	return goPanicLinePattern.MatchString(errorLog) ||
+		strings.Contains(errorLog, "goroutine 1 [")
+}
+
+func (goPanicParser) Parse(errorLog string) *LogAnalysis {
+	analysis := &LogAnalysis{}
+
+	if m := goPanicLinePattern.FindStringSubmatch(errorLog); m != nil {
+		analysis.Message = strings.TrimSpace(strings.TrimSuffix(m[2], " [recovered]"))
+		analysis.ErrorType = goErrorType(m[1], analysis.Message)
+	} else {
+		analysis.ErrorType = "goroutine dump"
+	}
+
+	analysis.Frames = parseGoFrames(panickingGoroutine(errorLog))
+	return analysis
+}
+
+// goErrorType keeps the stable part of a panic message: runtime errors are
+// cut before any bracketed values ("index out of range [5] with length 3"),
+// custom panic values are not part of the type at all.
+func goErrorType(kind, msg string) string {
+	if kind == "fatal error" {
+		return kind + ": " + msg
+	}
+	if rest, ok := strings.CutPrefix(msg, "runtime error: "); ok {
+		if idx := strings.Index(rest, " ["); idx != -1 {
+			rest = rest[:idx]
+		}
+		return "runtime error: " + rest
+	}
+	return "panic"
+}
+
+// panickingGoroutine returns the lines of the goroutine that panicked. In a
+// dump this is the first goroutine in the "running" state, falling back to
+// the first goroutine listed.
+func panickingGoroutine(errorLog string) []string {
+	var blocks [][]string
+	var current []string
+	running := -1
+
+	for _, line := range strings.Split(errorLog, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if m := goGoroutineHeader.FindStringSubmatch(line); m != nil {
+			if current != nil {
+				blocks = append(blocks, current)
+			}
+			current = []string{}
+			if running == -1 && strings.HasPrefix(m[1], "running") {
+				running = len(blocks)
+			}
+			continue
+		}
+		if current != nil {
+			if strings.TrimSpace(line) == "" {
+				blocks = append(blocks, current)
+				current = nil
+				continue
+			}
+			current = append(current, line)
+		}
+	}
+	if current != nil {
+		blocks = append(blocks, current)
+	}
+
+	if len(blocks) == 0 {
+		return nil
+	}
+	if running != -1 {
+		return blocks[running]
+	}
+	return blocks[0]
+}
+
+// parseGoFrames reads the frames of a goroutine. Every frame is a function
+// line followed by its location; other lines, such as "exit status 2" from
+// go run or "...additional frames elided...", are skipped.
+func parseGoFrames(lines []string) []StackFrame {
+	var frames []StackFrame
+	for i := 0; i+1 < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "\t") || strings.HasPrefix(line, " ") {
+			continue
+		}
+		m := goFrameLocationPattern.FindStringSubmatch(lines[i+1])
+		if m == nil {
+			continue
+		}
+		i++
+
+		function := strings.TrimPrefix(line, "created by ")
+		if idx := strings.Index(function, " in goroutine "); idx != -1 {
+			function = function[:idx]
+		}
+		frame := StackFrame{Function: stripGoCallArgs(function), File: m[1]}
+		frame.Line, _ = strconv.Atoi(m[2])
+
+		if isGoRuntimeFrame(frame.Function) {
+			continue
+		}
+		frame.InApp = !isGoStdlibFrame(frame.Function)
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// stripGoCallArgs turns "main.(*Server).handle(0xc000010000, {0x0, 0x0})"
+// into "main.(*Server).handle".
+func stripGoCallArgs(function string) string {
+	function = strings.TrimSpace(function)
+	if !strings.HasSuffix(function, ")") {
+		return function
+	}
+
+	depth := 0
+	for i := len(function) - 1; i >= 0; i-- {
+		switch function[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+			if depth == 0 {
+				return function[:i]
+			}
+		}
+	}
+	return function
+}
+
+func isGoRuntimeFrame(function string) bool {
+	return function == "panic" ||
+		strings.HasPrefix(function, "runtime.") ||
+		strings.HasPrefix(function, "runtime/debug.")
+}
+
+// isGoStdlibFrame reports whether the function belongs to the standard
+// library, whose import paths have no dot in their first element. Package
+// main is always application code.
+func isGoStdlibFrame(function string) bool {
+	first, _, found := strings.Cut(function, "/")
+	if !found {
+		first, _, _ = strings.Cut(function, ".")
+	}
+	return first != "main" && !strings.Contains(first, ".")
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGoPanicParser(t *testing.T) {
+	total := StackFrame{Function: "github.com/acme/api/orders.(*Service).Total", File: "/build/src/orders/service.go", Line: 42, InApp: true}
+	tests := []struct {
+		fixture       string
+		wantErrorType string
+		wantMessage   string
+		wantFrames    []StackFrame
+	}{
+		{
+			fixture:       "go/panic.log",
+			wantErrorType: "runtime error: index out of range",
+			wantMessage:   "runtime error: index out of range [5] with length 3",
+			wantFrames: []StackFrame{
+				total,
+				{Function: "github.com/acme/api/http.(*Handler).ServeOrders", File: "/build/src/http/handler.go", Line: 88, InApp: true},
+				{Function: "net/http.HandlerFunc.ServeHTTP", File: "/usr/local/go/src/net/http/server.go", Line: 2136},
+				{Function: "net/http.(*conn).serve", File: "/usr/local/go/src/net/http/server.go", Line: 2039},
+				{Function: "net/http.(*Server).Serve", File: "/usr/local/go/src/net/http/server.go", Line: 3285},
+			},
+		},
+		{
+			// The panicking goroutine is listed after blocked ones.
+			fixture:       "go/dump.log",
+			wantErrorType: "runtime error: index out of range",
+			wantMessage:   "runtime error: index out of range [9] with length 2",
+			wantFrames: []StackFrame{
+				{Function: "github.com/acme/api/orders.(*Service).Total", File: "/app/orders/service.go", Line: 44, InApp: true},
+				{Function: "github.com/acme/api/http.(*Handler).ServeOrders", File: "/app/http/handler.go", Line: 91, InApp: true},
+				{Function: "net/http.HandlerFunc.ServeHTTP", File: "/usr/local/go/src/net/http/server.go", Line: 2136},
+				{Function: "net/http.(*conn).serve", File: "/usr/local/go/src/net/http/server.go", Line: 2039},
+				{Function: "net/http.(*Server).Serve", File: "/usr/local/go/src/net/http/server.go", Line: 3285},
+			},
+		},
+		{
+			// Re-panicked from a deferred recover: the marker is not part
+			// of the message, and the runtime's panic frame is dropped.
+			fixture:       "go/recovered.log",
+			wantErrorType: "runtime error: invalid memory address or nil pointer dereference",
+			wantMessage:   "runtime error: invalid memory address or nil pointer dereference",
+			wantFrames: []StackFrame{
+				{Function: "github.com/acme/api/middleware.Recover.func1.1", File: "/build/src/middleware/recover.go", Line: 21, InApp: true},
+				{Function: "github.com/acme/api/billing.(*Invoice).Total", File: "/build/src/billing/invoice.go", Line: 57, InApp: true},
+				{Function: "github.com/acme/api/billing.Charge", File: "/build/src/billing/charge.go", Line: 33, InApp: true},
+			},
+		},
+		{
+			// No goroutine is running: the first one listed is kept.
+			fixture:       "go/deadlock.log",
+			wantErrorType: "fatal error: all goroutines are asleep - deadlock!",
+			wantMessage:   "all goroutines are asleep - deadlock!",
+			wantFrames: []StackFrame{
+				{Function: "main.main", File: "/build/src/cmd/worker/main.go", Line: 14, InApp: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			log := readFixture(t, tt.fixture)
+			if !(goPanicParser{}).Detect(log) {
+				t.Fatal("not detected")
+			}
+			analysis := goPanicParser{}.Parse(log)
+			if analysis.ErrorType != tt.wantErrorType || analysis.Message != tt.wantMessage {
+				t.Errorf("got %q: %q, want %q: %q", analysis.ErrorType, analysis.Message, tt.wantErrorType, tt.wantMessage)
+			}
+			assertFrames(t, analysis.Frames, tt.wantFrames)
+		})
+	}
+}
+
+func TestPanickingGoroutine(t *testing.T) {
+	tests := []struct {
+		name string
+		log  string
+		// want is the first line of the goroutine kept.
+		want string
+	}{
+		{name: "running goroutine after others", log: readFixture(t, "go/dump.log"), want: "github.com/acme/api/orders.(*Service).Total(0xc000220000, {0xc0002a2000, 0x2, 0x2})"},
+		{name: "first goroutine when none runs", log: readFixture(t, "go/deadlock.log"), want: "main.main()"},
+		{name: "CRLF line endings", log: strings.ReplaceAll(readFixture(t, "go/panic.log"), "\n", "\r\n"), want: "github.com/acme/api/orders.(*Service).Total(0xc000120000, {0xc0001a2000, 0x3, 0x4})"},
+		{name: "no goroutines", log: "panic: boom\n", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := panickingGoroutine(tt.log)
+			var got string
+			if len(lines) > 0 {
+				got = lines[0]
+			}
+			if got != tt.want {
+				t.Errorf("first line = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGoFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []StackFrame
+	}{
+		{
+			name: "method with arguments",
+			lines: []string{
+				"github.com/acme/api/orders.(*Service).Total(0xc000120000, {0xc0001a2000, 0x3, 0x4})",
+				"\t/build/src/orders/service.go:42 +0x1d4",
+			},
+			want: []StackFrame{{Function: "github.com/acme/api/orders.(*Service).Total", File: "/build/src/orders/service.go", Line: 42, InApp: true}},
+		},
+		{
+			name: "generic function",
+			lines: []string{
+				"github.com/acme/api/cache.Get[...](0xc000010000, {0x9a3f40, 0x5})",
+				"\t/build/src/cache/cache.go:17 +0x44",
+			},
+			want: []StackFrame{{Function: "github.com/acme/api/cache.Get[...]", File: "/build/src/cache/cache.go", Line: 17, InApp: true}},
+		},
+		{
+			name: "runtime frames dropped",
+			lines: []string{
+				"runtime.gopanic({0x7a2f60, 0xb3c4d0})",
+				"\t/usr/local/go/src/runtime/panic.go:914 +0x21f",
+				"runtime/debug.Stack()",
+				"\t/usr/local/go/src/runtime/debug/stack.go:24 +0x5e",
+				"main.run()",
+				"\t/build/src/main.go:9 +0x1d",
+			},
+			want: []StackFrame{{Function: "main.run", File: "/build/src/main.go", Line: 9, InApp: true}},
+		},
+		{
+			name: "creator",
+			lines: []string{
+				"created by github.com/acme/api/worker.New in goroutine 1",
+				"\t/build/src/worker/pool.go:19 +0x9c",
+			},
+			want: []StackFrame{{Function: "github.com/acme/api/worker.New", File: "/build/src/worker/pool.go", Line: 19, InApp: true}},
+		},
+		{
+			name: "inlined frame",
+			lines: []string{
+				"encoding/json.(*decodeState).object(...)",
+				"\t/usr/local/go/src/encoding/json/decode.go:642",
+			},
+			want: []StackFrame{{Function: "encoding/json.(*decodeState).object", File: "/usr/local/go/src/encoding/json/decode.go", Line: 642}},
+		},
+		{
+			name: "lines that are not frames",
+			lines: []string{
+				"main.a(...)",
+				"\t/build/src/main.go:5",
+				"...additional frames elided...",
+				"main.main()",
+				"\t/build/src/main.go:9 +0x1d",
+				"exit status 2",
+			},
+			want: []StackFrame{
+				{Function: "main.a", File: "/build/src/main.go", Line: 5, InApp: true},
+				{Function: "main.main", File: "/build/src/main.go", Line: 9, InApp: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertFrames(t, parseGoFrames(tt.lines), tt.want)
+		})
+	}
+}
+
+func TestGoErrorType(t *testing.T) {
+	tests := []struct {
+		kind, msg string
+		want      string
+	}{
+		{"panic", "runtime error: index out of range [5] with length 3", "runtime error: index out of range"},
+		{"panic", "runtime error: slice bounds out of range [:12] with capacity 8", "runtime error: slice bounds out of range"},
+		{"panic", "runtime error: invalid memory address or nil pointer dereference", "runtime error: invalid memory address or nil pointer dereference"},
+		{"panic", "order 42 has no lines", "panic"},
+		{"panic", "assignment to entry in nil map", "panic"},
+		{"fatal error", "concurrent map writes", "fatal error: concurrent map writes"},
+	}
+	for _, tt := range tests {
+		if got := goErrorType(tt.kind, tt.msg); got != tt.want {
+			t.Errorf("goErrorType(%q, %q) = %q, want %q", tt.kind, tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestGoPanicFingerprint(t *testing.T) {
+	// The same panic, alone and in a goroutine dump, with other values,
+	// addresses, line numbers and build paths.
+	assertSameFingerprint(t, "go", "go/panic.log", "go/dump.log")
+
+	if analyzeErrorLog(readFixture(t, "go/panic.log")).Fingerprint == analyzeErrorLog(readFixture(t, "go/recovered.log")).Fingerprint {
+		t.Error("different panics have the same fingerprint")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const pythonTracebackHeader = "Traceback (most recent call last):"
+
+var (
+	pythonFramePattern     = regexp.MustCompile(`^\s+File "([^"]+)", line (\d+), in (.+)$`)
+	pythonExceptionPattern = regexp.MustCompile(`^([A-Za-z_][\w.]*)(?::\s*(.*))?$`)
+)
+
+// pythonTraceback is one traceback of a possibly chained exception.
+type pythonTraceback struct {
+	frames    []StackFrame
+	errorType string
+	message   string
+}
+
+// pythonParser handles Python tracebacks, including chained exceptions
+// ("During handling of the above exception..." and "The above exception was
+// the direct cause..."). Python prints the original exception first, so the
+// first traceback in the log is the root cause and is what gets fingerprinted;
+// the exceptions wrapping it are only reported.
+type pythonParser struct{}
+
+func (pythonParser) Format() string { return "python" }
+
+func (pythonParser) Detect(errorLog string) bool {
+	return strings.Contains(errorLog, pythonTracebackHeader)
+}
+
+func (pythonParser) Parse(errorLog string) *LogAnalysis {
+	tracebacks := parsePythonTracebacks(errorLog)
+	if len(tracebacks) == 0 {
+		return nil
+	}
+
+	root := tracebacks[0]
+	analysis := &LogAnalysis{
+		ErrorType: root.errorType,
+		Message:   root.message,
+		Frames:    root.frames,
+	}
+	for _, tb := range tracebacks[1:] {
+		analysis.Wrappers = append(analysis.Wrappers, tb.errorType)
+	}
+	return analysis
+}
+
+func parsePythonTracebacks(errorLog string) []pythonTraceback {
+	var tracebacks []pythonTraceback
+	var current *pythonTraceback
+
+	for _, line := range strings.Split(errorLog, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if strings.TrimSpace(line) == pythonTracebackHeader {
+			tracebacks = append(tracebacks, pythonTraceback{})
+			current = &tracebacks[len(tracebacks)-1]
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		if m := pythonFramePattern.FindStringSubmatch(line); m != nil {
+			lineNo, _ := strconv.Atoi(m[2])
+			// Python prints the innermost call last; frames are stored
+			// innermost first.
+			current.frames = append([]StackFrame{{
+				Function: m[3],
+				File:     m[1],
+				Line:     lineNo,
+				InApp:    isPythonAppFile(m[1]),
+			}}, current.frames...)
+			continue
+		}
+
+		if line == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+
+		if current.errorType == "" {
+			if m := pythonExceptionPattern.FindStringSubmatch(line); m != nil {
+				current.errorType = m[1]
+				current.message = strings.TrimSpace(m[2])
+			}
+		}
+	}
+
+	return tracebacks
+}
+
+// isPythonAppFile reports whether a traceback file belongs to the application
+// rather than the interpreter's standard library or installed packages.
+func isPythonAppFile(file string) bool {
+	if strings.HasPrefix(file, "<") {
+		return false
+	}
+	for _, marker := range []string{"site-packages", "dist-packages", "/lib/python", `\Lib\`} {
+		if strings.Contains(file, marker) {
+			return false
+		}
+	}
+	return true
+}
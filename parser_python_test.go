@@ -0,0 +1,73 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPythonParser(t *testing.T) {
+	tests := []struct {
+		fixture       string
+		wantErrorType string
+		wantMessage   string
+		wantWrappers  []string
+		wantFrames    []StackFrame
+	}{
+		{
+			// The root cause is printed first, the exception raised while
+			// handling it after.
+			fixture:       "python/during_handling.log",
+			wantErrorType: "KeyError",
+			wantMessage:   "'customer_id'",
+			wantWrappers:  []string{"billing.errors.InvoiceError"},
+			wantFrames: []StackFrame{
+				{Function: "load_invoice", File: "/srv/app/billing/invoices.py", Line: 42, InApp: true},
+				{Function: "get_invoice", File: "/srv/app/api/views.py", Line: 18, InApp: true},
+				{Function: "full_dispatch_request", File: "/usr/local/lib/python3.11/site-packages/flask/app.py", Line: 1823},
+			},
+		},
+		{
+			// Chained with "raise ... from", then failing in the error
+			// handler, after a log line and with 3.11 caret markers.
+			fixture:       "python/direct_cause.log",
+			wantErrorType: "KeyError",
+			wantMessage:   "'customer_id'",
+			wantWrappers:  []string{"billing.errors.InvoiceError", "AttributeError"},
+			wantFrames: []StackFrame{
+				{Function: "load_invoice", File: "/app/billing/invoices.py", Line: 47, InApp: true},
+				{Function: "get_invoice", File: "/app/api/views.py", Line: 21, InApp: true},
+			},
+		},
+		{
+			fixture:       "python/windows.log",
+			wantErrorType: "ConnectionError",
+			wantFrames: []StackFrame{
+				{Function: "sync_all", File: `C:\svc\sync\worker.py`, Line: 12, InApp: true},
+				{Function: "main", File: `C:\svc\sync\worker.py`, Line: 30, InApp: true},
+				{Function: "_run_code", File: "<frozen runpy>", Line: 88},
+				{Function: "run", File: `C:\Python311\Lib\asyncio\runners.py`, Line: 118},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			log := readFixture(t, tt.fixture)
+			if !(pythonParser{}).Detect(log) {
+				t.Fatal("not detected")
+			}
+			analysis := pythonParser{}.Parse(log)
+			if analysis.ErrorType != tt.wantErrorType || analysis.Message != tt.wantMessage {
+				t.Errorf("got %q: %q, want %q: %q", analysis.ErrorType, analysis.Message, tt.wantErrorType, tt.wantMessage)
+			}
+			if !slices.Equal(analysis.Wrappers, tt.wantWrappers) {
+				t.Errorf("wrappers = %q, want %q", analysis.Wrappers, tt.wantWrappers)
+			}
+			assertFrames(t, analysis.Frames, tt.wantFrames)
+		})
+	}
+}
+
+func TestPythonFingerprint(t *testing.T) {
+	// The same root cause, wrapped differently, from another deployment.
+	assertSameFingerprint(t, "python", "python/during_handling.log", "python/direct_cause.log")
+}
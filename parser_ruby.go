@@ -0,0 +1,119 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	rubyFramePattern       = regexp.MustCompile(`^\s*(?:from )?(.+?\.rb|<internal:[^>]+>):(\d+):in [` + "`" + `'](.+?)'`)
+	rubyInlineError        = regexp.MustCompile(`:in [` + "`" + `'].+?': (.*) \(([A-Z][\w:]*)\)\s*$`)
+	railsExceptionHeader   = regexp.MustCompile(`^([A-Z]\w*(?:::[A-Z]\w*)*) \((.*)\):?\s*$`)
+	rubyGemDisplayPath     = regexp.MustCompile(`^\S+ \([\d.]+\) `)
+	rubyBlockPrefixPattern = regexp.MustCompile(`^block (?:\(\d+ levels\) )?in `)
+)
+
+// rubyParser handles plain Ruby exceptions and Rails-style exception logs.
+// Frames from gems and the Ruby standard library are kept but not treated as
+// application code, and the controller and model found in the application
+// frames are reported as metadata so they end up in the issue.
+type rubyParser struct{}
+
+func (rubyParser) Format() string { return "ruby" }
+
+func (rubyParser) Detect(errorLog string) bool {
+	for _, line := range strings.Split(errorLog, "\n") {
+		if rubyFramePattern.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rubyParser) Parse(errorLog string) *LogAnalysis {
+	analysis := &LogAnalysis{}
+
+	for _, line := range strings.Split(errorLog, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if m := rubyFramePattern.FindStringSubmatch(line); m != nil {
+			if analysis.ErrorType == "" {
+				if e := rubyInlineError.FindStringSubmatch(line); e != nil {
+					analysis.Message = e[1]
+					analysis.ErrorType = e[2]
+				}
+			}
+
+			lineNo, _ := strconv.Atoi(m[2])
+			analysis.Frames = append(analysis.Frames, StackFrame{
+				Function: rubyBlockPrefixPattern.ReplaceAllString(m[3], ""),
+				File:     m[1],
+				Line:     lineNo,
+				InApp:    isRubyAppFile(m[1]),
+			})
+			continue
+		}
+
+		if analysis.ErrorType == "" && len(analysis.Frames) == 0 {
+			if m := railsExceptionHeader.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+				analysis.ErrorType = m[1]
+				analysis.Message = m[2]
+			}
+		}
+	}
+
+	tagRailsComponents(analysis)
+	return analysis
+}
+
+// tagRailsComponents records the first controller and model found in the
+// application frames, e.g. "UsersController#show" and "User".
+func tagRailsComponents(analysis *LogAnalysis) {
+	for _, f := range analysis.Frames {
+		if !f.InApp {
+			continue
+		}
+
+		if name, ok := railsComponent(f.File, "app/controllers/"); ok && analysis.Metadata["rails_controller"] == "" {
+			analysis.setMetadata("rails_controller", name+"#"+f.Function)
+		}
+		if name, ok := railsComponent(f.File, "app/models/"); ok && analysis.Metadata["rails_model"] == "" {
+			analysis.setMetadata("rails_model", name)
+		}
+	}
+}
+
+// railsComponent maps "app/controllers/admin/users_controller.rb" to
+// "Admin::UsersController".
+func railsComponent(file, dir string) (string, bool) {
+	idx := strings.Index(file, dir)
+	if idx == -1 {
+		return "", false
+	}
+
+	path := strings.TrimSuffix(file[idx+len(dir):], ".rb")
+	var parts []string
+	for _, segment := range strings.Split(path, "/") {
+		var sb strings.Builder
+		for _, word := range strings.Split(segment, "_") {
+			if word != "" {
+				sb.WriteString(strings.ToUpper(word[:1]) + word[1:])
+			}
+		}
+		parts = append(parts, sb.String())
+	}
+	return strings.Join(parts, "::"), true
+}
+
+func isRubyAppFile(file string) bool {
+	if rubyGemDisplayPath.MatchString(file) || strings.HasPrefix(file, "<internal:") {
+		return false
+	}
+	for _, marker := range []string{"/gems/", "/lib/ruby/", "/vendor/bundle/"} {
+		if strings.Contains(file, marker) {
+			return false
+		}
+	}
+	return true
+}
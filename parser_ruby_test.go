@@ -0,0 +1,85 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestRubyParser(t *testing.T) {
+	tests := []struct {
+		fixture       string
+		wantErrorType string
+		wantMessage   string
+		wantMetadata  map[string]string
+		wantFrames    []StackFrame
+	}{
+		{
+			// A Rails request log, with gems shown by name and version.
+			fixture:       "ruby/rails.log",
+			wantErrorType: "ActiveRecord::RecordNotFound",
+			wantMessage:   "Couldn't find User with 'id'=42",
+			wantMetadata:  map[string]string{"rails_controller": "Admin::UsersController#show", "rails_model": "User"},
+			wantFrames: []StackFrame{
+				{Function: "find_active!", File: "app/models/user.rb", Line: 18, InApp: true},
+				{Function: "show", File: "app/controllers/admin/users_controller.rb", Line: 12, InApp: true},
+				{Function: "send_action", File: "actionpack (7.1.2) lib/action_controller/metal/basic_implicit_render.rb", Line: 6},
+				{Function: "process_action", File: "actionpack (7.1.2) lib/abstract_controller/base.rb", Line: 224},
+				{Function: "call", File: "/usr/local/bundle/gems/rack-3.0.8/lib/rack/tempfile_reaper.rb", Line: 20},
+			},
+		},
+		{
+			// Plain Ruby: the error is on the first frame's line, and
+			// blocks are folded into their method.
+			fixture:       "ruby/plain.log",
+			wantErrorType: "NoMethodError",
+			wantMessage:   "undefined method `strip' for nil",
+			wantFrames: []StackFrame{
+				{Function: "parse", File: "/srv/app/lib/importer.rb", Line: 42, InApp: true},
+				{Function: "each", File: "/srv/app/lib/importer.rb", Line: 40, InApp: true},
+				{Function: "parse", File: "/srv/app/lib/importer.rb", Line: 40, InApp: true},
+				{Function: "open", File: "/usr/lib/ruby/3.2.0/csv.rb", Line: 1500},
+				{Function: "parse", File: "/srv/app/lib/importer.rb", Line: 38, InApp: true},
+				{Function: "loop", File: "<internal:kernel>", Line: 187},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			log := readFixture(t, tt.fixture)
+			if !(rubyParser{}).Detect(log) {
+				t.Fatal("not detected")
+			}
+			analysis := rubyParser{}.Parse(log)
+			if analysis.ErrorType != tt.wantErrorType || analysis.Message != tt.wantMessage {
+				t.Errorf("got %q: %q, want %q: %q", analysis.ErrorType, analysis.Message, tt.wantErrorType, tt.wantMessage)
+			}
+			if !maps.Equal(analysis.Metadata, tt.wantMetadata) {
+				t.Errorf("metadata = %v, want %v", analysis.Metadata, tt.wantMetadata)
+			}
+			assertFrames(t, analysis.Frames, tt.wantFrames)
+		})
+	}
+}
+
+func TestRailsComponent(t *testing.T) {
+	tests := []struct {
+		file, dir string
+		want      string
+		wantOK    bool
+	}{
+		{"app/controllers/admin/users_controller.rb", "app/controllers/", "Admin::UsersController", true},
+		{"/srv/app/app/models/line_item.rb", "app/models/", "LineItem", true},
+		{"app/services/billing.rb", "app/models/", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := railsComponent(tt.file, tt.dir)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("railsComponent(%q, %q) = %q, %v, want %q, %v", tt.file, tt.dir, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRubyFingerprint(t *testing.T) {
+	// The same error for another record, after a deploy.
+	assertSameFingerprint(t, "ruby", "ruby/rails.log", "ruby/rails_other.log")
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	rustPanicPattern      = regexp.MustCompile(`(?m)^thread '([^']*)' panicked at (?:'(.*)', )?([^\s:]+):(\d+)(?::\d+)?:?\s*$`)
+	rustBacktraceFrame    = regexp.MustCompile(`^\s*\d+:\s+(?:0x[0-9a-f]+ - )?(.+?)\s*$`)
+	rustBacktraceLocation = regexp.MustCompile(`^\s+at (.+?):(\d+)(?::\d+)?\s*$`)
+	rustSymbolHash        = regexp.MustCompile(`::h[0-9a-f]{16}$`)
+)
+
+// rustStdCrates are the crates whose frames are part of the panic machinery
+// or the standard library rather than the service.
+var rustStdCrates = map[string]bool{
+	"std":                          true,
+	"core":                         true,
+	"alloc":                        true,
+	"backtrace":                    true,
+	"rust_begin_unwind":            true,
+	"__rust_begin_short_backtrace": true,
+	"__rust_end_short_backtrace":   true,
+}
+
+// rustParser handles Rust panics, with or without RUST_BACKTRACE output. The
+// hash suffix rustc appends to symbols in full backtraces ("::h1a2b...") is
+// stripped so that rebuilds don't change the fingerprint.
+type rustParser struct{}
+
+func (rustParser) Format() string { return "rust" }
+
+func (rustParser) Detect(errorLog string) bool {
+	return rustPanicPattern.MatchString(errorLog)
+}
+
+func (rustParser) Parse(errorLog string) *LogAnalysis {
+	m := rustPanicPattern.FindStringSubmatch(errorLog)
+	if m == nil {
+		return nil
+	}
+
+	analysis := &LogAnalysis{ErrorType: "panic", Message: m[2]}
+	if analysis.Message == "" {
+		// Since Rust 1.73 the message is on the line after the location.
+		rest := errorLog[strings.Index(errorLog, m[0])+len(m[0]):]
+		for _, line := range strings.Split(strings.TrimLeft(rest, "\r\n"), "\n") {
+			analysis.Message = strings.TrimSpace(line)
+			break
+		}
+	}
+
+	analysis.Frames = parseRustBacktrace(errorLog)
+	if len(analysis.Frames) == 0 {
+		line, _ := strconv.Atoi(m[4])
+		analysis.Frames = []StackFrame{{File: m[3], Line: line, InApp: true}}
+	}
+	return analysis
+}
+
+func parseRustBacktrace(errorLog string) []StackFrame {
+	_, backtrace, found := strings.Cut(errorLog, "stack backtrace:")
+	if !found {
+		return nil
+	}
+
+	var frames []StackFrame
+	for _, line := range strings.Split(backtrace, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if m := rustBacktraceLocation.FindStringSubmatch(line); m != nil {
+			if len(frames) > 0 && frames[len(frames)-1].File == "" {
+				last := &frames[len(frames)-1]
+				last.File = m[1]
+				last.Line, _ = strconv.Atoi(m[2])
+				last.InApp = last.InApp && !isRustDependencyFile(last.File)
+			}
+			continue
+		}
+
+		m := rustBacktraceFrame.FindStringSubmatch(line)
+		// Frames the unwinder couldn't symbolize say nothing about where
+		// the panic happened.
+		if m == nil || m[1] == "<unknown>" {
+			continue
+		}
+		symbol := rustSymbolHash.ReplaceAllString(m[1], "")
+		frames = append(frames, StackFrame{
+			Function: symbol,
+			InApp:    !rustStdCrates[rustCrate(symbol)],
+		})
+	}
+
+	// Drop the unwinding prologue so the first frame is where the panic
+	// was raised.
+	for len(frames) > 0 && rustStdCrates[rustCrate(frames[0].Function)] {
+		frames = frames[1:]
+	}
+	return frames
+}
+
+// rustCrate returns the crate a symbol belongs to, looking through trait
+// impl syntax like "<myapp::Db as core::ops::Drop>::drop" and
+// "<&mut myapp::Db as ...>".
+func rustCrate(symbol string) string {
+	symbol = strings.TrimPrefix(strings.TrimLeft(symbol, "<&"), "mut ")
+	crate, _, _ := strings.Cut(symbol, "::")
+	return crate
+}
+
+// isRustDependencyFile reports files that come from the toolchain or from
+// crates downloaded by cargo.
+func isRustDependencyFile(file string) bool {
+	return strings.HasPrefix(file, "/rustc/") ||
+		strings.Contains(file, "/.cargo/registry/") ||
+		strings.Contains(file, "/.cargo/git/")
+}
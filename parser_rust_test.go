@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestRustParser(t *testing.T) {
+	tests := []struct {
+		fixture     string
+		wantMessage string
+		wantFrames  []StackFrame
+	}{
+		{
+			// RUST_BACKTRACE=full: addresses and symbol hashes, the
+			// unwinding prologue, a dependency and an unresolved frame.
+			fixture:     "rust/full.log",
+			wantMessage: "called `Option::unwrap()` on a `None` value",
+			wantFrames: []StackFrame{
+				{Function: "orders::cache::Cache::get", File: "/home/build/orders/src/orders/cache.rs", Line: 27, InApp: true},
+				{Function: "orders::service::OrderService::load::{{closure}}", File: "/home/build/orders/src/orders/service.rs", Line: 58, InApp: true},
+				{Function: "<orders::api::Handler as tower_service::Service<http::request::Request<B>>>::call", File: "/home/build/orders/src/orders/api.rs", Line: 112, InApp: true},
+				{Function: "tokio::runtime::task::core::Core<T,S>::poll", File: "/home/build/.cargo/registry/src/index.crates.io-6f17d22bba15001f/tokio-1.35.1/src/runtime/task/core.rs", Line: 328},
+			},
+		},
+		{
+			// RUST_BACKTRACE=1, with frames that have no location.
+			fixture:     "rust/short.log",
+			wantMessage: "called `Option::unwrap()` on a `None` value",
+			wantFrames: []StackFrame{
+				{Function: "orders::cache::Cache::get", File: "./src/orders/cache.rs", Line: 31, InApp: true},
+				{Function: "orders::service::OrderService::load::{{closure}}", File: "./src/orders/service.rs", Line: 60, InApp: true},
+				{Function: "<orders::api::Handler as tower_service::Service<http::request::Request<B>>>::call", File: "./src/orders/api.rs", Line: 115, InApp: true},
+			},
+		},
+		{
+			// Before Rust 1.73, without a backtrace: the location is the
+			// only frame.
+			fixture:     "rust/legacy.log",
+			wantMessage: `failed to read config: Os { code: 2, kind: NotFound, message: "No such file or directory" }`,
+			wantFrames:  []StackFrame{{File: "src/main.rs", Line: 14, InApp: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			log := readFixture(t, tt.fixture)
+			if !(rustParser{}).Detect(log) {
+				t.Fatal("not detected")
+			}
+			analysis := rustParser{}.Parse(log)
+			if analysis.ErrorType != "panic" || analysis.Message != tt.wantMessage {
+				t.Errorf("got %q: %q, want %q: %q", analysis.ErrorType, analysis.Message, "panic", tt.wantMessage)
+			}
+			assertFrames(t, analysis.Frames, tt.wantFrames)
+		})
+	}
+}
+
+func TestRustCrate(t *testing.T) {
+	tests := []struct{ symbol, want string }{
+		{"orders::cache::Cache::get", "orders"},
+		{"<orders::Db as core::ops::Drop>::drop", "orders"},
+		{"<&mut serde_json::de::Deserializer<R> as serde::de::Deserializer>::deserialize_any", "serde_json"},
+		{"rust_begin_unwind", "rust_begin_unwind"},
+	}
+	for _, tt := range tests {
+		if got := rustCrate(tt.symbol); got != tt.want {
+			t.Errorf("rustCrate(%q) = %q, want %q", tt.symbol, got, tt.want)
+		}
+	}
+}
+
+func TestRustFingerprint(t *testing.T) {
+	// The same panic with a full and a short backtrace, from another build.
+	assertSameFingerprint(t, "rust", "rust/full.log", "rust/short.log")
+}
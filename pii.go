@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// PII policies.
+const (
+	piiMask = "mask"
+	piiHash = "hash"
+	piiDrop = "drop"
+	piiKeep = "keep"
+)
+
+// PII types, which are also the keys of PIIConfig.
+const (
+	piiEmail     = "email"
+	piiIP        = "ip"
+	piiPhone     = "phone"
+	piiAccountID = "account_id"
+)
+
+func init() {
+	metrics.describe("triage_pii_scrubbed_total", "counter", "Personal data scrubbed from error logs and issue text, by type and policy.")
+}
+
+// PIIConfig sets the policy for each type of personal data: mask (the
+// default) replaces it with a placeholder, hash with a keyed hash so that
+// occurrences of the same value can still be correlated, drop removes it
+// and keep leaves it alone. The hash key is a secret and only read from
+// PII_HASH_SALT.
+type PIIConfig struct {
+	Email     string `yaml:"email"`
+	IP        string `yaml:"ip"`
+	Phone     string `yaml:"phone"`
+	AccountID string `yaml:"account_id"`
+	// AccountIDPatterns are regular expressions for the account or user
+	// identifiers of the application, e.g. 'acct_[0-9a-f]{12}'. If the
+	// pattern has a group named "id", only that group is scrubbed.
+	AccountIDPatterns []string `yaml:"account_id_patterns"`
+}
+
+var (
+	emailPattern = regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`)
+	ipv4Pattern  = regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`)
+	// ipv6Candidate finds runs of hex digits and colons; candidates are
+	// confirmed with net.ParseIP.
+	ipv6Candidate = regexp.MustCompile(`[0-9A-Fa-f:]{3,39}`)
+	// phonePattern matches international numbers with a leading + and
+	// North American (555) 123-4567 or 555-123-4567 formats, but not bare
+	// digit runs, which in logs are mostly IDs, ports and timestamps.
+	phonePattern = regexp.MustCompile(`\+\d{1,3}[ .-]?(?:\(?\d{1,4}\)?[ .-]?){1,4}\d{2,4}\b|\(\d{3}\) ?\d{3}-\d{4}\b|\b\d{3}-\d{3}-\d{4}\b`)
+)
+
+// piiScrubber applies the PII policies. It is configured at startup from
+// the pii config setting.
+type piiScrubber struct {
+	policies   map[string]string
+	accountIDs []*regexp.Regexp
+	salt       []byte
+}
+
+var pii = newPIIScrubber(PIIConfig{})
+
+func piiPolicies(cfg PIIConfig) map[string]string {
+	policies := map[string]string{
+		piiEmail:     cfg.Email,
+		piiIP:        cfg.IP,
+		piiPhone:     cfg.Phone,
+		piiAccountID: cfg.AccountID,
+	}
+	for kind, policy := range policies {
+		if policy == "" {
+			policies[kind] = piiMask
+		}
+	}
+	return policies
+}
+
+// compilePII validates the PII config and builds the scrubber. Invalid
+// policies fall back to mask and invalid patterns are left out.
+func compilePII(cfg PIIConfig) (*piiScrubber, []string) {
+	var problems []string
+	s := &piiScrubber{policies: piiPolicies(cfg), salt: []byte(os.Getenv("PII_HASH_SALT"))}
+
+	hashing := false
+	for _, kind := range []string{piiEmail, piiIP, piiPhone, piiAccountID} {
+		switch s.policies[kind] {
+		case piiMask, piiDrop, piiKeep:
+		case piiHash:
+			hashing = true
+		default:
+			problems = append(problems, fmt.Sprintf("pii.%s: unknown policy %q, expected mask, hash, drop or keep", kind, s.policies[kind]))
+			s.policies[kind] = piiMask
+		}
+	}
+	if hashing && len(s.salt) == 0 {
+		problems = append(problems, "PII_HASH_SALT must be set to hash personal data, otherwise hashes of IPs and phone numbers can be reversed by brute force")
+	}
+
+	for i, pattern := range cfg.AccountIDPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("pii.account_id_patterns[%d]: invalid regular expression: %v", i, err))
+			continue
+		}
+		s.accountIDs = append(s.accountIDs, re)
+	}
+	return s, problems
+}
+
+func newPIIScrubber(cfg PIIConfig) *piiScrubber {
+	s, _ := compilePII(cfg)
+	return s
+}
+
+// sanitizeText masks secrets, then personal data. It is applied to logs
+// on arrival, to everything sent to the LLM and to what is written to the
+// issue tracker.
+func sanitizeText(s string) string {
+	return pii.scrub(redactSecrets(s))
+}
+
+// scrub applies the policies to s. Account IDs go first, since their
+// patterns may be more specific than the built-in detectors.
+func (p *piiScrubber) scrub(s string) string {
+	if s == "" {
+		return s
+	}
+	for _, re := range p.accountIDs {
+		s = p.replace(s, piiAccountID, re, re.SubexpIndex("id"), nil)
+	}
+	s = p.replace(s, piiEmail, emailPattern, 0, nil)
+	s = p.replace(s, piiIP, ipv4Pattern, 0, func(s string, start, end int) bool {
+		return isPersonalIP(s[start:end])
+	})
+	s = p.replace(s, piiIP, ipv6Candidate, 0, func(s string, start, end int) bool {
+		v := s[start:end]
+		return isTokenBoundary(s, start, end) && (strings.Contains(v, "::") || strings.Count(v, ":") == 7) && isPersonalIP(v)
+	})
+	s = p.replace(s, piiPhone, phonePattern, 0, nil)
+	return s
+}
+
+// replace applies the policy for kind to the matches of re, or of its
+// group if group > 0. accept, if not nil, filters matches by position.
+func (p *piiScrubber) replace(s, kind string, re *regexp.Regexp, group int, accept func(s string, start, end int) bool) string {
+	policy := p.policies[kind]
+	if policy == piiKeep {
+		return s
+	}
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+
+	var sb strings.Builder
+	last, n := 0, 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if group > 0 && m[2*group] >= 0 {
+			start, end = m[2*group], m[2*group+1]
+		}
+		if accept != nil && !accept(s, start, end) {
+			continue
+		}
+		sb.WriteString(s[last:start])
+		sb.WriteString(p.replacement(kind, policy, s[start:end]))
+		last = end
+		n++
+	}
+	sb.WriteString(s[last:])
+	if n > 0 {
+		metrics.add("triage_pii_scrubbed_total", labelSet("type", kind, "policy", policy), float64(n))
+	}
+	return sb.String()
+}
+
+func (p *piiScrubber) replacement(kind, policy, value string) string {
+	switch policy {
+	case piiDrop:
+		return ""
+	case piiHash:
+		mac := hmac.New(sha256.New, p.salt)
+		mac.Write([]byte(strings.ToLower(value)))
+		return "[" + kind + ":" + hex.EncodeToString(mac.Sum(nil))[:12] + "]"
+	}
+	return "[" + strings.ToUpper(kind) + "]"
+}
+
+// isPersonalIP reports whether an address may identify a person: loopback,
+// private, link-local and unspecified addresses are infrastructure and
+// kept, as they help debugging.
+func isPersonalIP(s string) bool {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return false
+	}
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsUnspecified()
+}
+
+// isTokenBoundary reports whether s[start:end] is not part of a longer
+// word, e.g. the "::Ba" of "Foo::Bar".
+func isTokenBoundary(s string, start, end int) bool {
+	isWord := func(b byte) bool {
+		return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+	}
+	return (start == 0 || !isWord(s[start-1])) && (end == len(s) || !isWord(s[end]))
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+const defaultProjectStatusField = "Status"
+
+func init() {
+	metrics.describe("triage_project_items_total", "counter", "New issues added to the project board, by result (added or failed).")
+}
+
+// ProjectConfig configures the GitHub Project (v2) board new issues are
+// added to.
+type ProjectConfig struct {
+	// Owner is the organization or user the project belongs to.
+	Owner string `yaml:"owner"`
+	// Number is the project's number, from its URL, e.g. 5 for
+	// https://github.com/orgs/myorg/projects/5.
+	Number int `yaml:"number"`
+	// Status is the option new items get in the status field, e.g.
+	// "Triage". Empty leaves the status unset.
+	Status string `yaml:"status"`
+	// StatusField is the single select field holding the status, "Status"
+	// by default.
+	StatusField string `yaml:"status_field"`
+}
+
+// enabled reports whether a project board is configured.
+func (pc ProjectConfig) enabled() bool { return pc.Owner != "" }
+
+var projectConfig ProjectConfig
+
+func configProject(cfg *Config) ProjectConfig {
+	out := cfg.Project
+	out.StatusField = firstNonEmpty(out.StatusField, defaultProjectStatusField)
+	return out
+}
+
+func validateProject(pc ProjectConfig) []string {
+	var problems []string
+	if !pc.enabled() {
+		if pc.Number != 0 || pc.Status != "" {
+			problems = append(problems, "project.owner must be set to add issues to a project")
+		}
+		return problems
+	}
+	if pc.Number < 1 {
+		problems = append(problems, fmt.Sprintf("project.number: %d must be a positive project number", pc.Number))
+	}
+	return problems
+}
+
+const projectQuery = `query($owner: String!, $number: Int!, $field: String!) {
+  repositoryOwner(login: $owner) {
+    ... on ProjectV2Owner {
+      projectV2(number: $number) {
+        id
+        field(name: $field) {
+          ... on ProjectV2SingleSelectField {
+            id
+            options { id name }
+          }
+        }
+      }
+    }
+  }
+}`
+
+const addProjectItemMutation = `mutation($project: ID!, $content: ID!) {
+  addProjectV2ItemById(input: {projectId: $project, contentId: $content}) {
+    item { id }
+  }
+}`
+
+const setProjectStatusMutation = `mutation($project: ID!, $item: ID!, $field: ID!, $option: String!) {
+  updateProjectV2ItemFieldValue(input: {projectId: $project, itemId: $item, fieldId: $field, value: {singleSelectOptionId: $option}}) {
+    projectV2Item { id }
+  }
+}`
+
+// projectBoard is the project and status option items are set to, as IDs
+// of GitHub's GraphQL API.
+type projectBoard struct {
+	ID       string
+	FieldID  string
+	OptionID string
+}
+
+// findProject looks up the configured project and, if a status is
+// configured, the option of the status field with that name.
+func findProject(ctx context.Context, pc ProjectConfig) (projectBoard, error) {
+	var data struct {
+		RepositoryOwner *struct {
+			ProjectV2 *struct {
+				ID    string `json:"id"`
+				Field *struct {
+					ID      string `json:"id"`
+					Options []struct {
+						ID   string `json:"id"`
+						Name string `json:"name"`
+					} `json:"options"`
+				} `json:"field"`
+			} `json:"projectV2"`
+		} `json:"repositoryOwner"`
+	}
+	err := githubGraphQL(ctx, projectQuery, map[string]any{"owner": pc.Owner, "number": pc.Number, "field": pc.StatusField}, &data)
+	if err != nil {
+		return projectBoard{}, err
+	}
+	if data.RepositoryOwner == nil || data.RepositoryOwner.ProjectV2 == nil {
+		return projectBoard{}, fmt.Errorf("project %d of %s not found", pc.Number, pc.Owner)
+	}
+	project := data.RepositoryOwner.ProjectV2
+	board := projectBoard{ID: project.ID}
+	if pc.Status == "" {
+		return board, nil
+	}
+	if project.Field == nil || project.Field.ID == "" {
+		return projectBoard{}, fmt.Errorf("project %d of %s has no single select field %q", pc.Number, pc.Owner, pc.StatusField)
+	}
+	var names []string
+	for _, o := range project.Field.Options {
+		if strings.EqualFold(o.Name, pc.Status) {
+			board.FieldID, board.OptionID = project.Field.ID, o.ID
+			return board, nil
+		}
+		names = append(names, o.Name)
+	}
+	return projectBoard{}, fmt.Errorf("field %q of project %d has no option %q (options: %s)", pc.StatusField, pc.Number, pc.Status, strings.Join(names, ", "))
+}
+
+// addIssueToProject adds an issue to the configured project and sets its
+// status. Adding an issue that is already on the board returns its item,
+// so that retries are harmless.
+func addIssueToProject(ctx context.Context, target repoTarget, number int) error {
+	board, err := findProject(ctx, projectConfig)
+	if err != nil {
+		return err
+	}
+
+	var issue *github.Issue
+	err = retryGitHub(ctx, func() (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		issue, resp, err = ghClient.Issues.Get(ctx, target.Owner, target.Repo, number)
+		return resp, err
+	})
+	if err != nil {
+		return err
+	}
+
+	var added struct {
+		AddProjectV2ItemByID struct {
+			Item struct {
+				ID string `json:"id"`
+			} `json:"item"`
+		} `json:"addProjectV2ItemById"`
+	}
+	if err := githubGraphQL(ctx, addProjectItemMutation, map[string]any{"project": board.ID, "content": issue.GetNodeID()}, &added); err != nil {
+		return fmt.Errorf("adding issue to project: %w", err)
+	}
+	if board.OptionID == "" {
+		return nil
+	}
+
+	var updated struct{}
+	vars := map[string]any{"project": board.ID, "item": added.AddProjectV2ItemByID.Item.ID, "field": board.FieldID, "option": board.OptionID}
+	if err := githubGraphQL(ctx, setProjectStatusMutation, vars, &updated); err != nil {
+		return fmt.Errorf("setting project status: %w", err)
+	}
+	return nil
+}
+
+// addToProject adds an issue the run created to the team's project board.
+func (t *toolSession) addToProject(number int) error {
+	if !projectConfig.enabled() {
+		return nil
+	}
+	if err := checkAction(t.target, actionAddToProject, nil); err != nil {
+		t.log.Info("Not adding the new issue to the project board", "issue_number", number, "reason", err)
+		return nil
+	}
+
+	res, done := writer.submitAndWait(&githubIntent{
+		Kind:        intentProject,
+		Target:      t.target,
+		IssueNumber: number,
+		RunID:       runID(t.ctx),
+	})
+	if done && res.Err != nil {
+		metrics.add("triage_project_items_total", `result="failed"`, 1)
+		return fmt.Errorf("adding issue #%d to the project board: %w", number, res.Err)
+	}
+	if done {
+		metrics.add("triage_project_items_total", `result="added"`, 1)
+	}
+	t.log.Info("Added the new issue to the project board", "issue_number", number, "queued", !done)
+	return nil
+}
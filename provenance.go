@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// Provenance records how an issue was filed. It is kept in a
+// machine-readable footer of every created issue, so that tooling such as
+// janitors, consolidation and audits can recognize the agent's issues and
+// trace them back to the run, log and prompt that produced them.
+type Provenance struct {
+	RunID       string `json:"run_id,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Version     string `json:"version"`
+	Model       string `json:"model"`
+	// PromptVersion identifies the system prompt and issue templates.
+	PromptVersion string `json:"prompt_version"`
+	// Source is the ingestion endpoint the log came in through, e.g.
+	// process_error or alertmanager.
+	Source      string     `json:"source,omitempty"`
+	SubmittedAt *time.Time `json:"submitted_at,omitempty"`
+}
+
+// provenanceBlock finds the provenance footer in an issue body.
+var provenanceBlock = regexp.MustCompile(`<!-- triage:provenance (\{.*\}) -->`)
+
+// promptVersion is a short hash of the system prompt and issue templates,
+// which changes whenever they do.
+var promptVersion = func() string {
+	data, _ := json.Marshal([]any{agentSystemPrompt, issueTemplates})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}()
+
+// runProvenance returns the provenance of issues created by a run. Runs
+// that aren't recorded, such as dry runs, only have their ID.
+func runProvenance(id string) Provenance {
+	p := Provenance{RunID: id, Version: version, Model: llmConfig.Model, PromptVersion: promptVersion}
+	if run, ok := runs.get(id); ok {
+		submitted := run.StartedAt.UTC().Truncate(time.Second)
+		p.Fingerprint, p.Source, p.SubmittedAt = run.Fingerprint, run.Source, &submitted
+	}
+	return p
+}
+
+// issueFooter is appended to the body of created issues: a line for people
+// naming the version that filed it, and the provenance as JSON in an HTML
+// comment, for tools.
+func issueFooter(p Provenance) string {
+	data, _ := json.Marshal(p)
+	return fmt.Sprintf("\n\n---\n_Filed by %s %s_\n<!-- triage:provenance %s -->", userAgentConfig.Service, version, data)
+}
+
+// parseProvenance reads the provenance footer of an issue body.
+func parseProvenance(body string) (Provenance, bool) {
+	var p Provenance
+	m := provenanceBlock.FindStringSubmatch(body)
+	if m == nil || json.Unmarshal([]byte(m[1]), &p) != nil {
+		return Provenance{}, false
+	}
+	return p, true
+}
+
+type ingestSourceKey struct{}
+
+// withIngestSource records the ingestion source in the request context,
+// for the provenance of the runs it starts.
+func withIngestSource(source string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), ingestSourceKey{}, source)))
+	}
+}
+
+func ingestSource(ctx context.Context) string {
+	source, _ := ctx.Value(ingestSourceKey{}).(string)
+	return source
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// pruneInterval is how often finished jobs and outbox entries past their
+// retention are deleted.
+const pruneInterval = time.Hour
+
+func init() {
+	metrics.describe("triage_pruned_total", "counter", "Store entries deleted after their retention, by kind (job or outbox).")
+}
+
+// startPruning deletes the finished jobs and outbox entries kept past
+// their retention, at start and then every pruneInterval, until ctx is
+// done, so that the store doesn't grow with every request.
+func startPruning(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		for {
+			now := time.Now()
+			metrics.add("triage_pruned_total", `kind="job"`, float64(jobs.prune(ctx, now)))
+			metrics.add("triage_pruned_total", `kind="outbox"`, float64(pruneOutbox(ctx, now)))
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRateLimitBurst is how many requests a client can make at once
+	// when rate limiting is on and no burst is configured.
+	defaultRateLimitBurst = 10
+	// defaultCollapseWindow is how long a finished run keeps answering
+	// identical errors.
+	defaultCollapseWindow = time.Minute
+	// rateLimitPruneInterval is how often idle clients are forgotten.
+	rateLimitPruneInterval = time.Minute
+)
+
+func init() {
+	metrics.describe("triage_rate_limited_total", "counter", "Ingestion requests, and error logs of batches, refused with 429 because the client exceeded its rate limit, by source.")
+	metrics.describe("triage_collapsed_errors_total", "counter", "Error logs answered by a run in flight or just finished for the same fingerprint and repository instead of a run of their own.")
+}
+
+// RateLimitConfig protects the service from floods, such as a crashing
+// service posting the same log in a loop.
+type RateLimitConfig struct {
+	// RequestsPerMinute is the rate each client (API key, or source IP
+	// without API keys) may sustain on the ingestion endpoints. Rate
+	// limiting is off when it is 0.
+	RequestsPerMinute float64 `yaml:"requests_per_minute"`
+	// Burst is how many requests a client can make at once (default 10).
+	Burst int `yaml:"burst"`
+	// CollapseWindow is how long a finished run answers errors with the
+	// same fingerprint and repository (default 1m). Errors arriving while
+	// the run is in flight always join it.
+	CollapseWindow string `yaml:"collapse_window"`
+}
+
+// configRateLimit returns the rate limit settings, with
+// RATE_LIMIT_PER_MINUTE, RATE_LIMIT_BURST and COLLAPSE_WINDOW taking
+// precedence over the config file. Unparsable numbers become -1, which
+// validateRateLimit reports.
+func configRateLimit(cfg *Config) RateLimitConfig {
+	out := cfg.RateLimit
+	if v := os.Getenv("RATE_LIMIT_PER_MINUTE"); v != "" {
+		var err error
+		if out.RequestsPerMinute, err = strconv.ParseFloat(v, 64); err != nil {
+			out.RequestsPerMinute = -1
+		}
+	}
+	if v := os.Getenv("RATE_LIMIT_BURST"); v != "" {
+		var err error
+		if out.Burst, err = strconv.Atoi(v); err != nil {
+			out.Burst = -1
+		}
+	}
+	if out.Burst == 0 {
+		out.Burst = defaultRateLimitBurst
+	}
+	out.CollapseWindow = envOr("COLLAPSE_WINDOW", firstNonEmpty(out.CollapseWindow, defaultCollapseWindow.String()))
+	return out
+}
+
+func validateRateLimit(rc RateLimitConfig) []string {
+	var problems []string
+	if rc.RequestsPerMinute < 0 || math.IsInf(rc.RequestsPerMinute, 0) || math.IsNaN(rc.RequestsPerMinute) {
+		problems = append(problems, "rate_limit.requests_per_minute (or RATE_LIMIT_PER_MINUTE): must be a non-negative number")
+	}
+	if rc.Burst < 0 {
+		problems = append(problems, "rate_limit.burst (or RATE_LIMIT_BURST): must be a positive number")
+	}
+	if d, err := time.ParseDuration(rc.CollapseWindow); err != nil || d < 0 {
+		problems = append(problems, fmt.Sprintf("rate_limit.collapse_window (or COLLAPSE_WINDOW): invalid duration %q", rc.CollapseWindow))
+	}
+	return problems
+}
+
+// tokenBucket holds a client's tokens as of last.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per client.
+type rateLimiter struct {
+	mu        sync.Mutex
+	perSecond float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	pruned    time.Time
+}
+
+// limiter is nil when rate limiting is off.
+var limiter *rateLimiter
+
+func newRateLimiter(rc RateLimitConfig) *rateLimiter {
+	if rc.RequestsPerMinute <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		perSecond: rc.RequestsPerMinute / 60,
+		burst:     float64(rc.Burst),
+		buckets:   make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token from the client's bucket. When it is empty, it
+// returns how long until the next token.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	taken, wait := l.take(client, 1, now)
+	return taken == 1, wait
+}
+
+// take takes up to n tokens from the client's bucket and returns how many
+// it took. When it took fewer, it also returns how long until the next
+// token.
+func (l *rateLimiter) take(client string, n int, now time.Time) (int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.pruned) > rateLimitPruneInterval {
+		l.pruneLocked(now)
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.perSecond)
+	b.last = now
+	taken := min(n, int(b.tokens))
+	b.tokens -= float64(taken)
+	if taken < n {
+		return taken, time.Duration((1 - b.tokens) / l.perSecond * float64(time.Second))
+	}
+	return taken, 0
+}
+
+// pruneLocked forgets the clients whose bucket has refilled, which is the
+// state a new bucket starts in.
+func (l *rateLimiter) pruneLocked(now time.Time) {
+	l.pruned = now
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.perSecond >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// rateLimitClient identifies the client of a request: its API key, or its
+// address without one. Behind a proxy every request comes from the proxy's
+// address, so use API keys there.
+func rateLimitClient(r *http.Request) string {
+	if key, ok := r.Context().Value(apiKeyContextKey{}).(apiKey); ok {
+		return "key:" + key.name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimit replies 429 with a Retry-After header to clients over their
+// rate.
+func rateLimit(source string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client := rateLimitClient(r)
+		if ok, wait := limiter.allow(client, time.Now()); !ok {
+			metrics.add("triage_rate_limited_total", labelSet("source", source), 1)
+			slog.WarnContext(r.Context(), "Refused request over the rate limit", "source", source, "client", client)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// floodCall is one triage run, shared by the identical errors that arrive
+// while it is in flight or within the collapse window after it.
+type floodCall struct {
+	done      chan struct{}
+	resp      APIResponse
+	err       error
+	finished  time.Time
+	collapsed int
+}
+
+// floodCollapser aggregates a flood of identical errors into a single
+// triage run, like searchCoalescer does for issue searches.
+type floodCollapser struct {
+	mu     sync.Mutex
+	window time.Duration
+	calls  map[string]*floodCall
+}
+
+var floods = &floodCollapser{window: defaultCollapseWindow, calls: make(map[string]*floodCall)}
+
+// triage runs the triage of an error, or joins the run of an identical one
+// in flight or finished within the window. Joined responses are marked
+// Collapsed.
+func (c *floodCollapser) triage(ctx context.Context, target repoTarget, fingerprint string, run func(context.Context) (APIResponse, error)) (APIResponse, error) {
+	key := target.String() + "|" + fingerprint
+
+	c.mu.Lock()
+	c.pruneLocked()
+	if call, ok := c.calls[key]; ok {
+		call.collapsed++
+		c.mu.Unlock()
+		metrics.add("triage_collapsed_errors_total", "", 1)
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return APIResponse{}, ctx.Err()
+		}
+		if call.err != nil {
+			return APIResponse{}, call.err
+		}
+		resp := call.resp
+		resp.Collapsed = true
+		slog.DebugContext(ctx, "Collapsed error into an identical one's run", "fingerprint", fingerprint, "run_id", resp.RunID)
+		return resp, nil
+	}
+	call := &floodCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	// The run outlives the request that started it: others may be waiting
+	// on it.
+	call.resp, call.err = run(context.WithoutCancel(ctx))
+
+	c.mu.Lock()
+	call.finished = time.Now()
+	if call.err != nil {
+		// Let the next error try again.
+		delete(c.calls, key)
+	}
+	collapsed := call.collapsed
+	c.mu.Unlock()
+	close(call.done)
+
+	if collapsed > 0 {
+		slog.InfoContext(ctx, "Collapsed identical errors into run", "fingerprint", fingerprint, "run_id", call.resp.RunID, "collapsed", collapsed)
+	}
+	return call.resp, call.err
+}
+
+func (c *floodCollapser) pruneLocked() {
+	now := time.Now()
+	for key, call := range c.calls {
+		if !call.finished.IsZero() && now.Sub(call.finished) > c.window {
+			delete(c.calls, key)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+func init() {
+	metrics.describe("triage_reconciled_total", "counter", "Incomplete issue creations checked against the tracker, by what was checked (outbox or dead_letter) and result (completed or discarded).")
+}
+
+// errRunInterrupted fails runs whose instance stopped while they ran.
+var errRunInterrupted = errors.New("interrupted: the instance running it stopped")
+
+// startReconciler checks the mutations that were left incomplete against
+// GitHub, at start and then as the instances that made them stop. Dead
+// letters are checked once, at start.
+func startReconciler(ctx context.Context) {
+	if tracker.Name() != trackerGitHub {
+		return
+	}
+	go func() {
+		reconcileDeadLetters(ctx)
+		ticker := time.NewTicker(jobHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			reconcileOutbox(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// reconcileOutbox resolves the outbox entries whose claim expired, as the
+// run that made it was interrupted. If GitHub has the issue the create was
+// for, the entry, the fingerprint and the run are completed with it, as
+// the create would have done. Otherwise the claim is released, so that the
+// next create of the fingerprint files the issue, and the run is retried
+// unless a resumed job triages the error again. Either is only done if
+// the entry wasn't changed meanwhile, e.g. by a run taking the claim over.
+func reconcileOutbox(ctx context.Context) {
+	index, err := store.ListValues(ctx, outboxPendingPrefix)
+	if err != nil {
+		logStoreError("list outbox index", err)
+		return
+	}
+	for _, indexKey := range sortedKeys(index) {
+		key := string(index[indexKey])
+		e, data, ok, err := readOutbox(ctx, key)
+		if err != nil {
+			logStoreError("get outbox entry", err)
+			continue
+		}
+		if !ok || e.State != outboxPending {
+			logStoreError("delete outbox index", store.DeleteValue(ctx, indexKey))
+			continue
+		}
+		if e.leased() {
+			continue
+		}
+		target, err := parseRepoTarget(e.Repository)
+		if err != nil {
+			continue
+		}
+
+		issue, err := findGitHubIssueByFingerprint(ctx, target, e.Fingerprint, e.StartedAt.Add(-outboxLookback))
+		if err != nil {
+			slog.Warn("Reconciling outbox entry failed", "repository", e.Repository, "fingerprint", e.Fingerprint, "error", err)
+			continue
+		}
+		if issue != nil {
+			created := githubTrackerIssue(target, issue)
+			if !finishOutbox(ctx, key, data, e, created) {
+				continue
+			}
+			lifecycles.recordIssue(e.Repository, e.Fingerprint, created.URL)
+			runs.resolve(e.RunID, created)
+			metrics.add("triage_reconciled_total", `kind="outbox",result="completed"`, 1)
+			slog.Info("Completed interrupted issue creation", "fingerprint", e.Fingerprint, "run_id", e.RunID, "issue_url", created.URL)
+			continue
+		}
+
+		if !releaseOutbox(ctx, key, data, e) {
+			continue
+		}
+		runs.interrupt(e.RunID, !jobs.pendingFingerprint(e.Fingerprint))
+		metrics.add("triage_reconciled_total", `kind="outbox",result="discarded"`, 1)
+		slog.Info("Discarded interrupted issue creation: the issue was not created", "fingerprint", e.Fingerprint, "run_id", e.RunID)
+	}
+}
+
+// reconcileDeadLetters looks for the issues of the dead letters of the
+// last outboxTTL, as a run may fail after GitHub created its issue, e.g.
+// when every attempt timed out. A dead letter whose issue is found, by the
+// run ID of its provenance footer, is completed with it and leaves the
+// dead letters.
+func reconcileDeadLetters(ctx context.Context) {
+	for _, run := range runs.deadLetters() {
+		if run.Fingerprint == "" || run.FinishedAt == nil || time.Since(*run.FinishedAt) > outboxTTL {
+			continue
+		}
+		target, err := parseRepoTarget(run.Repository)
+		if err != nil {
+			continue
+		}
+		issue, err := findGitHubIssueByFingerprint(ctx, target, run.Fingerprint, run.StartedAt.Add(-outboxLookback))
+		if err != nil {
+			slog.Warn("Reconciling dead letter failed", "run_id", run.ID, "error", err)
+			continue
+		}
+		// Only the run's own issue completes it.
+		if p, _ := parseProvenance(issue.GetBody()); issue == nil || p.RunID != run.ID {
+			continue
+		}
+		created := githubTrackerIssue(target, issue)
+		lifecycles.recordIssue(run.Repository, run.Fingerprint, created.URL)
+		runs.resolve(run.ID, created)
+		metrics.add("triage_reconciled_total", `kind="dead_letter",result="completed"`, 1)
+		slog.Info("Completed dead letter whose issue was created", "run_id", run.ID, "issue_url", created.URL)
+	}
+}
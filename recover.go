@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+func init() {
+	metrics.describe("triage_panics_total", "counter", "Panics recovered from, by where they happened (http, triage, job, tool or writer).")
+}
+
+// recoveredPanic logs and counts a recovered panic with its stack, and
+// returns it as an error.
+func recoveredPanic(ctx context.Context, where string, rec any) error {
+	metrics.add("triage_panics_total", labelSet("where", where), 1)
+	slog.ErrorContext(ctx, "Recovered from panic", "where", where, "panic", rec, "stack", string(debug.Stack()))
+	return fmt.Errorf("internal error: %v", rec)
+}
+
+// recoverPanics replies 500 to requests whose handler panics, instead of
+// dropping the connection, and logs the panic with the request ID.
+// Goroutines started by handlers recover on their own, as a panic there
+// would take the whole server down.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			recoveredPanic(r.Context(), "http", rec)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Entropy detector defaults: random base64 or alphanumeric strings of this
+// length score above English words, identifiers and hex digests (which top
+// out at 4 bits per character).
+const (
+	defaultEntropyThreshold = 4.2
+	defaultEntropyMinLength = 20
+	// entropyLengthMargin scales the threshold down for short tokens. A
+	// token of n characters has at most log2(n) bits per character, 4.32
+	// for 20, and a random one about 0.3 less, so short tokens are held to
+	// log2(n) - entropyLengthMargin. The default threshold applies from 26
+	// characters on.
+	entropyLengthMargin = 0.5
+)
+
+func init() {
+	metrics.describe("triage_secrets_redacted_total", "counter", "Secrets masked before text reached the LLM or the issue tracker, by detector.")
+}
+
+// RedactionConfig configures how secrets are masked in error logs before
+// they are sent to the LLM, stored with a run or written to an issue.
+type RedactionConfig struct {
+	// Disabled turns redaction off entirely.
+	Disabled bool `yaml:"disabled"`
+	// Patterns are detectors in addition to the built-in ones.
+	Patterns []RedactionPattern `yaml:"patterns"`
+	// EntropyThreshold is the bits per character above which a long token
+	// is treated as a secret (default 4.2). Tokens too short to reach it
+	// are held to log2(length) - 0.5 instead. A negative value turns the
+	// entropy detector off.
+	EntropyThreshold float64 `yaml:"entropy_threshold"`
+	// EntropyMinLength is the shortest token the entropy detector looks at
+	// (default 20).
+	EntropyMinLength int `yaml:"entropy_min_length"`
+}
+
+// RedactionPattern is a regular expression detector. If the pattern has a
+// group named "secret", only that group is masked, e.g.
+// `token=(?P<secret>\w+)` keeps "token=".
+type RedactionPattern struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+}
+
+type secretDetector struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// builtinSecretPatterns are the detectors that always run.
+var builtinSecretPatterns = []RedactionPattern{
+	{Name: "private_key", Pattern: `-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`},
+	{Name: "aws_access_key", Pattern: `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`},
+	{Name: "github_token", Pattern: `\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`},
+	{Name: "slack_token", Pattern: `\bxox[abposr]-[A-Za-z0-9-]{10,}`},
+	{Name: "jwt", Pattern: `\beyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{5,}`},
+	{Name: "url_credentials", Pattern: `\b[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:(?P<secret>[^@\s/]+)@`},
+	{Name: "bearer_token", Pattern: `(?i)\bbearer\s+(?P<secret>[A-Za-z0-9._~+/-]{16,}=*)`},
+	{Name: "credential", Pattern: `(?i)\b(?:password|passwd|pwd|secret|api[_-]?key|access[_-]?token|auth[_-]?token|client[_-]?secret)\b["']?\s*[:=]\s*["']?(?P<secret>[^\s"',;&\[][^\s"',;&]{3,})`},
+}
+
+// entropyTokenPattern finds candidate tokens for the entropy detector. "="
+// only ends a token, as base64 padding, so that in "key=value" the value is
+// judged on its own.
+var entropyTokenPattern = regexp.MustCompile(`[A-Za-z0-9+/_-]+={0,2}`)
+
+// redactor masks secrets. It is configured at startup from the redaction
+// config setting.
+type redactor struct {
+	disabled         bool
+	detectors        []secretDetector
+	entropyThreshold float64
+	entropyMinLength int
+}
+
+var secrets = newRedactor(RedactionConfig{})
+
+// compileRedaction validates the redaction config and builds the redactor.
+// Invalid patterns are left out.
+func compileRedaction(cfg RedactionConfig) (*redactor, []string) {
+	var problems []string
+	r := &redactor{
+		disabled:         cfg.Disabled,
+		entropyThreshold: cfg.EntropyThreshold,
+		entropyMinLength: cfg.EntropyMinLength,
+	}
+	if r.entropyThreshold == 0 {
+		r.entropyThreshold = defaultEntropyThreshold
+	}
+	if r.entropyMinLength <= 0 {
+		r.entropyMinLength = defaultEntropyMinLength
+	}
+
+	for _, p := range builtinSecretPatterns {
+		r.detectors = append(r.detectors, secretDetector{name: p.Name, pattern: regexp.MustCompile(p.Pattern)})
+	}
+	for i, p := range cfg.Patterns {
+		prefix := fmt.Sprintf("redaction.patterns[%d]", i)
+		if p.Name == "" {
+			problems = append(problems, prefix+".name must be set")
+			continue
+		}
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil || p.Pattern == "" {
+			problems = append(problems, fmt.Sprintf("%s.pattern: invalid regular expression %q", prefix, p.Pattern))
+			continue
+		}
+		r.detectors = append(r.detectors, secretDetector{name: p.Name, pattern: re})
+	}
+	return r, problems
+}
+
+func newRedactor(cfg RedactionConfig) *redactor {
+	r, _ := compileRedaction(cfg)
+	return r
+}
+
+// redactSecrets masks the secrets in s with the configured redactor.
+func redactSecrets(s string) string {
+	return secrets.redact(s)
+}
+
+// redact replaces every secret in s with "[REDACTED:<detector>]".
+func (r *redactor) redact(s string) string {
+	if r.disabled || s == "" {
+		return s
+	}
+	for _, d := range r.detectors {
+		s = d.redact(s)
+	}
+	if r.entropyThreshold > 0 {
+		s = entropyTokenPattern.ReplaceAllStringFunc(s, func(token string) string {
+			if len(token) < r.entropyMinLength || !hasLetterAndDigit(token) || shannonEntropy(token) < r.entropyThresholdFor(len(token)) {
+				return token
+			}
+			metrics.add("triage_secrets_redacted_total", labelSet("detector", "entropy"), 1)
+			return redactionMask("entropy")
+		})
+	}
+	return s
+}
+
+// entropyThresholdFor returns the entropy threshold of a token of n
+// characters: the configured one, lowered to what a random token that
+// short can reach.
+func (r *redactor) entropyThresholdFor(n int) float64 {
+	return min(r.entropyThreshold, math.Log2(float64(n))-entropyLengthMargin)
+}
+
+func (d secretDetector) redact(s string) string {
+	group := d.pattern.SubexpIndex("secret")
+	matches := d.pattern.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if group > 0 && m[2*group] >= 0 {
+			start, end = m[2*group], m[2*group+1]
+		}
+		sb.WriteString(s[last:start])
+		sb.WriteString(redactionMask(d.name))
+		last = end
+	}
+	sb.WriteString(s[last:])
+	metrics.add("triage_secrets_redacted_total", labelSet("detector", d.name), float64(len(matches)))
+	return sb.String()
+}
+
+func redactionMask(detector string) string {
+	return "[REDACTED:" + detector + "]"
+}
+
+// shannonEntropy returns the bits of entropy per character of s.
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	var h float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+func hasLetterAndDigit(s string) bool {
+	letter := strings.ContainsFunc(s, func(r rune) bool { return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' })
+	digit := strings.ContainsFunc(s, func(r rune) bool { return r >= '0' && r <= '9' })
+	return letter && digit
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+func init() {
+	metrics.describe("triage_relabeled_issues_total", "counter", "Agent-created issues updated by the relabel migration, by change (labels or assignees).")
+}
+
+// relabelRequest asks for the agent's issues in a repository to be brought
+// in line with the current labeling and assignment policies.
+type relabelRequest struct {
+	// Repository is "owner/repo"; empty means the default repository.
+	Repository string `json:"repository,omitempty"`
+	// DryRun reports the changes without making them.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// relabeledIssue is an issue the migration changed, or would change.
+type relabeledIssue struct {
+	Number    int      `json:"number"`
+	URL       string   `json:"url"`
+	Labels    []string `json:"labels,omitempty"`
+	Assignees []string `json:"assignees,omitempty"`
+}
+
+type relabelResult struct {
+	Repository string `json:"repository"`
+	DryRun     bool   `json:"dry_run,omitempty"`
+	// Scanned is how many issues had the provenance footer.
+	Scanned int              `json:"scanned"`
+	Updated []relabeledIssue `json:"updated"`
+	// Skipped are the issues whose error log couldn't be found, with why.
+	Skipped []string `json:"skipped,omitempty"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+func handleAdminRelabelIssues(w http.ResponseWriter, r *http.Request) {
+	var req relabelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if tracker.Name() != trackerGitHub {
+		http.Error(w, "Relabeling issues needs the github tracker", http.StatusBadRequest)
+		return
+	}
+	target := repoTarget{Owner: ghOwner, Repo: ghRepo}
+	if req.Repository != "" {
+		var err error
+		if target, err = parseRepoTarget(req.Repository); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid repository: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := relabelIssues(r.Context(), target, req.DryRun)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Relabeling issues failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Relabeled issues", "repository", target.String(), "dry_run", req.DryRun, "scanned", result.Scanned, "updated", len(result.Updated))
+	writeJSON(w, http.StatusOK, result)
+}
+
+// relabelIssues applies the current labeling and assignment policies to
+// the issues the agent filed in a repository, recognized by their
+// provenance footer. Each issue's error log is analyzed again, from its
+// run if it is still recorded and otherwise from the issue body, and the
+// issue gets the labels the analysis and the rules would give it now. An
+// issue nobody is assigned to is assigned to the users of the rules and
+// of CODEOWNERS, as allowed by the action policy and the auto_assign
+// flag. Labels and assignees are only added, never removed, so changes
+// made by people are kept.
+func relabelIssues(ctx context.Context, target repoTarget, dryRun bool) (relabelResult, error) {
+	result := relabelResult{Repository: target.String(), DryRun: dryRun, Updated: []relabeledIssue{}}
+	opts := &github.IssueListByRepoOptions{
+		State:       "all",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		var issues []*github.Issue
+		var resp *github.Response
+		err := retryGitHub(ctx, func() (*github.Response, error) {
+			var err error
+			issues, resp, err = ghClient.Issues.ListByRepo(ctx, target.Owner, target.Repo, opts)
+			return resp, err
+		})
+		if err != nil {
+			return result, err
+		}
+		for _, issue := range issues {
+			p, ok := parseProvenance(issue.GetBody())
+			if issue.IsPullRequest() || !ok {
+				continue
+			}
+			result.Scanned++
+
+			analysis, errorLog, ok := issueAnalysis(ctx, p, issue.GetBody())
+			if !ok {
+				result.Skipped = append(result.Skipped, fmt.Sprintf("#%d: no error log found in its run or body", issue.GetNumber()))
+				continue
+			}
+			change, err := relabelIssue(ctx, target, issue, analysis, errorLog, dryRun)
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("#%d: %v", issue.GetNumber(), err))
+				continue
+			}
+			if len(change.Labels) > 0 || len(change.Assignees) > 0 {
+				result.Updated = append(result.Updated, change)
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return result, nil
+}
+
+// issueAnalysis analyzes the error log an issue was filed for: the log of
+// its run, or else the code block of its body with the issue's
+// fingerprint, as runs are eventually dropped.
+func issueAnalysis(ctx context.Context, p Provenance, body string) (*LogAnalysis, string, bool) {
+	if run, ok := runs.get(p.RunID); ok && run.ErrorLog != "" {
+		analysis := analyzeErrorLog(run.ErrorLog)
+		translateAnalysis(ctx, analysis)
+		return analysis, run.ErrorLog, true
+	}
+	for _, m := range fencedBlock.FindAllStringSubmatch(body, -1) {
+		errorLog := sanitizeText(normalizeLogText(m[1]))
+		if errorLog == "" {
+			continue
+		}
+		analysis := analyzeErrorLog(errorLog)
+		translateAnalysis(ctx, analysis)
+		if analysis.Fingerprint == p.Fingerprint {
+			return analysis, errorLog, true
+		}
+	}
+	return nil, "", false
+}
+
+// relabelIssue adds the labels and assignees an issue is missing under the
+// current policies.
+func relabelIssue(ctx context.Context, target repoTarget, issue *github.Issue, analysis *LogAnalysis, errorLog string, dryRun bool) (relabeledIssue, error) {
+	change := relabeledIssue{Number: issue.GetNumber(), URL: issue.GetHTMLURL()}
+
+	classifySeverity(errorLog, analysis)
+	labels, users, _, _ := ruleActions(matchRules(analysis, target), analysis)
+	labels = appendNew(slices.Clone(analysis.Labels), labels...)
+
+	var current []string
+	for _, l := range issue.Labels {
+		current = append(current, strings.ToLower(l.GetName()))
+	}
+	for _, label := range labels {
+		if slices.Contains(current, strings.ToLower(label)) || checkAction(target, actionAddLabels, []string{label}) != nil {
+			continue
+		}
+		change.Labels = append(change.Labels, label)
+	}
+
+	if len(issue.Assignees) == 0 && checkAction(target, actionAssign, nil) == nil && flags.allows(ctx, flagAutoAssign, target) {
+		if files := appFrameFiles(analysis); len(files) > 0 {
+			owned, err := resolveCodeOwners(ctx, target, files)
+			if err != nil {
+				return change, fmt.Errorf("reading CODEOWNERS: %w", err)
+			}
+			owners, _ := splitOwners(owned)
+			users = appendNew(users, owners...)
+		}
+		change.Assignees = users
+	}
+
+	if dryRun {
+		return change, nil
+	}
+	if len(change.Labels) > 0 {
+		if res, _ := writer.submitAndWait(&githubIntent{Kind: intentAddLabels, Target: target, IssueNumber: change.Number, Labels: change.Labels}); res.Err != nil {
+			return change, res.Err
+		}
+		metrics.add("triage_relabeled_issues_total", `change="labels"`, 1)
+	}
+	if len(change.Assignees) > 0 {
+		if res, _ := writer.submitAndWait(&githubIntent{Kind: intentAssign, Target: target, IssueNumber: change.Number, Labels: change.Assignees}); res.Err != nil {
+			return change, res.Err
+		}
+		metrics.add("triage_relabeled_issues_total", `change="assignees"`, 1)
+	}
+	return change, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// allowedRepos lists the repositories callers may target with the
+	// "repository" request field. Entries are "owner/repo" or "owner/*".
+	allowedRepos []string
+	// repoOverrideToken, when set, must be presented as a bearer token to
+	// override the target repository.
+	repoOverrideToken string
+)
+
+func parseAllowedRepos(s string) ([]string, error) {
+	var repos []string
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, err := parseRepoTarget(entry); err != nil {
+			return nil, err
+		}
+		repos = append(repos, strings.ToLower(entry))
+	}
+	return repos, nil
+}
+
+func isRepoAllowed(target repoTarget) bool {
+	return repoMatches(allowedRepos, target)
+}
+
+// repoMatches reports whether the repository matches one of the
+// lower-cased "owner/repo" or "owner/*" entries.
+func repoMatches(entries []string, target repoTarget) bool {
+	full := strings.ToLower(target.String())
+	for _, entry := range entries {
+		if entry == full || entry == strings.ToLower(target.Owner)+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveRepoOverride validates a per-request repository override. It
+// returns the HTTP status to reply with when the override is refused.
+func resolveRepoOverride(r *http.Request, repository string) (repoTarget, int, error) {
+	target, err := parseRepoTarget(repository)
+	if err != nil {
+		return repoTarget{}, http.StatusBadRequest, fmt.Errorf("invalid repository: %w", err)
+	}
+
+	if repoOverrideToken != "" {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(repoOverrideToken)) != 1 {
+			return repoTarget{}, http.StatusUnauthorized, fmt.Errorf("a valid bearer token is required to override the repository")
+		}
+	}
+
+	if !isRepoAllowed(target) {
+		return repoTarget{}, http.StatusForbidden, fmt.Errorf("repository %s is not in the allowlist", target)
+	}
+
+	return target, 0, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultReportWindow is the period a usage report covers by default.
+const defaultReportWindow = 30 * 24 * time.Hour
+
+// rejectionLabels mark an LLM-created issue that people closed as not
+// worth having. The GitHub search syntax matches any of them.
+const rejectionLabels = "invalid,wontfix,duplicate"
+
+// teams maps a team name to the "owner/repo" or "owner/*" entries it owns,
+// for the usage report.
+var teams map[string][]string
+
+// UsageStats aggregates triage activity for a repository or a team.
+type UsageStats struct {
+	Runs          int     `json:"runs"`
+	Failed        int     `json:"failed"`
+	IssuesCreated int     `json:"issues_created"`
+	Duplicates    int     `json:"duplicates"`
+	DuplicateRate float64 `json:"duplicate_rate"`
+	// MeanTimeToIssue is the mean time from an error arriving to its
+	// issue being created, in seconds.
+	MeanTimeToIssue float64 `json:"mean_time_to_issue_seconds"`
+	// LLMIssues and RejectedIssues count issues labelled "llm created" in
+	// the period on GitHub, and those closed as invalid, wontfix or
+	// duplicate. AcceptanceRate is the share not rejected.
+	LLMIssues      int      `json:"llm_issues"`
+	RejectedIssues int      `json:"rejected_issues"`
+	AcceptanceRate *float64 `json:"acceptance_rate,omitempty"`
+	Error          string   `json:"error,omitempty"`
+	// Tokens is the LLM token usage of the runs.
+	Tokens int `json:"tokens"`
+
+	timeToIssue time.Duration
+}
+
+type UsageReport struct {
+	Since        time.Time              `json:"since"`
+	Repositories map[string]*UsageStats `json:"repositories"`
+	Teams        map[string]*UsageStats `json:"teams"`
+	Total        *UsageStats            `json:"total"`
+}
+
+func (s *UsageStats) addRun(run TriageRun) {
+	s.Runs++
+	if run.Usage != nil {
+		s.Tokens += run.Usage.TotalTokens
+	}
+	switch {
+	case run.Status == runStatusFailed:
+		s.Failed++
+	case run.Outcome == runOutcomeCreated:
+		s.IssuesCreated++
+		if run.FinishedAt != nil {
+			s.timeToIssue += run.FinishedAt.Sub(run.StartedAt)
+		}
+	case run.Outcome == runOutcomeDuplicate:
+		s.Duplicates++
+	}
+}
+
+func (s *UsageStats) merge(o *UsageStats) {
+	s.Runs += o.Runs
+	s.Failed += o.Failed
+	s.IssuesCreated += o.IssuesCreated
+	s.Duplicates += o.Duplicates
+	s.Tokens += o.Tokens
+	s.timeToIssue += o.timeToIssue
+	s.LLMIssues += o.LLMIssues
+	s.RejectedIssues += o.RejectedIssues
+}
+
+func (s *UsageStats) finalize() {
+	if n := s.IssuesCreated + s.Duplicates; n > 0 {
+		s.DuplicateRate = float64(s.Duplicates) / float64(n)
+	}
+	if s.IssuesCreated > 0 {
+		s.MeanTimeToIssue = (s.timeToIssue / time.Duration(s.IssuesCreated)).Seconds()
+	}
+	if s.LLMIssues > 0 {
+		rate := 1 - float64(s.RejectedIssues)/float64(s.LLMIssues)
+		s.AcceptanceRate = &rate
+	}
+}
+
+// buildUsageReport aggregates the runs since the given time per repository
+// and team, and asks GitHub how the LLM-created issues of each repository
+// were received.
+func buildUsageReport(ctx context.Context, since time.Time) *UsageReport {
+	report := &UsageReport{
+		Since:        since,
+		Repositories: make(map[string]*UsageStats),
+		Teams:        make(map[string]*UsageStats),
+		Total:        &UsageStats{},
+	}
+
+	for _, run := range runs.list("") {
+		if run.StartedAt.Before(since) || run.Status == runStatusRunning {
+			continue
+		}
+		stats, ok := report.Repositories[run.Repository]
+		if !ok {
+			stats = &UsageStats{}
+			report.Repositories[run.Repository] = stats
+		}
+		stats.addRun(run)
+	}
+
+	for repo, stats := range report.Repositories {
+		target, err := parseRepoTarget(repo)
+		if err != nil {
+			continue
+		}
+		if err := countLLMIssues(ctx, target, since, stats); err != nil {
+			stats.Error = err.Error()
+		}
+	}
+
+	repos := make([]string, 0, len(report.Repositories))
+	for repo := range report.Repositories {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	for _, repo := range repos {
+		stats := report.Repositories[repo]
+		report.Total.merge(stats)
+
+		target, _ := parseRepoTarget(repo)
+		for team, entries := range teams {
+			if !repoMatches(entries, target) {
+				continue
+			}
+			if report.Teams[team] == nil {
+				report.Teams[team] = &UsageStats{}
+			}
+			report.Teams[team].merge(stats)
+		}
+	}
+
+	for _, stats := range report.Repositories {
+		stats.finalize()
+	}
+	for _, stats := range report.Teams {
+		stats.finalize()
+	}
+	report.Total.finalize()
+	return report
+}
+
+func countLLMIssues(ctx context.Context, target repoTarget, since time.Time, stats *UsageStats) error {
+	base := fmt.Sprintf(`repo:%s is:issue label:"llm created" created:>=%s`, target, since.Format("2006-01-02"))
+
+	all, _, err := ghClient.Search.Issues(ctx, base, nil)
+	if err != nil {
+		return err
+	}
+	rejected, _, err := ghClient.Search.Issues(ctx, base+" is:closed label:"+rejectionLabels, nil)
+	if err != nil {
+		return err
+	}
+	stats.LLMIssues = all.GetTotal()
+	stats.RejectedIssues = rejected.GetTotal()
+	return nil
+}
+
+// handleAdminUsageReport serves the org-level usage report. The period
+// is set with "since" as a duration, e.g. "?since=168h".
+func handleAdminUsageReport(w http.ResponseWriter, r *http.Request) {
+	window := defaultReportWindow
+	if s := r.URL.Query().Get("since"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid since %q, expected a duration such as 168h", s), http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+
+	writeJSON(w, http.StatusOK, buildUsageReport(r.Context(), time.Now().Add(-window)))
+}
+
+// parseTeams validates and lower-cases the team repository entries.
+func parseTeams(cfg map[string][]string) (map[string][]string, error) {
+	out := make(map[string][]string, len(cfg))
+	for team, entries := range cfg {
+		repos, err := parseAllowedRepos(strings.Join(entries, ","))
+		if err != nil {
+			return nil, fmt.Errorf("team %s: %w", team, err)
+		}
+		out[team] = repos
+	}
+	return out, nil
+}
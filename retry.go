@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts = 4
+	defaultRetryBackoff     = time.Minute
+	defaultRetryMaxBackoff  = time.Hour
+	// retryPollInterval is how often the store is checked for runs due
+	// for a retry.
+	retryPollInterval = 15 * time.Second
+	// maxDueRuns caps how many due runs are retried per poll; the rest
+	// wait for the next.
+	maxDueRuns = 20
+)
+
+func init() {
+	metrics.describe("triage_run_retries_total", "counter", "Automatic retries of failed runs, by result (succeeded or failed).")
+	metrics.describe("triage_dead_letters_total", "counter", "Runs that failed on their last attempt and became dead letters.")
+}
+
+// RetryConfig sets how failed runs, e.g. after an LLM or GitHub outage,
+// are retried before they become dead letters.
+type RetryConfig struct {
+	// MaxAttempts is how many times a run is tried in all (default 4). 1
+	// turns automatic retries off.
+	MaxAttempts int `yaml:"max_attempts"`
+	// Backoff is the wait before the first retry, doubled for each one
+	// after it (default 1m).
+	Backoff string `yaml:"backoff"`
+	// MaxBackoff caps the wait (default 1h).
+	MaxBackoff string `yaml:"max_backoff"`
+}
+
+// retryPolicy is the resolved RetryConfig.
+type retryPolicy struct {
+	maxAttempts         int
+	backoff, maxBackoff time.Duration
+}
+
+var retries = retryPolicy{maxAttempts: defaultRetryMaxAttempts, backoff: defaultRetryBackoff, maxBackoff: defaultRetryMaxBackoff}
+
+// configRetry returns the retry settings, with RETRY_MAX_ATTEMPTS and
+// RETRY_BACKOFF taking precedence over the config file. An unparsable
+// RETRY_MAX_ATTEMPTS becomes -1, which validateRetry reports.
+func configRetry(cfg *Config) RetryConfig {
+	out := cfg.Retry
+	if v := os.Getenv("RETRY_MAX_ATTEMPTS"); v != "" {
+		var err error
+		if out.MaxAttempts, err = strconv.Atoi(v); err != nil {
+			out.MaxAttempts = -1
+		}
+	}
+	if out.MaxAttempts == 0 {
+		out.MaxAttempts = defaultRetryMaxAttempts
+	}
+	out.Backoff = envOr("RETRY_BACKOFF", firstNonEmpty(out.Backoff, defaultRetryBackoff.String()))
+	out.MaxBackoff = firstNonEmpty(out.MaxBackoff, defaultRetryMaxBackoff.String())
+	return out
+}
+
+func validateRetry(rc RetryConfig) []string {
+	var problems []string
+	if rc.MaxAttempts < 1 {
+		problems = append(problems, "retry.max_attempts (or RETRY_MAX_ATTEMPTS): must be at least 1")
+	}
+	if d, err := time.ParseDuration(rc.Backoff); err != nil || d <= 0 {
+		problems = append(problems, fmt.Sprintf("retry.backoff (or RETRY_BACKOFF): invalid duration %q", rc.Backoff))
+	}
+	if d, err := time.ParseDuration(rc.MaxBackoff); err != nil || d <= 0 {
+		problems = append(problems, fmt.Sprintf("retry.max_backoff: invalid duration %q", rc.MaxBackoff))
+	}
+	return problems
+}
+
+func newRetryPolicy(rc RetryConfig) retryPolicy {
+	p := retryPolicy{maxAttempts: rc.MaxAttempts}
+	p.backoff, _ = time.ParseDuration(rc.Backoff)
+	p.maxBackoff, _ = time.ParseDuration(rc.MaxBackoff)
+	return p
+}
+
+// next returns when a run that failed on the given attempt is retried, or
+// nil if it was its last.
+func (p retryPolicy) next(attempts int, failedAt time.Time) *time.Time {
+	if attempts >= p.maxAttempts {
+		return nil
+	}
+	wait := p.backoff
+	for i := 1; i < attempts && wait < p.maxBackoff; i++ {
+		wait *= 2
+	}
+	at := failedAt.Add(min(wait, p.maxBackoff))
+	return &at
+}
+
+// startRetrier retries failed runs as they become due until ctx is done.
+// The schedule is kept on the runs in the store, so retries survive
+// restarts.
+func startRetrier(ctx context.Context) {
+	if retries.maxAttempts <= 1 {
+		return
+	}
+	slog.Info("Automatic retries enabled", "max_attempts", retries.maxAttempts, "backoff", retries.backoff, "max_backoff", retries.maxBackoff)
+	go func() {
+		ticker := time.NewTicker(retryPollInterval)
+		defer ticker.Stop()
+		for {
+			retryDueRuns(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// retryDueRuns retries the failed runs whose retry is due, one at a time.
+func retryDueRuns(ctx context.Context) {
+	for _, run := range runs.due(maxDueRuns) {
+		if _, err := retryRun(ctx, run.ID); err != nil {
+			if !errors.Is(err, errRunNotRetryable) {
+				metrics.add("triage_run_retries_total", labelSet("result", "failed"), 1)
+			}
+			continue
+		}
+		metrics.add("triage_run_retries_total", labelSet("result", "succeeded"), 1)
+	}
+}
+
+// errRunNotRetryable is returned by retryRun for runs that are not failed
+// or are already being retried.
+var errRunNotRetryable = errors.New("run is not failed or is already being retried")
+
+// retryRun re-runs a failed run against the repository it originally
+// targeted, with the analysis it was started with.
+func retryRun(ctx context.Context, id string) (APIResponse, error) {
+	existing, ok := runs.get(id)
+	if !ok || existing.Status != runStatusFailed {
+		return APIResponse{}, errRunNotRetryable
+	}
+	target, err := parseRepoTarget(existing.Repository)
+	if err != nil {
+		return APIResponse{}, fmt.Errorf("run has an invalid repository: %w", err)
+	}
+
+	run, ok := runs.restart(existing.ID)
+	if !ok {
+		return APIResponse{}, errRunNotRetryable
+	}
+	slog.InfoContext(ctx, "Retrying run", "run_id", run.ID, "attempt", run.Attempts)
+
+	var analysis *LogAnalysis
+	if in := run.Input; in != nil && in.Analysis != nil {
+		// The retrier runs outside any request: its calls belong to the
+		// run's.
+		if requestID(ctx) == "" {
+			ctx = contextWithClientTag(contextWithRequestID(ctx, in.RequestID), in.ClientTag)
+		}
+		analysis = in.Analysis
+	} else {
+		// Runs recorded before their input was kept.
+		analysis = analyzeErrorLog(run.ErrorLog)
+		translateAnalysis(ctx, analysis)
+	}
+	if state, ok := lifecycles.get(target.String(), analysis.Fingerprint); ok {
+		analysis.Lifecycle = &state
+	}
+	return executeTriage(ctx, run, newToolSession(target, false), analysis)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+)
+
+// RouteRule sends the errors it matches to a repository. Every field that
+// is set must match.
+type RouteRule struct {
+	errorMatch `yaml:",inline"`
+	// Log is a regular expression matched against the whole submitted
+	// log, e.g. a logger name or a path prefix.
+	Log string `yaml:"log"`
+	// Repository receives the matching errors ("owner/repo").
+	Repository string `yaml:"repository"`
+
+	logPattern *regexp.Regexp
+	target     repoTarget
+}
+
+// routeRules come from the routes config setting, in order; the first
+// matching rule wins.
+var routeRules []RouteRule
+
+// compileRouteRules validates the rules and compiles their patterns.
+// Invalid rules are left out.
+func compileRouteRules(rules []RouteRule) ([]RouteRule, []string) {
+	var out []RouteRule
+	var problems []string
+	for i, rule := range rules {
+		prefix := fmt.Sprintf("routes[%d]", i)
+		n := len(problems)
+		if rule.Log != "" {
+			p, err := regexp.Compile(rule.Log)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s.log: invalid regular expression: %v", prefix, err))
+			}
+			rule.logPattern = p
+		}
+		switch {
+		case rule.Service != "" || rule.Category != "" || rule.Error != "":
+			problems = append(problems, rule.compile(prefix)...)
+		case rule.Log == "":
+			problems = append(problems, prefix+": needs at least one of service, category, error or log")
+		}
+		target, err := parseRepoTarget(rule.Repository)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s.repository: %v", prefix, err))
+		}
+		rule.target = target
+		if len(problems) == n {
+			out = append(out, rule)
+		}
+	}
+	return out, problems
+}
+
+func (r RouteRule) matches(errorLog string, analysis *LogAnalysis) bool {
+	if r.logPattern != nil && !r.logPattern.MatchString(errorLog) {
+		return false
+	}
+	if r.Service == "" && r.Category == "" && r.Error == "" {
+		return true
+	}
+	return r.errorMatch.matches(analysis)
+}
+
+// routeTarget picks the repository for a log when neither the request nor
+// the service registry names one: the first matching route, then the
+// external dependencies repository for dependency failures, then the
+// default repository.
+func routeTarget(errorLog string, analysis *LogAnalysis) repoTarget {
+	for i, rule := range routeRules {
+		if rule.matches(errorLog, analysis) {
+			slog.Info("Routing by rule", "repository", rule.target.String(), "route", i)
+			return rule.target
+		}
+	}
+	if analysis.Category == categoryDependency && externalDepsTarget != nil {
+		slog.Info("Routing dependency failure", "repository", externalDepsTarget.String())
+		return *externalDepsTarget
+	}
+	return repoTarget{Owner: ghOwner, Repo: ghRepo}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+)
+
+func init() {
+	metrics.describe("triage_rule_matches_total", "counter", "Post-classification rules that matched an error, by rule.")
+}
+
+// Rule is a post-classification action policy: when its CEL expression
+// holds for an analysed error, its actions are taken on the issue the run
+// created or found. Notifications go through the Slack bot token and the
+// PagerDuty routing key, which are secrets and only read from
+// SLACK_BOT_TOKEN and PAGERDUTY_ROUTING_KEY.
+type Rule struct {
+	Name string `yaml:"name"`
+	// When is a CEL expression over severity, service, category,
+	// error_type, message, format, fingerprint, repository, labels and
+	// metadata, e.g. `severity == "critical" && service == "payments"`.
+	When string `yaml:"when"`
+	// Notify are Slack channels, e.g. "#payments-oncall", or "pagerduty".
+	// The notifications setting decides when each is notified.
+	Notify []string `yaml:"notify"`
+	// Assign are GitHub users ("@alice") or teams ("@org/payments"). Teams
+	// can't be assigned issues, so they are mentioned in a comment.
+	// "@oncall" is whoever is on call for the error's service.
+	Assign []string `yaml:"assign"`
+	// Labels are added to the issue.
+	Labels []string `yaml:"labels"`
+}
+
+// compiledRule is a rule with its expression compiled.
+type compiledRule struct {
+	Rule
+	program cel.Program
+}
+
+// rules come from the rules config setting.
+var rules []compiledRule
+
+// ruleEnv declares the variables rule expressions can use.
+func ruleEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("severity", cel.StringType),
+		cel.Variable("service", cel.StringType),
+		cel.Variable("category", cel.StringType),
+		cel.Variable("error_type", cel.StringType),
+		cel.Variable("message", cel.StringType),
+		cel.Variable("format", cel.StringType),
+		cel.Variable("fingerprint", cel.StringType),
+		cel.Variable("repository", cel.StringType),
+		cel.Variable("labels", cel.ListType(cel.StringType)),
+		cel.Variable("metadata", cel.MapType(cel.StringType, cel.StringType)),
+	)
+}
+
+// compileRules validates the rules and compiles their expressions. Invalid
+// rules are left out.
+func compileRules(in []Rule) ([]compiledRule, []string) {
+	if len(in) == 0 {
+		return nil, nil
+	}
+	env, err := ruleEnv()
+	if err != nil {
+		return nil, []string{fmt.Sprintf("rules: %v", err)}
+	}
+
+	var out []compiledRule
+	var problems []string
+	names := make(map[string]bool)
+	for i, rule := range in {
+		prefix := fmt.Sprintf("rules[%d]", i)
+		n := len(problems)
+		switch {
+		case rule.Name == "":
+			problems = append(problems, prefix+".name must be set")
+		case names[rule.Name]:
+			problems = append(problems, fmt.Sprintf("%s.name: duplicate rule %q", prefix, rule.Name))
+		}
+		names[rule.Name] = true
+		if len(rule.Notify) == 0 && len(rule.Assign) == 0 && len(rule.Labels) == 0 {
+			problems = append(problems, prefix+": needs at least one of notify, assign or labels")
+		}
+		for j, channel := range rule.Notify {
+			problems = append(problems, validateNotifyChannel(fmt.Sprintf("%s.notify[%d]", prefix, j), channel)...)
+		}
+		for j, who := range rule.Assign {
+			if !strings.HasPrefix(who, "@") || len(who) < 2 {
+				problems = append(problems, fmt.Sprintf("%s.assign[%d]: %q must be a GitHub user or team, e.g. @alice or @org/payments", prefix, j, who))
+			}
+		}
+
+		ast, iss := env.Compile(rule.When)
+		if rule.When == "" {
+			problems = append(problems, prefix+".when must be set")
+		} else if iss.Err() != nil {
+			problems = append(problems, fmt.Sprintf("%s.when: %v", prefix, iss.Err()))
+		} else if ast.OutputType() != cel.BoolType {
+			problems = append(problems, fmt.Sprintf("%s.when: must be a boolean expression, not %s", prefix, ast.OutputType()))
+		}
+		if len(problems) > n {
+			continue
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s.when: %v", prefix, err))
+			continue
+		}
+		out = append(out, compiledRule{Rule: rule, program: program})
+	}
+	return out, problems
+}
+
+// ruleVars returns the values of the rule variables for an analysed error.
+func ruleVars(analysis *LogAnalysis, target repoTarget) map[string]any {
+	metadata := analysis.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	labels := analysis.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+	return map[string]any{
+		"severity":    metadata["severity"],
+		"service":     analysisService(analysis),
+		"category":    analysis.Category,
+		"error_type":  analysis.ErrorType,
+		"message":     analysis.Message,
+		"format":      analysis.Format,
+		"fingerprint": analysis.Fingerprint,
+		"repository":  target.String(),
+		"labels":      labels,
+		"metadata":    metadata,
+	}
+}
+
+// matchRules returns the rules whose expression holds for the error. A
+// rule that fails to evaluate, e.g. on a missing metadata key, doesn't
+// match.
+func matchRules(analysis *LogAnalysis, target repoTarget) []Rule {
+	if len(rules) == 0 {
+		return nil
+	}
+	vars := ruleVars(analysis, target)
+	var matched []Rule
+	for _, rule := range rules {
+		out, _, err := rule.program.Eval(vars)
+		if err != nil {
+			slog.Warn("Rule failed to evaluate", "rule", rule.Name, "fingerprint", analysis.Fingerprint, "error", err)
+			continue
+		}
+		if ok, _ := out.Value().(bool); ok {
+			metrics.add("triage_rule_matches_total", labelSet("rule", rule.Name), 1)
+			matched = append(matched, rule.Rule)
+		}
+	}
+	return matched
+}
+
+// applyRules takes the actions of the matched rules on the result of a
+// run. Labels and assignees need an issue; notifications are sent either
+// way, as their channel policies allow. Failures are logged: the run itself succeeded.
+func applyRules(ctx context.Context, matched []Rule, target repoTarget, analysis *LogAnalysis, result TriageResult) {
+	labels, users, teams, channels := ruleActions(matched, analysis)
+	var names []string
+	for _, rule := range matched {
+		names = append(names, rule.Name)
+	}
+	slog.InfoContext(ctx, "Rules matched", "rules", names, "fingerprint", analysis.Fingerprint)
+
+	if (len(users) > 0 || len(teams) > 0) && !flags.allows(ctx, flagAutoAssign, target) {
+		users, teams = nil, nil
+	}
+	if number := result.IssueNumber; number > 0 {
+		if len(labels) > 0 {
+			writer.submit(&githubIntent{Kind: intentAddLabels, Target: target, IssueNumber: number, Labels: labels, RunID: runID(ctx)})
+		}
+		if len(users) > 0 {
+			writer.submit(&githubIntent{Kind: intentAssign, Target: target, IssueNumber: number, Labels: users, RunID: runID(ctx)})
+		}
+		if len(teams) > 0 {
+			body := fmt.Sprintf("%s: this error matches the %s rule.", strings.Join(teams, " "), strings.Join(names, ", "))
+			writer.submit(&githubIntent{Kind: intentComment, Target: target, IssueNumber: number, Body: body, RunID: runID(ctx)})
+		}
+	}
+
+	if len(channels) == 0 {
+		return
+	}
+	for _, channel := range channels {
+		notify(ctx, channel, analysis, target, result)
+	}
+}
+
+// ruleActions collects the labels, assignees and notification channels of
+// the matched rules. Assignees are split into users and teams, with
+// @oncall replaced by the service's current on-call.
+func ruleActions(matched []Rule, analysis *LogAnalysis) (labels, users, teams, channels []string) {
+	for _, rule := range matched {
+		labels = appendNew(labels, rule.Labels...)
+		channels = appendNew(channels, rule.Notify...)
+		for _, who := range rule.Assign {
+			if who == onCallMention {
+				for _, handle := range strings.Split(analysis.Metadata["oncall"], ", ") {
+					if handle != "" {
+						users = appendNew(users, strings.TrimPrefix(handle, "@"))
+					}
+				}
+				continue
+			}
+			if strings.Contains(who, "/") {
+				teams = appendNew(teams, who)
+			} else {
+				users = appendNew(users, strings.TrimPrefix(who, "@"))
+			}
+		}
+	}
+	return labels, users, teams, channels
+}
+
+// appendNew appends the values not already in s.
+func appendNew(s []string, values ...string) []string {
+	for _, v := range values {
+		if !slices.Contains(s, v) {
+			s = append(s, v)
+		}
+	}
+	return s
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// errorMatch selects errors by service, category and message. Every field
+// that is set must match.
+type errorMatch struct {
+	// Service matches the service named in the request (or an alert's
+	// service label).
+	Service string `yaml:"service"`
+	// Category matches the analysis category, e.g. "dependency".
+	Category string `yaml:"category"`
+	// Error is a regular expression matched against the error type and
+	// message, e.g. "(?i)connection pool exhausted".
+	Error string `yaml:"error"`
+
+	errorPattern *regexp.Regexp
+}
+
+// compile validates the matcher and compiles its pattern.
+func (m *errorMatch) compile(prefix string) []string {
+	var problems []string
+	if m.Service == "" && m.Category == "" && m.Error == "" {
+		problems = append(problems, prefix+": needs at least one of service, category or error")
+	}
+	if m.Error != "" {
+		p, err := regexp.Compile(m.Error)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s.error: invalid regular expression: %v", prefix, err))
+		}
+		m.errorPattern = p
+	}
+	return problems
+}
+
+func (m errorMatch) matches(analysis *LogAnalysis) bool {
+	if m.Service != "" && m.Service != analysisService(analysis) {
+		return false
+	}
+	if m.Category != "" && m.Category != analysis.Category {
+		return false
+	}
+	if m.errorPattern != nil && !m.errorPattern.MatchString(analysis.ErrorType+": "+analysis.Message) {
+		return false
+	}
+	return true
+}
+
+// analysisService returns the service an analysed error came from, if
+// known.
+func analysisService(analysis *LogAnalysis) string {
+	return firstNonEmpty(analysis.Metadata["service"], analysis.Metadata["label_service"])
+}
+
+// RunbookRule links a runbook to the errors it matches.
+type RunbookRule struct {
+	errorMatch `yaml:",inline"`
+	// URL is the runbook link. {service}, {category} and {error_type} are
+	// replaced with the matching error's values.
+	URL string `yaml:"url"`
+}
+
+// runbookRules come from the runbooks config setting.
+var runbookRules []RunbookRule
+
+// compileRunbookRules validates the rules and compiles their patterns.
+// Invalid rules are left out.
+func compileRunbookRules(rules []RunbookRule) ([]RunbookRule, []string) {
+	var out []RunbookRule
+	var problems []string
+	for i, rule := range rules {
+		prefix := fmt.Sprintf("runbooks[%d]", i)
+		n := len(problems)
+		problems = append(problems, rule.compile(prefix)...)
+		if u, err := url.Parse(rule.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			problems = append(problems, fmt.Sprintf("%s.url: %q is not an http(s) URL", prefix, rule.URL))
+		}
+		if len(problems) == n {
+			out = append(out, rule)
+		}
+	}
+	return out, problems
+}
+
+func (r RunbookRule) link(analysis *LogAnalysis) string {
+	return strings.NewReplacer(
+		"{service}", url.PathEscape(analysisService(analysis)),
+		"{category}", url.PathEscape(analysis.Category),
+		"{error_type}", url.PathEscape(analysis.ErrorType),
+	).Replace(r.URL)
+}
+
+// linkRunbooks adds the runbooks of every matching rule to the analysis'
+// "runbooks" metadata, after any the service registry already listed.
+func linkRunbooks(analysis *LogAnalysis) {
+	var links []string
+	if existing := analysis.Metadata["runbooks"]; existing != "" {
+		links = strings.Split(existing, ", ")
+	}
+	for _, rule := range runbookRules {
+		if !rule.matches(analysis) {
+			continue
+		}
+		if link := rule.link(analysis); !slices.Contains(links, link) {
+			links = append(links, link)
+		}
+	}
+	if len(links) > 0 {
+		analysis.setMetadata("runbooks", strings.Join(links, ", "))
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxRuns is how many runs the in-memory store keeps before dropping the
+// oldest.
+const maxRuns = 1000
+
+const (
+	runStatusRunning   = "running"
+	runStatusSucceeded = "succeeded"
+	runStatusFailed    = "failed"
+)
+
+// Outcomes of a succeeded run.
+const (
+	runOutcomeCreated   = "created"
+	runOutcomeDuplicate = "duplicate"
+	runOutcomeNone      = "none"
+)
+
+// TriageRun is the record of one pipeline run, kept for the admin API.
+// Failed runs double as the dead-letter list and can be retried.
+type TriageRun struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	Outcome     string `json:"outcome,omitempty"`
+	Repository  string `json:"repository"`
+	Fingerprint string `json:"fingerprint"`
+	ErrorLog    string `json:"error_log"`
+	// LogSHA256 is the hash of ErrorLog.
+	LogSHA256 string `json:"log_sha256,omitempty"`
+	// Source is the ingestion endpoint the log came in through.
+	Source     string     `json:"source,omitempty"`
+	Output     string     `json:"output,omitempty"`
+	IssueURL   string     `json:"issue_url,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Attempts   int        `json:"attempts"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// NextRetryAt is when a failed run is retried automatically. Failed
+	// runs without one are dead letters.
+	NextRetryAt *time.Time `json:"next_retry_at,omitempty"`
+	// Input is what the run was started with, for retries. Its error log
+	// is ErrorLog.
+	Input *triageInput `json:"input,omitempty"`
+
+	// Usage is the token usage of the run's model calls, and Iterations
+	// breaks it down by iteration of the agent loop.
+	Usage      *TokenUsage    `json:"usage,omitempty"`
+	Iterations []RunIteration `json:"iterations,omitempty"`
+	// ToolCalls are the tool calls of the agent, with their arguments and
+	// results, and Result is the decision the run ended with, including
+	// the number of the issue it created or found.
+	ToolCalls []ToolCall    `json:"tool_calls,omitempty"`
+	Result    *TriageResult `json:"result,omitempty"`
+	// Canary is the dry run of the run through the canary model, when one
+	// is configured and the run was sampled.
+	Canary *CanaryRun `json:"canary,omitempty"`
+}
+
+// runRegistry records runs in the store.
+type runRegistry struct {
+	mu sync.Mutex
+}
+
+var runs = &runRegistry{}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (reg *runRegistry) start(in triageInput) TriageRun {
+	run := TriageRun{
+		ID:          newID(),
+		Status:      runStatusRunning,
+		Repository:  in.Target.String(),
+		Fingerprint: in.Analysis.Fingerprint,
+		ErrorLog:    in.ErrorLog,
+		LogSHA256:   logHash(in.ErrorLog),
+		Source:      in.Source,
+		Attempts:    1,
+		StartedAt:   time.Now().UTC(),
+	}
+	// The lifecycle is read again on retry, and the log is kept once.
+	analysis := *in.Analysis
+	analysis.Lifecycle = nil
+	in.Analysis, in.ErrorLog = &analysis, ""
+	run.Input = &in
+	logStoreError("save run", store.SaveRun(context.Background(), run))
+	return run
+}
+
+// restart marks a failed run as running again for a retry. It fails if
+// the run was restarted or changed since it was read, e.g. by another
+// replica retrying it.
+func (reg *runRegistry) restart(id string) (TriageRun, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	run, ok := reg.get(id)
+	if !ok || run.Status != runStatusFailed {
+		return TriageRun{}, false
+	}
+	attempts := run.Attempts
+	run.Status = runStatusRunning
+	run.Attempts++
+	run.Error = ""
+	run.Outcome = ""
+	run.Result = nil
+	run.FinishedAt = nil
+	run.NextRetryAt = nil
+	restarted, err := store.SaveRunIf(context.Background(), run, runStatusFailed, attempts)
+	if err != nil || !restarted {
+		logStoreError("save run", err)
+		return TriageRun{}, false
+	}
+	return run, true
+}
+
+// finish records the result of a run, or the error it failed with.
+func (reg *runRegistry) finish(id string, result *TriageResult, output string, err error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	iterations, calls := llmUsage.take(id), toolCalls.take(id)
+	run, ok := reg.get(id)
+	if !ok {
+		return
+	}
+
+	run.Iterations, run.Usage = iterations, nil
+	for _, it := range iterations {
+		if run.Usage == nil {
+			run.Usage = &TokenUsage{}
+		}
+		run.Usage.add(it.TokenUsage)
+	}
+
+	now := time.Now().UTC()
+	run.FinishedAt = &now
+	run.ToolCalls = calls
+	run.Result = result
+	run.Outcome, run.IssueURL = "", ""
+	if result != nil {
+		run.Outcome, run.IssueURL = result.Action, result.IssueURL
+	}
+	run.Output = output
+	if err != nil {
+		run.Status = runStatusFailed
+		run.Error = err.Error()
+		run.NextRetryAt = retries.next(run.Attempts, now)
+		if run.NextRetryAt == nil {
+			metrics.add("triage_dead_letters_total", "", 1)
+			slog.Warn("Run failed on its last attempt and is a dead letter", "run_id", run.ID, "attempts", run.Attempts, "error", err)
+		}
+	} else {
+		run.Status = runStatusSucceeded
+	}
+	logStoreError("save run", store.SaveRun(context.Background(), run))
+}
+
+// recordCanary records the canary run of a run.
+func (reg *runRegistry) recordCanary(id string, cr CanaryRun) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	run, ok := reg.get(id)
+	if !ok {
+		return
+	}
+	run.Canary = &cr
+	logStoreError("save run", store.SaveRun(context.Background(), run))
+}
+
+// resolve records that a run that was interrupted or failed created an
+// issue after all, as found by the reconciler.
+func (reg *runRegistry) resolve(id string, issue TrackerIssue) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	run, ok := reg.get(id)
+	if !ok || run.Status == runStatusSucceeded {
+		return
+	}
+	now := time.Now().UTC()
+	run.Status, run.Error, run.FinishedAt, run.NextRetryAt = runStatusSucceeded, "", &now, nil
+	run.Outcome, run.IssueURL = runOutcomeCreated, issue.URL
+	run.Result = &TriageResult{
+		Action:      runOutcomeCreated,
+		IssueNumber: issue.Number,
+		IssueURL:    issue.URL,
+		Summary:     "The run did not finish, but its issue was created.",
+	}
+	logStoreError("save run", store.SaveRun(context.Background(), run))
+}
+
+// interrupt fails a run whose instance stopped while it ran, scheduling a
+// retry if retry is set.
+func (reg *runRegistry) interrupt(id string, retry bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	run, ok := reg.get(id)
+	if !ok || run.Status != runStatusRunning {
+		return
+	}
+	now := time.Now().UTC()
+	run.Status, run.Error, run.FinishedAt = runStatusFailed, errRunInterrupted.Error(), &now
+	if retry {
+		run.NextRetryAt = retries.next(run.Attempts, now)
+	}
+	logStoreError("save run", store.SaveRun(context.Background(), run))
+}
+
+func (reg *runRegistry) get(id string) (TriageRun, bool) {
+	run, ok, err := store.GetRun(context.Background(), id)
+	logStoreError("get run", err)
+	return run, ok
+}
+
+// list returns runs newest first, optionally filtered by status.
+func (reg *runRegistry) list(status string) []TriageRun {
+	out, err := store.ListRuns(context.Background(), status)
+	logStoreError("list runs", err)
+	if out == nil {
+		out = []TriageRun{}
+	}
+	return out
+}
+
+// due returns up to limit failed runs whose retry is due, the earliest
+// due first.
+func (reg *runRegistry) due(limit int) []TriageRun {
+	out, err := store.DueRuns(context.Background(), time.Now(), limit)
+	logStoreError("list due runs", err)
+	return out
+}
+
+// deadLetters returns the failed runs that are not retried anymore, newest
+// first.
+func (reg *runRegistry) deadLetters() []TriageRun {
+	out := []TriageRun{}
+	for _, run := range reg.list(runStatusFailed) {
+		if run.NextRetryAt == nil {
+			out = append(out, run)
+		}
+	}
+	return out
+}
+
+// handleGetRun reports the status of a run, for callers that got its ID
+// from /process_error or a job.
+func handleGetRun(w http.ResponseWriter, r *http.Request) {
+	run, ok := runs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "run not found", http.StatusNotFound)
+		return
+	}
+	if target, err := parseRepoTarget(run.Repository); err == nil {
+		if err := authorizeRepo(r, target); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, run)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SandboxConfig redirects all issue tracker writes to a sandbox repository,
+// so that the full pipeline can run against production logs while its
+// output is reviewed in isolation. Reads, such as searches for duplicates,
+// still go to the intended repositories.
+type SandboxConfig struct {
+	// Repo is the sandbox repository ("owner/repo"). Sandbox mode is off
+	// when it is empty.
+	Repo string `yaml:"repo"`
+}
+
+// sandboxTarget is the sandbox repository, nil when sandbox mode is off.
+var sandboxTarget *repoTarget
+
+func configSandbox(cfg *Config) SandboxConfig {
+	out := cfg.Sandbox
+	out.Repo = envOr("SANDBOX_REPO", out.Repo)
+	return out
+}
+
+func validateSandbox(sc SandboxConfig) []string {
+	if sc.Repo == "" {
+		return nil
+	}
+	if _, err := parseRepoTarget(sc.Repo); err != nil {
+		return []string{fmt.Sprintf("sandbox.repo (or SANDBOX_REPO): %v", err)}
+	}
+	return nil
+}
+
+var (
+	// mentionPattern matches @user and @org/team mentions, which would
+	// notify people from the sandbox.
+	mentionPattern = regexp.MustCompile("(^|[^\\w`])(@[A-Za-z0-9][A-Za-z0-9-]*(?:/[A-Za-z0-9_.-]+)?)")
+	// issueRefPattern matches references to issues of other repositories,
+	// which GitHub would show on the referenced issue.
+	issueRefPattern = regexp.MustCompile("(^|[^\\w`/])((?:https?://[^\\s/]+/)?[A-Za-z0-9_.-]+/[A-Za-z0-9_.-]+(?:#|/issues/|/pull/)[0-9]+)")
+)
+
+// defang keeps text written to the sandbox from notifying people or
+// linking back to the intended repository, by quoting mentions and issue
+// references as code.
+func defang(s string) string {
+	s = mentionPattern.ReplaceAllString(s, "$1`$2`")
+	return issueRefPattern.ReplaceAllString(s, "$1`$2`")
+}
+
+// sandboxIssueKey is the store key of the sandbox issue standing in for an
+// issue of the intended repository.
+func sandboxIssueKey(target repoTarget, number int) string {
+	return fmt.Sprintf("sandbox:%s#%d", strings.ToLower(target.String()), number)
+}
+
+// sandboxIssueRef is a sandbox issue standing in for an issue of the
+// intended repository. Own is set for issues created in the sandbox.
+type sandboxIssueRef struct {
+	Number int  `json:"number"`
+	Own    bool `json:"own,omitempty"`
+}
+
+func saveSandboxIssue(ctx context.Context, target repoTarget, number int, ref sandboxIssueRef) error {
+	data, err := json.Marshal(ref)
+	if err != nil {
+		return err
+	}
+	return store.SetValue(ctx, sandboxIssueKey(target, number), data)
+}
+
+// sandboxIssue returns the sandbox issue standing in for an issue of the
+// intended repository. Issues created in the sandbox stand in for
+// themselves, as the bot knows them by their sandbox number; for other
+// issues a stand-in is created on first use, which collects the writes
+// meant for the original.
+func sandboxIssue(ctx context.Context, target repoTarget, number int) (sandboxIssueRef, error) {
+	var ref sandboxIssueRef
+	data, ok, err := store.GetValue(ctx, sandboxIssueKey(target, number))
+	if err != nil {
+		return ref, err
+	}
+	if ok && json.Unmarshal(data, &ref) == nil {
+		return ref, nil
+	}
+
+	created, err := tracker.Create(ctx, *sandboxTarget, plannedIssue{
+		Title: fmt.Sprintf("Sandbox: writes to %s issue %d", target, number),
+		Body:  fmt.Sprintf("_Writes the bot would have made to `%s#%d`, which is not in the sandbox._", target, number),
+	})
+	if err != nil {
+		return ref, fmt.Errorf("creating sandbox stand-in for %s#%d: %w", target, number, err)
+	}
+	ref.Number = created.Number
+	return ref, saveSandboxIssue(ctx, target, number, ref)
+}
+
+// applySandboxed applies an intent to the sandbox repository instead of
+// its target. Creates get a note of the intended repository; writes to
+// issues the sandbox doesn't have become comments on their stand-in.
+// Jira mirroring and the creation fallbacks are skipped, as they would
+// write outside the sandbox.
+func (gw *githubWriter) applySandboxed(ctx context.Context, in *githubIntent) intentResult {
+	intended, sb := in.Target, *sandboxTarget
+	in.Title, in.Body = defang(in.Title), defang(in.Body)
+
+	if in.Kind == intentCreate {
+		body := fmt.Sprintf("_Sandbox: intended for `%s`._\n\n%s", intended, in.Body)
+		created, err := tracker.Create(ctx, sb, plannedIssue{Title: in.Title, Body: body, Labels: in.Labels})
+		if err != nil {
+			return intentResult{Err: err}
+		}
+		logStoreError("save sandbox issue", saveSandboxIssue(ctx, intended, created.Number, sandboxIssueRef{Number: created.Number, Own: true}))
+		return intentResult{URL: created.URL, Number: created.Number}
+	}
+
+	standIn, err := sandboxIssue(ctx, intended, in.IssueNumber)
+	if err != nil {
+		return intentResult{Err: err, Number: in.IssueNumber}
+	}
+	out := &githubIntent{Kind: in.Kind, Target: sb, IssueNumber: standIn.Number, Body: in.Body, Labels: in.Labels, RunID: in.RunID}
+
+	ref := fmt.Sprintf("`%s#%d`", intended, in.IssueNumber)
+	users := "`@" + strings.Join(in.Labels, "`, `@") + "`"
+	switch {
+	case in.Kind == intentAssign:
+		out.Kind, out.Body = intentComment, fmt.Sprintf("_Would assign %s to %s._", users, ref)
+	case standIn.Own || in.Kind == intentOccurrences:
+		// Applied as is: the stand-in's body collects the occurrences.
+	case in.Kind == intentComment:
+		out.Body = fmt.Sprintf("_Comment on %s:_\n\n%s", ref, in.Body)
+	case in.Kind == intentAddLabels:
+		out.Kind, out.Body = intentComment, fmt.Sprintf("_Would add labels %s to %s._", strings.Join(in.Labels, ", "), ref)
+	case in.Kind == intentRemoveLabels:
+		out.Kind, out.Body = intentComment, fmt.Sprintf("_Would remove labels %s from %s._", strings.Join(in.Labels, ", "), ref)
+	case in.Kind == intentReopen:
+		out.Kind, out.Body = intentComment, fmt.Sprintf("_Would reopen %s._", ref)
+	case in.Kind == intentMilestone:
+		out.Kind, out.Body = intentComment, fmt.Sprintf("_Would add %s to the milestone of release %s._", ref, in.Title)
+	case in.Kind == intentProject:
+		out.Kind, out.Body = intentComment, fmt.Sprintf("_Would add %s to project %d of %s._", ref, projectConfig.Number, projectConfig.Owner)
+	}
+
+	res := gw.apply(out)
+	res.Number = in.IssueNumber
+	return res
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// maxSARIFFindings caps how many new findings one upload triages, since
+// each one is an agent run.
+const maxSARIFFindings = 25
+
+// sarifLog is the subset of a SARIF 2.1.0 log the service reads.
+type sarifLog struct {
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name  string      `json:"name"`
+			Rules []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifRule struct {
+	ID               string `json:"id"`
+	HelpURI          string `json:"helpUri"`
+	ShortDescription struct {
+		Text string `json:"text"`
+	} `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID  string `json:"ruleId"`
+	Level   string `json:"level"`
+	Message struct {
+		Text string `json:"text"`
+	} `json:"message"`
+	Locations []struct {
+		PhysicalLocation struct {
+			ArtifactLocation struct {
+				URI string `json:"uri"`
+			} `json:"artifactLocation"`
+			Region struct {
+				StartLine int `json:"startLine"`
+				Snippet   struct {
+					Text string `json:"text"`
+				} `json:"snippet"`
+			} `json:"region"`
+		} `json:"physicalLocation"`
+	} `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+}
+
+type SARIFResponse struct {
+	Repository string          `json:"repository"`
+	Findings   []FindingResult `json:"findings"`
+}
+
+// sarifFindings turns every result of a SARIF log into a finding. The
+// fingerprint is built from the tool, the rule ID, the file and the tool's
+// own partial fingerprint (or the message), but not the line, which moves
+// as code changes around it.
+func sarifFindings(doc *sarifLog) []finding {
+	var out []finding
+	for _, run := range doc.Runs {
+		tool := run.Tool.Driver.Name
+		rules := make(map[string]sarifRule)
+		for _, rule := range run.Tool.Driver.Rules {
+			rules[rule.ID] = rule
+		}
+
+		for _, res := range run.Results {
+			a := &LogAnalysis{
+				Format:    "sarif",
+				Category:  categoryStaticAnalysis,
+				ErrorType: res.RuleID,
+				Message:   res.Message.Text,
+			}
+			a.setMetadata("tool", tool)
+			a.setMetadata("rule_id", res.RuleID)
+			if res.Level != "" {
+				a.setMetadata("level", res.Level)
+			}
+			if rule, ok := rules[res.RuleID]; ok {
+				if rule.ShortDescription.Text != "" {
+					a.setMetadata("rule", rule.ShortDescription.Text)
+				}
+				if rule.HelpURI != "" {
+					a.setMetadata("rule_help", rule.HelpURI)
+				}
+			}
+			a.addLabel("static-analysis")
+
+			var file, snippet string
+			var line int
+			if len(res.Locations) > 0 {
+				loc := res.Locations[0].PhysicalLocation
+				file, line, snippet = loc.ArtifactLocation.URI, loc.Region.StartLine, loc.Region.Snippet.Text
+				a.Frames = []StackFrame{{File: file, Line: line, InApp: true}}
+			}
+
+			partial := normalizeMessage(res.Message.Text)
+			if len(res.PartialFingerprints) > 0 {
+				keys := make([]string, 0, len(res.PartialFingerprints))
+				for k := range res.PartialFingerprints {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				partial = keys[0] + "=" + res.PartialFingerprints[keys[0]]
+			}
+			a.FingerprintKey = strings.Join([]string{"sarif", tool, res.RuleID, file, partial}, ":")
+			a.Fingerprint = computeFingerprint(a)
+
+			var sb strings.Builder
+			fmt.Fprintf(&sb, "%s %s", tool, res.RuleID)
+			if res.Level != "" {
+				fmt.Fprintf(&sb, " (%s)", res.Level)
+			}
+			fmt.Fprintf(&sb, ": %s\n", res.Message.Text)
+			if file != "" {
+				fmt.Fprintf(&sb, "  at %s:%d\n", file, line)
+			}
+			if snippet != "" {
+				fmt.Fprintf(&sb, "\n%s\n", snippet)
+			}
+			a.SimHash = fmt.Sprintf("%016x", simhashLog(sb.String()))
+
+			out = append(out, finding{Name: res.RuleID, Text: sb.String(), Analysis: a})
+		}
+	}
+	return out
+}
+
+// handleProcessSARIF triages the findings of a SARIF upload. The
+// repository can be overridden with the "repository" query parameter.
+func handleProcessSARIF(w http.ResponseWriter, r *http.Request) {
+	var doc sarifLog
+	data, err := io.ReadAll(r.Body)
+	if err == nil {
+		data, err = decodeText(data, r.Header.Get("Content-Type"))
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid SARIF document: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(doc.Runs) == 0 {
+		http.Error(w, "SARIF document has no runs", http.StatusBadRequest)
+		return
+	}
+
+	target, status, err := findingsTarget(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	var stream *findingsStream
+	var emit func(FindingResult)
+	if wantsStream(r) {
+		stream = newFindingsStream(w)
+		emit = stream.result
+	}
+
+	results := triageFindings(r.Context(), target, sarifFindings(&doc), maxSARIFFindings, emit)
+	slog.InfoContext(r.Context(), "Processed SARIF upload", "repository", target.String(), "findings", len(results))
+	resp := SARIFResponse{Repository: target.String(), Findings: results}
+	if stream != nil {
+		stream.summary(resp)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
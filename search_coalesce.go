@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// searchResultTTL is how long a finished search keeps answering
+// near-identical queries, so events of the same storm that arrive just
+// after a search completes don't repeat it.
+const searchResultTTL = 15 * time.Second
+
+func init() {
+	metrics.describe("triage_github_searches_total", "counter", "Issue searches requested by the agent, by whether they called the issue tracker or were coalesced.")
+}
+
+// searchCall is one issue tracker search, shared by every run that asks for a
+// near-identical query while it is in flight or fresh.
+type searchCall struct {
+	done     chan struct{}
+	result   []TrackerIssue
+	err      error
+	finished time.Time
+}
+
+// searchCoalescer collapses concurrent near-identical searches into one
+// tracker call and fans the result out to all waiting runs.
+type searchCoalescer struct {
+	mu    sync.Mutex
+	calls map[string]*searchCall
+}
+
+var searches = &searchCoalescer{calls: make(map[string]*searchCall)}
+
+// searchKey normalizes a query so that queries differing only in case,
+// punctuation, spacing or word order share a search.
+func searchKey(target repoTarget, query string) string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '.'
+	})
+	sort.Strings(words)
+	return strings.ToLower(target.String()) + "|" + strings.Join(words, " ")
+}
+
+// search runs the issue search, or joins an identical one in flight or
+// finished within searchResultTTL.
+func (c *searchCoalescer) search(ctx context.Context, target repoTarget, query string) ([]TrackerIssue, error) {
+	key := searchKey(target, query)
+
+	c.mu.Lock()
+	c.pruneLocked()
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		metrics.add("triage_github_searches_total", `result="coalesced"`, 1)
+		select {
+		case <-call.done:
+			return call.result, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &searchCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	metrics.add("triage_github_searches_total", `result="api"`, 1)
+	// The search outlives the run that started it: other runs may be
+	// waiting on it.
+	call.result, call.err = tracker.Search(context.WithoutCancel(ctx), target, query)
+
+	c.mu.Lock()
+	call.finished = time.Now()
+	if call.err != nil {
+		// Don't keep serving an error to later runs.
+		delete(c.calls, key)
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.result, call.err
+}
+
+func (c *searchCoalescer) pruneLocked() {
+	now := time.Now()
+	for key, call := range c.calls {
+		if !call.finished.IsZero() && now.Sub(call.finished) > searchResultTTL {
+			delete(c.calls, key)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// selfTestLog is the canned error log used by the synthetic probe. The
+// marker makes it unique, so the expected verdict is always "create".
+const selfTestLog = `panic: runtime error: invalid memory address or nil pointer dereference [triage-selftest]
+
+goroutine 1 [running]:
+github.com/triage/selftest.(*Probe).Check(0x0)
+	/selftest/probe.go:12 +0x1d
+github.com/triage/selftest.Run()
+	/selftest/probe.go:30 +0x25
+`
+
+// selfTestTimeout bounds a single probe run.
+const selfTestTimeout = 2 * time.Minute
+
+func init() {
+	metrics.describe("triage_selftest_success", "gauge", "Whether the last synthetic self-test run succeeded (1) or failed (0).")
+	metrics.describe("triage_selftest_last_run_timestamp_seconds", "gauge", "Unix time of the last synthetic self-test run.")
+	metrics.describe("triage_selftest_duration_seconds", "gauge", "Duration of the last synthetic self-test run.")
+	metrics.describe("triage_selftest_runs_total", "counter", "Synthetic self-test runs by result.")
+}
+
+// startSelfTest runs the synthetic probe every interval until ctx is done.
+func startSelfTest(ctx context.Context, interval time.Duration) {
+	slog.Info("Self-test probe enabled", "interval", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			runSelfTest(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// runSelfTest triages the canned log end to end in dry-run mode, covering
+// the LLM, the prompt and GitHub search, and exports the outcome as metrics.
+func runSelfTest(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := selfTestOnce(ctx)
+
+	metrics.set("triage_selftest_last_run_timestamp_seconds", "", float64(start.Unix()))
+	metrics.set("triage_selftest_duration_seconds", "", time.Since(start).Seconds())
+	if err != nil {
+		slog.Error("Self-test failed", "error", err)
+		metrics.set("triage_selftest_success", "", 0)
+		metrics.add("triage_selftest_runs_total", `result="failure"`, 1)
+		return err
+	}
+
+	slog.Info("Self-test succeeded", "duration", time.Since(start).Round(time.Millisecond))
+	metrics.set("triage_selftest_success", "", 1)
+	metrics.add("triage_selftest_runs_total", `result="success"`, 1)
+	return nil
+}
+
+func selfTestOnce(ctx context.Context) error {
+	target := repoTarget{Owner: ghOwner, Repo: ghRepo}
+	session := newToolSession(target, true)
+	analysis := analyzeErrorLog(selfTestLog)
+
+	// Probe runs are not recorded in the run registry: a failed probe must
+	// not show up as a dead letter that could be retried for real.
+	run := TriageRun{ID: "selftest-" + newID(), ErrorLog: selfTestLog}
+	if _, err := executeTriage(ctx, run, session, analysis); err != nil {
+		return fmt.Errorf("pipeline: %w", err)
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.searchErr != nil {
+		return fmt.Errorf("GitHub search: %w", session.searchErr)
+	}
+	if session.searches == 0 {
+		return fmt.Errorf("the agent did not search for existing issues")
+	}
+	if len(session.plannedIssues) != 1 {
+		return fmt.Errorf("expected verdict \"create\" with one planned issue, got %d planned issues", len(session.plannedIssues))
+	}
+
+	issue := session.plannedIssues[0]
+	if !strings.Contains(issue.Body, analysis.Fingerprint) {
+		return fmt.Errorf("planned issue body does not contain the fingerprint %s", analysis.Fingerprint)
+	}
+	return nil
+}
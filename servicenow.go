@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// defaultIncidentCategories are the operational categories that become
+// incidents unless servicenow.categories says otherwise.
+var defaultIncidentCategories = []string{categoryResource, categoryDependency, categoryAlert}
+
+// ServiceNowConfig routes operational errors to ServiceNow incidents
+// instead of issues. The password is a secret and only read from
+// SERVICENOW_PASSWORD.
+type ServiceNowConfig struct {
+	// Instance is the ServiceNow instance, e.g.
+	// https://myorg.service-now.com. Incidents are off when it is empty.
+	Instance string `yaml:"instance"`
+	Username string `yaml:"username"`
+	// Categories are the analysis categories opened as incidents (default
+	// resource, dependency and alert).
+	Categories []string `yaml:"categories"`
+	// AssignmentGroup is the group incidents are assigned to.
+	AssignmentGroup string `yaml:"assignment_group"`
+	// CIs maps service names to configuration items (name or sys_id).
+	CIs map[string]string `yaml:"cis"`
+	// DefaultCI is used for errors of services not in CIs.
+	DefaultCI string `yaml:"default_ci"`
+}
+
+// serviceNow opens incidents when configured; nil otherwise.
+var serviceNow *serviceNowClient
+
+// configServiceNow returns the ServiceNow settings, with
+// SERVICENOW_INSTANCE and SERVICENOW_USERNAME taking precedence over the
+// config file.
+func configServiceNow(cfg *Config) ServiceNowConfig {
+	out := cfg.ServiceNow
+	out.Instance = envOr("SERVICENOW_INSTANCE", out.Instance)
+	out.Username = envOr("SERVICENOW_USERNAME", out.Username)
+	if len(out.Categories) == 0 {
+		out.Categories = defaultIncidentCategories
+	}
+	return out
+}
+
+func validateServiceNow(sc ServiceNowConfig) []string {
+	if sc.Instance == "" {
+		return nil
+	}
+	var problems []string
+	if u, err := url.Parse(sc.Instance); err != nil || u.Scheme == "" || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("servicenow.instance (or SERVICENOW_INSTANCE): %q must be the URL of the instance, e.g. https://myorg.service-now.com", sc.Instance))
+	}
+	if sc.Username == "" || os.Getenv("SERVICENOW_PASSWORD") == "" {
+		problems = append(problems, "servicenow.username (or SERVICENOW_USERNAME) and SERVICENOW_PASSWORD must be set to open incidents")
+	}
+	for i, category := range sc.Categories {
+		if _, ok := issueTemplates[category]; !ok {
+			problems = append(problems, fmt.Sprintf("servicenow.categories[%d]: unknown category %q, expected one of %s", i, category, strings.Join(sortedKeys(issueTemplates), ", ")))
+		}
+	}
+	return problems
+}
+
+// serviceNowClient opens and updates incidents through the Table API.
+// Incidents carry the fingerprint as their correlation ID, so a recurring
+// error adds a work note to its open incident instead of opening another.
+type serviceNowClient struct {
+	*restClient
+	cfg ServiceNowConfig
+}
+
+func newServiceNowClient(sc ServiceNowConfig, password string) *serviceNowClient {
+	return &serviceNowClient{
+		restClient: newRESTClient("servicenow", sc.Instance, func(req *http.Request) {
+			req.SetBasicAuth(sc.Username, password)
+		}),
+		cfg: sc,
+	}
+}
+
+// handles reports whether an error is operational and opened as an
+// incident.
+func (c *serviceNowClient) handles(analysis *LogAnalysis) bool {
+	return slices.Contains(c.cfg.Categories, analysis.Category)
+}
+
+type serviceNowIncident struct {
+	SysID  string `json:"sys_id"`
+	Number string `json:"number"`
+}
+
+func (c *serviceNowClient) incidentURL(sysID string) string {
+	return c.baseURL + "/nav_to.do?uri=" + url.QueryEscape("incident.do?sys_id="+sysID)
+}
+
+// openIncident finds the open incident of the fingerprint, or creates one.
+// The boolean is true if the incident already existed.
+func (c *serviceNowClient) openIncident(ctx context.Context, errorLog string, analysis *LogAnalysis) (serviceNowIncident, bool, error) {
+	params := url.Values{
+		"sysparm_query":  {"active=true^correlation_id=" + analysis.Fingerprint},
+		"sysparm_fields": {"sys_id,number"},
+		"sysparm_limit":  {"1"},
+	}
+	var found struct {
+		Result []serviceNowIncident `json:"result"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/now/table/incident?"+params.Encode(), nil, &found); err != nil {
+		return serviceNowIncident{}, false, err
+	}
+	if len(found.Result) > 0 {
+		incident := found.Result[0]
+		note := map[string]string{"work_notes": "The error occurred again.\n\n" + truncate(errorLog, 4000)}
+		if err := c.do(ctx, http.MethodPatch, "/api/now/table/incident/"+url.PathEscape(incident.SysID), note, nil); err != nil {
+			return incident, true, err
+		}
+		return incident, true, nil
+	}
+
+	level := incidentLevel(analysis.Metadata["severity"])
+	fields := map[string]string{
+		"short_description":   truncate(incidentTitle(analysis), 160),
+		"description":         incidentDescription(errorLog, analysis),
+		"correlation_id":      analysis.Fingerprint,
+		"correlation_display": "error-triage",
+		"urgency":             level,
+		"impact":              level,
+	}
+	if c.cfg.AssignmentGroup != "" {
+		fields["assignment_group"] = c.cfg.AssignmentGroup
+	}
+	if ci := c.ci(analysis); ci != "" {
+		fields["cmdb_ci"] = ci
+	}
+
+	var created struct {
+		Result serviceNowIncident `json:"result"`
+	}
+	// Display values let the config name groups and CIs instead of using
+	// their sys_ids.
+	path := "/api/now/table/incident?sysparm_input_display_value=true&sysparm_fields=sys_id,number"
+	if err := c.do(ctx, http.MethodPost, path, fields, &created); err != nil {
+		return serviceNowIncident{}, false, err
+	}
+	return created.Result, false, nil
+}
+
+// ci returns the configuration item mapped to the error's service.
+func (c *serviceNowClient) ci(analysis *LogAnalysis) string {
+	if ci, ok := c.cfg.CIs[analysisService(analysis)]; ok {
+		return ci
+	}
+	return c.cfg.DefaultCI
+}
+
+// incidentLevel maps a severity to ServiceNow urgency and impact: 1
+// (high), 2 (medium) or 3 (low).
+func incidentLevel(severity string) string {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return "1"
+	case "high", "error", "warning":
+		return "2"
+	}
+	return "3"
+}
+
+func incidentTitle(analysis *LogAnalysis) string {
+	title := firstNonEmpty(analysis.ErrorType, analysis.Category+" error")
+	if analysis.Message != "" {
+		title += ": " + analysis.Message
+	}
+	if service := analysisService(analysis); service != "" {
+		title = "[" + service + "] " + title
+	}
+	return title
+}
+
+func incidentDescription(errorLog string, analysis *LogAnalysis) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Category: %s\nFingerprint: %s\n", analysis.Category, analysis.Fingerprint)
+	for _, k := range sortedKeys(analysis.Metadata) {
+		fmt.Fprintf(&sb, "%s: %s\n", k, analysis.Metadata[k])
+	}
+	if k := analysis.KnownIssue; k != nil {
+		fmt.Fprintf(&sb, "Known issue %s: %s\n", k.ID, k.Resolution)
+	}
+	fmt.Fprintf(&sb, "\nError log:\n%s", truncate(errorLog, 20000))
+	return sanitizeText(sb.String())
+}
+
+// openIncidentRun handles a run of an operational error: instead of the
+// agent filing an issue, the error's ServiceNow incident is opened or
+// updated.
+func openIncidentRun(ctx context.Context, run TriageRun, session *toolSession, analysis *LogAnalysis) (APIResponse, error) {
+	resp := APIResponse{
+		Status:      "success",
+		RunID:       run.ID,
+		Repository:  session.target.String(),
+		Fingerprint: analysis.Fingerprint,
+		KnownIssue:  analysis.KnownIssue,
+	}
+	if session.dryRun {
+		resp.Message = "Dry run: a ServiceNow incident would have been opened."
+		resp.Result = &TriageResult{Action: runOutcomeNone, Summary: resp.Message}
+		runs.finish(run.ID, resp.Result, resp.Message, nil)
+		return resp, nil
+	}
+
+	incident, existed, err := serviceNow.openIncident(ctx, run.ErrorLog, analysis)
+	if err != nil {
+		slog.ErrorContext(ctx, "Opening ServiceNow incident failed", "run_id", run.ID, "error", err)
+		runs.finish(run.ID, nil, "", err)
+		return APIResponse{}, err
+	}
+
+	result := TriageResult{Action: runOutcomeCreated, IssueURL: serviceNow.incidentURL(incident.SysID)}
+	result.Summary = fmt.Sprintf("Opened ServiceNow incident %s.", incident.Number)
+	if existed {
+		result.Action, result.Duplicate = runOutcomeDuplicate, true
+		result.Summary = fmt.Sprintf("Added a work note to open ServiceNow incident %s.", incident.Number)
+	}
+	slog.InfoContext(ctx, result.Summary, "run_id", run.ID, "issue_url", result.IssueURL)
+
+	resp.Message = result.Summary
+	resp.IssueURL = result.IssueURL
+	resp.Result = &result
+	runs.finish(run.ID, &result, result.Summary, nil)
+	return resp, nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Service sources.
+const (
+	serviceFromConfig = "config"
+	serviceFromAPI    = "api"
+)
+
+// severities are the values a service's severity override may take.
+var severities = []string{"critical", "high", "medium", "low"}
+
+// Service is a known service's defaults, applied to every submission that
+// names it in the "service" field.
+type Service struct {
+	Name string `json:"name" yaml:"-"`
+	// Repository receives the service's issues ("owner/repo") unless the
+	// request names a repository.
+	Repository string `json:"repository,omitempty" yaml:"repository"`
+	// Owners are GitHub handles listed as the service's owners.
+	Owners []string `json:"owners,omitempty" yaml:"owners"`
+	// Labels are added to the suggested labels of every issue.
+	Labels []string `json:"labels,omitempty" yaml:"labels"`
+	// Runbooks are links included in the issue body.
+	Runbooks []string `json:"runbooks,omitempty" yaml:"runbooks"`
+	// Severity overrides the severity of the service's issues.
+	Severity string `json:"severity,omitempty" yaml:"severity"`
+	// OnCall is the service's on-call schedule (ID, or Opsgenie schedule
+	// name), whose current on-call replaces Owners when on-call lookup is
+	// configured.
+	OnCall string `json:"oncall,omitempty" yaml:"oncall"`
+	// Source is "config" for services from the config file and "api" for
+	// services managed through the admin API, which take precedence.
+	Source string `json:"source" yaml:"-"`
+}
+
+// apply adds the service's defaults to the analysis of one of its logs.
+func (s Service) apply(analysis *LogAnalysis) {
+	analysis.setMetadata("service", s.Name)
+	if len(s.Owners) > 0 {
+		analysis.setMetadata("service_owners", strings.Join(s.Owners, ", "))
+	}
+	if len(s.Runbooks) > 0 {
+		analysis.setMetadata("runbooks", strings.Join(s.Runbooks, ", "))
+	}
+	if s.Severity != "" {
+		analysis.setMetadata("severity", s.Severity)
+	}
+	for _, label := range s.Labels {
+		analysis.addLabel(label)
+	}
+}
+
+// validateService returns the problems with a service definition, each
+// prefixed with where to find it.
+func validateService(prefix string, s Service) []string {
+	var problems []string
+	if s.Repository != "" {
+		if _, err := parseRepoTarget(s.Repository); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.repository: %v", prefix, err))
+		}
+	}
+	for i, owner := range s.Owners {
+		if strings.TrimPrefix(owner, "@") == "" || strings.ContainsAny(owner, " \t") {
+			problems = append(problems, fmt.Sprintf("%s.owners[%d]: %q is not a GitHub handle", prefix, i, owner))
+		}
+	}
+	for i, label := range s.Labels {
+		if strings.TrimSpace(label) == "" {
+			problems = append(problems, fmt.Sprintf("%s.labels[%d]: empty label", prefix, i))
+		}
+	}
+	for i, link := range s.Runbooks {
+		if u, err := url.Parse(link); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("%s.runbooks[%d]: %q is not an http(s) URL", prefix, i, link))
+		}
+	}
+	if s.Severity != "" && !slices.Contains(severities, s.Severity) {
+		problems = append(problems, fmt.Sprintf("%s.severity: unknown severity %q, expected one of %s", prefix, s.Severity, strings.Join(severities, ", ")))
+	}
+	return problems
+}
+
+// servicesKey is the store key holding the services managed through the
+// admin API.
+const servicesKey = "services"
+
+var errServiceFromConfig = errors.New("service is defined in the config file")
+
+// serviceRegistry combines the services from the config file with the ones
+// managed through the admin API, which are kept in the store.
+type serviceRegistry struct {
+	mu         sync.Mutex
+	configured map[string]Service
+}
+
+var services = &serviceRegistry{}
+
+// configure sets the services from the config file.
+func (reg *serviceRegistry) configure(cfg map[string]Service) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.configured = make(map[string]Service, len(cfg))
+	for name, s := range cfg {
+		s.Name, s.Source = name, serviceFromConfig
+		reg.configured[name] = s
+	}
+}
+
+func (reg *serviceRegistry) managed() map[string]Service {
+	out := make(map[string]Service)
+	data, ok, err := store.GetValue(context.Background(), servicesKey)
+	if err != nil || !ok {
+		logStoreError("get services", err)
+		return out
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		logStoreError("get services", err)
+	}
+	return out
+}
+
+func (reg *serviceRegistry) get(name string) (Service, bool) {
+	if name == "" {
+		return Service{}, false
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if s, ok := reg.managed()[name]; ok {
+		return s, true
+	}
+	s, ok := reg.configured[name]
+	return s, ok
+}
+
+// list returns all services by name.
+func (reg *serviceRegistry) list() []Service {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	all := make(map[string]Service)
+	for name, s := range reg.configured {
+		all[name] = s
+	}
+	for name, s := range reg.managed() {
+		all[name] = s
+	}
+
+	out := make([]Service, 0, len(all))
+	for _, s := range all {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// put creates or replaces a service managed through the admin API.
+func (reg *serviceRegistry) put(s Service) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	s.Source = serviceFromAPI
+	all := reg.managed()
+	all[s.Name] = s
+	return reg.save(all)
+}
+
+// delete removes a service managed through the admin API. Services from
+// the config file can only be removed there.
+func (reg *serviceRegistry) delete(name string) (bool, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	all := reg.managed()
+	if _, ok := all[name]; !ok {
+		if _, ok := reg.configured[name]; ok {
+			return false, errServiceFromConfig
+		}
+		return false, nil
+	}
+	delete(all, name)
+	return true, reg.save(all)
+}
+
+func (reg *serviceRegistry) save(all map[string]Service) error {
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return store.SetValue(context.Background(), servicesKey, data)
+}
+
+func handleAdminListServices(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, services.list())
+}
+
+func handleAdminGetService(w http.ResponseWriter, r *http.Request) {
+	s, ok := services.get(r.PathValue("name"))
+	if !ok {
+		http.Error(w, "Service not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+func handleAdminPutService(w http.ResponseWriter, r *http.Request) {
+	var s Service
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	s.Name = r.PathValue("name")
+	if problems := validateService("service", s); len(problems) > 0 {
+		http.Error(w, strings.Join(problems, "\n"), http.StatusBadRequest)
+		return
+	}
+
+	if err := services.put(s); err != nil {
+		http.Error(w, fmt.Sprintf("Saving service failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Service updated", "service", s.Name)
+	s, _ = services.get(s.Name)
+	writeJSON(w, http.StatusOK, s)
+}
+
+func handleAdminDeleteService(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ok, err := services.delete(name)
+	switch {
+	case errors.Is(err, errServiceFromConfig):
+		http.Error(w, "Service is defined in the config file and can only be removed there", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Deleting service failed: %v", err), http.StatusInternalServerError)
+		return
+	case !ok:
+		http.Error(w, "Service not found", http.StatusNotFound)
+		return
+	}
+	slog.InfoContext(r.Context(), "Service deleted", "service", name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// severityPatterns classify an error's severity from its log, most severe
+// first. The first match wins.
+var severityPatterns = []struct {
+	severity string
+	reason   string
+	pattern  *regexp.Regexp
+}{
+	{"critical", "data loss or corruption", regexp.MustCompile(`(?i)\b(data (loss|lost|corruption)|(data|database|index|file|table) (is )?corrupt(ed)?|corruption detected|lost (writes|updates|records|messages)|checksum mismatch|split[- ]brain)\b`)},
+	{"high", "crash", regexp.MustCompile(`(?i)(\bpanic:|\bfatal( error)?:|segmentation fault|\bSIGSEGV\b|\bSIGABRT\b|core dumped|\bdeadlock\b|stack overflow|out of memory|OOMKilled)`)},
+	{"low", "warning", regexp.MustCompile(`(?im)^\S*\s*(\[?warn(ing)?\]?:?\s|.*\bdeprecat)`)},
+}
+
+// classifySeverity sets the severity of an error. A severity a maintainer
+// set on the fingerprint's issue wins; otherwise an error without one from
+// the service registry or an alert is classified: critical for data loss,
+// high for crashes, OOM kills and 5xx responses, low for warnings, client
+// errors and static analysis, medium otherwise. It then adds the matching
+// "severity:" label.
+func classifySeverity(errorLog string, analysis *LogAnalysis) {
+	if st := analysis.Lifecycle; st != nil && st.Severity != "" {
+		analysis.setMetadata("severity", st.Severity)
+		analysis.setMetadata("severity_reason", "set by a maintainer")
+	} else if analysis.Metadata["severity"] == "" {
+		severity, reason := severityOf(errorLog, analysis)
+		analysis.setMetadata("severity", severity)
+		analysis.setMetadata("severity_reason", reason)
+	}
+	if label := severityLabel(analysis.Metadata["severity"]); label != "" {
+		analysis.addLabel(label)
+	}
+}
+
+func severityOf(errorLog string, analysis *LogAnalysis) (severity, reason string) {
+	for _, p := range severityPatterns[:2] {
+		if p.pattern.MatchString(errorLog) {
+			return p.severity, p.reason
+		}
+	}
+	switch status := analysis.Metadata["http_status"]; {
+	case strings.HasPrefix(status, "5"):
+		return "high", "HTTP " + status
+	case strings.HasPrefix(status, "4"):
+		return "low", "HTTP " + status
+	}
+	switch analysis.Category {
+	case categoryResource:
+		return "high", "resource exhaustion"
+	case categoryStaticAnalysis:
+		return "low", "static analysis finding"
+	}
+	if p := severityPatterns[2]; p.pattern.MatchString(errorLog) {
+		return p.severity, p.reason
+	}
+	return "medium", "error"
+}
+
+// severityLabel returns the label of a severity, mapping alert severities
+// to the closest issue severity; empty for unknown severities.
+func severityLabel(severity string) string {
+	switch s := strings.ToLower(severity); s {
+	case "critical", "high", "medium", "low":
+		return "severity:" + s
+	case "error":
+		return "severity:high"
+	case "warning":
+		return "severity:medium"
+	case "info":
+		return "severity:low"
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// signatureHeader carries the HMAC-SHA256 of the request body, hex encoded
+// and optionally prefixed with "sha256=", like GitHub's webhooks.
+const signatureHeader = "X-Signature"
+
+// maxSignedBodySize bounds the body read to verify its signature, before
+// the handler applies its own limits.
+const maxSignedBodySize = 32 << 20
+
+// Ingestion sources, each of which can have its own signing secret.
+const (
+	sourceProcessError = "process_error"
+	sourceSARIF        = "sarif"
+	sourceTestResults  = "test_results"
+	sourceAlertmanager = "alertmanager"
+	sourceSessions     = "sessions"
+)
+
+var signedSources = []string{sourceProcessError, sourceSARIF, sourceTestResults, sourceAlertmanager, sourceSessions}
+
+// signingSecrets are the secrets of the ingestion sources. A source without
+// a secret accepts unsigned requests.
+var signingSecrets map[string]string
+
+func init() {
+	metrics.describe("triage_signature_failures_total", "counter", "Ingestion requests refused for a missing or invalid X-Signature, by source.")
+}
+
+// signingSecretEnv is the variable holding a source's secret, e.g.
+// SIGNING_SECRET_ALERTMANAGER.
+func signingSecretEnv(source string) string {
+	return "SIGNING_SECRET_" + strings.ToUpper(source)
+}
+
+// loadSigningSecrets reads the secret of each source from its variable,
+// falling back to SIGNING_SECRET. Secrets are only read from the
+// environment.
+func loadSigningSecrets() map[string]string {
+	out := make(map[string]string)
+	for _, source := range signedSources {
+		if secret := envOr(signingSecretEnv(source), os.Getenv("SIGNING_SECRET")); secret != "" {
+			out[source] = secret
+		}
+	}
+	return out
+}
+
+// requireSignature rejects requests to an ingestion endpoint whose body is
+// not signed with the source's secret, so that callers who can reach the
+// service can't make it run the agent. The body is handed on unchanged.
+func requireSignature(source string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := signingSecrets[source]
+		if !ok {
+			next(w, r)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBodySize))
+		if err != nil {
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+		signature := r.Header.Get(signatureHeader)
+		if !validSignature(secret, signature, body) {
+			metrics.add("triage_signature_failures_total", labelSet("source", source), 1)
+			slog.WarnContext(r.Context(), "Refused request with an invalid signature", "source", source, "signed", signature != "", "remote_addr", r.RemoteAddr)
+			http.Error(w, "Missing or invalid "+signatureHeader+" header", http.StatusUnauthorized)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	})
+}
+
+// validSignature checks the HMAC-SHA256 of payload in constant time. The
+// "sha256=" prefix is optional.
+func validSignature(secret, signature string, payload []byte) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || len(got) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(got, mac.Sum(nil))
+}
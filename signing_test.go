@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// sign returns the hex HMAC-SHA256 of payload.
+func sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidSignature(t *testing.T) {
+	payload := []byte(`{"error_log":"panic: boom"}`)
+	valid := sign("secret", payload)
+	tests := []struct {
+		name      string
+		signature string
+		payload   []byte
+		want      bool
+	}{
+		{name: "bare", signature: valid, payload: payload, want: true},
+		{name: "prefixed", signature: "sha256=" + valid, payload: payload, want: true},
+		{name: "uppercase hex", signature: "sha256=" + upperHex(valid), payload: payload, want: true},
+		{name: "other payload", signature: valid, payload: []byte(`{"error_log":"panic: other"}`), want: false},
+		{name: "other secret", signature: sign("other", payload), payload: payload, want: false},
+		{name: "truncated", signature: valid[:32], payload: payload, want: false},
+		{name: "not hex", signature: "sha256=zz", payload: payload, want: false},
+		{name: "empty", signature: "", payload: payload, want: false},
+		{name: "prefix only", signature: "sha256=", payload: payload, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validSignature("secret", tt.signature, tt.payload); got != tt.want {
+				t.Errorf("validSignature(%q) = %v, want %v", tt.signature, got, tt.want)
+			}
+		})
+	}
+}
+
+func upperHex(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'a' && c <= 'f' {
+			b[i] = c - 'a' + 'A'
+		}
+	}
+	return string(b)
+}
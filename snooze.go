@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// snoozePrefix prefixes the store keys of snoozed issues, one per
+	// issue: "snooze:owner/repo#N".
+	snoozePrefix = "snooze:"
+	// legacySnoozesKey is the store key that held all snoozes in a single
+	// value before they were kept per issue.
+	legacySnoozesKey = "snoozes"
+	// snoozeCheckInterval is how often ended snoozes are looked for.
+	snoozeCheckInterval = time.Minute
+	// maxSnooze caps how long an issue can be snoozed.
+	maxSnooze = 90 * 24 * time.Hour
+)
+
+// issueSnooze pauses the agent's comments on an issue until Until, counting
+// the occurrences it would have commented on for the reminder.
+type issueSnooze struct {
+	Repository     string     `json:"repository"`
+	IssueNumber    int        `json:"issue_number"`
+	Until          time.Time  `json:"until"`
+	By             string     `json:"by"`
+	Suppressed     int        `json:"suppressed"`
+	LastSuppressed *time.Time `json:"last_suppressed,omitempty"`
+	// Ended is set by the instance that posts the reminder, so that other
+	// instances don't post it too.
+	Ended bool `json:"ended,omitempty"`
+}
+
+// snoozeRegistry keeps each snoozed issue under its own store key, updated
+// with compare-and-set, so that snoozes on different issues don't contend
+// and concurrent updates of one aren't lost across instances.
+type snoozeRegistry struct{}
+
+var snoozes = &snoozeRegistry{}
+
+func snoozeKey(target repoTarget, number int) string {
+	return fmt.Sprintf("%s%s#%d", snoozePrefix, strings.ToLower(target.String()), number)
+}
+
+// read returns a snooze with its stored value, which update compares
+// against.
+func (reg *snoozeRegistry) read(ctx context.Context, key string) (issueSnooze, []byte, bool, error) {
+	data, ok, err := store.GetValue(ctx, key)
+	if err != nil || !ok {
+		return issueSnooze{}, nil, false, err
+	}
+	var s issueSnooze
+	if err := json.Unmarshal(data, &s); err != nil {
+		return issueSnooze{}, nil, false, err
+	}
+	return s, data, true, nil
+}
+
+// update applies change to a snooze, absent if ok is false, and saves it
+// if the stored value hasn't changed since it was read, retrying
+// otherwise. change returns false to leave the snooze as it is. It returns
+// the snooze with its stored value.
+func (reg *snoozeRegistry) update(ctx context.Context, key string, change func(s *issueSnooze, ok bool) bool) (issueSnooze, []byte, error) {
+	for {
+		s, old, ok, err := reg.read(ctx, key)
+		if err != nil {
+			return issueSnooze{}, nil, err
+		}
+		if !change(&s, ok) {
+			return s, old, nil
+		}
+		data, err := json.Marshal(s)
+		if err != nil {
+			return issueSnooze{}, nil, err
+		}
+		swapped, err := store.SetValueIf(ctx, key, old, data)
+		if err != nil || swapped {
+			return s, data, err
+		}
+	}
+}
+
+// remove deletes an ended snooze, unless it was snoozed again meanwhile.
+func (reg *snoozeRegistry) remove(ctx context.Context, key string, ended []byte) error {
+	_, err := store.DeleteValueIf(ctx, key, ended)
+	return err
+}
+
+// snooze snoozes an issue until the given time, replacing an earlier
+// snooze but keeping its count.
+func (reg *snoozeRegistry) snooze(target repoTarget, number int, until time.Time, by string) error {
+	_, _, err := reg.update(context.Background(), snoozeKey(target, number), func(s *issueSnooze, ok bool) bool {
+		if s.Ended {
+			*s = issueSnooze{}
+		}
+		s.Repository, s.IssueNumber, s.Until, s.By = target.String(), number, until, by
+		return true
+	})
+	return err
+}
+
+// unsnooze ends an issue's snooze, returning it.
+func (reg *snoozeRegistry) unsnooze(target repoTarget, number int) (issueSnooze, bool, error) {
+	key := snoozeKey(target, number)
+	var found bool
+	s, data, err := reg.update(context.Background(), key, func(s *issueSnooze, ok bool) bool {
+		found = ok && !s.Ended
+		s.Ended = true
+		return found
+	})
+	if err != nil || !found {
+		return issueSnooze{}, false, err
+	}
+	return s, true, reg.remove(context.Background(), key, data)
+}
+
+// suppress counts an occurrence on a snoozed issue. It reports whether the
+// issue is snoozed, in which case the occurrence must not be commented on.
+func (reg *snoozeRegistry) suppress(target repoTarget, number int) (issueSnooze, bool) {
+	var snoozed bool
+	s, _, err := reg.update(context.Background(), snoozeKey(target, number), func(s *issueSnooze, ok bool) bool {
+		now := time.Now()
+		snoozed = ok && !s.Ended && now.Before(s.Until)
+		if snoozed {
+			s.Suppressed++
+			s.LastSuppressed = &now
+		}
+		return snoozed
+	})
+	logStoreError("save snooze", err)
+	return s, snoozed
+}
+
+// expire removes and returns the snoozes that ended. Each is returned by
+// one instance only.
+func (reg *snoozeRegistry) expire(now time.Time) []issueSnooze {
+	ctx := context.Background()
+	values, err := store.ListValues(ctx, snoozePrefix)
+	if err != nil {
+		logStoreError("list snoozes", err)
+		return nil
+	}
+	var ended []issueSnooze
+	for _, key := range sortedKeys(values) {
+		var s issueSnooze
+		if err := json.Unmarshal(values[key], &s); err != nil {
+			logStoreError("get snooze", err)
+			continue
+		}
+		if now.Before(s.Until) && !s.Ended {
+			continue
+		}
+		var claimed bool
+		s, data, err := reg.update(ctx, key, func(s *issueSnooze, ok bool) bool {
+			claimed = ok && !s.Ended && !now.Before(s.Until)
+			s.Ended = s.Ended || claimed
+			return claimed
+		})
+		if err != nil {
+			logStoreError("save snooze", err)
+			continue
+		}
+		if claimed {
+			ended = append(ended, s)
+		}
+		if s.Ended {
+			logStoreError("delete snooze", reg.remove(ctx, key, data))
+		}
+	}
+	return ended
+}
+
+// moveLegacySnoozes moves the snoozes stored in a single value by earlier
+// versions to their own keys.
+func (reg *snoozeRegistry) moveLegacySnoozes(ctx context.Context) error {
+	data, ok, err := store.GetValue(ctx, legacySnoozesKey)
+	if err != nil || !ok {
+		return err
+	}
+	var all map[string]issueSnooze
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for key, s := range all {
+		data, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		// A snooze set since under the new key wins.
+		if _, err := store.SetValueIf(ctx, snoozePrefix+key, nil, data); err != nil {
+			return err
+		}
+	}
+	return store.DeleteValue(ctx, legacySnoozesKey)
+}
+
+// parseSnoozeDuration parses a snooze duration: days ("7d"), weeks ("2w")
+// or a Go duration ("12h").
+func parseSnoozeDuration(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	switch unit := s[len(s)-1:]; unit {
+	case "d", "w":
+		n, convErr := strconv.Atoi(s[:len(s)-1])
+		d, err = time.Duration(n)*24*time.Hour, convErr
+		if unit == "w" {
+			d *= 7
+		}
+	default:
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q, expected e.g. 12h, 7d or 2w", s)
+	}
+	if d > maxSnooze {
+		return 0, fmt.Errorf("can't snooze for more than %d days", maxSnooze/(24*time.Hour))
+	}
+	return d, nil
+}
+
+// runSnoozeCommand handles "/triage snooze <duration>".
+func runSnoozeCommand(ctx context.Context, cmd botCommand) (string, error) {
+	if len(cmd.Args) != 1 {
+		return "", fmt.Errorf("usage: %s snooze <duration>, e.g. 7d", botCommandPrefix)
+	}
+	d, err := parseSnoozeDuration(cmd.Args[0])
+	if err != nil {
+		return "", err
+	}
+	until := time.Now().Add(d).UTC().Truncate(time.Minute)
+	if err := snoozes.snooze(cmd.Target, cmd.IssueNumber, until, cmd.By); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Snoozed until %s: occurrences of this error won't be commented on until then, and a summary will be posted when the snooze ends.",
+		until.Format(issueTimeLayout)), nil
+}
+
+// runUnsnoozeCommand handles "/triage unsnooze", ending the snooze early
+// with its summary.
+func runUnsnoozeCommand(ctx context.Context, cmd botCommand) (string, error) {
+	s, ok, err := snoozes.unsnooze(cmd.Target, cmd.IssueNumber)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "This issue isn't snoozed.", nil
+	}
+	return snoozeSummary(s, "was ended early by @"+cmd.By), nil
+}
+
+// snoozeSummary is the reminder posted when a snooze ends.
+func snoozeSummary(s issueSnooze, how string) string {
+	summary := fmt.Sprintf("**Snooze over:** the snooze @%s set until %s %s. ", s.By, s.Until.Format(issueTimeLayout), how)
+	switch s.Suppressed {
+	case 0:
+		return summary + "The error didn't occur again while it was snoozed."
+	case 1:
+		return summary + fmt.Sprintf("The error occurred once while it was snoozed, at %s.", s.LastSuppressed.UTC().Format(issueTimeLayout))
+	}
+	return summary + fmt.Sprintf("The error occurred %d times while it was snoozed, last at %s.", s.Suppressed, s.LastSuppressed.UTC().Format(issueTimeLayout))
+}
+
+// startSnoozeReminders posts the summary of ended snoozes until ctx is
+// done.
+func startSnoozeReminders(ctx context.Context) {
+	logStoreError("move snoozes", snoozes.moveLegacySnoozes(ctx))
+	go func() {
+		ticker := time.NewTicker(snoozeCheckInterval)
+		defer ticker.Stop()
+		for {
+			for _, s := range snoozes.expire(time.Now()) {
+				target, err := parseRepoTarget(s.Repository)
+				if err != nil {
+					continue
+				}
+				slog.Info("Snooze ended", "repository", s.Repository, "issue_number", s.IssueNumber, "suppressed", s.Suppressed)
+				writer.submit(&githubIntent{Kind: intentComment, Target: target, IssueNumber: s.IssueNumber, Body: snoozeSummary(s, "has ended")})
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+const (
+	// defaultSnippetContext is how many lines get_file_snippet shows before
+	// and after the requested line, unless the agent asks for more.
+	defaultSnippetContext = 10
+	maxSnippetContext     = 30
+	// maxSnippetFileSize skips files too large to be source code.
+	maxSnippetFileSize = 1 << 20
+	// defaultCommitWindowDays is how far back list_recent_commits looks,
+	// unless the agent asks for another window.
+	defaultCommitWindowDays = 14
+	maxCommitWindowDays     = 90
+	maxRecentCommits        = 10
+)
+
+// snippetLanguages maps file extensions to the language of Markdown code
+// blocks, for highlighting in the issue.
+var snippetLanguages = map[string]string{
+	".go": "go", ".py": "python", ".rb": "ruby", ".rs": "rust", ".cs": "csharp",
+	".java": "java", ".kt": "kotlin", ".js": "javascript", ".ts": "typescript",
+	".php": "php", ".sql": "sql",
+}
+
+// getFileSnippet fetches the lines around a stack trace location from the
+// target repository's default branch, trying the same path candidates as
+// suggest_owners. The code passes through secret redaction and PII
+// scrubbing like the log does.
+func (t *toolSession) getFileSnippet(args map[string]any) (string, error) {
+	file, _ := args["path"].(string)
+	line, ok := args["line"].(float64)
+	if file == "" || !ok || line < 1 || line != float64(int(line)) {
+		return "", fmt.Errorf("missing or invalid 'path' or 'line' argument for get_file_snippet")
+	}
+	around := defaultSnippetContext
+	if n, ok := args["context"].(float64); ok && n >= 0 {
+		around = min(int(n), maxSnippetContext)
+	}
+
+	t.log.Info("Tool call", "tool", "get_file_snippet", "path", file, "line", int(line))
+
+	for _, candidate := range repoPathCandidates(file) {
+		content, _, resp, err := ghClient.Repositories.GetContents(t.ctx, t.target.Owner, t.target.Repo, candidate, nil)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			t.log.Error("Fetching file failed", "path", candidate, "error", err)
+			return fmt.Sprintf("Error fetching %s: %v", candidate, err), err
+		}
+		// A directory, symlink or submodule.
+		if content == nil || content.GetType() != "file" {
+			continue
+		}
+		if content.GetSize() > maxSnippetFileSize {
+			return fmt.Sprintf("%s is too large to show (%d bytes).", candidate, content.GetSize()), nil
+		}
+		text, err := content.GetContent()
+		if err != nil {
+			return fmt.Sprintf("Error decoding %s: %v", candidate, err), err
+		}
+		return formatSnippet(candidate, sanitizeText(text), int(line), around), nil
+	}
+	return fmt.Sprintf("No file matching %s was found in %s.", file, t.target), nil
+}
+
+// formatSnippet renders the lines around line as a numbered code block,
+// marking line with ">".
+func formatSnippet(file, text string, line, around int) string {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if line > len(lines) {
+		return fmt.Sprintf("%s has only %d lines, the file may have changed since the error was logged.", file, len(lines))
+	}
+	first, last := max(line-around, 1), min(line+around, len(lines))
+	width := len(fmt.Sprint(last))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s, lines %d-%d (line %d marked with >):\n```%s\n", file, first, last, line, snippetLanguages[path.Ext(file)])
+	for i := first; i <= last; i++ {
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&sb, "%s %*d | %s\n", marker, width, i, lines[i-1])
+	}
+	sb.WriteString("```")
+	return sb.String()
+}
+
+// listRecentCommits lists the latest commits to a file in the target
+// repository, so that the agent can point out recent changes that may have
+// caused the error.
+func (t *toolSession) listRecentCommits(args map[string]any) (string, error) {
+	file, _ := args["path"].(string)
+	if file == "" {
+		return "", fmt.Errorf("missing or invalid 'path' argument for list_recent_commits")
+	}
+	days := defaultCommitWindowDays
+	if n, ok := args["since_days"].(float64); ok && n >= 1 {
+		days = min(int(n), maxCommitWindowDays)
+	}
+
+	t.log.Info("Tool call", "tool", "list_recent_commits", "path", file, "since_days", days)
+
+	since := time.Now().AddDate(0, 0, -days)
+	for _, candidate := range repoPathCandidates(file) {
+		commits, _, err := ghClient.Repositories.ListCommits(t.ctx, t.target.Owner, t.target.Repo, &github.CommitsListOptions{
+			Path:        candidate,
+			Since:       since,
+			ListOptions: github.ListOptions{PerPage: maxRecentCommits},
+		})
+		if err != nil {
+			t.log.Error("Listing commits failed", "path", candidate, "error", err)
+			return fmt.Sprintf("Error listing commits: %v", err), err
+		}
+		if len(commits) == 0 {
+			continue
+		}
+
+		var lines []string
+		for _, c := range commits {
+			sha := c.GetSHA()
+			if len(sha) > 7 {
+				sha = sha[:7]
+			}
+			subject, _, _ := strings.Cut(c.GetCommit().GetMessage(), "\n")
+			author := firstNonEmpty(c.GetAuthor().GetLogin(), c.GetCommit().GetAuthor().GetName())
+			lines = append(lines, fmt.Sprintf("- %s %s by %s: %s (%s)",
+				sha, c.GetCommit().GetAuthor().GetDate().UTC().Format(issueTimeLayout), author,
+				sanitizeText(truncate(subject, maxSnippetLength)), c.GetHTMLURL()))
+		}
+		return fmt.Sprintf("Commits to %s in the last %d days, newest first:\n%s", candidate, days, strings.Join(lines, "\n")), nil
+	}
+	return fmt.Sprintf("No commits to %s in the last %d days.", file, days), nil
+}
+
+const blameQuery = `query($owner: String!, $repo: String!, $path: String!) {
+  repository(owner: $owner, name: $repo) {
+    defaultBranchRef {
+      target {
+        ... on Commit {
+          blame(path: $path) {
+            ranges {
+              startingLine
+              endingLine
+              commit {
+                abbreviatedOid
+                committedDate
+                url
+                messageHeadline
+                author { name user { login } }
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+// blameRange is the commit that last changed a range of lines.
+type blameRange struct {
+	StartingLine int `json:"startingLine"`
+	EndingLine   int `json:"endingLine"`
+	Commit       struct {
+		AbbreviatedOid  string    `json:"abbreviatedOid"`
+		CommittedDate   time.Time `json:"committedDate"`
+		URL             string    `json:"url"`
+		MessageHeadline string    `json:"messageHeadline"`
+		Author          struct {
+			Name string `json:"name"`
+			User *struct {
+				Login string `json:"login"`
+			} `json:"user"`
+		} `json:"author"`
+	} `json:"commit"`
+}
+
+// blameFile returns the blame of a file on the default branch. ok is false
+// if the file doesn't exist.
+func blameFile(ctx context.Context, target repoTarget, file string) (ranges []blameRange, ok bool, err error) {
+	var data struct {
+		Repository struct {
+			DefaultBranchRef struct {
+				Target struct {
+					Blame *struct {
+						Ranges []blameRange `json:"ranges"`
+					} `json:"blame"`
+				} `json:"target"`
+			} `json:"defaultBranchRef"`
+		} `json:"repository"`
+	}
+	err = githubGraphQL(ctx, blameQuery, map[string]any{"owner": target.Owner, "repo": target.Repo, "path": file}, &data)
+	var gqlErr *graphQLError
+	if errors.As(err, &gqlErr) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	blame := data.Repository.DefaultBranchRef.Target.Blame
+	if blame == nil || len(blame.Ranges) == 0 {
+		return nil, false, nil
+	}
+	return blame.Ranges, true, nil
+}
+
+// blameLine tells the agent who last changed the line of a stack trace
+// location, and when, as a hint at who may know the code best.
+func (t *toolSession) blameLine(args map[string]any) (string, error) {
+	file, _ := args["path"].(string)
+	line, ok := args["line"].(float64)
+	if file == "" || !ok || line < 1 || line != float64(int(line)) {
+		return "", fmt.Errorf("missing or invalid 'path' or 'line' argument for blame_line")
+	}
+
+	t.log.Info("Tool call", "tool", "blame_line", "path", file, "line", int(line))
+
+	for _, candidate := range repoPathCandidates(file) {
+		ranges, found, err := blameFile(t.ctx, t.target, candidate)
+		if err != nil {
+			t.log.Error("Blaming file failed", "path", candidate, "error", err)
+			return fmt.Sprintf("Error blaming %s: %v", candidate, err), err
+		}
+		if !found {
+			continue
+		}
+		for _, r := range ranges {
+			if int(line) < r.StartingLine || int(line) > r.EndingLine {
+				continue
+			}
+			c := r.Commit
+			author := c.Author.Name
+			if c.Author.User != nil && c.Author.User.Login != "" {
+				author = fmt.Sprintf("@%s (%s)", c.Author.User.Login, c.Author.Name)
+			}
+			days := int(time.Since(c.CommittedDate).Hours() / 24)
+			return fmt.Sprintf("Line %d of %s was last changed %d days ago, on %s, by %s in commit %s (%s): %s",
+				int(line), candidate, days, c.CommittedDate.UTC().Format(issueTimeLayout), author, c.AbbreviatedOid, c.URL,
+				sanitizeText(truncate(c.MessageHeadline, maxSnippetLength))), nil
+		}
+		return fmt.Sprintf("%s has no line %d, the file may have changed since the error was logged.", candidate, int(line)), nil
+	}
+	return fmt.Sprintf("No file matching %s was found in %s.", file, t.target), nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxStabilityReleases caps the service/version pairs tracked, since each
+// one is a set of metric series.
+const maxStabilityReleases = 500
+
+func init() {
+	metrics.describe("triage_errors_total", "counter", "Error logs received, by service and version.")
+	metrics.describe("triage_sessions_total", "counter", "Sessions reported by clients, by service and version.")
+	metrics.describe("triage_crash_free_ratio", "gauge", "Share of sessions without a reported error, by service and version.")
+}
+
+// ReleaseStability is the error and session count of one service release.
+type ReleaseStability struct {
+	Service  string `json:"service"`
+	Version  string `json:"version"`
+	Errors   int64  `json:"errors"`
+	Sessions int64  `json:"sessions"`
+	// CrashRate and CrashFree are only set once sessions have been
+	// reported. Every error is counted as one crashed session.
+	CrashRate *float64  `json:"crash_rate,omitempty"`
+	CrashFree *float64  `json:"crash_free,omitempty"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+type stabilityRegistry struct {
+	mu       sync.Mutex
+	releases map[[2]string]*ReleaseStability
+}
+
+var stability = &stabilityRegistry{releases: make(map[[2]string]*ReleaseStability)}
+
+func (reg *stabilityRegistry) recordError(service, version string) {
+	reg.update(service, version, func(r *ReleaseStability) { r.Errors++ })
+}
+
+func (reg *stabilityRegistry) recordSessions(service, version string, n int64) {
+	reg.update(service, version, func(r *ReleaseStability) { r.Sessions += n })
+}
+
+func (reg *stabilityRegistry) update(service, version string, fn func(*ReleaseStability)) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	key := [2]string{service, version}
+	r, ok := reg.releases[key]
+	if !ok {
+		r = &ReleaseStability{Service: service, Version: version}
+		reg.releases[key] = r
+		reg.evictLocked()
+	}
+	fn(r)
+	r.LastSeen = time.Now().UTC()
+
+	labels := labelSet("service", service, "version", version)
+	metrics.set("triage_errors_total", labels, float64(r.Errors))
+	metrics.set("triage_sessions_total", labels, float64(r.Sessions))
+	if r.Sessions > 0 {
+		metrics.set("triage_crash_free_ratio", labels, crashFree(r.Errors, r.Sessions))
+	}
+}
+
+func crashFree(errors, sessions int64) float64 {
+	return max(0, 1-float64(errors)/float64(sessions))
+}
+
+// list returns every tracked release, by service and most recent first.
+func (reg *stabilityRegistry) list() []ReleaseStability {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	out := []ReleaseStability{}
+	for _, r := range reg.releases {
+		c := *r
+		if c.Sessions > 0 {
+			rate := min(1, float64(c.Errors)/float64(c.Sessions))
+			free := crashFree(c.Errors, c.Sessions)
+			c.CrashRate, c.CrashFree = &rate, &free
+		}
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Service != out[j].Service {
+			return out[i].Service < out[j].Service
+		}
+		return out[i].LastSeen.After(out[j].LastSeen)
+	})
+	return out
+}
+
+func (reg *stabilityRegistry) evictLocked() {
+	for len(reg.releases) > maxStabilityReleases {
+		var oldest *ReleaseStability
+		for _, r := range reg.releases {
+			if oldest == nil || r.LastSeen.Before(oldest.LastSeen) {
+				oldest = r
+			}
+		}
+		delete(reg.releases, [2]string{oldest.Service, oldest.Version})
+		labels := labelSet("service", oldest.Service, "version", oldest.Version)
+		metrics.remove("triage_errors_total", labels)
+		metrics.remove("triage_sessions_total", labels)
+		metrics.remove("triage_crash_free_ratio", labels)
+	}
+}
+
+// SessionReport is a client's count of sessions for a release since its
+// last report.
+type SessionReport struct {
+	Service  string `json:"service"`
+	Version  string `json:"version"`
+	Sessions int64  `json:"sessions"`
+}
+
+func handleReportSessions(w http.ResponseWriter, r *http.Request) {
+	var req SessionReport
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Service == "" || req.Sessions <= 0 {
+		http.Error(w, "service and a positive sessions count are required", http.StatusBadRequest)
+		return
+	}
+
+	stability.recordSessions(req.Service, req.Version, req.Sessions)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleListStability(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, stability.list())
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Store persists triage state: runs (failed runs are the dead letters),
+// fingerprint lifecycles and the agent memory. The in-memory store keeps a
+// single instance self-contained; the SQL stores let state survive restarts
+// and be shared between replicas.
+type Store interface {
+	SaveRun(ctx context.Context, run TriageRun) error
+	GetRun(ctx context.Context, id string) (TriageRun, bool, error)
+	// SaveRunIf saves a run only if the stored one has the given status
+	// and attempts, and reports whether it did, so that of several
+	// replicas retrying a run one does.
+	SaveRunIf(ctx context.Context, run TriageRun, status string, attempts int) (bool, error)
+	// ListRuns returns runs newest first, optionally filtered by status.
+	ListRuns(ctx context.Context, status string) ([]TriageRun, error)
+	// DueRuns returns up to limit failed runs whose retry is due at now,
+	// the earliest due first.
+	DueRuns(ctx context.Context, now time.Time, limit int) ([]TriageRun, error)
+
+	SaveFingerprint(ctx context.Context, st FingerprintState) error
+	// GetFingerprint returns the state of a fingerprint in a repository.
+	// States are kept per repository and fingerprint.
+	GetFingerprint(ctx context.Context, repository, fingerprint string) (FingerprintState, bool, error)
+	// ListFingerprints returns fingerprints most recently seen first,
+	// optionally filtered by state.
+	ListFingerprints(ctx context.Context, state string) ([]FingerprintState, error)
+
+	GetValue(ctx context.Context, key string) ([]byte, bool, error)
+	SetValue(ctx context.Context, key string, value []byte) error
+	// SetValueIf sets a key only if its value is old, or if it has none
+	// when old is nil, and reports whether it did. Replicas sharing a store
+	// claim work with it.
+	SetValueIf(ctx context.Context, key string, old, value []byte) (bool, error)
+	DeleteValue(ctx context.Context, key string) error
+	// DeleteValueIf deletes a key only if its value is old, and reports
+	// whether it did.
+	DeleteValueIf(ctx context.Context, key string, old []byte) (bool, error)
+	// ListValues returns the values of the keys starting with prefix.
+	ListValues(ctx context.Context, prefix string) (map[string][]byte, error)
+
+	Close() error
+}
+
+// Store drivers.
+const (
+	storeMemory   = "memory"
+	storeSQLite   = "sqlite"
+	storePostgres = "postgres"
+)
+
+// defaultSQLitePath is the database file used when the sqlite driver is
+// chosen without a DSN.
+const defaultSQLitePath = "triage.db"
+
+// StoreConfig selects the storage backend.
+type StoreConfig struct {
+	// Driver is memory (default), sqlite or postgres.
+	Driver string `yaml:"driver"`
+	// DSN is the SQLite file or the Postgres connection string.
+	DSN string `yaml:"dsn"`
+	// Migrate controls applying schema migrations on start. It defaults
+	// to true; turn it off to run "triage db migrate" as a separate
+	// deployment step.
+	Migrate *bool `yaml:"migrate"`
+}
+
+func (c StoreConfig) AutoMigrate() bool {
+	return c.Migrate == nil || *c.Migrate
+}
+
+func (c StoreConfig) dsn() string {
+	if c.Driver == storeSQLite && c.DSN == "" {
+		return defaultSQLitePath
+	}
+	return c.DSN
+}
+
+var store Store = newMemoryStore()
+
+func openStore(cfg StoreConfig) (Store, error) {
+	var dialect string
+	switch cfg.Driver {
+	case "", storeMemory:
+		return newMemoryStore(), nil
+	case storeSQLite, storePostgres:
+		dialect = cfg.Driver
+	default:
+		return nil, fmt.Errorf("unknown store driver %q, expected memory, sqlite or postgres", cfg.Driver)
+	}
+
+	s, err := openSQLStore(dialect, cfg.dsn())
+	if err != nil {
+		return nil, err
+	}
+	if err := prepareSchema(s, cfg.AutoMigrate()); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// prepareSchema applies pending migrations, or with migrations off checks
+// that the schema is already current.
+func prepareSchema(s *sqlStore, autoMigrate bool) error {
+	ctx := context.Background()
+	if !autoMigrate {
+		return s.checkSchema(ctx)
+	}
+
+	applied, err := s.migrate(ctx)
+	for _, m := range applied {
+		slog.Info("Applied database migration", "version", m.Version, "name", m.Name)
+	}
+	return err
+}
+
+// storeMemoryAdapter exposes the store's key/value space as the pipeline
+// memory. Values are stored as JSON.
+type storeMemoryAdapter struct {
+	store Store
+}
+
+func (m storeMemoryAdapter) Get(key string) (any, error) {
+	data, ok, err := m.store.GetValue(context.Background(), "memory:"+key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var v any
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+func (m storeMemoryAdapter) Set(key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return m.store.SetValue(context.Background(), "memory:"+key, data)
+}
+
+func (m storeMemoryAdapter) Append(key string, value any) error {
+	existing, err := m.Get(key)
+	if err != nil {
+		return err
+	}
+	list, _ := existing.([]any)
+	return m.Set(key, append(list, value))
+}
+
+// logStoreError reports a store failure where the caller can't return it.
+func logStoreError(op string, err error) {
+	if err != nil {
+		slog.Error("Store error", "op", op, "error", err)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fingerprintKey identifies the state of a fingerprint in a repository:
+// the same error is tracked separately in each repository it is filed in.
+func fingerprintKey(repository, fingerprint string) string {
+	return strings.ToLower(repository) + ":" + fingerprint
+}
+
+// memoryStore keeps everything in process memory, bounded by maxRuns and
+// maxFingerprints.
+type memoryStore struct {
+	mu           sync.RWMutex
+	runs         map[string]TriageRun
+	fingerprints map[string]FingerprintState
+	values       map[string][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		runs:         make(map[string]TriageRun),
+		fingerprints: make(map[string]FingerprintState),
+		values:       make(map[string][]byte),
+	}
+}
+
+func (s *memoryStore) SaveRun(ctx context.Context, run TriageRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.runs[run.ID] = run
+	for len(s.runs) > maxRuns {
+		var oldest *TriageRun
+		for _, r := range s.runs {
+			if r.Status != runStatusRunning && (oldest == nil || r.StartedAt.Before(oldest.StartedAt)) {
+				oldest = &r
+			}
+		}
+		if oldest == nil {
+			break
+		}
+		delete(s.runs, oldest.ID)
+	}
+	return nil
+}
+
+func (s *memoryStore) SaveRunIf(ctx context.Context, run TriageRun, status string, attempts int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.runs[run.ID]
+	if !ok || current.Status != status || current.Attempts != attempts {
+		return false, nil
+	}
+	s.runs[run.ID] = run
+	return true, nil
+}
+
+func (s *memoryStore) GetRun(ctx context.Context, id string) (TriageRun, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	run, ok := s.runs[id]
+	return run, ok, nil
+}
+
+func (s *memoryStore) ListRuns(ctx context.Context, status string) ([]TriageRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := []TriageRun{}
+	for _, run := range s.runs {
+		if status == "" || run.Status == status {
+			out = append(out, run)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.After(out[j].StartedAt) })
+	return out, nil
+}
+
+func (s *memoryStore) DueRuns(ctx context.Context, now time.Time, limit int) ([]TriageRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := []TriageRun{}
+	for _, run := range s.runs {
+		if run.Status == runStatusFailed && run.NextRetryAt != nil && !run.NextRetryAt.After(now) {
+			out = append(out, run)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].NextRetryAt.Before(*out[j].NextRetryAt) })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (s *memoryStore) SaveFingerprint(ctx context.Context, st FingerprintState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st.History = slices.Clone(st.History)
+	s.fingerprints[fingerprintKey(st.Repository, st.Fingerprint)] = st
+	for len(s.fingerprints) > maxFingerprints {
+		var oldest *FingerprintState
+		for _, f := range s.fingerprints {
+			if oldest == nil || f.LastSeen.Before(oldest.LastSeen) {
+				oldest = &f
+			}
+		}
+		delete(s.fingerprints, fingerprintKey(oldest.Repository, oldest.Fingerprint))
+	}
+	return nil
+}
+
+func (s *memoryStore) GetFingerprint(ctx context.Context, repository, fingerprint string) (FingerprintState, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	st, ok := s.fingerprints[fingerprintKey(repository, fingerprint)]
+	st.History = slices.Clone(st.History)
+	return st, ok, nil
+}
+
+func (s *memoryStore) ListFingerprints(ctx context.Context, state string) ([]FingerprintState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := []FingerprintState{}
+	for _, st := range s.fingerprints {
+		if state == "" || st.State == state {
+			st.History = slices.Clone(st.History)
+			out = append(out, st)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastSeen.After(out[j].LastSeen) })
+	return out, nil
+}
+
+func (s *memoryStore) GetValue(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.values[key]
+	return slices.Clone(v), ok, nil
+}
+
+func (s *memoryStore) SetValue(ctx context.Context, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values[key] = slices.Clone(value)
+	return nil
+}
+
+func (s *memoryStore) SetValueIf(ctx context.Context, key string, old, value []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.values[key]
+	if ok != (old != nil) || !bytes.Equal(current, old) {
+		return false, nil
+	}
+	s.values[key] = slices.Clone(value)
+	return true, nil
+}
+
+func (s *memoryStore) DeleteValue(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.values, key)
+	return nil
+}
+
+func (s *memoryStore) DeleteValueIf(ctx context.Context, key string, old []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.values[key]
+	if !ok || !bytes.Equal(current, old) {
+		return false, nil
+	}
+	delete(s.values, key)
+	return true, nil
+}
+
+func (s *memoryStore) ListValues(ctx context.Context, prefix string) (map[string][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make(map[string][]byte)
+	for k, v := range s.values {
+		if strings.HasPrefix(k, prefix) {
+			out[k] = slices.Clone(v)
+		}
+	}
+	return out, nil
+}
+
+func (s *memoryStore) Close() error { return nil }
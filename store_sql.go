@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// sqlStore keeps state in SQLite or Postgres. Records are stored as JSON,
+// next to the columns they are looked up and ordered by.
+type sqlStore struct {
+	db      *sql.DB
+	dialect string
+}
+
+func openSQLStore(dialect, dsn string) (*sqlStore, error) {
+	driver := "sqlite"
+	if dialect == storePostgres {
+		driver = "pgx"
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == storeSQLite {
+		// SQLite allows one writer; a single connection avoids "database
+		// is locked" errors under concurrent requests.
+		db.SetMaxOpenConns(1)
+	}
+
+	s := &sqlStore{db: db, dialect: dialect}
+	ctx := context.Background()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to %s: %w", dialect, err)
+	}
+	return s, nil
+}
+
+// rebind rewrites "?" placeholders to "$n" for Postgres.
+func (s *sqlStore) rebind(query string) string {
+	if s.dialect != storePostgres {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&sb, "$%d", n)
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func (s *sqlStore) exec(ctx context.Context, query string, args ...any) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(query), args...)
+	return err
+}
+
+func (s *sqlStore) SaveRun(ctx context.Context, run TriageRun) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	return s.exec(ctx, `INSERT INTO runs (id, status, started_at, attempts, next_retry_at, data) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET status = excluded.status, started_at = excluded.started_at,
+			attempts = excluded.attempts, next_retry_at = excluded.next_retry_at, data = excluded.data`,
+		run.ID, run.Status, run.StartedAt.UnixNano(), run.Attempts, runRetryAt(run), string(data))
+}
+
+func (s *sqlStore) SaveRunIf(ctx context.Context, run TriageRun, status string, attempts int) (bool, error) {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return false, err
+	}
+	res, err := s.db.ExecContext(ctx, s.rebind(`UPDATE runs SET status = ?, started_at = ?, attempts = ?, next_retry_at = ?, data = ?
+		WHERE id = ? AND status = ? AND attempts = ?`),
+		run.Status, run.StartedAt.UnixNano(), run.Attempts, runRetryAt(run), string(data), run.ID, status, attempts)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// runRetryAt is the next_retry_at column of a run: NULL unless it is
+// retried.
+func runRetryAt(run TriageRun) any {
+	if run.NextRetryAt == nil {
+		return nil
+	}
+	return run.NextRetryAt.UnixNano()
+}
+
+func (s *sqlStore) GetRun(ctx context.Context, id string) (TriageRun, bool, error) {
+	var run TriageRun
+	ok, err := s.getJSON(ctx, `SELECT data FROM runs WHERE id = ?`, &run, id)
+	return run, ok, err
+}
+
+func (s *sqlStore) ListRuns(ctx context.Context, status string) ([]TriageRun, error) {
+	query := `SELECT data FROM runs ORDER BY started_at DESC`
+	var args []any
+	if status != "" {
+		query = `SELECT data FROM runs WHERE status = ? ORDER BY started_at DESC`
+		args = append(args, status)
+	}
+
+	out := []TriageRun{}
+	err := s.listJSON(ctx, query, args, func(data []byte) error {
+		var run TriageRun
+		if err := json.Unmarshal(data, &run); err != nil {
+			return err
+		}
+		out = append(out, run)
+		return nil
+	})
+	return out, err
+}
+
+func (s *sqlStore) DueRuns(ctx context.Context, now time.Time, limit int) ([]TriageRun, error) {
+	out := []TriageRun{}
+	err := s.listJSON(ctx, `SELECT data FROM runs WHERE status = ? AND next_retry_at <= ? ORDER BY next_retry_at LIMIT ?`,
+		[]any{runStatusFailed, now.UnixNano(), limit}, func(data []byte) error {
+			var run TriageRun
+			if err := json.Unmarshal(data, &run); err != nil {
+				return err
+			}
+			out = append(out, run)
+			return nil
+		})
+	return out, err
+}
+
+func (s *sqlStore) SaveFingerprint(ctx context.Context, st FingerprintState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return s.exec(ctx, `INSERT INTO fingerprint_states (repository, fingerprint, state, last_seen, data) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (repository, fingerprint) DO UPDATE SET state = excluded.state, last_seen = excluded.last_seen, data = excluded.data`,
+		strings.ToLower(st.Repository), st.Fingerprint, st.State, st.LastSeen.UnixNano(), string(data))
+}
+
+func (s *sqlStore) GetFingerprint(ctx context.Context, repository, fingerprint string) (FingerprintState, bool, error) {
+	var st FingerprintState
+	ok, err := s.getJSON(ctx, `SELECT data FROM fingerprint_states WHERE repository = ? AND fingerprint = ?`, &st, strings.ToLower(repository), fingerprint)
+	return st, ok, err
+}
+
+func (s *sqlStore) ListFingerprints(ctx context.Context, state string) ([]FingerprintState, error) {
+	query := `SELECT data FROM fingerprint_states ORDER BY last_seen DESC`
+	var args []any
+	if state != "" {
+		query = `SELECT data FROM fingerprint_states WHERE state = ? ORDER BY last_seen DESC`
+		args = append(args, state)
+	}
+
+	out := []FingerprintState{}
+	err := s.listJSON(ctx, query, args, func(data []byte) error {
+		var st FingerprintState
+		if err := json.Unmarshal(data, &st); err != nil {
+			return err
+		}
+		out = append(out, st)
+		return nil
+	})
+	return out, err
+}
+
+func (s *sqlStore) GetValue(ctx context.Context, key string) ([]byte, bool, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT value FROM kv WHERE key = ?`), key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(value), true, nil
+}
+
+func (s *sqlStore) SetValue(ctx context.Context, key string, value []byte) error {
+	return s.exec(ctx, `INSERT INTO kv (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, key, string(value))
+}
+
+func (s *sqlStore) SetValueIf(ctx context.Context, key string, old, value []byte) (bool, error) {
+	var res sql.Result
+	var err error
+	if old == nil {
+		res, err = s.db.ExecContext(ctx, s.rebind(`INSERT INTO kv (key, value) VALUES (?, ?) ON CONFLICT (key) DO NOTHING`), key, string(value))
+	} else {
+		res, err = s.db.ExecContext(ctx, s.rebind(`UPDATE kv SET value = ? WHERE key = ? AND value = ?`), string(value), key, string(old))
+	}
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *sqlStore) DeleteValue(ctx context.Context, key string) error {
+	return s.exec(ctx, `DELETE FROM kv WHERE key = ?`, key)
+}
+
+func (s *sqlStore) DeleteValueIf(ctx context.Context, key string, old []byte) (bool, error) {
+	res, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM kv WHERE key = ? AND value = ?`), key, string(old))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *sqlStore) ListValues(ctx context.Context, prefix string) (map[string][]byte, error) {
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT key, value FROM kv WHERE key LIKE ? ESCAPE '\'`), pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string][]byte)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		// SQLite's LIKE ignores case.
+		if strings.HasPrefix(key, prefix) {
+			out[key] = []byte(value)
+		}
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlStore) getJSON(ctx context.Context, query string, v any, args ...any) (bool, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, s.rebind(query), args...).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal([]byte(data), v)
+}
+
+func (s *sqlStore) listJSON(ctx context.Context, query string, args []any, fn func([]byte) error) error {
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		if err := fn([]byte(data)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// testStores returns the store backends to run a test against: memory and
+// a migrated SQLite file.
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+	sqlite, err := openStore(StoreConfig{Driver: storeSQLite, DSN: filepath.Join(t.TempDir(), "triage.db")})
+	if err != nil {
+		t.Fatalf("opening sqlite store: %v", err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	return map[string]Store{storeMemory: newMemoryStore(), storeSQLite: sqlite}
+}
+
+func TestSetValueIf(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial []byte
+		old     []byte
+		value   []byte
+		swapped bool
+		want    []byte
+	}{
+		{name: "insert when absent", old: nil, value: []byte("a"), swapped: true, want: []byte("a")},
+		{name: "insert when present", initial: []byte("a"), old: nil, value: []byte("b"), swapped: false, want: []byte("a")},
+		{name: "replace unchanged", initial: []byte("a"), old: []byte("a"), value: []byte("b"), swapped: true, want: []byte("b")},
+		{name: "replace changed", initial: []byte("b"), old: []byte("a"), value: []byte("c"), swapped: false, want: []byte("b")},
+		{name: "replace absent", old: []byte("a"), value: []byte("b"), swapped: false},
+	}
+	for backend, s := range testStores(t) {
+		for i, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				key := "test:set-if:" + string(rune('a'+i))
+				if tt.initial != nil {
+					if err := s.SetValue(ctx, key, tt.initial); err != nil {
+						t.Fatalf("SetValue: %v", err)
+					}
+				}
+				swapped, err := s.SetValueIf(ctx, key, tt.old, tt.value)
+				if err != nil {
+					t.Fatalf("SetValueIf: %v", err)
+				}
+				if swapped != tt.swapped {
+					t.Errorf("swapped = %v, want %v", swapped, tt.swapped)
+				}
+				got, ok, err := s.GetValue(ctx, key)
+				if err != nil {
+					t.Fatalf("GetValue: %v", err)
+				}
+				if ok != (tt.want != nil) || string(got) != string(tt.want) {
+					t.Errorf("value = %q (present %v), want %q", got, ok, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestDeleteValueIf(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial []byte
+		old     []byte
+		deleted bool
+	}{
+		{name: "unchanged", initial: []byte("a"), old: []byte("a"), deleted: true},
+		{name: "changed", initial: []byte("b"), old: []byte("a"), deleted: false},
+		{name: "absent", old: []byte("a"), deleted: false},
+	}
+	for backend, s := range testStores(t) {
+		for i, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				key := "test:delete-if:" + string(rune('a'+i))
+				if tt.initial != nil {
+					if err := s.SetValue(ctx, key, tt.initial); err != nil {
+						t.Fatalf("SetValue: %v", err)
+					}
+				}
+				deleted, err := s.DeleteValueIf(ctx, key, tt.old)
+				if err != nil {
+					t.Fatalf("DeleteValueIf: %v", err)
+				}
+				if deleted != tt.deleted {
+					t.Errorf("deleted = %v, want %v", deleted, tt.deleted)
+				}
+				_, ok, err := s.GetValue(ctx, key)
+				if err != nil {
+					t.Fatalf("GetValue: %v", err)
+				}
+				if want := tt.initial != nil && !tt.deleted; ok != want {
+					t.Errorf("present = %v, want %v", ok, want)
+				}
+			})
+		}
+	}
+}
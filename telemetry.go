@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultTelemetryInterval = 24 * time.Hour
+	// telemetryTitleSample caps how many created issues are looked up per
+	// report to measure title edits.
+	telemetryTitleSample = 50
+	telemetryTimeout     = 2 * time.Minute
+	// telemetryInstanceKey is the store key of the instance's random ID.
+	telemetryInstanceKey = "telemetry:instance"
+)
+
+func init() {
+	metrics.describe("triage_telemetry_reports_total", "counter", "Telemetry reports sent, by result.")
+}
+
+// TelemetryConfig opts in to reporting aggregate quality metrics to a
+// central endpoint, so that teams running many instances can compare
+// configurations. Telemetry is off unless an endpoint is set.
+type TelemetryConfig struct {
+	// Endpoint receives the reports as JSON POSTs.
+	Endpoint string `yaml:"endpoint"`
+	// Interval is how often a report is sent, covering the period since
+	// the last one (default 24h).
+	Interval string `yaml:"interval"`
+}
+
+// telemetryConfig is the configured telemetry.
+var telemetryConfig = TelemetryConfig{Interval: defaultTelemetryInterval.String()}
+
+func configTelemetry(cfg *Config) TelemetryConfig {
+	out := cfg.Telemetry
+	out.Endpoint = envOr("TELEMETRY_ENDPOINT", out.Endpoint)
+	out.Interval = envOr("TELEMETRY_INTERVAL", firstNonEmpty(out.Interval, defaultTelemetryInterval.String()))
+	return out
+}
+
+func validateTelemetry(tc TelemetryConfig) []string {
+	if tc.Endpoint == "" {
+		return nil
+	}
+	var problems []string
+	if u, err := url.Parse(tc.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("telemetry.endpoint (or TELEMETRY_ENDPOINT): %q is not an http(s) URL", tc.Endpoint))
+	}
+	if d, err := time.ParseDuration(tc.Interval); err != nil || d <= 0 {
+		problems = append(problems, fmt.Sprintf("telemetry.interval (or TELEMETRY_INTERVAL): invalid duration %q", tc.Interval))
+	}
+	return problems
+}
+
+// TelemetryReport is what an instance reports. It holds counts and rates
+// only: no repository, issue, log or user data.
+type TelemetryReport struct {
+	// Instance is a random ID, kept in the store, that tells the reports
+	// of one instance apart without identifying it.
+	Instance    string    `json:"instance"`
+	Version     string    `json:"version"`
+	Features    []string  `json:"features"`
+	LLMModel    string    `json:"llm_model"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+
+	Runs          int `json:"runs"`
+	Failed        int `json:"failed"`
+	IssuesCreated int `json:"issues_created"`
+	Duplicates    int `json:"duplicates"`
+	// Reopened counts runs that reopened a closed issue as a regression.
+	Reopened      int     `json:"reopened"`
+	DuplicateRate float64 `json:"duplicate_rate"`
+	ReopenRate    float64 `json:"reopen_rate"`
+	// TitleEditRate is the share of checked issues whose title people
+	// changed from the one the agent wrote. Only GitHub issues are
+	// checked, up to 50 per report.
+	TitlesChecked int      `json:"titles_checked"`
+	TitleEditRate *float64 `json:"title_edit_rate,omitempty"`
+}
+
+// telemetryInstance returns the instance ID, creating it on first use.
+func telemetryInstance(ctx context.Context) string {
+	if data, ok, err := store.GetValue(ctx, telemetryInstanceKey); err == nil && ok {
+		return string(data)
+	}
+	id := newID()
+	logStoreError("save telemetry instance", store.SetValue(ctx, telemetryInstanceKey, []byte(id)))
+	return id
+}
+
+// buildTelemetryReport aggregates the runs of a period.
+func buildTelemetryReport(ctx context.Context, start, end time.Time) TelemetryReport {
+	report := TelemetryReport{
+		Instance:    telemetryInstance(ctx),
+		Version:     version,
+		Features:    features,
+		LLMModel:    llmConfig.Model,
+		PeriodStart: start.UTC(),
+		PeriodEnd:   end.UTC(),
+	}
+
+	stats := &UsageStats{}
+	var created []TriageRun
+	for _, run := range runs.list("") {
+		if run.StartedAt.Before(start) || !run.StartedAt.Before(end) || run.Status == runStatusRunning {
+			continue
+		}
+		stats.addRun(run)
+		if run.Result != nil && run.Result.Reopened {
+			report.Reopened++
+		}
+		if run.Outcome == runOutcomeCreated {
+			created = append(created, run)
+		}
+	}
+	stats.finalize()
+	report.Runs, report.Failed = stats.Runs, stats.Failed
+	report.IssuesCreated, report.Duplicates = stats.IssuesCreated, stats.Duplicates
+	report.DuplicateRate = stats.DuplicateRate
+	if n := stats.IssuesCreated + stats.Duplicates; n > 0 {
+		report.ReopenRate = float64(report.Reopened) / float64(n)
+	}
+
+	if tracker.Name() == trackerGitHub {
+		edited := 0
+		for _, run := range created {
+			if report.TitlesChecked == telemetryTitleSample {
+				break
+			}
+			changed, ok := titleEdited(ctx, run)
+			if !ok {
+				continue
+			}
+			report.TitlesChecked++
+			if changed {
+				edited++
+			}
+		}
+		if report.TitlesChecked > 0 {
+			rate := float64(edited) / float64(report.TitlesChecked)
+			report.TitleEditRate = &rate
+		}
+	}
+	return report
+}
+
+// titleEdited reports whether the title of the issue a run created differs
+// from the one the agent gave it. ok is false when either is unknown.
+func titleEdited(ctx context.Context, run TriageRun) (changed, ok bool) {
+	var title string
+	for _, call := range run.ToolCalls {
+		if t, _ := call.Arguments["title"].(string); call.Tool == "create_github_issue" && call.Error == "" && t != "" {
+			title = t
+		}
+	}
+	target, err := parseRepoTarget(run.Repository)
+	number := 0
+	if run.Result != nil {
+		number = run.Result.IssueNumber
+	}
+	if title == "" || err != nil || number == 0 {
+		return false, false
+	}
+	issue, _, err := ghClient.Issues.Get(ctx, target.Owner, target.Repo, number)
+	if err != nil {
+		return false, false
+	}
+	return issue.GetTitle() != title, true
+}
+
+// startTelemetry sends a report every interval until ctx is done, if
+// telemetry is on.
+func startTelemetry(ctx context.Context) {
+	tc := telemetryConfig
+	if tc.Endpoint == "" {
+		return
+	}
+	interval, _ := time.ParseDuration(tc.Interval)
+	slog.Info("Telemetry enabled", "endpoint", tc.Endpoint, "interval", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		start := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case end := <-ticker.C:
+				sendTelemetry(ctx, tc.Endpoint, start, end)
+				start = end
+			}
+		}
+	}()
+}
+
+func sendTelemetry(ctx context.Context, endpoint string, start, end time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, telemetryTimeout)
+	defer cancel()
+
+	report := buildTelemetryReport(ctx, start, end)
+	resp, err := postJSON(ctx, endpoint, report, nil)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("telemetry endpoint returned %s", resp.Status)
+		}
+	}
+	if err != nil {
+		metrics.add("triage_telemetry_reports_total", `result="failure"`, 1)
+		slog.Warn("Sending telemetry failed", "error", err)
+		return
+	}
+	metrics.add("triage_telemetry_reports_total", `result="success"`, 1)
+	slog.Info("Sent telemetry report", "runs", report.Runs)
+}
+
+// handleAdminTelemetry shows the report that would be sent for the last
+// interval, so that operators can see exactly what leaves the instance.
+func handleAdminTelemetry(w http.ResponseWriter, r *http.Request) {
+	interval, _ := time.ParseDuration(telemetryConfig.Interval)
+	end := time.Now()
+	writeJSON(w, http.StatusOK, buildTelemetryReport(r.Context(), end.Add(-interval), end))
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxTestFailures caps how many new test failures one upload triages.
+	maxTestFailures = 25
+	// maxTestResultsSize bounds an uploaded report.
+	maxTestResultsSize = 20 << 20
+	// maxTestOutput caps the failure output kept per test.
+	maxTestOutput = 8000
+)
+
+const (
+	testPassed  = "pass"
+	testFailed  = "fail"
+	testSkipped = "skip"
+)
+
+// testOutcome is the result of one test in one CI run.
+type testOutcome struct {
+	Suite   string
+	Name    string
+	Outcome string
+	// Message is the failure message, Output the full failure output.
+	Message string
+	Output  string
+}
+
+// junitSuite covers both <testsuites> and <testsuite> roots; suites can
+// nest.
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure"`
+	Error     *junitFailure `xml:"error"`
+	Skipped   *struct{}     `xml:"skipped"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func parseJUnit(data []byte) ([]testOutcome, error) {
+	var root junitSuite
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	// The body was already converted to UTF-8, whatever encoding the XML
+	// declaration names.
+	dec.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+	if err := dec.Decode(&root); err != nil {
+		return nil, err
+	}
+
+	var out []testOutcome
+	var walk func(s junitSuite)
+	walk = func(s junitSuite) {
+		for _, c := range s.Cases {
+			suite := c.Classname
+			if suite == "" {
+				suite = s.Name
+			}
+			o := testOutcome{Suite: suite, Name: c.Name, Outcome: testPassed}
+			failure := c.Failure
+			if failure == nil {
+				failure = c.Error
+			}
+			switch {
+			case failure != nil:
+				o.Outcome = testFailed
+				o.Message = failure.Message
+				if o.Message == "" {
+					o.Message = firstLine(failure.Text)
+				}
+				o.Output = strings.TrimSpace(failure.Text)
+			case c.Skipped != nil:
+				o.Outcome = testSkipped
+			}
+			out = append(out, o)
+		}
+		for _, child := range s.Suites {
+			walk(child)
+		}
+	}
+	walk(root)
+	return out, nil
+}
+
+// goTestEvent is one line of "go test -json" output.
+type goTestEvent struct {
+	Action  string `json:"Action"`
+	Package string `json:"Package"`
+	Test    string `json:"Test"`
+	Output  string `json:"Output"`
+}
+
+// goTestMessagePattern matches the "file_test.go:42: message" lines that
+// t.Error and t.Fatal print.
+var goTestMessagePattern = regexp.MustCompile(`^\s*\w+_test\.go:\d+: (.*)`)
+
+func parseGoTestJSON(data []byte) ([]testOutcome, error) {
+	type key struct{ pkg, test string }
+	outputs := make(map[key]*strings.Builder)
+	var out []testOutcome
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var ev goTestEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return nil, fmt.Errorf("invalid go test -json line: %w", err)
+		}
+		if ev.Test == "" {
+			continue
+		}
+
+		k := key{ev.Package, ev.Test}
+		switch ev.Action {
+		case "output":
+			b := outputs[k]
+			if b == nil {
+				b = &strings.Builder{}
+				outputs[k] = b
+			}
+			if b.Len() < maxTestOutput {
+				b.WriteString(ev.Output)
+			}
+		case "pass", "fail", "skip":
+			o := testOutcome{Suite: ev.Package, Name: ev.Test, Outcome: ev.Action}
+			if ev.Action == "fail" {
+				if b := outputs[k]; b != nil {
+					o.Output = strings.TrimSpace(b.String())
+				}
+				o.Message = goTestFailureMessage(o.Output)
+			}
+			delete(outputs, k)
+			out = append(out, o)
+		}
+	}
+	return out, sc.Err()
+}
+
+// goTestFailureMessage picks the first t.Error/t.Fatal message from a
+// test's output, falling back to the first line that isn't test framing.
+func goTestFailureMessage(output string) string {
+	var fallback string
+	for _, line := range strings.Split(output, "\n") {
+		if m := goTestMessagePattern.FindStringSubmatch(line); m != nil {
+			return strings.TrimSpace(m[1])
+		}
+		trimmed := strings.TrimSpace(line)
+		if fallback == "" && trimmed != "" && !strings.HasPrefix(trimmed, "=== ") && !strings.HasPrefix(trimmed, "--- ") {
+			fallback = trimmed
+		}
+	}
+	return fallback
+}
+
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// TestStats is a test's pass/fail history across uploaded runs.
+type TestStats struct {
+	Suite    string `json:"suite"`
+	Name     string `json:"name"`
+	Runs     int    `json:"runs"`
+	Failures int    `json:"failures"`
+	// Flips counts changes between passing and failing from one run to
+	// the next; a test that flips often is flaky rather than broken.
+	Flips       int       `json:"flips"`
+	LastOutcome string    `json:"last_outcome"`
+	LastRun     time.Time `json:"last_run"`
+}
+
+// Flaky reports whether the test has both passed and failed.
+func (s TestStats) Flaky() bool {
+	return s.Failures > 0 && s.Failures < s.Runs
+}
+
+type testStatsRegistry struct {
+	mu    sync.RWMutex
+	tests map[string]*TestStats
+}
+
+var testStats = &testStatsRegistry{tests: make(map[string]*TestStats)}
+
+// record adds one run's outcome of a test and returns the updated stats.
+// Skipped tests don't count.
+func (reg *testStatsRegistry) record(o testOutcome) TestStats {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	key := o.Suite + "." + o.Name
+	st, ok := reg.tests[key]
+	if !ok {
+		st = &TestStats{Suite: o.Suite, Name: o.Name}
+		reg.tests[key] = st
+	}
+	if o.Outcome == testSkipped {
+		return *st
+	}
+
+	st.Runs++
+	if o.Outcome == testFailed {
+		st.Failures++
+	}
+	if st.LastOutcome != "" && st.LastOutcome != o.Outcome {
+		st.Flips++
+	}
+	st.LastOutcome = o.Outcome
+	st.LastRun = time.Now().UTC()
+	return *st
+}
+
+// list returns tests with failures, flakiest first.
+func (reg *testStatsRegistry) list() []TestStats {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	out := []TestStats{}
+	for _, st := range reg.tests {
+		if st.Failures > 0 {
+			out = append(out, *st)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Flips != out[j].Flips {
+			return out[i].Flips > out[j].Flips
+		}
+		return out[i].Failures > out[j].Failures
+	})
+	return out
+}
+
+// testFinding turns a failed test into a finding keyed by the test and its
+// normalized failure message.
+func testFinding(o testOutcome, stats TestStats) finding {
+	a := &LogAnalysis{
+		Format:    "test",
+		Category:  categoryTest,
+		ErrorType: o.Suite + "." + o.Name,
+		Message:   o.Message,
+	}
+	a.setMetadata("test_suite", o.Suite)
+	a.setMetadata("test_name", o.Name)
+	a.setMetadata("test_history", fmt.Sprintf("failed %d of %d runs, %d pass/fail flips", stats.Failures, stats.Runs, stats.Flips))
+	if stats.Flaky() {
+		a.setMetadata("flaky", "yes")
+		a.addLabel("flaky-test")
+	} else {
+		a.addLabel("test-failure")
+	}
+
+	a.FingerprintKey = strings.Join([]string{"test", o.Suite, o.Name, normalizeMessage(o.Message)}, ":")
+	a.Fingerprint = computeFingerprint(a)
+
+	text := fmt.Sprintf("--- FAIL: %s (%s)\n%s\n", o.Name, o.Suite, truncate(o.Output, maxTestOutput))
+	a.SimHash = fmt.Sprintf("%016x", simhashLog(text))
+	return finding{Name: a.ErrorType, Text: text, Analysis: a}
+}
+
+type TestResultsResponse struct {
+	Repository string          `json:"repository"`
+	Tests      int             `json:"tests"`
+	Failed     int             `json:"failed"`
+	Findings   []FindingResult `json:"findings"`
+}
+
+// handleProcessTestResults records a CI run's test results and triages its
+// failures. The report is JUnit XML or "go test -json" output, chosen by
+// the "format" query parameter ("junit" or "gotest") or detected from the
+// body.
+func handleProcessTestResults(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxTestResultsSize))
+	if err == nil {
+		data, err = decodeText(data, r.Header.Get("Content-Type"))
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "gotest"
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+			format = "junit"
+		}
+	}
+
+	var outcomes []testOutcome
+	switch format {
+	case "junit":
+		outcomes, err = parseJUnit(data)
+	case "gotest":
+		outcomes, err = parseGoTestJSON(data)
+	default:
+		http.Error(w, fmt.Sprintf("Unknown format %q, expected junit or gotest", format), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid %s report: %v", format, err), http.StatusBadRequest)
+		return
+	}
+
+	target, status, err := findingsTarget(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	var findings []finding
+	for _, o := range outcomes {
+		o.Message = normalizeLogText(o.Message)
+		o.Output = normalizeLogText(o.Output)
+		stats := testStats.record(o)
+		if o.Outcome == testFailed {
+			findings = append(findings, testFinding(o, stats))
+		}
+	}
+
+	var stream *findingsStream
+	var emit func(FindingResult)
+	if wantsStream(r) {
+		stream = newFindingsStream(w)
+		emit = stream.result
+	}
+
+	results := triageFindings(r.Context(), target, findings, maxTestFailures, emit)
+	slog.InfoContext(r.Context(), "Processed test report", "format", format, "repository", target.String(), "tests", len(outcomes), "failed", len(findings))
+	resp := TestResultsResponse{
+		Repository: target.String(),
+		Tests:      len(outcomes),
+		Failed:     len(findings),
+		Findings:   results,
+	}
+	if stream != nil {
+		stream.summary(resp)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func handleListTestStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, testStats.list())
+}
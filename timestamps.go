@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// issueTimeLayout is how times are written in issues and comments: in UTC,
+// whatever zone they were logged in.
+const issueTimeLayout = "2006-01-02 15:04 UTC"
+
+// maxTimestampSkew is how far in the future a log timestamp may be, e.g.
+// from a skewed clock, before it is clamped to now.
+const maxTimestampSkew = 5 * time.Minute
+
+// TimestampConfig configures how log timestamps are read.
+type TimestampConfig struct {
+	// DefaultZone is the IANA time zone of timestamps logged without an
+	// offset, e.g. "Europe/Berlin" (default UTC).
+	DefaultZone string `yaml:"default_zone"`
+}
+
+// logLocation is the zone of timestamps logged without an offset.
+var logLocation = time.UTC
+
+func configTimestamps(cfg *Config) TimestampConfig {
+	out := cfg.Timestamps
+	out.DefaultZone = envOr("LOG_TIMEZONE", out.DefaultZone)
+	return out
+}
+
+func validateTimestamps(tc TimestampConfig) []string {
+	if tc.DefaultZone == "" {
+		return nil
+	}
+	if _, err := time.LoadLocation(tc.DefaultZone); err != nil {
+		return []string{fmt.Sprintf("timestamps.default_zone (or LOG_TIMEZONE): %v", err)}
+	}
+	return nil
+}
+
+// timestampFormat is a timestamp format found in logs.
+type timestampFormat struct {
+	pattern *regexp.Regexp
+	parse   func(match string, loc *time.Location) (time.Time, error)
+}
+
+// timestampFormats are the formats log timestamps are read in. Timestamps
+// without an offset are taken to be in the default zone.
+var timestampFormats = []timestampFormat{
+	// ISO 8601 and its relatives: Python's logging (comma before the
+	// fraction), space instead of T, +0200 and UTC zones.
+	{
+		pattern: regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d{1,9})?(?: ?(?:Z|UTC|[+-]\d{2}:?\d{2})\b)?`),
+		parse: func(s string, loc *time.Location) (time.Time, error) {
+			rest := strings.NewReplacer(",", ".", "UTC", "Z", " ", "").Replace(s[11:])
+			s = s[:10] + "T" + rest
+			if n := len(s); n > 5 && (s[n-5] == '+' || s[n-5] == '-') {
+				s = s[:n-2] + ":" + s[n-2:]
+			}
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return t, nil
+			}
+			return time.ParseInLocation("2006-01-02T15:04:05", s, loc)
+		},
+	},
+	// Go's log package and glog.
+	{
+		pattern: regexp.MustCompile(`\b\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(?:\.\d{1,9})?\b`),
+		parse: func(s string, loc *time.Location) (time.Time, error) {
+			return time.ParseInLocation("2006/01/02 15:04:05", s, loc)
+		},
+	},
+	// Common Log Format, as written by Apache and nginx.
+	{
+		pattern: regexp.MustCompile(`\b\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}`),
+		parse: func(s string, _ *time.Location) (time.Time, error) {
+			return time.Parse("02/Jan/2006:15:04:05 -0700", s)
+		},
+	},
+	// RFC 1123, as in HTTP headers and Java's Date.toString.
+	{
+		pattern: regexp.MustCompile(`\b(?:Mon|Tue|Wed|Thu|Fri|Sat|Sun), \d{2} [A-Z][a-z]{2} \d{4} \d{2}:\d{2}:\d{2} (?:GMT|UTC|[+-]\d{4})`),
+		parse: func(s string, _ *time.Location) (time.Time, error) {
+			if t, err := time.Parse(time.RFC1123Z, s); err == nil {
+				return t, nil
+			}
+			return time.Parse(time.RFC1123, s)
+		},
+	},
+	// Syslog, which has no year: the latest one not in the future.
+	{
+		pattern: regexp.MustCompile(`\b(?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec) [ \d]\d \d{2}:\d{2}:\d{2}\b`),
+		parse: func(s string, loc *time.Location) (time.Time, error) {
+			t, err := time.ParseInLocation("Jan _2 15:04:05", s, loc)
+			if err != nil {
+				return t, err
+			}
+			now := time.Now().In(loc)
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			return t, nil
+		},
+	},
+	// Unix time in seconds or milliseconds in the time field of a JSON log.
+	{
+		pattern: regexp.MustCompile(`"(?:ts|time|timestamp|@timestamp)"\s*:\s*\d{10}(?:\d{3}|\.\d{1,9})?\b`),
+		parse: func(s string, _ *time.Location) (time.Time, error) {
+			s = s[strings.LastIndexAny(s, ": ")+1:]
+			if len(s) == 13 && !strings.Contains(s, ".") {
+				ms, err := strconv.ParseInt(s, 10, 64)
+				return time.UnixMilli(ms), err
+			}
+			f, err := strconv.ParseFloat(s, 64)
+			return time.Unix(0, int64(f*float64(time.Second))), err
+		},
+	},
+}
+
+// logTimestamps returns the earliest and latest timestamps in a log, in
+// UTC, and false if it has none. Timestamps before 2000 are taken for
+// something else, and those in the future are clamped to now.
+func logTimestamps(errorLog string) (first, last time.Time, ok bool) {
+	now := time.Now()
+	for _, f := range timestampFormats {
+		for _, m := range f.pattern.FindAllString(errorLog, -1) {
+			t, err := f.parse(m, logLocation)
+			if err != nil || t.Year() < 2000 {
+				continue
+			}
+			if t.After(now.Add(maxTimestampSkew)) {
+				t = now
+			}
+			t = t.UTC()
+			if !ok || t.Before(first) {
+				first = t
+			}
+			if !ok || t.After(last) {
+				last = t
+			}
+			ok = true
+		}
+	}
+	return first, last, ok
+}
+
+// enrichTimestamps records when the error was logged, normalized to UTC,
+// so that issues show one format whatever the log used.
+func enrichTimestamps(errorLog string, analysis *LogAnalysis) {
+	first, last, ok := logTimestamps(errorLog)
+	if !ok {
+		return
+	}
+	analysis.LoggedAt, analysis.LoggedUntil = &first, &last
+	analysis.setMetadata("logged_at", first.Format(issueTimeLayout))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/luisya22/swarmlet"
+)
+
+// maxToolCallResult is how much of a tool result is kept in the run record.
+const maxToolCallResult = 2000
+
+// ToolCall is one tool call of a run, kept in the run record for auditing.
+type ToolCall struct {
+	Tool       string         `json:"tool"`
+	Arguments  map[string]any `json:"arguments,omitempty"`
+	Result     string         `json:"result,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	At         time.Time      `json:"at"`
+	DurationMS int64          `json:"duration_ms"`
+}
+
+// toolCallRecorder collects the tool calls of running runs until they
+// finish.
+type toolCallRecorder struct {
+	mu   sync.Mutex
+	runs map[string][]ToolCall
+}
+
+var toolCalls = &toolCallRecorder{runs: make(map[string][]ToolCall)}
+
+func (rec *toolCallRecorder) record(runID string, call ToolCall) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.runs[runID] = append(rec.runs[runID], call)
+}
+
+// take returns and forgets the tool calls of a run.
+func (rec *toolCallRecorder) take(runID string) []ToolCall {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	calls := rec.runs[runID]
+	delete(rec.runs, runID)
+	return calls
+}
+
+// recordToolCalls records the calls of the tools under the run of ctx.
+func recordToolCalls(ctx context.Context, tools []swarmlet.LLMTool) []swarmlet.LLMTool {
+	id := runID(ctx)
+	for i := range tools {
+		name, execute := tools[i].Name, tools[i].Executor
+		tools[i].Executor = func(args map[string]any) (string, error) {
+			start := time.Now()
+			out, err := execute(args)
+			call := ToolCall{
+				Tool:       name,
+				Arguments:  args,
+				Result:     truncate(out, maxToolCallResult),
+				At:         start.UTC(),
+				DurationMS: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				call.Error = err.Error()
+			}
+			toolCalls.record(id, call)
+			return out, err
+		}
+	}
+	return tools
+}
+
+// logHash identifies an error log in run records without comparing logs.
+func logHash(errorLog string) string {
+	sum := sha256.Sum256([]byte(errorLog))
+	return hex.EncodeToString(sum[:])
+}
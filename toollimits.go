@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luisya22/swarmlet"
+)
+
+const (
+	defaultToolTimeout        = 30 * time.Second
+	defaultToolMaxResultBytes = 16000
+)
+
+func init() {
+	metrics.describe("triage_tool_timeouts_total", "counter", "Tool calls abandoned after exceeding their timeout, by tool.")
+	metrics.describe("triage_tool_results_truncated_total", "counter", "Tool results cut to their maximum size, by tool.")
+}
+
+// ToolLimitsConfig bounds the tool calls of the agent, so that a slow
+// GitHub call or an enormous result can't blow a run's latency or context
+// budget.
+type ToolLimitsConfig struct {
+	// Timeout is how long a tool call may take (default 30s). The agent is
+	// told when a call times out; the call itself is abandoned, not
+	// cancelled, so a write may still be applied.
+	Timeout string `yaml:"timeout"`
+	// MaxResultBytes is the size tool results are truncated to (default
+	// 16000).
+	MaxResultBytes int `yaml:"max_result_bytes"`
+	// Tools overrides the limits of single tools, by name.
+	Tools map[string]ToolLimit `yaml:"tools"`
+}
+
+// ToolLimit overrides the limits of one tool. Unset fields take the
+// defaults of tool_limits.
+type ToolLimit struct {
+	Timeout        string `yaml:"timeout"`
+	MaxResultBytes int    `yaml:"max_result_bytes"`
+}
+
+// toolLimit is a tool's resolved limits.
+type toolLimit struct {
+	timeout  time.Duration
+	maxBytes int
+}
+
+// toolLimits are the limits of each tool, and of tools not listed under
+// "".
+var toolLimits = map[string]toolLimit{"": {timeout: defaultToolTimeout, maxBytes: defaultToolMaxResultBytes}}
+
+// configToolLimits returns the tool limits, with TOOL_TIMEOUT and
+// TOOL_MAX_RESULT_BYTES taking precedence over the defaults in the config
+// file. An unparsable TOOL_MAX_RESULT_BYTES becomes -1, which
+// validateToolLimits reports.
+func configToolLimits(cfg *Config) ToolLimitsConfig {
+	out := cfg.ToolLimits
+	out.Timeout = envOr("TOOL_TIMEOUT", firstNonEmpty(out.Timeout, defaultToolTimeout.String()))
+	if v := os.Getenv("TOOL_MAX_RESULT_BYTES"); v != "" {
+		var err error
+		if out.MaxResultBytes, err = strconv.Atoi(v); err != nil {
+			out.MaxResultBytes = -1
+		}
+	}
+	if out.MaxResultBytes == 0 {
+		out.MaxResultBytes = defaultToolMaxResultBytes
+	}
+	return out
+}
+
+func validateToolLimits(tc ToolLimitsConfig) []string {
+	var problems []string
+	if d, err := time.ParseDuration(tc.Timeout); err != nil || d <= 0 {
+		problems = append(problems, fmt.Sprintf("tool_limits.timeout (or TOOL_TIMEOUT): invalid duration %q", tc.Timeout))
+	}
+	if tc.MaxResultBytes < 0 {
+		problems = append(problems, "tool_limits.max_result_bytes (or TOOL_MAX_RESULT_BYTES): must be a positive number")
+	}
+	var names []string
+	for _, tool := range newToolSession(repoTarget{}, true).tools() {
+		names = append(names, tool.Name)
+	}
+	for _, name := range sortedKeys(tc.Tools) {
+		limit := tc.Tools[name]
+		if !slices.Contains(names, name) {
+			problems = append(problems, fmt.Sprintf("tool_limits.tools: unknown tool %q, expected one of %s", name, strings.Join(names, ", ")))
+		}
+		if limit.Timeout != "" {
+			if d, err := time.ParseDuration(limit.Timeout); err != nil || d <= 0 {
+				problems = append(problems, fmt.Sprintf("tool_limits.tools.%s.timeout: invalid duration %q", name, limit.Timeout))
+			}
+		}
+		if limit.MaxResultBytes < 0 {
+			problems = append(problems, fmt.Sprintf("tool_limits.tools.%s.max_result_bytes: must be a positive number", name))
+		}
+	}
+	return problems
+}
+
+// resolveToolLimits turns validated settings into toolLimits.
+func resolveToolLimits(tc ToolLimitsConfig) map[string]toolLimit {
+	def := toolLimit{maxBytes: tc.MaxResultBytes}
+	def.timeout, _ = time.ParseDuration(tc.Timeout)
+	out := map[string]toolLimit{"": def}
+	for name, limit := range tc.Tools {
+		l := def
+		if limit.Timeout != "" {
+			l.timeout, _ = time.ParseDuration(limit.Timeout)
+		}
+		if limit.MaxResultBytes > 0 {
+			l.maxBytes = limit.MaxResultBytes
+		}
+		out[name] = l
+	}
+	return out
+}
+
+// limitTools applies toolLimits to the executors of the tools. Panics of
+// the executors are recovered as errors, since they run on their own
+// goroutine.
+func limitTools(ctx context.Context, log *slog.Logger, tools []swarmlet.LLMTool) []swarmlet.LLMTool {
+	for i := range tools {
+		name, execute := tools[i].Name, tools[i].Executor
+		limit, ok := toolLimits[name]
+		if !ok {
+			limit = toolLimits[""]
+		}
+		tools[i].Executor = func(args map[string]any) (string, error) {
+			type result struct {
+				out string
+				err error
+			}
+			// Buffered, so that an abandoned call doesn't leak its goroutine
+			// once it returns.
+			done := make(chan result, 1)
+			go func() {
+				defer func() {
+					if rec := recover(); rec != nil {
+						done <- result{err: recoveredPanic(ctx, "tool", rec)}
+					}
+				}()
+				out, err := execute(args)
+				done <- result{out, err}
+			}()
+
+			timer := time.NewTimer(limit.timeout)
+			defer timer.Stop()
+			select {
+			case res := <-done:
+				return capToolResult(log, name, res.out, limit.maxBytes), res.err
+			case <-timer.C:
+				metrics.add("triage_tool_timeouts_total", labelSet("tool", name), 1)
+				log.Warn("Tool call timed out", "tool", name, "timeout", limit.timeout)
+				return "", fmt.Errorf("%s timed out after %s; its effect, if any, may still be applied later", name, limit.timeout)
+			}
+		}
+	}
+	return tools
+}
+
+// capToolResult truncates a result to maxBytes, at a line boundary where
+// possible, and tells the agent how much was cut.
+func capToolResult(log *slog.Logger, tool, out string, maxBytes int) string {
+	if len(out) <= maxBytes {
+		return out
+	}
+	cut := strings.ToValidUTF8(out[:maxBytes], "")
+	if i := strings.LastIndexByte(cut, '\n'); i > maxBytes/2 {
+		cut = cut[:i]
+	}
+	metrics.add("triage_tool_results_truncated_total", labelSet("tool", tool), 1)
+	log.Info("Truncated tool result", "tool", tool, "bytes", len(out), "max_bytes", maxBytes)
+	return fmt.Sprintf("%s\n[Result truncated: %d of %d bytes omitted. Narrow the query if you need the rest.]", cut, len(out)-len(cut), len(out))
+}
@@ -0,0 +1,650 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/luisya22/swarmlet"
+)
+
+// repoTarget is the GitHub repository a triage run searches and files
+// issues in.
+type repoTarget struct {
+	Owner string
+	Repo  string
+}
+
+func (t repoTarget) String() string {
+	return t.Owner + "/" + t.Repo
+}
+
+// parseRepoTarget parses an "owner/repo" string.
+func parseRepoTarget(s string) (repoTarget, error) {
+	owner, repo, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return repoTarget{}, fmt.Errorf("expected owner/repo, got %q", s)
+	}
+	return repoTarget{Owner: owner, Repo: repo}, nil
+}
+
+// toolSession is the per-run state the agent tools are bound to.
+type toolSession struct {
+	target repoTarget
+	// dryRun replaces GitHub writes with recording what would have been
+	// written.
+	dryRun bool
+
+	mu            sync.Mutex
+	searches      int
+	searchErr     error
+	plannedIssues []plannedIssue
+	// plannedComments are the comments a dry run would have posted.
+	plannedComments []plannedComment
+	// createdURLs and foundURLs are the issues the run created and the
+	// search hits it saw, which report_result is checked against.
+	createdURLs []string
+	foundURLs   map[string]bool
+	// foundNumbers maps the numbers of the search hits to their URLs, and
+	// foundClosed are those of the closed ones, which reopen_issue is
+	// limited to.
+	foundNumbers map[int]string
+	foundClosed  []int
+	// createdNumbers are the numbers of the issues the run created, which
+	// the service follows up on after the run.
+	createdNumbers []int
+	// reopened and commented are the existing issues the run reopened and
+	// commented on.
+	reopened  []int
+	commented []int
+	result    *TriageResult
+	// extraLabels may be applied in addition to agentLabels, e.g. the
+	// labels of a registered service.
+	extraLabels []string
+	// analysis and errorLog are the error being triaged, for the issue
+	// template.
+	analysis *LogAnalysis
+	errorLog string
+	// ctx and log carry the request and run IDs and the trace, as the
+	// executors get no context.
+	ctx context.Context
+	log *slog.Logger
+}
+
+// plannedIssue is an issue the agent created, or would have created in a
+// dry run.
+type plannedIssue struct {
+	Title  string   `json:"title"`
+	Body   string   `json:"body"`
+	Labels []string `json:"labels"`
+}
+
+// plannedComment is a comment the agent would have posted in a dry run.
+type plannedComment struct {
+	IssueNumber int    `json:"issue_number"`
+	Body        string `json:"body"`
+}
+
+func newToolSession(target repoTarget, dryRun bool) *toolSession {
+	return &toolSession{target: target, dryRun: dryRun, foundURLs: make(map[string]bool), foundNumbers: make(map[int]string), ctx: context.Background(), log: slog.Default()}
+}
+
+// createdIssues returns how many issues the run actually created on GitHub.
+func (t *toolSession) createdIssues() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.createdURLs)
+}
+
+// foundClosedIssue reports whether a search of the run found the issue
+// closed.
+func (t *toolSession) foundClosedIssue(number int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Contains(t.foundClosed, number)
+}
+
+// allowLabels lets the agent apply labels beyond agentLabels in this run.
+func (t *toolSession) allowLabels(labels ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, label := range labels {
+		if !slices.Contains(agentLabels, label) && !slices.Contains(t.extraLabels, label) {
+			t.extraLabels = append(t.extraLabels, label)
+		}
+	}
+}
+
+// labels returns the labels the agent may apply or remove in this run.
+func (t *toolSession) labels() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append(slices.Clone(agentLabels), t.extraLabels...)
+}
+
+// tools returns the agent tools bound to this session.
+func (t *toolSession) tools() []swarmlet.LLMTool {
+	return []swarmlet.LLMTool{
+		{
+			Name:        "search_github_issues",
+			Description: "Searches for existing issues in the issue tracker based on a query. Returns a list of issue titles, URLs and a snippet of the error each issue describes if found, otherwise indicates no issues found.",
+			Params: map[string]swarmlet.LLMToolFieldProperty{
+				"query": {
+					Type:        "string",
+					Description: "The search query for issues, e.g., 'bug in login module' or 'database connection error'.",
+				},
+			},
+			Executor: t.searchGithubIssues,
+		},
+		{
+			Name:        "create_github_issue",
+			Description: "Creates a new issue in the issue tracker. Provide a title, detailed body, and labels.",
+			Params: map[string]swarmlet.LLMToolFieldProperty{
+				"title": {
+					Type:        "string",
+					Description: "The title of the new issue (e.g., 'Bug: Login failure on homepage').",
+				},
+				"body": {
+					Type:        "string",
+					Description: "The detailed description for the issue, including stack traces or context.",
+				},
+				"labels": {
+					Type:        "array",
+					Description: labelsDescription("An array of labels to apply to the issue."),
+					Enum:        t.labels(),
+				},
+			},
+			Executor: t.createGithubIssues,
+		},
+		{
+			Name:        "add_labels",
+			Description: "Adds labels to an existing issue, e.g. to escalate an issue that keeps recurring.",
+			Params: map[string]swarmlet.LLMToolFieldProperty{
+				"issue_number": {
+					Type:        "integer",
+					Description: "The number of the existing issue.",
+				},
+				"labels": {
+					Type:        "array",
+					Description: labelsDescription("The labels to add."),
+					Enum:        t.labels(),
+				},
+			},
+			Executor: t.addLabels,
+		},
+		{
+			Name:        "remove_labels",
+			Description: "Removes labels from an existing issue.",
+			Params: map[string]swarmlet.LLMToolFieldProperty{
+				"issue_number": {
+					Type:        "integer",
+					Description: "The number of the existing issue.",
+				},
+				"labels": {
+					Type:        "array",
+					Description: "The labels to remove.",
+					Enum:        t.labels(),
+				},
+			},
+			Executor: t.removeLabels,
+		},
+		{
+			Name:        "comment_on_issue",
+			Description: "Adds a comment to an existing issue, e.g. to note that the error occurred again.",
+			Params: map[string]swarmlet.LLMToolFieldProperty{
+				"issue_number": {
+					Type:        "integer",
+					Description: "The number of the existing issue.",
+				},
+				"body": {
+					Type:        "string",
+					Description: "The comment text.",
+				},
+			},
+			Executor: t.commentOnIssue,
+		},
+		{
+			Name:        "reopen_issue",
+			Description: "Reopens a closed issue that the error has recurred in, labels it 'regression' and comments with the new occurrence. Use this instead of creating a duplicate of a closed issue. Only closed issues returned by search_github_issues can be reopened.",
+			Params: map[string]swarmlet.LLMToolFieldProperty{
+				"issue_number": {
+					Type:        "integer",
+					Description: "The number of the closed issue.",
+				},
+				"comment": {
+					Type:        "string",
+					Description: "A short note on the recurrence, e.g. when it happened and what differs from the original report.",
+				},
+			},
+			Executor: t.reopenIssue,
+		},
+		{
+			Name:        "suggest_owners",
+			Description: "Lists the owners of the given source files in the repository's CODEOWNERS file and their most active recent contributors, as likely owners of the error. Read-only: it does not assign anyone.",
+			Params: map[string]swarmlet.LLMToolFieldProperty{
+				"files": {
+					Type:        "array",
+					Description: "File paths from the application frames of the stack trace, e.g. ['internal/db/conn.go'].",
+				},
+			},
+			Executor: t.suggestOwners,
+		},
+		{
+			Name:        "get_file_snippet",
+			Description: "Fetches the source code around a line of a file in the repository, e.g. the location of an application frame of the stack trace. Read-only.",
+			Params: map[string]swarmlet.LLMToolFieldProperty{
+				"path": {
+					Type:        "string",
+					Description: "The file path from the stack trace, e.g. 'internal/db/conn.go'. Build and container path prefixes are stripped.",
+				},
+				"line": {
+					Type:        "integer",
+					Description: "The line number from the stack trace.",
+				},
+				"context": {
+					Type:        "integer",
+					Description: "How many lines to show before and after the line (default 10, at most 30).",
+				},
+			},
+			Executor: t.getFileSnippet,
+		},
+		{
+			Name:        "list_recent_commits",
+			Description: "Lists the latest commits to a file in the repository within a time window, with their SHA, date, author and subject, to connect the error to recent changes. Read-only.",
+			Params: map[string]swarmlet.LLMToolFieldProperty{
+				"path": {
+					Type:        "string",
+					Description: "The file path from the stack trace, e.g. 'internal/db/conn.go'. Build and container path prefixes are stripped.",
+				},
+				"since_days": {
+					Type:        "integer",
+					Description: "How many days back to look (default 14, at most 90).",
+				},
+			},
+			Executor: t.listRecentCommits,
+		},
+		{
+			Name:        "blame_line",
+			Description: "Returns the author, date and commit that last changed a line of a file in the repository, e.g. the line of an application frame of the stack trace. Read-only: it does not assign anyone.",
+			Params: map[string]swarmlet.LLMToolFieldProperty{
+				"path": {
+					Type:        "string",
+					Description: "The file path from the stack trace, e.g. 'internal/db/conn.go'. Build and container path prefixes are stripped.",
+				},
+				"line": {
+					Type:        "integer",
+					Description: "The line number from the stack trace.",
+				},
+			},
+			Executor: t.blameLine,
+		},
+		t.reportResultTool(),
+	}
+
+}
+
+// agentLabels are the labels the agent may apply or remove, unless the
+// config defines a label taxonomy.
+var agentLabels = []string{"bug", "llm created", "enhancement", "db-error", "resource", "oomkilled", "external-dependency", "static-analysis", "test-failure", "flaky-test", "alert"}
+
+// stringListArg reads an array-of-strings tool argument. A missing argument
+// is an empty list.
+func stringListArg(args map[string]any, name string) []string {
+	raw, _ := args[name].([]any)
+	var out []string
+	for _, v := range raw {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// issueNumberArg reads the issue_number tool argument, which arrives as a
+// JSON number.
+func issueNumberArg(args map[string]any, tool string) (int, error) {
+	n, ok := args["issue_number"].(float64)
+	if !ok || n < 1 || n != float64(int(n)) {
+		return 0, fmt.Errorf("missing or invalid 'issue_number' argument for %s", tool)
+	}
+	return int(n), nil
+}
+
+func (t *toolSession) searchGithubIssues(args map[string]any) (string, error) {
+	query, ok := args["query"].(string)
+	if !ok {
+		return "", fmt.Errorf("missing or invalid 'query' argument for search_github_issues")
+	}
+	t.log.Info("Tool call", "tool", "search_github_issues", "tracker", tracker.Name(), "query", query)
+
+	issues, err := searches.search(t.ctx, t.target, query)
+
+	t.mu.Lock()
+	t.searches++
+	if err != nil {
+		t.searchErr = err
+	}
+	t.mu.Unlock()
+
+	if err != nil {
+		t.log.Error("Searching issues failed", "tracker", tracker.Name(), "error", err)
+		return fmt.Sprintf("Error searching issues: %v", err), err
+	}
+
+	if len(issues) == 0 {
+		return "No existing issues found for this query.", nil
+	}
+
+	var results []string
+	t.mu.Lock()
+	for _, issue := range issues {
+		t.foundURLs[issue.URL] = true
+		t.foundNumbers[issue.Number] = issue.URL
+		if issue.Closed && !slices.Contains(t.foundClosed, issue.Number) {
+			t.foundClosed = append(t.foundClosed, issue.Number)
+		}
+	}
+	t.mu.Unlock()
+	for _, issue := range issues {
+		result := fmt.Sprintf("- Title: \"%s\", URL: %s", issue.Title, issue.URL)
+		if tracker.Name() != trackerGitHub {
+			result += fmt.Sprintf(", Number: %d", issue.Number)
+		}
+		if issue.Closed {
+			result += fmt.Sprintf(", State: closed (issue #%d)", issue.Number)
+		}
+		if snippet := issueSnippet(issue.Body); snippet != "" {
+			result += "\n  Snippet: " + snippet
+		}
+		results = append(results, result)
+	}
+	return fmt.Sprintf("Found %d existing issues:\n%s", len(issues), strings.Join(results, "\n")), nil
+
+}
+
+const maxSnippetLength = 200
+
+// errorLinePattern matches lines that look like the error itself rather
+// than prose or stack frames.
+var errorLinePattern = regexp.MustCompile(`(?i)(panic:|exception|\berror\b|\w+error\b|traceback|fatal|oomkilled)`)
+
+// issueSnippet picks the line of an issue body that best identifies the
+// error, so the agent can judge a search hit without fetching the issue:
+// the first error-looking line, plus the fingerprint if the body or its
+// provenance footer has one.
+func issueSnippet(body string) string {
+	var errorLine, fingerprint string
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "```") {
+			continue
+		}
+		if fp, ok := strings.CutPrefix(line, "Fingerprint:"); ok && fingerprint == "" {
+			fingerprint = strings.TrimSpace(fp)
+			continue
+		}
+		if errorLine == "" && errorLinePattern.MatchString(line) {
+			errorLine = line
+		}
+	}
+
+	if p, ok := parseProvenance(body); ok && fingerprint == "" {
+		fingerprint = p.Fingerprint
+	}
+	snippet := truncate(errorLine, maxSnippetLength)
+	if fingerprint != "" {
+		if snippet != "" {
+			snippet += " "
+		}
+		snippet += "(fingerprint " + fingerprint + ")"
+	}
+	return snippet
+}
+
+func (t *toolSession) createGithubIssues(args map[string]any) (string, error) {
+	title, ok := args["title"].(string)
+	if !ok {
+		return "", fmt.Errorf("missing or invalid 'title' argument for create_github_issue")
+	}
+
+	body, ok := args["body"].(string)
+	if !ok {
+		return "", fmt.Errorf("missing or invalid 'body' argument for create_github_issue")
+	}
+
+	labels := appendNew(stringListArg(args, "labels"), requiredLabels...)
+	if rendered, err := t.renderIssueBody(title, body, labels); err != nil {
+		t.log.Error("Issue template failed, using the body as written", "error", err)
+	} else {
+		body = rendered
+	}
+	body += issueFooter(runProvenance(runID(t.ctx)))
+
+	t.log.Info("Tool call", "tool", "create_github_issue", "tracker", tracker.Name(), "title", title, "labels", labels)
+
+	if err := checkAction(t.target, actionCreateIssue, labels); err != nil {
+		t.log.Warn("Refused tool call", "tool", "create_github_issue", "error", err)
+		return fmt.Sprintf("Refused: %v", err), nil
+	}
+
+	t.mu.Lock()
+	t.plannedIssues = append(t.plannedIssues, plannedIssue{Title: title, Body: body, Labels: labels})
+	t.mu.Unlock()
+
+	if t.dryRun {
+		return fmt.Sprintf("Dry run: the issue was not created. It would have had Title: \"%s\", Labels: %v", title, labels), nil
+	}
+
+	res, done := writer.submitAndWait(&githubIntent{
+		Kind:   intentCreate,
+		Target: t.target,
+		Title:  title,
+		Body:   body,
+		Labels: labels,
+		RunID:  runID(t.ctx),
+	})
+	if !done {
+		return "The issue was queued for creation but has not been created yet. Report that the issue is queued.", nil
+	}
+	if res.Err != nil {
+		t.log.Error("Creating issue failed", "tracker", tracker.Name(), "fallback", res.Fallback, "error", res.Err)
+		if res.Fallback != "" {
+			return fmt.Sprintf("Issue creation failed (%v), but the report was not lost: %s", res.Err, res.Fallback), nil
+		}
+		return fmt.Sprintf("Error creating issue: %v", res.Err), res.Err
+	}
+
+	t.log.Info("Created issue", "tracker", tracker.Name(), "issue_url", res.URL)
+	t.mu.Lock()
+	t.createdURLs = append(t.createdURLs, res.URL)
+	t.createdNumbers = append(t.createdNumbers, res.Number)
+	t.mu.Unlock()
+
+	msg := fmt.Sprintf("Issue created successfully! Title: \"%s\", Number: %d, URL: %s", title, res.Number, res.URL)
+	if res.Jira != nil {
+		msg += fmt.Sprintf(". Also created Jira issue %s: %s", res.Jira.Key, res.Jira.URL)
+	}
+	return msg, nil
+}
+
+func (t *toolSession) addLabels(args map[string]any) (string, error) {
+	return t.changeLabels("add_labels", actionAddLabels, intentAddLabels, args)
+}
+
+func (t *toolSession) removeLabels(args map[string]any) (string, error) {
+	return t.changeLabels("remove_labels", actionRemoveLabels, intentRemoveLabels, args)
+}
+
+func (t *toolSession) changeLabels(tool, action, kind string, args map[string]any) (string, error) {
+	number, err := issueNumberArg(args, tool)
+	if err != nil {
+		return "", err
+	}
+	labels := stringListArg(args, "labels")
+	if len(labels) == 0 {
+		return "", fmt.Errorf("missing or invalid 'labels' argument for %s", tool)
+	}
+
+	t.log.Info("Tool call", "tool", tool, "issue_number", number, "labels", labels)
+
+	if err := checkAction(t.target, action, labels); err != nil {
+		t.log.Warn("Refused tool call", "tool", tool, "error", err)
+		return fmt.Sprintf("Refused: %v", err), nil
+	}
+	if t.dryRun {
+		return fmt.Sprintf("Dry run: labels on issue #%d were not changed.", number), nil
+	}
+
+	res, done := writer.submitAndWait(&githubIntent{
+		Kind:        kind,
+		Target:      t.target,
+		IssueNumber: number,
+		Labels:      labels,
+		RunID:       runID(t.ctx),
+	})
+	if !done {
+		return fmt.Sprintf("The label change on issue #%d was queued but has not been applied yet.", number), nil
+	}
+	if res.Err != nil {
+		t.log.Error("Changing labels failed", "issue_number", number, "error", res.Err)
+		return fmt.Sprintf("Error changing labels on issue #%d: %v", number, res.Err), res.Err
+	}
+	return fmt.Sprintf("Labels on issue #%d updated: %s %v", number, tool, labels), nil
+}
+
+func (t *toolSession) commentOnIssue(args map[string]any) (string, error) {
+	number, err := issueNumberArg(args, "comment_on_issue")
+	if err != nil {
+		return "", err
+	}
+	body, ok := args["body"].(string)
+	if !ok || body == "" {
+		return "", fmt.Errorf("missing or invalid 'body' argument for comment_on_issue")
+	}
+
+	t.log.Info("Tool call", "tool", "comment_on_issue", "issue_number", number)
+
+	if err := checkAction(t.target, actionComment, nil); err != nil {
+		t.log.Warn("Refused tool call", "tool", "comment_on_issue", "error", err)
+		return fmt.Sprintf("Refused: %v", err), nil
+	}
+	if t.dryRun {
+		t.mu.Lock()
+		t.plannedComments = append(t.plannedComments, plannedComment{IssueNumber: number, Body: body})
+		t.mu.Unlock()
+		return fmt.Sprintf("Dry run: no comment was added to issue #%d.", number), nil
+	}
+	if s, ok := snoozes.suppress(t.target, number); ok {
+		t.log.Info("Issue is snoozed, not commenting", "issue_number", number)
+		return fmt.Sprintf("Issue #%d is snoozed until %s: no comment was added, the occurrence was counted for the reminder. Treat the error as handled.",
+			number, s.Until.Format(issueTimeLayout)), nil
+	}
+
+	res, done := writer.submitAndWait(&githubIntent{
+		Kind:        intentComment,
+		Target:      t.target,
+		IssueNumber: number,
+		Body:        body,
+		RunID:       runID(t.ctx),
+	})
+	if !done {
+		return fmt.Sprintf("The comment on issue #%d was queued but has not been posted yet.", number), nil
+	}
+	if res.Err != nil {
+		t.log.Error("Commenting failed", "issue_number", number, "error", res.Err)
+		return fmt.Sprintf("Error commenting on issue #%d: %v", number, res.Err), res.Err
+	}
+	t.mu.Lock()
+	t.commented = append(t.commented, number)
+	t.mu.Unlock()
+	return fmt.Sprintf("Comment added to issue #%d: %s", number, res.URL), nil
+}
+
+// regressionLabel is added to issues reopened by the agent.
+const regressionLabel = "regression"
+
+// reopenIssue reopens a closed issue the error recurred in, labels it as a
+// regression and notes the occurrence. The three writes share the issue's
+// lane in the writer, so they are applied in order.
+func (t *toolSession) reopenIssue(args map[string]any) (string, error) {
+	number, err := issueNumberArg(args, "reopen_issue")
+	if err != nil {
+		return "", err
+	}
+	comment, _ := args["comment"].(string)
+
+	t.log.Info("Tool call", "tool", "reopen_issue", "issue_number", number)
+
+	if !t.foundClosedIssue(number) {
+		return fmt.Sprintf("Refused: issue #%d was not found closed by a search in this run. Only reopen closed issues your searches returned.", number), nil
+	}
+	if err := checkAction(t.target, actionReopen, []string{regressionLabel}); err != nil {
+		t.log.Warn("Refused tool call", "tool", "reopen_issue", "error", err)
+		return fmt.Sprintf("Refused: %v", err), nil
+	}
+	if !flags.allows(t.ctx, flagReopen, t.target) {
+		return fmt.Sprintf("Refused: reopening issues is turned off in %s. Treat issue #%d as a duplicate without reopening it.", t.target, number), nil
+	}
+	if t.dryRun {
+		return fmt.Sprintf("Dry run: issue #%d was not reopened.", number), nil
+	}
+
+	note := "**Regression:** this error occurred again after the issue was closed."
+	if comment = strings.TrimSpace(comment); comment != "" {
+		note += "\n\n" + comment
+	}
+	// The writer applies the three in order; all of them are waited for,
+	// within one intentWaitTimeout.
+	steps := []struct {
+		what string
+		done <-chan intentResult
+	}{
+		{"reopening", writer.submit(&githubIntent{Kind: intentReopen, Target: t.target, IssueNumber: number, RunID: runID(t.ctx)})},
+		{fmt.Sprintf("labeling '%s'", regressionLabel), writer.submit(&githubIntent{Kind: intentAddLabels, Target: t.target, IssueNumber: number, Labels: []string{regressionLabel}, RunID: runID(t.ctx)})},
+		{"commenting", writer.submit(&githubIntent{Kind: intentComment, Target: t.target, IssueNumber: number, Body: note, RunID: runID(t.ctx)})},
+	}
+
+	timeout := time.After(intentWaitTimeout)
+	var pending, failed []string
+	for i, step := range steps {
+		var res intentResult
+		select {
+		case res = <-step.done:
+		case <-timeout:
+			for _, rest := range steps[i:] {
+				pending = append(pending, rest.what)
+			}
+		}
+		if pending != nil {
+			break
+		}
+		if res.Err == nil {
+			continue
+		}
+		if i == 0 {
+			t.log.Error("Reopening issue failed", "issue_number", number, "error", res.Err)
+			return fmt.Sprintf("Error reopening issue #%d: %v", number, res.Err), res.Err
+		}
+		t.log.Error("Reopening issue failed", "issue_number", number, "step", step.what, "error", res.Err)
+		failed = append(failed, fmt.Sprintf("%s failed: %v", step.what, res.Err))
+	}
+	if len(pending) == len(steps) {
+		return fmt.Sprintf("Reopening issue #%d was queued but has not been applied yet.", number), nil
+	}
+
+	t.mu.Lock()
+	t.reopened = append(t.reopened, number)
+	t.mu.Unlock()
+	if pending != nil {
+		failed = append(failed, strings.Join(pending, " and ")+" is still queued")
+	}
+	if failed != nil {
+		return fmt.Sprintf("Issue #%d reopened, but %s.", number, strings.Join(failed, "; ")), nil
+	}
+	return fmt.Sprintf("Issue #%d reopened, labeled '%s' and commented.", number, regressionLabel), nil
+}
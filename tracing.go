@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/luisya22/swarmlet"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultServiceName is the service name spans are exported with when none
+// is configured.
+const defaultServiceName = "github-triage"
+
+// TracingConfig exports OpenTelemetry spans of the HTTP handlers, the agent
+// runs, the LLM calls and the tool calls over OTLP/HTTP. Tracing is off
+// unless an endpoint is set. The standard OTEL_EXPORTER_OTLP_ variables,
+// e.g. for headers, are honored too.
+type TracingConfig struct {
+	// Endpoint is the OTLP/HTTP collector, e.g. http://localhost:4318.
+	Endpoint string `yaml:"endpoint"`
+	// ServiceName defaults to github-triage.
+	ServiceName string `yaml:"service_name"`
+	// SampleRatio is the fraction of traces sampled (default 1). Traces
+	// started by a caller keep the caller's decision.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// tracer is a no-op until initTracing installs a provider.
+var tracer = otel.Tracer("github.com/luisya22/swarmlet-github-triage-example")
+
+// configTracing returns the tracing settings, with
+// OTEL_EXPORTER_OTLP_ENDPOINT and OTEL_SERVICE_NAME taking precedence over
+// the config file.
+func configTracing(cfg *Config) TracingConfig {
+	out := cfg.Tracing
+	out.Endpoint = envOr("OTEL_EXPORTER_OTLP_ENDPOINT", out.Endpoint)
+	out.ServiceName = envOr("OTEL_SERVICE_NAME", firstNonEmpty(out.ServiceName, defaultServiceName))
+	if out.SampleRatio == 0 {
+		out.SampleRatio = 1
+	}
+	return out
+}
+
+func validateTracing(tc TracingConfig) []string {
+	var problems []string
+	if tc.Endpoint != "" {
+		if u, err := url.Parse(tc.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("tracing.endpoint (or OTEL_EXPORTER_OTLP_ENDPOINT): %q is not a URL", tc.Endpoint))
+		}
+	}
+	if tc.SampleRatio < 0 || tc.SampleRatio > 1 {
+		problems = append(problems, fmt.Sprintf("tracing.sample_ratio: must be between 0 and 1, got %g", tc.SampleRatio))
+	}
+	return problems
+}
+
+// initTracing installs the tracer provider and instruments the default
+// transport, which the GitHub and LLM clients use. It must run before
+// newLLM so that spans record the rerouted LLM requests. The returned
+// function flushes the pending spans.
+func initTracing(tc TracingConfig) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	if tc.Endpoint == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return noop, nil
+	}
+
+	var opts []otlptracehttp.Option
+	if os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(strings.TrimSuffix(tc.Endpoint, "/")+"/v1/traces"))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return noop, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", tc.ServiceName)))
+	if err != nil {
+		return noop, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(tc.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	// Only requests made within a trace get a span; background work such
+	// as the GitHub writer and the release verifier would otherwise start
+	// a trace per request.
+	http.DefaultTransport = otelhttp.NewTransport(http.DefaultTransport, otelhttp.WithFilter(func(r *http.Request) bool {
+		return trace.SpanFromContext(r.Context()).SpanContext().IsValid()
+	}))
+	return provider.Shutdown, nil
+}
+
+// traceHandler starts a span for each request, named after the route it
+// matches, continuing the caller's trace if it sent one.
+func traceHandler(mux *http.ServeMux) http.Handler {
+	return otelhttp.NewHandler(mux, "http", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			return pattern
+		}
+		return r.Method
+	}))
+}
+
+// endSpan records err on the span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedLLM records a span for each model call, so that LLM latency shows
+// up in the run's trace.
+type tracedLLM struct {
+	swarmlet.LLM
+	provider string
+	model    string
+}
+
+func (l tracedLLM) Generate(ctx context.Context, options swarmlet.LLMOptions, tools []swarmlet.LLMTool, prompt string, messages ...swarmlet.LLMMessage) (swarmlet.LLMMessage, error) {
+	ctx, span := tracer.Start(ctx, "llm.generate", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("llm.provider", l.provider),
+		attribute.String("llm.model", l.model),
+		attribute.Int("llm.messages", len(messages)),
+	))
+	msg, err := l.LLM.Generate(ctx, options, tools, prompt, messages...)
+	endSpan(span, err)
+	return msg, err
+}
+
+// traceTools wraps the tool executors to record a span for each tool call
+// under ctx. swarmlet doesn't pass a context to executors, so the span
+// covers the call, including its GitHub requests, without their details.
+func traceTools(ctx context.Context, tools []swarmlet.LLMTool) []swarmlet.LLMTool {
+	for i := range tools {
+		name, execute := tools[i].Name, tools[i].Executor
+		tools[i].Executor = func(args map[string]any) (string, error) {
+			_, span := tracer.Start(ctx, "tool "+name, trace.WithAttributes(attribute.String("tool.name", name)))
+			out, err := execute(args)
+			endSpan(span, err)
+			return out, err
+		}
+	}
+	return tools
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// Issue tracker backends.
+const (
+	trackerGitHub    = "github"
+	trackerJira      = "jira"
+	trackerBitbucket = "bitbucket"
+	trackerGitea     = "gitea"
+	trackerGitLab    = "gitlab"
+	trackerAzure     = "azure"
+	// trackerForgejo is an alias of trackerGitea.
+	trackerForgejo = "forgejo"
+)
+
+// TrackerIssue is an issue found or created in an issue tracker. Number
+// identifies it within the target, e.g. 42 for owner/repo#42 or OPS-42.
+type TrackerIssue struct {
+	Number int
+	Key    string
+	Title  string
+	Body   string
+	URL    string
+	// Closed is set when the issue is closed, resolved or done.
+	Closed bool
+}
+
+// IssueTracker is where the agent searches and files issues. The agent's
+// tools and the writer only go through this interface. Targets name a
+// GitHub repository; trackers with a single configured project ignore
+// them.
+type IssueTracker interface {
+	Name() string
+	Search(ctx context.Context, target repoTarget, query string) ([]TrackerIssue, error)
+	Create(ctx context.Context, target repoTarget, issue plannedIssue) (TrackerIssue, error)
+	// Comment returns the URL of the new comment.
+	Comment(ctx context.Context, target repoTarget, number int, body string) (string, error)
+	Label(ctx context.Context, target repoTarget, number int, add, remove []string) error
+	Reopen(ctx context.Context, target repoTarget, number int) error
+}
+
+// tracker is the configured issue tracker, GitHub by default.
+var tracker IssueTracker = githubTracker{}
+
+// newTracker returns the issue tracker selected in the config. The config
+// must have been validated.
+func newTracker(cfg *Config) IssueTracker {
+	switch configTracker(cfg) {
+	case trackerJira:
+		return newJiraClient(configJira(cfg), os.Getenv("JIRA_API_TOKEN"))
+	case trackerBitbucket:
+		return newBitbucketClient(configBitbucket(cfg))
+	case trackerGitea, trackerForgejo:
+		return newGiteaClient(configGitea(cfg), os.Getenv("GITEA_TOKEN"))
+	case trackerGitLab:
+		return newGitLabClient(configGitLab(cfg), os.Getenv("GITLAB_TOKEN"))
+	case trackerAzure:
+		return newAzureClient(configAzureDevOps(cfg), os.Getenv("AZURE_DEVOPS_TOKEN"))
+	}
+	return githubTracker{}
+}
+
+// githubTracker files issues in GitHub repositories, retrying transient
+// errors.
+type githubTracker struct{}
+
+func (githubTracker) Name() string { return trackerGitHub }
+
+func (githubTracker) Search(ctx context.Context, target repoTarget, query string) ([]TrackerIssue, error) {
+	searchQuery := fmt.Sprintf("%s is:issue in:title,body repo:%s/%s", query, target.Owner, target.Repo)
+	result, _, err := ghClient.Search.Issues(ctx, searchQuery, nil)
+	if err != nil {
+		return nil, err
+	}
+	var issues []TrackerIssue
+	for _, issue := range result.Issues {
+		issues = append(issues, githubTrackerIssue(target, &issue))
+	}
+	return issues, nil
+}
+
+// Create retries like the other writes, but an attempt that failed may
+// have created the issue anyway, e.g. when GitHub timed out after filing
+// it. Before each retry it looks for an issue with the same provenance
+// fingerprint created since the first attempt, and returns that one.
+func (githubTracker) Create(ctx context.Context, target repoTarget, issue plannedIssue) (TrackerIssue, error) {
+	req := &github.IssueRequest{
+		Title:  &issue.Title,
+		Body:   &issue.Body,
+		Labels: &issue.Labels,
+	}
+	p, _ := parseProvenance(issue.Body)
+	since := time.Now().UTC().Add(-outboxLookback)
+	var created *github.Issue
+	attempt := 0
+	err := retryGitHub(ctx, func() (*github.Response, error) {
+		if attempt++; attempt > 1 && p.Fingerprint != "" {
+			existing, err := findGitHubIssueByFingerprint(ctx, target, p.Fingerprint, since)
+			if err == nil && existing != nil {
+				slog.Warn("Issue creation failed but the issue was created", "fingerprint", p.Fingerprint, "issue_url", existing.GetHTMLURL())
+				metrics.add("triage_outbox_deduplicated_total", `found="reconciled"`, 1)
+				created = existing
+				return nil, nil
+			}
+		}
+		var resp *github.Response
+		var err error
+		created, resp, err = ghClient.Issues.Create(ctx, target.Owner, target.Repo, req)
+		return resp, err
+	})
+	if err != nil {
+		return TrackerIssue{}, err
+	}
+	return githubTrackerIssue(target, created), nil
+}
+
+func (githubTracker) Comment(ctx context.Context, target repoTarget, number int, body string) (string, error) {
+	var comment *github.IssueComment
+	err := retryGitHub(ctx, func() (*github.Response, error) {
+		var resp *github.Response
+		var err error
+		comment, resp, err = ghClient.Issues.CreateComment(ctx, target.Owner, target.Repo, number, &github.IssueComment{Body: &body})
+		return resp, err
+	})
+	if err != nil {
+		return "", err
+	}
+	return comment.GetHTMLURL(), nil
+}
+
+func (githubTracker) Label(ctx context.Context, target repoTarget, number int, add, remove []string) error {
+	if len(add) > 0 {
+		err := retryGitHub(ctx, func() (*github.Response, error) {
+			_, resp, err := ghClient.Issues.AddLabelsToIssue(ctx, target.Owner, target.Repo, number, add)
+			return resp, err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, label := range remove {
+		err := retryGitHub(ctx, func() (*github.Response, error) {
+			return ghClient.Issues.RemoveLabelForIssue(ctx, target.Owner, target.Repo, number, label)
+		})
+		if err != nil {
+			return fmt.Errorf("removing label %q: %w", label, err)
+		}
+	}
+	return nil
+}
+
+func (githubTracker) Reopen(ctx context.Context, target repoTarget, number int) error {
+	state := "open"
+	return retryGitHub(ctx, func() (*github.Response, error) {
+		_, resp, err := ghClient.Issues.Edit(ctx, target.Owner, target.Repo, number, &github.IssueRequest{State: &state})
+		return resp, err
+	})
+}
+
+func githubTrackerIssue(target repoTarget, issue *github.Issue) TrackerIssue {
+	return TrackerIssue{
+		Number: issue.GetNumber(),
+		Key:    fmt.Sprintf("%s#%d", target, issue.GetNumber()),
+		Title:  issue.GetTitle(),
+		Body:   issue.GetBody(),
+		URL:    issue.GetHTMLURL(),
+		Closed: issue.GetState() == "closed",
+	}
+}
+
+// restClient is the JSON REST client the tracker backends other than
+// GitHub are built on.
+type restClient struct {
+	name    string
+	baseURL string
+	auth    func(*http.Request)
+	http    *http.Client
+}
+
+func newRESTClient(name, baseURL string, auth func(*http.Request)) *restClient {
+	return &restClient{
+		name:    name,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		auth:    auth,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do sends in as JSON, if not nil, and decodes the response into out, if
+// not nil. Responses other than 2xx are errors.
+func (c *restClient) do(ctx context.Context, method, path string, in, out any) error {
+	return c.doAs(ctx, method, path, "application/json", in, out)
+}
+
+// doAs is do with another JSON content type, e.g. for JSON Patch.
+func (c *restClient) doAs(ctx context.Context, method, path, contentType string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	c.auth(req)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s %s returned %s: %s", c.name, method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
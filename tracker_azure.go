@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	azureDevOpsURL        = "https://dev.azure.com"
+	azureDevOpsAPIVersion = "7.1"
+	// azureCurrentIteration as iteration_path files bugs in the team's
+	// current iteration.
+	azureCurrentIteration = "current"
+	azureReproSteps       = "Microsoft.VSTS.TCM.ReproSteps"
+)
+
+// azureClosedStates are the Bug states, across the built-in processes,
+// that count as closed.
+var azureClosedStates = []string{"Resolved", "Closed", "Done", "Removed"}
+
+// AzureDevOpsConfig configures the azure tracker, which files Bug work
+// items in Azure Boards. The personal access token is a secret and only
+// read from AZURE_DEVOPS_TOKEN.
+type AzureDevOpsConfig struct {
+	Organization string `yaml:"organization"`
+	Project      string `yaml:"project"`
+	// Team is used to look up the current iteration.
+	Team string `yaml:"team"`
+	// AreaPath is the default area path, e.g. `Shop\Checkout`. Empty
+	// leaves the project's default area.
+	AreaPath string `yaml:"area_path"`
+	// AreaPaths maps target repositories ("owner/repo") to area paths, so
+	// routes and services land in their team's area.
+	AreaPaths map[string]string `yaml:"area_paths"`
+	// IterationPath is an iteration path, or "current" for the current
+	// iteration of Team. Empty leaves the project's default iteration.
+	IterationPath string `yaml:"iteration_path"`
+	// ReopenState is the Bug state a reopened work item is moved to
+	// (default Active, as in the Agile process; Scrum projects use New).
+	ReopenState string `yaml:"reopen_state"`
+}
+
+// configAzureDevOps returns the Azure DevOps settings, with
+// AZURE_DEVOPS_ORG, AZURE_DEVOPS_PROJECT, AZURE_DEVOPS_AREA_PATH and
+// AZURE_DEVOPS_ITERATION_PATH taking precedence over the config file.
+func configAzureDevOps(cfg *Config) AzureDevOpsConfig {
+	out := cfg.AzureDevOps
+	out.Organization = envOr("AZURE_DEVOPS_ORG", out.Organization)
+	out.Project = envOr("AZURE_DEVOPS_PROJECT", out.Project)
+	out.AreaPath = envOr("AZURE_DEVOPS_AREA_PATH", out.AreaPath)
+	out.IterationPath = envOr("AZURE_DEVOPS_ITERATION_PATH", out.IterationPath)
+	if out.ReopenState == "" {
+		out.ReopenState = "Active"
+	}
+	return out
+}
+
+func validateAzureDevOps(ac AzureDevOpsConfig) []string {
+	var problems []string
+	if ac.Organization == "" || ac.Project == "" {
+		problems = append(problems, "azure_devops.organization and azure_devops.project (or AZURE_DEVOPS_ORG and AZURE_DEVOPS_PROJECT) must be set to use Azure Boards")
+	}
+	if os.Getenv("AZURE_DEVOPS_TOKEN") == "" {
+		problems = append(problems, "AZURE_DEVOPS_TOKEN must be set to use Azure Boards")
+	}
+	if ac.IterationPath == azureCurrentIteration && ac.Team == "" {
+		problems = append(problems, "azure_devops.team must be set to use the current iteration")
+	}
+	for _, repo := range sortedKeys(ac.AreaPaths) {
+		if _, err := parseRepoTarget(repo); err != nil {
+			problems = append(problems, fmt.Sprintf("azure_devops.area_paths: %v", err))
+		}
+	}
+	return problems
+}
+
+// azureClient is the IssueTracker for Azure Boards. Issues are Bug work
+// items in the configured project, numbered by work item ID.
+type azureClient struct {
+	*restClient
+	cfg AzureDevOpsConfig
+	// projectURL is the organization URL plus the project.
+	projectURL string
+}
+
+func newAzureClient(ac AzureDevOpsConfig, token string) *azureClient {
+	orgURL := azureDevOpsURL + "/" + url.PathEscape(ac.Organization)
+	return &azureClient{
+		restClient: newRESTClient("azure devops", orgURL, func(req *http.Request) {
+			req.SetBasicAuth("", token)
+		}),
+		cfg:        ac,
+		projectURL: orgURL + "/" + url.PathEscape(ac.Project),
+	}
+}
+
+func (c *azureClient) Name() string { return trackerAzure }
+
+// apiPath returns a project-scoped API path with the API version.
+func (c *azureClient) apiPath(path string, params url.Values) string {
+	if params == nil {
+		params = url.Values{}
+	}
+	if !params.Has("api-version") {
+		params.Set("api-version", azureDevOpsAPIVersion)
+	}
+	return "/" + url.PathEscape(c.cfg.Project) + "/_apis/" + path + "?" + params.Encode()
+}
+
+func (c *azureClient) workItemURL(id int) string {
+	return c.projectURL + "/_workitems/edit/" + strconv.Itoa(id)
+}
+
+type azureWorkItem struct {
+	ID     int            `json:"id"`
+	Fields map[string]any `json:"fields"`
+}
+
+func (c *azureClient) trackerIssue(w azureWorkItem) TrackerIssue {
+	title, _ := w.Fields["System.Title"].(string)
+	body, _ := w.Fields[azureReproSteps].(string)
+	state, _ := w.Fields["System.State"].(string)
+	return TrackerIssue{
+		Number: w.ID,
+		Key:    "AB#" + strconv.Itoa(w.ID),
+		Title:  title,
+		Body:   body,
+		URL:    c.workItemURL(w.ID),
+		Closed: slices.Contains(azureClosedStates, state),
+	}
+}
+
+// wiqlString quotes s as a WIQL string literal.
+func wiqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func (c *azureClient) Search(ctx context.Context, target repoTarget, query string) ([]TrackerIssue, error) {
+	q := wiqlString(query)
+	wiql := map[string]string{"query": fmt.Sprintf(
+		"SELECT [System.Id] FROM WorkItems WHERE [System.TeamProject] = @project AND [System.WorkItemType] = 'Bug' AND ([System.Title] CONTAINS %s OR [%s] CONTAINS WORDS %s) ORDER BY [System.CreatedDate] DESC",
+		q, azureReproSteps, q)}
+	var result struct {
+		WorkItems []struct {
+			ID int `json:"id"`
+		} `json:"workItems"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.apiPath("wit/wiql", url.Values{"$top": {"20"}}), wiql, &result); err != nil {
+		return nil, err
+	}
+	if len(result.WorkItems) == 0 {
+		return nil, nil
+	}
+
+	var ids []string
+	for _, w := range result.WorkItems {
+		ids = append(ids, strconv.Itoa(w.ID))
+	}
+	var items struct {
+		Value []azureWorkItem `json:"value"`
+	}
+	params := url.Values{"ids": {strings.Join(ids, ",")}, "fields": {"System.Title,System.State," + azureReproSteps}}
+	if err := c.do(ctx, http.MethodGet, c.apiPath("wit/workitems", params), nil, &items); err != nil {
+		return nil, err
+	}
+	var issues []TrackerIssue
+	for _, w := range items.Value {
+		issues = append(issues, c.trackerIssue(w))
+	}
+	return issues, nil
+}
+
+// azurePatchOp is a JSON Patch operation on a work item.
+type azurePatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// Create files a Bug with the body as Markdown repro steps, the labels as
+// tags, and the area and iteration paths mapped from the target.
+func (c *azureClient) Create(ctx context.Context, target repoTarget, issue plannedIssue) (TrackerIssue, error) {
+	ops := []azurePatchOp{
+		{Op: "add", Path: "/fields/System.Title", Value: issue.Title},
+		{Op: "add", Path: "/fields/" + azureReproSteps, Value: issue.Body},
+		{Op: "add", Path: "/multilineFieldsFormat/" + azureReproSteps, Value: "Markdown"},
+	}
+	if len(issue.Labels) > 0 {
+		ops = append(ops, azurePatchOp{Op: "add", Path: "/fields/System.Tags", Value: strings.Join(issue.Labels, "; ")})
+	}
+	if area := c.areaPath(target); area != "" {
+		ops = append(ops, azurePatchOp{Op: "add", Path: "/fields/System.AreaPath", Value: area})
+	}
+	iteration, err := c.iterationPath(ctx)
+	if err != nil {
+		return TrackerIssue{}, fmt.Errorf("looking up the current iteration: %w", err)
+	}
+	if iteration != "" {
+		ops = append(ops, azurePatchOp{Op: "add", Path: "/fields/System.IterationPath", Value: iteration})
+	}
+
+	var created azureWorkItem
+	if err := c.doAs(ctx, http.MethodPost, c.apiPath("wit/workitems/$Bug", nil), "application/json-patch+json", ops, &created); err != nil {
+		return TrackerIssue{}, err
+	}
+	out := c.trackerIssue(created)
+	out.Title, out.Body = issue.Title, issue.Body
+	return out, nil
+}
+
+// areaPath returns the area path mapped to the target, or the default.
+func (c *azureClient) areaPath(target repoTarget) string {
+	if area, ok := c.cfg.AreaPaths[target.String()]; ok {
+		return area
+	}
+	return c.cfg.AreaPath
+}
+
+func (c *azureClient) iterationPath(ctx context.Context) (string, error) {
+	if c.cfg.IterationPath != azureCurrentIteration {
+		return c.cfg.IterationPath, nil
+	}
+	var result struct {
+		Value []struct {
+			Path string `json:"path"`
+		} `json:"value"`
+	}
+	path := "/" + url.PathEscape(c.cfg.Project) + "/" + url.PathEscape(c.cfg.Team) +
+		"/_apis/work/teamsettings/iterations?" + url.Values{"$timeframe": {"current"}, "api-version": {azureDevOpsAPIVersion}}.Encode()
+	if err := c.do(ctx, http.MethodGet, path, nil, &result); err != nil {
+		return "", err
+	}
+	if len(result.Value) == 0 {
+		return "", fmt.Errorf("team %q has no current iteration", c.cfg.Team)
+	}
+	return result.Value[0].Path, nil
+}
+
+func (c *azureClient) Comment(ctx context.Context, target repoTarget, number int, body string) (string, error) {
+	var comment struct {
+		ID int `json:"id"`
+	}
+	// Comments are still a preview API.
+	params := url.Values{"api-version": {azureDevOpsAPIVersion + "-preview.4"}, "format": {"markdown"}}
+	if err := c.do(ctx, http.MethodPost, c.apiPath("wit/workItems/"+strconv.Itoa(number)+"/comments", params), map[string]string{"text": body}, &comment); err != nil {
+		return "", err
+	}
+	return c.workItemURL(number), nil
+}
+
+// Label adds and removes tags. Tags are a single field, so the current
+// tags are read and the whole field replaced.
+func (c *azureClient) Label(ctx context.Context, target repoTarget, number int, add, remove []string) error {
+	var item azureWorkItem
+	path := c.apiPath("wit/workitems/"+strconv.Itoa(number), url.Values{"fields": {"System.Tags"}})
+	if err := c.do(ctx, http.MethodGet, path, nil, &item); err != nil {
+		return err
+	}
+
+	current, _ := item.Fields["System.Tags"].(string)
+	var tags []string
+	for _, tag := range strings.Split(current, ";") {
+		if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(remove, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	for _, tag := range add {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	ops := []azurePatchOp{{Op: "add", Path: "/fields/System.Tags", Value: strings.Join(tags, "; ")}}
+	return c.doAs(ctx, http.MethodPatch, c.apiPath("wit/workitems/"+strconv.Itoa(number), nil), "application/json-patch+json", ops, nil)
+}
+
+func (c *azureClient) Reopen(ctx context.Context, target repoTarget, number int) error {
+	ops := []azurePatchOp{{Op: "add", Path: "/fields/System.State", Value: c.cfg.ReopenState}}
+	return c.doAs(ctx, http.MethodPatch, c.apiPath("wit/workitems/"+strconv.Itoa(number), nil), "application/json-patch+json", ops, nil)
+}
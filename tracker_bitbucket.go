@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+)
+
+const bitbucketAPIURL = "https://api.bitbucket.org/2.0"
+
+// BitbucketConfig configures the bitbucket tracker. Credentials are secrets
+// and only read from the environment: BITBUCKET_TOKEN (a repository or
+// workspace access token), or BITBUCKET_APP_PASSWORD for this username.
+type BitbucketConfig struct {
+	Username string `yaml:"username"`
+}
+
+// errBitbucketLabels is returned for label changes: Bitbucket issues have
+// no labels.
+var errBitbucketLabels = errors.New("Bitbucket issues do not support labels")
+
+func configBitbucket(cfg *Config) BitbucketConfig {
+	return BitbucketConfig{Username: envOr("BITBUCKET_USERNAME", cfg.Bitbucket.Username)}
+}
+
+func validateBitbucket(bc BitbucketConfig) []string {
+	if os.Getenv("BITBUCKET_TOKEN") != "" {
+		return nil
+	}
+	if bc.Username == "" || os.Getenv("BITBUCKET_APP_PASSWORD") == "" {
+		return []string{"BITBUCKET_TOKEN, or bitbucket.username (or BITBUCKET_USERNAME) and BITBUCKET_APP_PASSWORD, must be set to use Bitbucket"}
+	}
+	return nil
+}
+
+// bitbucketClient is the IssueTracker for Bitbucket Cloud. Targets are
+// workspace/repository. Issues are created as bugs; their labels are
+// dropped.
+type bitbucketClient struct {
+	*restClient
+}
+
+func newBitbucketClient(bc BitbucketConfig) *bitbucketClient {
+	token, password := os.Getenv("BITBUCKET_TOKEN"), os.Getenv("BITBUCKET_APP_PASSWORD")
+	return &bitbucketClient{newRESTClient("bitbucket", bitbucketAPIURL, func(req *http.Request) {
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+			return
+		}
+		req.SetBasicAuth(bc.Username, password)
+	})}
+}
+
+// bitbucketClosedStates are the issue states that count as closed.
+var bitbucketClosedStates = []string{"resolved", "closed", "invalid", "duplicate", "wontfix"}
+
+type bitbucketIssue struct {
+	ID      int    `json:"id"`
+	Title   string `json:"title"`
+	Content struct {
+		Raw string `json:"raw"`
+	} `json:"content"`
+	Links bitbucketLinks `json:"links"`
+	State string         `json:"state"`
+}
+
+type bitbucketLinks struct {
+	HTML struct {
+		Href string `json:"href"`
+	} `json:"html"`
+}
+
+func (b bitbucketIssue) trackerIssue(target repoTarget) TrackerIssue {
+	return TrackerIssue{
+		Number: b.ID,
+		Key:    fmt.Sprintf("%s#%d", target, b.ID),
+		Title:  b.Title,
+		Body:   b.Content.Raw,
+		URL:    b.Links.HTML.Href,
+		Closed: slices.Contains(bitbucketClosedStates, b.State),
+	}
+}
+
+func bitbucketIssuesPath(target repoTarget) string {
+	return "/repositories/" + url.PathEscape(target.Owner) + "/" + url.PathEscape(target.Repo) + "/issues"
+}
+
+func (c *bitbucketClient) Name() string { return trackerBitbucket }
+
+func (c *bitbucketClient) Search(ctx context.Context, target repoTarget, query string) ([]TrackerIssue, error) {
+	// Bitbucket's query language quotes strings like JQL.
+	q := jqlString(query)
+	params := url.Values{
+		"q":       {fmt.Sprintf("title ~ %s OR content.raw ~ %s", q, q)},
+		"sort":    {"-created_on"},
+		"pagelen": {"20"},
+	}
+	var page struct {
+		Values []bitbucketIssue `json:"values"`
+	}
+	if err := c.do(ctx, http.MethodGet, bitbucketIssuesPath(target)+"?"+params.Encode(), nil, &page); err != nil {
+		return nil, err
+	}
+	var issues []TrackerIssue
+	for _, issue := range page.Values {
+		issues = append(issues, issue.trackerIssue(target))
+	}
+	return issues, nil
+}
+
+func (c *bitbucketClient) Create(ctx context.Context, target repoTarget, issue plannedIssue) (TrackerIssue, error) {
+	req := map[string]any{
+		"title":   issue.Title,
+		"content": map[string]string{"raw": issue.Body},
+		"kind":    "bug",
+	}
+	var created bitbucketIssue
+	if err := c.do(ctx, http.MethodPost, bitbucketIssuesPath(target), req, &created); err != nil {
+		return TrackerIssue{}, err
+	}
+	return created.trackerIssue(target), nil
+}
+
+func (c *bitbucketClient) Comment(ctx context.Context, target repoTarget, number int, body string) (string, error) {
+	req := map[string]any{"content": map[string]string{"raw": body}}
+	var comment struct {
+		Links bitbucketLinks `json:"links"`
+	}
+	path := bitbucketIssuesPath(target) + "/" + strconv.Itoa(number) + "/comments"
+	if err := c.do(ctx, http.MethodPost, path, req, &comment); err != nil {
+		return "", err
+	}
+	return comment.Links.HTML.Href, nil
+}
+
+func (c *bitbucketClient) Label(ctx context.Context, target repoTarget, number int, add, remove []string) error {
+	return errBitbucketLabels
+}
+
+// Reopen sets the issue's state through the changes endpoint, which also
+// records the change in the issue's history.
+func (c *bitbucketClient) Reopen(ctx context.Context, target repoTarget, number int) error {
+	req := map[string]any{"changes": map[string]any{"state": map[string]string{"new": "open"}}}
+	return c.do(ctx, http.MethodPost, bitbucketIssuesPath(target)+"/"+strconv.Itoa(number)+"/changes", req, nil)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// GiteaConfig configures the gitea tracker, which also serves Forgejo. The
+// access token is a secret and only read from GITEA_TOKEN.
+type GiteaConfig struct {
+	// URL is the instance, e.g. https://codeberg.org.
+	URL string `yaml:"url"`
+}
+
+// configGitea returns the Gitea settings, with GITEA_URL taking
+// precedence over the config file.
+func configGitea(cfg *Config) GiteaConfig {
+	return GiteaConfig{URL: envOr("GITEA_URL", cfg.Gitea.URL)}
+}
+
+func validateGitea(gc GiteaConfig) []string {
+	var problems []string
+	if u, err := url.Parse(gc.URL); gc.URL == "" || err != nil || u.Scheme == "" || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("gitea.url (or GITEA_URL): %q must be the URL of the Gitea or Forgejo instance, e.g. https://codeberg.org", gc.URL))
+	}
+	if os.Getenv("GITEA_TOKEN") == "" {
+		problems = append(problems, "GITEA_TOKEN must be set to use Gitea")
+	}
+	return problems
+}
+
+// giteaClient is the IssueTracker for Gitea and Forgejo, which share the
+// same API. Targets are repositories on the instance.
+type giteaClient struct {
+	*restClient
+}
+
+func newGiteaClient(gc GiteaConfig, token string) *giteaClient {
+	return &giteaClient{newRESTClient("gitea", gc.URL+"/api/v1", func(req *http.Request) {
+		req.Header.Set("Authorization", "token "+token)
+	})}
+}
+
+type giteaIssue struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	HTMLURL string `json:"html_url"`
+	State   string `json:"state"`
+}
+
+func (g giteaIssue) trackerIssue(target repoTarget) TrackerIssue {
+	return TrackerIssue{
+		Number: g.Number,
+		Key:    fmt.Sprintf("%s#%d", target, g.Number),
+		Title:  g.Title,
+		Body:   g.Body,
+		URL:    g.HTMLURL,
+		Closed: g.State == "closed",
+	}
+}
+
+func giteaRepoPath(target repoTarget) string {
+	return "/repos/" + url.PathEscape(target.Owner) + "/" + url.PathEscape(target.Repo)
+}
+
+func (c *giteaClient) Name() string { return trackerGitea }
+
+func (c *giteaClient) Search(ctx context.Context, target repoTarget, query string) ([]TrackerIssue, error) {
+	params := url.Values{
+		"q":     {query},
+		"type":  {"issues"},
+		"state": {"all"},
+		"limit": {"20"},
+	}
+	var found []giteaIssue
+	if err := c.do(ctx, http.MethodGet, giteaRepoPath(target)+"/issues?"+params.Encode(), nil, &found); err != nil {
+		return nil, err
+	}
+	var issues []TrackerIssue
+	for _, issue := range found {
+		issues = append(issues, issue.trackerIssue(target))
+	}
+	return issues, nil
+}
+
+// Create creates the issue, then adds its labels by name: creating with
+// labels would require their IDs.
+func (c *giteaClient) Create(ctx context.Context, target repoTarget, issue plannedIssue) (TrackerIssue, error) {
+	var created giteaIssue
+	req := map[string]string{"title": issue.Title, "body": issue.Body}
+	if err := c.do(ctx, http.MethodPost, giteaRepoPath(target)+"/issues", req, &created); err != nil {
+		return TrackerIssue{}, err
+	}
+	if len(issue.Labels) > 0 {
+		// The issue exists: a failure here must not make the writer
+		// create it again through the fallbacks.
+		if err := c.Label(ctx, target, created.Number, issue.Labels, nil); err != nil {
+			slog.ErrorContext(ctx, "Labeling issue failed", "issue_url", created.HTMLURL, "error", err)
+		}
+	}
+	return created.trackerIssue(target), nil
+}
+
+func (c *giteaClient) Comment(ctx context.Context, target repoTarget, number int, body string) (string, error) {
+	var comment struct {
+		HTMLURL string `json:"html_url"`
+	}
+	path := giteaRepoPath(target) + "/issues/" + strconv.Itoa(number) + "/comments"
+	if err := c.do(ctx, http.MethodPost, path, map[string]string{"body": body}, &comment); err != nil {
+		return "", err
+	}
+	return comment.HTMLURL, nil
+}
+
+// Label adds labels by name. Removing a label needs its ID, which is
+// looked up on the issue; labels the issue doesn't have are ignored.
+func (c *giteaClient) Label(ctx context.Context, target repoTarget, number int, add, remove []string) error {
+	path := giteaRepoPath(target) + "/issues/" + strconv.Itoa(number) + "/labels"
+	if len(add) > 0 {
+		if err := c.do(ctx, http.MethodPost, path, map[string][]string{"labels": add}, nil); err != nil {
+			return err
+		}
+	}
+	if len(remove) == 0 {
+		return nil
+	}
+
+	var current []struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &current); err != nil {
+		return err
+	}
+	for _, label := range remove {
+		for _, l := range current {
+			if l.Name != label {
+				continue
+			}
+			if err := c.do(ctx, http.MethodDelete, path+"/"+strconv.FormatInt(l.ID, 10), nil, nil); err != nil {
+				return fmt.Errorf("removing label %q: %w", label, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *giteaClient) Reopen(ctx context.Context, target repoTarget, number int) error {
+	path := giteaRepoPath(target) + "/issues/" + strconv.Itoa(number)
+	return c.do(ctx, http.MethodPatch, path, map[string]string{"state": "open"}, nil)
+}
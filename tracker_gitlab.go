@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const gitlabDefaultURL = "https://gitlab.com"
+
+// GitLabConfig configures the gitlab tracker. The access token is a secret
+// and only read from GITLAB_TOKEN.
+type GitLabConfig struct {
+	// URL is the instance, https://gitlab.com by default.
+	URL string `yaml:"url"`
+	// Project is the path of the project every issue is filed in, e.g.
+	// "group/subgroup/project". When empty, the target repository is used
+	// as the project path.
+	Project string `yaml:"project"`
+}
+
+// configGitLab returns the GitLab settings, with GITLAB_BASE_URL and
+// GITLAB_PROJECT taking precedence over the config file.
+func configGitLab(cfg *Config) GitLabConfig {
+	out := GitLabConfig{
+		URL:     envOr("GITLAB_BASE_URL", cfg.GitLab.URL),
+		Project: envOr("GITLAB_PROJECT", cfg.GitLab.Project),
+	}
+	if out.URL == "" {
+		out.URL = gitlabDefaultURL
+	}
+	return out
+}
+
+func validateGitLab(gc GitLabConfig) []string {
+	var problems []string
+	if u, err := url.Parse(gc.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("gitlab.url (or GITLAB_BASE_URL): %q must be the URL of the GitLab instance, e.g. https://gitlab.example.com", gc.URL))
+	}
+	if os.Getenv("GITLAB_TOKEN") == "" {
+		problems = append(problems, "GITLAB_TOKEN must be set to use GitLab")
+	}
+	return problems
+}
+
+// gitlabClient is the IssueTracker for GitLab. Issue numbers are the
+// project-scoped IIDs shown in the UI.
+type gitlabClient struct {
+	*restClient
+	webURL  string
+	project string
+}
+
+func newGitLabClient(gc GitLabConfig, token string) *gitlabClient {
+	webURL := strings.TrimRight(gc.URL, "/")
+	return &gitlabClient{
+		restClient: newRESTClient("gitlab", webURL+"/api/v4", func(req *http.Request) {
+			req.Header.Set("PRIVATE-TOKEN", token)
+		}),
+		webURL:  webURL,
+		project: gc.Project,
+	}
+}
+
+type gitlabIssue struct {
+	IID         int    `json:"iid"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	WebURL      string `json:"web_url"`
+	State       string `json:"state"`
+}
+
+func (c *gitlabClient) trackerIssue(target repoTarget, g gitlabIssue) TrackerIssue {
+	return TrackerIssue{
+		Number: g.IID,
+		Key:    fmt.Sprintf("%s#%d", c.projectPath(target), g.IID),
+		Title:  g.Title,
+		Body:   g.Description,
+		URL:    g.WebURL,
+		Closed: g.State == "closed",
+	}
+}
+
+// projectPath is the configured project, or the target repository.
+func (c *gitlabClient) projectPath(target repoTarget) string {
+	if c.project != "" {
+		return c.project
+	}
+	return target.String()
+}
+
+// issuesPath addresses the project by its URL-encoded path, which the API
+// accepts in place of the numeric ID.
+func (c *gitlabClient) issuesPath(target repoTarget) string {
+	return "/projects/" + url.PathEscape(c.projectPath(target)) + "/issues"
+}
+
+func (c *gitlabClient) Name() string { return trackerGitLab }
+
+func (c *gitlabClient) Search(ctx context.Context, target repoTarget, query string) ([]TrackerIssue, error) {
+	params := url.Values{
+		"search":   {query},
+		"in":       {"title,description"},
+		"order_by": {"created_at"},
+		"per_page": {"20"},
+	}
+	var found []gitlabIssue
+	if err := c.do(ctx, http.MethodGet, c.issuesPath(target)+"?"+params.Encode(), nil, &found); err != nil {
+		return nil, err
+	}
+	var issues []TrackerIssue
+	for _, issue := range found {
+		issues = append(issues, c.trackerIssue(target, issue))
+	}
+	return issues, nil
+}
+
+func (c *gitlabClient) Create(ctx context.Context, target repoTarget, issue plannedIssue) (TrackerIssue, error) {
+	req := map[string]string{
+		"title":       issue.Title,
+		"description": issue.Body,
+		"labels":      strings.Join(issue.Labels, ","),
+	}
+	var created gitlabIssue
+	if err := c.do(ctx, http.MethodPost, c.issuesPath(target), req, &created); err != nil {
+		return TrackerIssue{}, err
+	}
+	return c.trackerIssue(target, created), nil
+}
+
+func (c *gitlabClient) Comment(ctx context.Context, target repoTarget, number int, body string) (string, error) {
+	var note struct {
+		ID int `json:"id"`
+	}
+	path := c.issuesPath(target) + "/" + strconv.Itoa(number) + "/notes"
+	if err := c.do(ctx, http.MethodPost, path, map[string]string{"body": body}, &note); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s/-/issues/%d#note_%d", c.webURL, c.projectPath(target), number, note.ID), nil
+}
+
+func (c *gitlabClient) Label(ctx context.Context, target repoTarget, number int, add, remove []string) error {
+	req := map[string]string{}
+	if len(add) > 0 {
+		req["add_labels"] = strings.Join(add, ",")
+	}
+	if len(remove) > 0 {
+		req["remove_labels"] = strings.Join(remove, ",")
+	}
+	return c.do(ctx, http.MethodPut, c.issuesPath(target)+"/"+strconv.Itoa(number), req, nil)
+}
+
+func (c *gitlabClient) Reopen(ctx context.Context, target repoTarget, number int) error {
+	return c.do(ctx, http.MethodPut, c.issuesPath(target)+"/"+strconv.Itoa(number), map[string]string{"state_event": "reopen"}, nil)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/luisya22/swarmlet"
+)
+
+const (
+	translationTimeout = 20 * time.Second
+	// minLanguageScore is how many stopwords of a language a message must
+	// contain, and more than of English, to be taken as that language.
+	minLanguageScore = 2
+)
+
+func init() {
+	metrics.describe("triage_translations_total", "counter", "Non-English error messages translated for fingerprinting and search, by result (translated, cached or failed).")
+}
+
+// TranslationConfig configures the translation of localized error messages.
+type TranslationConfig struct {
+	// Disabled keeps localized messages as they are, at the cost of their
+	// errors not deduplicating against their English counterparts.
+	Disabled bool `yaml:"disabled"`
+}
+
+var translationDisabled bool
+
+const translationPrompt = `You translate error messages into English so that they can be matched with the same errors logged in English.
+Reply with the English message only, on one line, without quotes or explanations.
+If the message is the localized version of a standard message of a well-known product, such as Windows, .NET, Java, Oracle, SQL Server or PostgreSQL, reply with that product's exact English message.
+Keep error codes, identifiers, numbers, paths and quoted values unchanged.`
+
+// languageStopwords are common words of the languages localized error
+// messages come in, by language. Words shared between languages count for
+// each of them.
+var languageStopwords = map[string][]string{
+	"English":    {"the", "is", "not", "no", "a", "an", "of", "to", "be", "been", "was", "has", "and", "or", "such", "could", "cannot", "unable", "found", "in", "for", "with", "does", "exist", "object", "failed", "denied", "invalid", "error", "file", "directory"},
+	"German":     {"der", "die", "das", "nicht", "ist", "wurde", "werden", "kann", "konnte", "ein", "eine", "einer", "und", "oder", "mit", "für", "auf", "zu", "vorhanden", "ungültig", "gefunden", "fehler", "zugriff", "verweigert"},
+	"French":     {"le", "la", "les", "est", "pas", "une", "des", "du", "ne", "n'est", "impossible", "introuvable", "erreur", "accès", "refusé", "été", "être", "aucun", "aucune"},
+	"Spanish":    {"el", "los", "las", "se", "es", "una", "del", "por", "puede", "pudo", "encontró", "acceso", "denegado", "está", "existe"},
+	"Portuguese": {"não", "foi", "uma", "da", "os", "ao", "pode", "erro", "encontrado", "acesso", "negado", "está", "existe"},
+	"Italian":    {"il", "non", "è", "una", "della", "di", "impossibile", "errore", "trovato", "stato", "accesso", "negato", "esiste"},
+	"Dutch":      {"het", "niet", "een", "kan", "van", "fout", "gevonden", "worden", "toegang", "geweigerd", "bestaat"},
+}
+
+// detectLanguage guesses the language of a message: by its script for
+// non-Latin scripts, else by its stopwords. It returns "" for English and
+// for messages it can't tell, which are left as they are.
+func detectLanguage(msg string) string {
+	var letters, kana, han int
+	scripts := map[string]int{}
+	for _, r := range msg {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts["Russian"]++
+		case unicode.Is(unicode.Hangul, r):
+			scripts["Korean"]++
+		case unicode.Is(unicode.Greek, r):
+			scripts["Greek"]++
+		case unicode.Is(unicode.Arabic, r):
+			scripts["Arabic"]++
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["Hebrew"]++
+		}
+	}
+	switch {
+	case kana > 0:
+		return "Japanese"
+	case han > 0:
+		return "Chinese"
+	}
+	for _, lang := range sortedKeys(scripts) {
+		// A few foreign letters, e.g. in a quoted name, don't make the
+		// message foreign.
+		if scripts[lang]*4 >= letters {
+			return lang
+		}
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(msg), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	best, bestScore, english := "", 0, 0
+	for _, lang := range sortedKeys(languageStopwords) {
+		score := 0
+		for _, w := range words {
+			for _, stop := range languageStopwords[lang] {
+				if w == stop {
+					score++
+					break
+				}
+			}
+		}
+		if lang == "English" {
+			english = score
+		} else if score > bestScore {
+			best, bestScore = lang, score
+		}
+	}
+	if bestScore < minLanguageScore || bestScore <= english {
+		return ""
+	}
+	return best
+}
+
+// translationKey is the store key of a message's translation.
+func translationKey(msg string) string {
+	sum := sha256.Sum256([]byte(msg))
+	return "translation:" + hex.EncodeToString(sum[:])
+}
+
+// translateAnalysis replaces a non-English message with its English
+// translation and recomputes the fingerprint, so that localized errors
+// deduplicate against their English counterparts and are searched for in
+// English. The original message is kept for the issue. Translations are
+// kept in the store, which keeps a message's fingerprint stable.
+func translateAnalysis(ctx context.Context, analysis *LogAnalysis) {
+	msg := analysis.Message
+	if translationDisabled || msg == "" || analysis.OriginalMessage != "" {
+		return
+	}
+	lang := detectLanguage(msg)
+	if lang == "" {
+		return
+	}
+
+	english, result := "", "cached"
+	if data, ok, err := store.GetValue(ctx, translationKey(msg)); err == nil && ok {
+		english = string(data)
+	} else {
+		logStoreError("get translation", err)
+		english, err = translateMessage(ctx, msg)
+		if err != nil || english == "" {
+			metrics.add("triage_translations_total", `result="failed"`, 1)
+			slog.WarnContext(ctx, "Translating error message failed, keeping it as is", "language", lang, "error", err)
+			return
+		}
+		result = "translated"
+		logStoreError("save translation", store.SetValue(ctx, translationKey(msg), []byte(english)))
+	}
+	metrics.add("triage_translations_total", labelSet("result", result), 1)
+
+	analysis.Language, analysis.OriginalMessage, analysis.Message = lang, msg, english
+	analysis.Fingerprint = computeFingerprint(analysis)
+	slog.InfoContext(ctx, "Translated error message", "language", lang, "fingerprint", analysis.Fingerprint)
+}
+
+// translateMessage asks the model for the English version of a message.
+func translateMessage(ctx context.Context, msg string) (string, error) {
+	if llmKey == "" {
+		return "", nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, translationTimeout)
+	defer cancel()
+
+	node := swarmlet.NewLLmCallNode(
+		swarmlet.WithID("translator"),
+		swarmlet.WithSystemPrompt(translationPrompt),
+		swarmlet.WithPropmtTemplate("%s"),
+		swarmlet.WithLLMOptions(swarmlet.LLMOptions{Temperature: 0, MaxTokens: llmOptions.MaxTokens}),
+	)
+	out, err := swarmlet.NewPipeline("Translation", node, newLLM(llmConfig, llmKey, ""), memory).Run(ctx, msg, "translate-"+newID(), io.Discard)
+	if err != nil {
+		return "", err
+	}
+	out, _, _ = strings.Cut(strings.TrimSpace(out), "\n")
+	out = strings.Trim(strings.TrimSpace(out), "\"'`")
+	// Longer replies are explanations rather than translations.
+	if len(out) > 4*len(msg)+100 {
+		return "", nil
+	}
+	return out, nil
+}
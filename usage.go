@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runKeyTag joins a run ID to the API key given to a run's LLM client.
+// swarmlet's client takes no context and returns no usage, so the run is
+// recovered from the Authorization header in the transport, which removes
+// the tag before the request is sent.
+const runKeyTag = ":triage-run:"
+
+func init() {
+	metrics.describe("triage_llm_tokens_total", "counter", "LLM tokens used by agent runs, by kind (prompt or completion) and stage: the tools the model called in the iteration, or answer.")
+}
+
+// TokenUsage counts the tokens of one or more model calls.
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u *TokenUsage) add(o TokenUsage) {
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.TotalTokens += o.TotalTokens
+}
+
+// RunIteration is one model call of the agent loop: the tokens it used and
+// the tools it asked for, which run before the next iteration. The last
+// iteration of a run usually calls no tools and gives the final answer.
+type RunIteration struct {
+	Iteration int `json:"iteration"`
+	TokenUsage
+	ToolCalls  []string `json:"tool_calls,omitempty"`
+	DurationMS int64    `json:"duration_ms"`
+}
+
+// stage names what an iteration did, for the token metric.
+func (it RunIteration) stage() string {
+	if len(it.ToolCalls) == 0 {
+		return "answer"
+	}
+	var names []string
+	for _, name := range it.ToolCalls {
+		names = appendNew(names, name)
+	}
+	return strings.Join(names, "+")
+}
+
+// usageRecorder collects the iterations of running runs until they finish.
+type usageRecorder struct {
+	mu   sync.Mutex
+	runs map[string][]RunIteration
+}
+
+var llmUsage = &usageRecorder{runs: make(map[string][]RunIteration)}
+
+func (u *usageRecorder) record(runID string, it RunIteration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	it.Iteration = len(u.runs[runID]) + 1
+	u.runs[runID] = append(u.runs[runID], it)
+
+	stage := it.stage()
+	metrics.add("triage_llm_tokens_total", labelSet("kind", "prompt", "stage", stage), float64(it.PromptTokens))
+	metrics.add("triage_llm_tokens_total", labelSet("kind", "completion", "stage", stage), float64(it.CompletionTokens))
+}
+
+// take returns and forgets the iterations of a run.
+func (u *usageRecorder) take(runID string) []RunIteration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	its := u.runs[runID]
+	delete(u.runs, runID)
+	return its
+}
+
+func tagRunKey(apiKey, runID string) string {
+	if runID == "" {
+		return apiKey
+	}
+	return apiKey + runKeyTag + runID
+}
+
+// usageTransport records the usage of the model calls made for a run, read
+// from the OpenAI-compatible chat completion responses.
+type usageTransport struct {
+	base http.RoundTripper
+}
+
+func (t *usageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	auth, runID, ok := strings.Cut(req.Header.Get("Authorization"), runKeyTag)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	// The run goes on in the context, for the routing of canary runs.
+	req = req.Clone(contextWithRunID(req.Context(), runID))
+	req.Header.Set("Authorization", auth)
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var completion struct {
+		Usage   TokenUsage `json:"usage"`
+		Choices []struct {
+			Message struct {
+				ToolCalls []struct {
+					Function struct {
+						Name string `json:"name"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if json.Unmarshal(body, &completion) == nil {
+		it := RunIteration{TokenUsage: completion.Usage, DurationMS: time.Since(start).Milliseconds()}
+		for _, choice := range completion.Choices {
+			for _, call := range choice.Message.ToolCalls {
+				it.ToolCalls = append(it.ToolCalls, call.Function.Name)
+			}
+		}
+		llmUsage.record(runID, it)
+	}
+	return resp, nil
+}
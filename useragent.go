@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// version is the version of the service, set at build time with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// clientTagHeader carries an optional tag naming the caller, e.g. a CI
+// pipeline or a team, which is passed on to GitHub and the LLM provider in
+// the User-Agent.
+const clientTagHeader = "X-Client-Tag"
+
+// validUserAgentToken limits the service name, tenant and client tags that
+// go into the User-Agent.
+var validUserAgentToken = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// UserAgentConfig names the service in the User-Agent of its outgoing
+// requests, so that upstream providers' dashboards and abuse reports can
+// be traced back to the deployment.
+type UserAgentConfig struct {
+	// Service defaults to github-triage.
+	Service string `yaml:"service"`
+	// Tenant identifies the team or customer running the deployment.
+	Tenant string `yaml:"tenant"`
+}
+
+// userAgentConfig is the configured User-Agent, read by the transport.
+var userAgentConfig = UserAgentConfig{Service: defaultServiceName}
+
+// configUserAgent returns the User-Agent settings, with USER_AGENT_SERVICE
+// and TENANT taking precedence over the config file.
+func configUserAgent(cfg *Config) UserAgentConfig {
+	out := cfg.UserAgent
+	out.Service = envOr("USER_AGENT_SERVICE", firstNonEmpty(out.Service, defaultServiceName))
+	out.Tenant = envOr("TENANT", out.Tenant)
+	return out
+}
+
+func validateUserAgent(uc UserAgentConfig) []string {
+	var problems []string
+	if !validUserAgentToken.MatchString(uc.Service) {
+		problems = append(problems, fmt.Sprintf("user_agent.service (or USER_AGENT_SERVICE): %q must be 1 to 64 letters, digits, dots, dashes or underscores", uc.Service))
+	}
+	if uc.Tenant != "" && !validUserAgentToken.MatchString(uc.Tenant) {
+		problems = append(problems, fmt.Sprintf("user_agent.tenant (or TENANT): %q must be 1 to 64 letters, digits, dots, dashes or underscores", uc.Tenant))
+	}
+	return problems
+}
+
+// userAgent returns the User-Agent of requests made with ctx, e.g.
+// "github-triage/v1.2.3 (tenant=payments; client=ci-nightly)".
+func userAgent(ctx context.Context) string {
+	ua := userAgentConfig.Service + "/" + version
+	var comments []string
+	if userAgentConfig.Tenant != "" {
+		comments = append(comments, "tenant="+userAgentConfig.Tenant)
+	}
+	if tag := clientTag(ctx); tag != "" {
+		comments = append(comments, "client="+tag)
+	}
+	if len(comments) > 0 {
+		ua += " (" + strings.Join(comments, "; ") + ")"
+	}
+	return ua
+}
+
+// installUserAgent sets the User-Agent of every outgoing request, which
+// covers the GitHub and LLM clients.
+func installUserAgent(uc UserAgentConfig) {
+	userAgentConfig = uc
+	http.DefaultTransport = &userAgentTransport{base: http.DefaultTransport}
+}
+
+type userAgentTransport struct {
+	base http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent(req.Context()))
+	return t.base.RoundTrip(req)
+}
+
+type clientTagKey struct{}
+
+// withClientTag keeps the caller's X-Client-Tag in the request context.
+// Tags that don't fit in a User-Agent are ignored.
+func withClientTag(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tag := r.Header.Get(clientTagHeader); validUserAgentToken.MatchString(tag) {
+			r = r.WithContext(contextWithClientTag(r.Context(), tag))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func contextWithClientTag(ctx context.Context, tag string) context.Context {
+	if tag == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, clientTagKey{}, tag)
+}
+
+func clientTag(ctx context.Context) string {
+	tag, _ := ctx.Value(clientTagKey{}).(string)
+	return tag
+}
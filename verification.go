@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// defaultVerifyWindow is how long a fixed fingerprint is watched for
+// regressions before it is declared resolved.
+const defaultVerifyWindow = 7 * 24 * time.Hour
+
+// maxRegressionNote caps the log quoted in a regression comment.
+const maxRegressionNote = 4000
+
+// fixVersionLabelPrefix marks the release a closed issue was fixed in, when
+// the issue's milestone doesn't name it, e.g. "fixed-in:v1.4.2".
+const fixVersionLabelPrefix = "fixed-in:"
+
+var (
+	versionPattern   = regexp.MustCompile(`^v?\d+(\.\d+)*`)
+	issueNumberInURL = regexp.MustCompile(`/issues/(\d+)`)
+
+	verifyWindow = defaultVerifyWindow
+)
+
+// versionOlder reports whether release a is older than release b. Versions
+// are compared numerically by their dotted prefix ("v1.10.0-rc1" is 1.10.0);
+// versions that don't parse are never older, so an unknown release counts
+// as a regression.
+func versionOlder(a, b string) bool {
+	pa, okA := parseVersion(a)
+	pb, okB := parseVersion(b)
+	if !okA || !okB {
+		return false
+	}
+	for i := 0; i < max(len(pa), len(pb)); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return false
+}
+
+func parseVersion(v string) ([]int, bool) {
+	m := versionPattern.FindString(strings.TrimSpace(v))
+	if m == "" {
+		return nil, false
+	}
+	var parts []int
+	for _, p := range strings.Split(strings.TrimPrefix(m, "v"), ".") {
+		n, _ := strconv.Atoi(p)
+		parts = append(parts, n)
+	}
+	return parts, true
+}
+
+// issueNumberFromURL extracts the number from an issue's HTML URL.
+func issueNumberFromURL(url string) (int, bool) {
+	m := issueNumberInURL.FindStringSubmatch(url)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	return n, err == nil
+}
+
+// issueFixVersion returns the release a closed issue was fixed in: its
+// milestone if that is a version, otherwise a "fixed-in:" label. A
+// milestone that isn't newer than lastSeen, the release the error was last
+// seen from, is the release the issue was filed for rather than its fix.
+func issueFixVersion(issue *github.Issue, lastSeen string) string {
+	if title := issue.GetMilestone().GetTitle(); versionPattern.MatchString(title) && (!versionPattern.MatchString(lastSeen) || versionOlder(lastSeen, title)) {
+		return title
+	}
+	for _, label := range issue.Labels {
+		if v, ok := strings.CutPrefix(label.GetName(), fixVersionLabelPrefix); ok && v != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// startReleaseVerifier checks fingerprint issues every interval until ctx
+// is done.
+func startReleaseVerifier(ctx context.Context, interval time.Duration) {
+	slog.Info("Release verification enabled", "interval", interval, "window", verifyWindow)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			verifyReleases(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// verifyReleases advances fingerprints through the fix part of their
+// lifecycle:
+//   - open states whose issue was closed move to fixed, with the fix
+//     version from the issue;
+//   - fixed fingerprints with a fix version start a verification window;
+//   - verifying fingerprints whose window passed quietly get a "verified
+//     fixed" comment and are resolved.
+//
+// Regressions during the window are detected as logs arrive, see
+// lifecycleRegistry.observe.
+func verifyReleases(ctx context.Context) {
+	for _, st := range lifecycles.list("") {
+		var err error
+		switch st.State {
+		case stateFiled, stateAcknowledged, stateRegressed:
+			err = checkIssueClosed(ctx, st)
+		case stateFixed:
+			if st.FixVersion != "" {
+				until := time.Now().UTC().Add(verifyWindow)
+				_, err = lifecycles.transitionWith(st.Repository, st.Fingerprint, stateVerifying, "verifying fix in "+st.FixVersion, func(s *FingerprintState) {
+					s.VerifyUntil = &until
+				})
+			}
+		case stateVerifying:
+			if st.VerifyUntil != nil && time.Now().After(*st.VerifyUntil) {
+				err = resolveVerified(st)
+			}
+		}
+		if err != nil {
+			slog.Error("Release verification failed", "fingerprint", st.Fingerprint, "error", err)
+		}
+	}
+}
+
+func checkIssueClosed(ctx context.Context, st FingerprintState) error {
+	target, err := parseRepoTarget(st.Repository)
+	if err != nil {
+		return err
+	}
+	number, ok := issueNumberFromURL(st.IssueURL)
+	if !ok {
+		return nil
+	}
+
+	issue, _, err := ghClient.Issues.Get(ctx, target.Owner, target.Repo, number)
+	if err != nil {
+		return err
+	}
+	if issue.GetState() != "closed" {
+		return nil
+	}
+
+	fixVersion := issueFixVersion(issue, st.LastVersion)
+	reason := "issue closed"
+	if fixVersion != "" {
+		reason += ", fixed in " + fixVersion
+	}
+	_, err = lifecycles.transitionWith(st.Repository, st.Fingerprint, stateFixed, reason, func(s *FingerprintState) {
+		s.FixVersion = fixVersion
+	})
+	return err
+}
+
+func resolveVerified(st FingerprintState) error {
+	target, err := parseRepoTarget(st.Repository)
+	if err != nil {
+		return err
+	}
+	if number, ok := issueNumberFromURL(st.IssueURL); ok {
+		writer.submit(&githubIntent{
+			Kind:        intentComment,
+			Target:      target,
+			IssueNumber: number,
+			Body: fmt.Sprintf("Verified fixed in %s: fingerprint `%s` has not been seen from %s or newer since the fix was released.",
+				st.FixVersion, st.Fingerprint, st.FixVersion),
+		})
+	}
+	_, err = lifecycles.transition(st.Repository, st.Fingerprint, stateResolved, "no occurrences during the verification window")
+	return err
+}
+
+// reopenRegression reopens the issue of a fingerprint that regressed and
+// explains why. The writes are queued; the request doesn't wait for them.
+func reopenRegression(st FingerprintState, version, errorLog string) {
+	target, err := parseRepoTarget(st.Repository)
+	if err != nil {
+		return
+	}
+	number, ok := issueNumberFromURL(st.IssueURL)
+	if !ok || !flags.allows(context.Background(), flagReopen, target) {
+		return
+	}
+	slog.Info("Fingerprint regressed, reopening its issue", "fingerprint", st.Fingerprint, "issue_url", st.IssueURL)
+
+	seenIn := "a release that was not reported"
+	if version != "" {
+		seenIn = version
+	}
+	note := fmt.Sprintf("**Regression:** fingerprint `%s` reappeared in %s", st.Fingerprint, seenIn)
+	if st.FixVersion != "" {
+		note += fmt.Sprintf(" after being fixed in %s", st.FixVersion)
+	}
+	note += ".\n\n```\n" + truncate(errorLog, maxRegressionNote) + "\n```"
+
+	writer.submit(&githubIntent{Kind: intentReopen, Target: target, IssueNumber: number})
+	writer.submit(&githubIntent{Kind: intentComment, Target: target, IssueNumber: number, Body: note})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// BuildInfo describes the running service, for debugging changes in the
+// agent's behavior.
+type BuildInfo struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit,omitempty"`
+	CommitTime string `json:"commit_time,omitempty"`
+	// Modified is set when the build had uncommitted changes.
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+	// Features lists the optional features that are on, and the drivers in
+	// use, e.g. "tracker:github" or "rate_limit".
+	Features []string `json:"features"`
+	// ConfigHash identifies the settings of the config file. Environment
+	// overrides are not included.
+	ConfigHash string `json:"config_hash"`
+	// PromptVersion identifies the system prompt and issue templates.
+	PromptVersion string `json:"prompt_version"`
+}
+
+var (
+	// features and configHash are set on start.
+	features   []string
+	configHash string
+)
+
+// hashConfig returns a short hash of the decoded config, which doesn't
+// change with comments or formatting.
+func hashConfig(cfg *Config) string {
+	data, _ := json.Marshal(cfg)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// enabledFeatures lists the features the service started with.
+func enabledFeatures(cfg *Config) []string {
+	out := []string{
+		"tracker:" + tracker.Name(),
+		"llm:" + llmConfig.Provider,
+		"store:" + firstNonEmpty(configStore(cfg).Driver, "memory"),
+		"cache:" + firstNonEmpty(configCache(cfg).Driver, "memory"),
+	}
+	for _, f := range []struct {
+		name string
+		on   bool
+	}{
+		{"tracing", configTracing(cfg).Endpoint != ""},
+		{"signing", len(signingSecrets) > 0},
+		{"api_keys", len(apiKeys) > 0},
+		{"rate_limit", limiter != nil},
+		{"admin_api", adminToken != ""},
+		{"webhooks", webhookSecret != ""},
+		{"jira_dual_write", jira != nil},
+		{"servicenow", serviceNow != nil},
+		{"oncall", onCall != nil},
+		{"selftest", envOr("SELFTEST_INTERVAL", cfg.SelfTest.Interval) != ""},
+		{"release_verification", envOr("VERIFY_INTERVAL", cfg.Verification.Interval) != ""},
+		{"ansi_markdown", ansiRendering == ansiMarkdown},
+		{"sandbox", sandboxTarget != nil},
+		{"telemetry", configTelemetry(cfg).Endpoint != ""},
+		{"project_board", configProject(cfg).enabled()},
+	} {
+		if f.on {
+			out = append(out, f.name)
+		}
+	}
+	return out
+}
+
+// buildInfo returns the version set at build time and the VCS information
+// Go embeds in builds of a checkout.
+func buildInfo() BuildInfo {
+	info := BuildInfo{Version: version, GoVersion: runtime.Version(), Features: features, ConfigHash: configHash, PromptVersion: promptVersion}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info.Commit = s.Value
+			case "vcs.time":
+				info.CommitTime = s.Value
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	return info
+}
+
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildInfo())
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+func init() {
+	metrics.describe("triage_bot_commands_total", "counter", "Bot commands in issue comments, by command and outcome (applied, refused or failed).")
+}
+
+// webhookSecret verifies GitHub webhook deliveries. The webhook listener is
+// disabled when it is not set.
+var webhookSecret string
+
+// botCommandPrefix starts a bot command line in an issue comment, e.g.
+// "/triage snooze 7d".
+const botCommandPrefix = "/triage"
+
+// botCommandAssociations may run bot commands: people with write access
+// to the repository.
+var botCommandAssociations = []string{"OWNER", "MEMBER", "COLLABORATOR"}
+
+// botCommand is a command from an issue comment.
+type botCommand struct {
+	Name string
+	Args []string
+
+	Target      repoTarget
+	IssueNumber int
+	IssueURL    string
+	// Labels are the issue's labels when the command was posted.
+	Labels []string
+	// By is the login of the commenter.
+	By string
+}
+
+// botCommands handle the commands by name. They return the reply to post
+// on the issue, if any.
+var botCommands = map[string]func(ctx context.Context, cmd botCommand) (string, error){
+	"snooze":          runSnoozeCommand,
+	"unsnooze":        runUnsnoozeCommand,
+	"dedupe-into":     runDedupeIntoCommand,
+	"not-a-duplicate": runNotADuplicateCommand,
+	"severity":        runSeverityCommand,
+}
+
+// parseBotCommands returns the commands in a comment, one per line.
+func parseBotCommands(body string) []botCommand {
+	var cmds []botCommand
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != botCommandPrefix {
+			continue
+		}
+		cmds = append(cmds, botCommand{Name: strings.ToLower(fields[1]), Args: fields[2:]})
+	}
+	return cmds
+}
+
+// handleGitHubWebhook receives the issue_comment events of the repositories
+// the service files issues in and runs the bot commands in new comments.
+func handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	if webhookSecret == "" {
+		http.Error(w, "Webhook listener is disabled: GITHUB_WEBHOOK_SECRET is not set", http.StatusForbidden)
+		return
+	}
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+	if !validWebhookSignature(r.Header.Get("X-Hub-Signature-256"), payload) {
+		http.Error(w, "Invalid webhook signature", http.StatusUnauthorized)
+		return
+	}
+
+	switch event := r.Header.Get("X-GitHub-Event"); event {
+	case "ping":
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case "issue_comment":
+	default:
+		slog.DebugContext(r.Context(), "Ignoring GitHub webhook event", "event", event)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var event github.IssueCommentEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid issue_comment event: %v", err), http.StatusBadRequest)
+		return
+	}
+	if event.GetAction() != "created" || event.GetSender().GetType() == "Bot" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	cmds := parseBotCommands(event.GetComment().GetBody())
+	if len(cmds) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	target, err := parseRepoTarget(event.GetRepo().GetFullName())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !strings.EqualFold(target.String(), ghOwner+"/"+ghRepo) && !isRepoAllowed(target) {
+		http.Error(w, fmt.Sprintf("Repository %s is not handled by this service", target), http.StatusForbidden)
+		return
+	}
+
+	issue := event.GetIssue()
+	var labels []string
+	for _, label := range issue.Labels {
+		labels = append(labels, label.GetName())
+	}
+	by := event.GetComment().GetUser().GetLogin()
+	allowed := slices.Contains(botCommandAssociations, event.GetComment().GetAuthorAssociation())
+	for _, cmd := range cmds {
+		cmd.Target, cmd.IssueNumber, cmd.IssueURL, cmd.Labels, cmd.By = target, issue.GetNumber(), issue.GetHTMLURL(), labels, by
+		runBotCommand(r.Context(), cmd, allowed)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validWebhookSignature checks the "sha256=" HMAC of a delivery.
+func validWebhookSignature(signature string, payload []byte) bool {
+	return strings.HasPrefix(signature, "sha256=") && validSignature(webhookSecret, signature, payload)
+}
+
+// runBotCommand runs a command and replies on the issue. Commands from
+// people without write access are ignored, unknown commands refused.
+func runBotCommand(ctx context.Context, cmd botCommand, allowed bool) {
+	name, outcome := cmd.Name, "applied"
+	var reply string
+	run, known := botCommands[cmd.Name]
+	if !known {
+		name = "unknown"
+	}
+	switch {
+	case !allowed:
+		outcome = "refused"
+		slog.WarnContext(ctx, "Refused bot command: no write access", "command", cmd.Name, "repository", cmd.Target.String(), "issue_number", cmd.IssueNumber, "user", cmd.By)
+	case !known:
+		outcome = "refused"
+		reply = fmt.Sprintf("Unknown command `%s %s`. Commands: %s.", botCommandPrefix, cmd.Name, strings.Join(sortedKeys(botCommands), ", "))
+	default:
+		var err error
+		reply, err = run(ctx, cmd)
+		if err != nil {
+			outcome = "failed"
+			reply = fmt.Sprintf("`%s %s` failed: %v", botCommandPrefix, cmd.Name, err)
+		}
+	}
+	metrics.add("triage_bot_commands_total", labelSet("command", name, "outcome", outcome), 1)
+	slog.InfoContext(ctx, "Bot command", "command", cmd.Name, "repository", cmd.Target.String(), "issue_number", cmd.IssueNumber, "user", cmd.By, "outcome", outcome)
+	if reply != "" {
+		writer.submit(&githubIntent{Kind: intentComment, Target: cmd.Target, IssueNumber: cmd.IssueNumber, Body: reply})
+	}
+}
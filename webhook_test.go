@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestValidWebhookSignature(t *testing.T) {
+	defer func(secret string) { webhookSecret = secret }(webhookSecret)
+	webhookSecret = "secret"
+
+	payload := []byte(`{"action":"created"}`)
+	tests := []struct {
+		name      string
+		signature string
+		want      bool
+	}{
+		{name: "prefixed", signature: "sha256=" + sign("secret", payload), want: true},
+		// GitHub always sends the prefix.
+		{name: "bare", signature: sign("secret", payload), want: false},
+		{name: "sha1", signature: "sha1=" + sign("secret", payload), want: false},
+		{name: "other secret", signature: "sha256=" + sign("other", payload), want: false},
+		{name: "empty", signature: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validWebhookSignature(tt.signature, payload); got != tt.want {
+				t.Errorf("validWebhookSignature(%q) = %v, want %v", tt.signature, got, tt.want)
+			}
+		})
+	}
+}